@@ -17,28 +17,67 @@ package admin
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"math/rand"
 	"net"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"flag"
 	log "github.com/golang/glog"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"saxml/admin/mgr"
 	"saxml/admin/validator"
 	"saxml/common/addr"
+	"saxml/common/auditlog"
+	"saxml/common/blob"
 	"saxml/common/config"
+	"saxml/common/errors"
 	"saxml/common/ipaddr"
 	"saxml/common/naming"
 	"saxml/common/platform/env"
 	"saxml/common/state"
+	"saxml/common/transport"
+	"saxml/common/watchable"
 
 	pb "saxml/protobuf/admin_go_proto_grpc"
 	pbgrpc "saxml/protobuf/admin_go_proto_grpc"
 )
 
+// startManager starts the manager of a leading admin server, restoring its state. Tests replace it
+// to observe the server while the state is being restored.
+var startManager = (*mgr.Mgr).Start
+
+// startSyncedManager starts the manager of a warm standby that took over, whose state is already
+// in sync with the backing store.
+var startSyncedManager = (*mgr.Mgr).StartRestored
+
+// leaderVar is 1 while an admin server in this process is the leader of its cell, exported for
+// dashboards that scrape /debug/vars.
+var leaderVar = expvar.NewInt("sax_admin_leader")
+
+// CheckpointResolver resolves the checkpoint path of a model being published, such as a "latest"
+// pointer or a versioned alias, to the concrete checkpoint model servers should load. Resolving
+// at publish time pins the model to that checkpoint even if the alias moves later.
+type CheckpointResolver interface {
+	Resolve(ctx context.Context, path string) (string, error)
+}
+
+// identityResolver is the default CheckpointResolver, which takes paths as they are.
+type identityResolver struct{}
+
+func (identityResolver) Resolve(ctx context.Context, path string) (string, error) {
+	return path, nil
+}
+
 // Server implements an admin server.
 type Server struct {
 	// The SAX cell this server runs in.
@@ -47,9 +86,25 @@ type Server struct {
 	// The port this server runs on.
 	port int
 
+	// The shard of the cell's model namespace this server owns, out of numShards. Immutable after
+	// Start.
+	shard     int
+	numShards int
+
+	// If positive, how often a warm standby syncs the manager state while waiting to become the
+	// leader. See EnableWarmStandby.
+	standbySyncPeriod time.Duration
+
 	// serverID is the unique id for this server.
 	serverID string
 
+	// Resolves checkpoint paths of models being published. See SetCheckpointResolver.
+	resolver CheckpointResolver
+
+	// Mirrors model server membership into an external service registry if not nil. See
+	// SetRegistry.
+	registry mgr.Registry
+
 	// The gRPC server where this server is registered.
 	gRPCServer env.Server
 
@@ -66,6 +121,65 @@ type Server struct {
 	cfg *pb.Config
 }
 
+// EnableWarmStandby makes Start, while it waits for this server to become the leader, keep the
+// manager state in sync with the state saved by the leader every period. When the leader dies,
+// this server then only catches up on the last changes once, and starts the manager on the synced
+// state instead of restoring it again. It must be called before Start.
+func (s *Server) EnableWarmStandby(period time.Duration) {
+	s.standbySyncPeriod = period
+}
+
+// syncStandby keeps the manager state in sync with the backing store until promoted is closed or
+// ctx is done. Once promoted, it syncs one last time to catch up on the last changes saved by the
+// leader, and returns true if the state is then in sync.
+func (s *Server) syncStandby(ctx context.Context, promoted <-chan struct{}) bool {
+	ticker := time.NewTicker(s.standbySyncPeriod)
+	defer ticker.Stop()
+	for {
+		if err := s.Mgr.Restore(ctx); err != nil {
+			log.Warningf("Failed to sync standby manager state: %v", err)
+		}
+		select {
+		case <-promoted:
+			if err := s.Mgr.Restore(ctx); err != nil {
+				log.Warningf("Failed to catch up on the manager state after taking over: %v", err)
+				return false
+			}
+			return true
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetCheckpointResolver makes Publish resolve checkpoint paths with resolver instead of taking
+// them as they are. It must be called before Start.
+func (s *Server) SetCheckpointResolver(resolver CheckpointResolver) {
+	s.resolver = resolver
+}
+
+// SetRegistry makes the server mirror model servers joining and leaving into an external service
+// registry, e.g. for existing infrastructure to discover them. Without one, membership is only
+// known to Sax. It must be called before Start.
+func (s *Server) SetRegistry(registry mgr.Registry) {
+	s.registry = registry
+}
+
+// SetShard makes this server own one of numShards shards of the cell's model namespace. Requests
+// for models of other shards are rejected. It must be called before Start.
+func (s *Server) SetShard(shard, numShards int) {
+	s.shard, s.numShards = shard, numShards
+}
+
+// checkShard returns nil iff this server owns the given model.
+func (s *Server) checkShard(modelID string) error {
+	if shard := addr.ShardOf(modelID, s.numShards); shard != s.shard {
+		return fmt.Errorf("model %s belongs to admin shard %d, not %d: %w", modelID, shard, s.shard, errors.ErrFailedPrecondition)
+	}
+	return nil
+}
+
 func (s *Server) adminACL() string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -73,21 +187,102 @@ func (s *Server) adminACL() string {
 }
 
 func (s *Server) Publish(ctx context.Context, in *pb.PublishRequest) (*pb.PublishResponse, error) {
+	if err := s.publish(ctx, in.GetModel(), nil); err != nil {
+		return nil, err
+	}
+	return &pb.PublishResponse{}, nil
+}
+
+// PublishStaged publishes a model like Publish, but rolls it out to canary replicas first.
+func (s *Server) PublishStaged(ctx context.Context, in *pb.PublishStagedRequest) (*pb.PublishStagedResponse, error) {
+	if err := s.publish(ctx, in.GetModel(), in.GetOptions()); err != nil {
+		return nil, err
+	}
+	return &pb.PublishStagedResponse{}, nil
+}
+
+// publish checks and publishes a model, in stages following options if set.
+func (s *Server) publish(ctx context.Context, model *pb.Model, options *pb.RolloutOptions) error {
 	// Only cell admins can publish models in this cell.
 	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
-		return nil, fmt.Errorf("permission error: %w", err)
+		return fmt.Errorf("permission error: %w", err)
+	}
+
+	if err := validator.ValidateModelProto(model, s.saxCell); err != nil {
+		return err
+	}
+	if err := s.checkShard(model.GetModelId()); err != nil {
+		return err
+	}
+	if err := s.checkConfigBlobs(ctx, model); err != nil {
+		return err
+	}
+	path, err := s.resolver.Resolve(ctx, model.GetCheckpointPath())
+	if err != nil {
+		return fmt.Errorf("failed to resolve checkpoint path %s of model %s: %w", model.GetCheckpointPath(), model.GetModelId(), err)
+	}
+	if path != model.GetCheckpointPath() {
+		log.Infof("Resolved checkpoint path %s of model %s to %s", model.GetCheckpointPath(), model.GetModelId(), path)
+		model = proto.Clone(model).(*pb.Model)
+		model.CheckpointPath = path
 	}
 
+	if options != nil {
+		return s.Mgr.PublishStaged(model, options)
+	}
+	return s.Mgr.Publish(model)
+}
+
+// SimulatePlacement computes where a model would be placed if it were published now.
+func (s *Server) SimulatePlacement(ctx context.Context, in *pb.SimulatePlacementRequest) (*pb.SimulatePlacementResponse, error) {
 	model := in.GetModel()
 	if err := validator.ValidateModelProto(model, s.saxCell); err != nil {
 		return nil, err
 	}
-
-	if err := s.Mgr.Publish(model); err != nil {
+	if err := s.checkShard(model.GetModelId()); err != nil {
 		return nil, err
 	}
+	sim, err := s.Mgr.SimulatePlacement(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SimulatePlacementResponse{
+		Requested:        int32(sim.Requested),
+		ModeletAddresses: sim.Servers,
+		Reasons:          sim.Reasons,
+		Shortfall:        int32(sim.Shortfall),
+		ShortfallReason:  sim.ShortfallReason,
+		FullServers:      int32(sim.FullServers),
+	}, nil
+}
 
-	return &pb.PublishResponse{}, nil
+// checkConfigBlobs returns nil iff all config blobs referenced by a model are stored in this cell.
+func (s *Server) checkConfigBlobs(ctx context.Context, model *pb.Model) error {
+	for name, digest := range model.GetConfigBlobs() {
+		if err := blob.Exists(ctx, s.saxCell, digest); err != nil {
+			return fmt.Errorf("config blob %s of model %s: %w", name, model.GetModelId(), err)
+		}
+	}
+	return nil
+}
+
+// UploadBlob stores a large model config blob, streamed in compressed chunks, in this cell.
+func (s *Server) UploadBlob(stream pbgrpc.Admin_UploadBlobServer) error {
+	ctx := stream.Context()
+	// Only cell admins can upload blobs, as only they can publish models referencing them.
+	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
+		return fmt.Errorf("permission error: %w", err)
+	}
+
+	digest, err := blob.Receive(ctx, s.saxCell, func() ([]byte, error) {
+		req, err := stream.Recv()
+		return req.GetChunk(), err
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Stored blob %s", digest)
+	return stream.SendAndClose(&pb.UploadBlobResponse{Digest: digest})
 }
 
 func (s *Server) checkAdminACL(ctx context.Context, fullName naming.ModelFullName) error {
@@ -118,6 +313,9 @@ func (s *Server) Update(ctx context.Context, in *pb.UpdateRequest) (*pb.UpdateRe
 	if err := validator.ValidateModelProto(model, s.saxCell); err != nil {
 		return nil, err
 	}
+	if err := s.checkShard(model.GetModelId()); err != nil {
+		return nil, err
+	}
 	fullName, err := naming.NewModelFullName(model.GetModelId())
 	if err != nil {
 		return nil, err
@@ -127,7 +325,15 @@ func (s *Server) Update(ctx context.Context, in *pb.UpdateRequest) (*pb.UpdateRe
 	if err := s.checkAdminACL(ctx, fullName); err != nil {
 		return nil, err
 	}
-	if err := s.Mgr.Update(fullName, model); err != nil {
+	if err := s.checkConfigBlobs(ctx, model); err != nil {
+		return nil, err
+	}
+	if options := in.GetRollout(); options != nil {
+		err = s.Mgr.UpdateStaged(fullName, model, options, in.GetForce())
+	} else {
+		err = s.Mgr.Update(fullName, model, in.GetForce())
+	}
+	if err != nil {
 		return nil, err
 	}
 
@@ -139,6 +345,9 @@ func (s *Server) Unpublish(ctx context.Context, in *pb.UnpublishRequest) (*pb.Un
 	if err := validator.ValidateModelFullName(modelFullName, s.saxCell); err != nil {
 		return nil, err
 	}
+	if err := s.checkShard(modelFullName); err != nil {
+		return nil, err
+	}
 	fullName, err := naming.NewModelFullName(modelFullName)
 	if err != nil {
 		return nil, err
@@ -148,7 +357,7 @@ func (s *Server) Unpublish(ctx context.Context, in *pb.UnpublishRequest) (*pb.Un
 	if err := s.checkAdminACL(ctx, fullName); err != nil {
 		return nil, err
 	}
-	if err := s.Mgr.Unpublish(fullName); err != nil {
+	if err := s.Mgr.Unpublish(fullName, in.GetForce()); err != nil {
 		return nil, err
 	}
 
@@ -163,6 +372,9 @@ func (s *Server) List(ctx context.Context, in *pb.ListRequest) (*pb.ListResponse
 		if err := validator.ValidateModelFullName(modelFullName, s.saxCell); err != nil {
 			return nil, err
 		}
+		if err := s.checkShard(modelFullName); err != nil {
+			return nil, err
+		}
 		fullName, err := naming.NewModelFullName(modelFullName)
 		if err != nil {
 			return nil, err
@@ -186,6 +398,9 @@ func (s *Server) locate(ctx context.Context, modelFullName string) ([]*pb.Joined
 		if err := validator.ValidateModelFullName(modelFullName, s.saxCell); err != nil {
 			return nil, err
 		}
+		if err := s.checkShard(modelFullName); err != nil {
+			return nil, err
+		}
 		fullName, err := naming.NewModelFullName(modelFullName)
 		if err != nil {
 			return nil, err
@@ -210,15 +425,23 @@ func (s *Server) Stats(ctx context.Context, in *pb.StatsRequest) (*pb.StatsRespo
 	if err != nil {
 		return nil, err
 	}
+	resp := makeStatsResponse(servers)
+	resp.ActiveBlackout = s.Mgr.ActiveBlackout()
+	return resp, nil
+}
 
+// makeStatsResponse aggregates the specs of the given model servers into a Stats response.
+func makeStatsResponse(servers []*pb.JoinedModelServer) *pb.StatsResponse {
 	type ServerType struct {
 		chipType pb.ModelServer_ChipType
 		chipTopo pb.ModelServer_ChipTopology
 	}
 	replicasPerServerType := make(map[ServerType]int32)
 	replicasPerServableModelPath := make(map[string]int32)
+	serversPerVersion := make(map[string]int32)
 
 	for _, server := range servers {
+		serversPerVersion[server.GetModelServer().GetVersion()]++
 		serverType := ServerType{server.GetModelServer().GetChipType(), server.GetModelServer().GetChipTopology()}
 		replicasPerServerType[serverType] = replicasPerServerType[serverType] + 1
 		for _, servableModelPath := range server.GetModelServer().GetServableModelPaths() {
@@ -234,7 +457,11 @@ func (s *Server) Stats(ctx context.Context, in *pb.StatsRequest) (*pb.StatsRespo
 			NumReplicas:  replicas,
 		})
 	}
-	return &pb.StatsResponse{ModelServerTypeStats: modelServerTypeStats, NumServersByServableModelPath: replicasPerServableModelPath}, nil
+	return &pb.StatsResponse{
+		ModelServerTypeStats:          modelServerTypeStats,
+		NumServersByServableModelPath: replicasPerServableModelPath,
+		NumServersByVersion:           serversPerVersion,
+	}
 }
 
 // WatchLoc handles WatchLoc RPC requests.
@@ -242,6 +469,9 @@ func (s *Server) WatchLoc(ctx context.Context, in *pb.WatchLocRequest) (*pb.Watc
 	if err := validator.ValidateWatchLocRequest(in); err != nil {
 		return nil, err
 	}
+	if err := s.checkShard(in.GetModelId()); err != nil {
+		return nil, err
+	}
 	seqno := in.GetSeqno()
 	if in.GetAdminServerId() != s.serverID {
 		seqno = 0
@@ -250,10 +480,62 @@ func (s *Server) WatchLoc(ctx context.Context, in *pb.WatchLocRequest) (*pb.Watc
 	if err != nil {
 		return nil, err
 	}
+	// Mgr.WatchLoc has validated the model ID.
+	fullName, _ := naming.NewModelFullName(in.GetModelId())
+	return s.watchLocResponse(fullName, result), nil
+}
+
+// WatchModel handles WatchModel RPC requests.
+func (s *Server) WatchModel(in *pb.WatchModelRequest, stream pbgrpc.Admin_WatchModelServer) error {
+	if err := validator.ValidateModelFullName(in.GetModelId(), s.saxCell); err != nil {
+		return err
+	}
+	if err := s.checkShard(in.GetModelId()); err != nil {
+		return err
+	}
+	fullName, err := naming.NewModelFullName(in.GetModelId())
+	if err != nil {
+		return err
+	}
+	// A seqno behind every recorded change gets the full set right away, even if the model has
+	// no change yet.
+	seqno := int32(-1)
+	for {
+		result, err := s.Mgr.WatchLoc(stream.Context(), in.GetModelId(), seqno)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(s.watchLocResponse(fullName, result)); err != nil {
+			return err
+		}
+		seqno = result.Next
+	}
+}
+
+// watchLocResponse describes a change to the model servers serving a model, with the zones,
+// labels, and assignment times of the servers it adds.
+func (s *Server) watchLocResponse(fullName naming.ModelFullName, result *watchable.WatchResult) *pb.WatchLocResponse {
+	var added []string
+	if result.Data != nil {
+		added = result.Data.ToList()
+	}
+	for _, m := range result.Log {
+		if m.Kind == watchable.Add {
+			added = append(added, m.Val)
+		}
+	}
+	labels := make(map[string]*pb.ServerLabels)
+	for addr, l := range s.Mgr.Labels(added) {
+		labels[addr] = &pb.ServerLabels{Labels: l}
+	}
 	return &pb.WatchLocResponse{
 		AdminServerId: s.serverID,
 		Result:        result.ToProto(),
-	}, nil
+		Zones:         s.Mgr.Zones(added),
+		Labels:        labels,
+		AssignedMs:    s.Mgr.AssignedMs(fullName, added),
+		Drains:        s.Mgr.Drains(added),
+	}
 }
 
 // WaitForReady handles WaitForReady RPC requests.
@@ -262,6 +544,9 @@ func (s *Server) WaitForReady(ctx context.Context, in *pb.WaitForReadyRequest) (
 	if err := validator.ValidateModelFullName(modelFullName, s.saxCell); err != nil {
 		return nil, err
 	}
+	if err := s.checkShard(modelFullName); err != nil {
+		return nil, err
+	}
 	fullName, err := naming.NewModelFullName(modelFullName)
 	if err != nil {
 		return nil, err
@@ -274,6 +559,253 @@ func (s *Server) WaitForReady(ctx context.Context, in *pb.WaitForReadyRequest) (
 	return &pb.WaitForReadyResponse{}, nil
 }
 
+func (s *Server) ApproveScale(ctx context.Context, in *pb.ApproveScaleRequest) (*pb.ApproveScaleResponse, error) {
+	// Only cell admins can approve spending on more replicas.
+	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
+		return nil, fmt.Errorf("permission error: %w", err)
+	}
+	modelFullName := in.GetModelId()
+	if err := validator.ValidateModelFullName(modelFullName, s.saxCell); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(modelFullName); err != nil {
+		return nil, err
+	}
+	fullName, err := naming.NewModelFullName(modelFullName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Mgr.ApproveScale(fullName); err != nil {
+		return nil, err
+	}
+
+	return &pb.ApproveScaleResponse{}, nil
+}
+
+func (s *Server) OverrideConstraints(ctx context.Context, in *pb.OverrideConstraintsRequest) (*pb.OverrideConstraintsResponse, error) {
+	// Only cell admins can bypass placement constraints.
+	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
+		return nil, fmt.Errorf("permission error: %w", err)
+	}
+	modelFullName := in.GetModelId()
+	if err := validator.ValidateModelFullName(modelFullName, s.saxCell); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(modelFullName); err != nil {
+		return nil, err
+	}
+	fullName, err := naming.NewModelFullName(modelFullName)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := time.Duration(in.GetDurationMs()) * time.Millisecond
+	override, err := s.Mgr.OverrideConstraints(fullName, in.GetOverride(), duration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.OverrideConstraintsResponse{Override: override}, nil
+}
+
+// SetReplicasFor temporarily raises the number of replicas of a model.
+func (s *Server) SetReplicasFor(ctx context.Context, in *pb.SetReplicasForRequest) (*pb.SetReplicasForResponse, error) {
+	// Only cell admins can change the number of replicas outside of an update.
+	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
+		return nil, fmt.Errorf("permission error: %w", err)
+	}
+	modelFullName := in.GetModelId()
+	if err := validator.ValidateModelFullName(modelFullName, s.saxCell); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(modelFullName); err != nil {
+		return nil, err
+	}
+	fullName, err := naming.NewModelFullName(modelFullName)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := time.Duration(in.GetDurationMs()) * time.Millisecond
+	bump, err := s.Mgr.SetReplicasFor(fullName, in.GetNumReplicas(), duration, in.GetForce())
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SetReplicasForResponse{Bump: bump}, nil
+}
+
+// ServingReady lists the model replicas assigned to joined model servers that aren't loaded yet.
+func (s *Server) ServingReady(ctx context.Context, in *pb.ServingReadyRequest) (*pb.ServingReadyResponse, error) {
+	unready, err := s.Mgr.UnreadyReplicas()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ServingReadyResponse{UnreadyReplicas: unready}, nil
+}
+
+// PromoteWarm sets how many warm pool replicas of a model serve traffic.
+func (s *Server) PromoteWarm(ctx context.Context, in *pb.PromoteWarmRequest) (*pb.PromoteWarmResponse, error) {
+	// Only cell admins can route traffic to warm pool replicas.
+	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
+		return nil, fmt.Errorf("permission error: %w", err)
+	}
+	modelFullName := in.GetModelId()
+	if err := validator.ValidateModelFullName(modelFullName, s.saxCell); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(modelFullName); err != nil {
+		return nil, err
+	}
+	fullName, err := naming.NewModelFullName(modelFullName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Mgr.PromoteWarm(fullName, in.GetNumPromoted(), in.GetForce()); err != nil {
+		return nil, err
+	}
+	return &pb.PromoteWarmResponse{}, nil
+}
+
+// DrainServer shifts a fraction of the client traffic of a model server to other replicas.
+func (s *Server) DrainServer(ctx context.Context, in *pb.DrainServerRequest) (*pb.DrainServerResponse, error) {
+	// Only cell admins can move traffic between model servers.
+	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
+		return nil, fmt.Errorf("permission error: %w", err)
+	}
+	if in.GetAddress() == "" {
+		return nil, fmt.Errorf("address cannot be empty: %w", errors.ErrInvalidArgument)
+	}
+	if err := s.Mgr.DrainServer(in.GetAddress(), in.GetFraction()); err != nil {
+		return nil, err
+	}
+	return &pb.DrainServerResponse{}, nil
+}
+
+// EvacuateLabel moves every model off the model servers tagged with a label.
+func (s *Server) EvacuateLabel(ctx context.Context, in *pb.EvacuateLabelRequest) (*pb.EvacuateLabelResponse, error) {
+	// Only cell admins can take model servers out of serving.
+	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
+		return nil, fmt.Errorf("permission error: %w", err)
+	}
+	if in.GetKey() == "" {
+		return nil, fmt.Errorf("label key cannot be empty: %w", errors.ErrInvalidArgument)
+	}
+	if err := s.Mgr.EvacuateLabel(ctx, in.GetKey(), in.GetValue(), in.GetForce()); err != nil {
+		return nil, err
+	}
+	return &pb.EvacuateLabelResponse{}, nil
+}
+
+// ListIncarnations lists the model server processes that joined from an address.
+func (s *Server) ListIncarnations(ctx context.Context, in *pb.ListIncarnationsRequest) (*pb.ListIncarnationsResponse, error) {
+	if in.GetAddress() == "" {
+		return nil, fmt.Errorf("address cannot be empty: %w", errors.ErrInvalidArgument)
+	}
+	return &pb.ListIncarnationsResponse{Incarnations: s.Mgr.Incarnations(in.GetAddress())}, nil
+}
+
+// PurgeStaleIncarnations forgets the model server processes that have left.
+func (s *Server) PurgeStaleIncarnations(ctx context.Context, in *pb.PurgeStaleIncarnationsRequest) (*pb.PurgeStaleIncarnationsResponse, error) {
+	// Only cell admins can drop model server records.
+	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
+		return nil, fmt.Errorf("permission error: %w", err)
+	}
+	return &pb.PurgeStaleIncarnationsResponse{NumPurged: int32(s.Mgr.PurgeStaleIncarnations())}, nil
+}
+
+func (s *Server) GetEffectiveConfig(ctx context.Context, in *pb.GetEffectiveConfigRequest) (*pb.GetEffectiveConfigResponse, error) {
+	s.mu.Lock()
+	cfg := s.cfg
+	s.mu.Unlock()
+	return &pb.GetEffectiveConfigResponse{Values: effectiveConfig(cfg, flag.CommandLine)}, nil
+}
+
+// effectiveConfig resolves the settings in force: every field of the cell config cfg, and every
+// Sax flag in flags, together with whether it was set or is left at its default value.
+//
+// Because the cell config is a proto3 message, a field explicitly set to its zero value is
+// reported as a default.
+func effectiveConfig(cfg *pb.Config, flags *flag.FlagSet) []*pb.ConfigValue {
+	var values []*pb.ConfigValue
+	msg := cfg.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		v := msg.Get(fd)
+		value := v.String()
+		if fd.IsList() {
+			var items []string
+			for j := 0; j < v.List().Len(); j++ {
+				items = append(items, prototext.MarshalOptions{}.Format(v.List().Get(j).Message().Interface()))
+			}
+			value = "[" + strings.Join(items, ", ") + "]"
+		} else if fd.Kind() == protoreflect.EnumKind {
+			if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+				value = string(ev.Name())
+			}
+		}
+		source := pb.ConfigValue_SOURCE_DEFAULT
+		if msg.Has(fd) {
+			source = pb.ConfigValue_SOURCE_CELL_CONFIG
+		}
+		values = append(values, &pb.ConfigValue{Name: "config." + string(fd.Name()), Value: value, Source: source})
+	}
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	flags.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "sax_") {
+			return
+		}
+		source := pb.ConfigValue_SOURCE_DEFAULT
+		if set[f.Name] {
+			source = pb.ConfigValue_SOURCE_FLAG
+		}
+		values = append(values, &pb.ConfigValue{Name: "flag." + f.Name, Value: f.Value.String(), Source: source})
+	})
+
+	sort.Slice(values, func(i, j int) bool { return values[i].GetName() < values[j].GetName() })
+	return values
+}
+
+// ExportAudit handles ExportAudit RPC requests.
+func (s *Server) ExportAudit(in *pb.ExportAuditRequest, stream pbgrpc.Admin_ExportAuditServer) error {
+	// Audit records cover every model in the cell and the reasons operators gave for their actions.
+	if err := s.gRPCServer.CheckACLs(stream.Context(), []string{s.adminACL()}); err != nil {
+		return fmt.Errorf("permission error: %w", err)
+	}
+	since := time.UnixMilli(in.GetSinceMs())
+	until := time.Now()
+	if in.GetUntilMs() != 0 {
+		until = time.UnixMilli(in.GetUntilMs())
+	}
+	if until.Before(since) {
+		return fmt.Errorf("until %v is before since %v: %w", until, since, errors.ErrInvalidArgument)
+	}
+	for _, r := range s.Mgr.ExportAudit(since, until) {
+		if err := stream.Send(&pb.ExportAuditResponse{Record: auditRecord(r)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditRecord converts a kept event to its proto form, with proto arguments in text format.
+func auditRecord(r auditlog.Record) *pb.AuditRecord {
+	args := make([]string, 0, len(r.Args))
+	for _, arg := range r.Args {
+		if msg, ok := arg.(proto.Message); ok {
+			args = append(args, prototext.MarshalOptions{}.Format(msg))
+		} else {
+			args = append(args, fmt.Sprint(arg))
+		}
+	}
+	return &pb.AuditRecord{TimeMs: r.Time.UnixMilli(), Type: r.Type.String(), Args: args}
+}
+
 func (s *Server) Join(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse, error) {
 	// Only servers run by the cell admin can join.
 	if err := s.gRPCServer.CheckACLs(ctx, []string{s.adminACL()}); err != nil {
@@ -283,7 +815,11 @@ func (s *Server) Join(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse
 		return nil, err
 	}
 
-	if err := s.Mgr.Join(ctx, in.GetAddress(), in.GetDebugAddress(), in.GetDataAddress(), in.GetModelServer()); err != nil {
+	err := s.Mgr.Join(ctx, in.GetAddress(), in.GetDebugAddress(), in.GetDataAddress(), in.GetModelServer())
+	if err == mgr.ErrRecovering {
+		return &pb.JoinResponse{Recovering: true}, nil
+	}
+	if err != nil {
 		return nil, err
 	}
 
@@ -291,7 +827,7 @@ func (s *Server) Join(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse
 }
 
 // Start starts running the server.
-func (s *Server) Start(ctx context.Context) error {
+func (s *Server) Start(ctx context.Context) (err error) {
 	if _, err := naming.SaxCellToCell(s.saxCell); err != nil {
 		return err
 	}
@@ -300,25 +836,60 @@ func (s *Server) Start(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("net.Listen on port %v error: %w", s.port, err)
 	}
-	gRPCServer, err := env.Get().NewServer(ctx)
-	if err != nil {
-		return fmt.Errorf("NewServer error: %w", err)
-	}
+	// Free the port if the server fails to start, e.g. because ctx is done before it becomes the
+	// leader, so another admin server can start on it.
+	defer func() {
+		if err != nil {
+			lis.Close()
+		}
+	}()
 
 	s.cfg, err = config.Load(ctx, s.saxCell)
 	log.Infof("Loaded config: %v", prototext.Format(s.cfg))
 	if err != nil {
 		return fmt.Errorf("config.Load error: %w", err)
 	}
+	// An admin server that can't connect to model servers the way the cell requires would fail
+	// every Join, so refuse to lead the cell at all.
+	if err := transport.Check(s.cfg.GetTransportSecurity()); err != nil {
+		return fmt.Errorf("cannot serve cell %s: %w", s.saxCell, err)
+	}
+	serverOpts, err := transport.ServerOptions(s.cfg.GetTransportSecurity())
+	if err != nil {
+		return fmt.Errorf("cannot serve cell %s: %w", s.saxCell, err)
+	}
+	serverOpts = append(serverOpts,
+		grpc.ChainUnaryInterceptor(s.recoveryUnaryInterceptor),
+		grpc.ChainStreamInterceptor(s.recoveryStreamInterceptor))
+	gRPCServer, err := env.Get().NewServer(ctx, serverOpts...)
+	if err != nil {
+		return fmt.Errorf("NewServer error: %w", err)
+	}
 	fsRoot := env.Get().FsRootDir(s.cfg.GetFsRoot())
 	if fsRoot == "" {
 		return fmt.Errorf("no fs_root specified")
 	}
 	fsPath := filepath.Join(fsRoot, s.saxCell)
+	if s.shard > 0 {
+		// Shard 0 keeps the state of an unsharded cell.
+		fsPath = filepath.Join(fsPath, fmt.Sprintf("shard%d", s.shard))
+	}
 	if err := env.Get().CreateDir(ctx, fsPath, ""); err != nil {
 		return fmt.Errorf("CreateDir from %v error: %w", fsPath, err)
 	}
 
+	s.Mgr = mgr.New(state.New(fsPath))
+	s.Mgr.SetScaleApprovalBaseline(int(s.cfg.GetScaleApprovalBaseline()))
+	s.Mgr.SetScaleDownStrategy(s.cfg.GetScaleDownStrategy())
+	s.Mgr.SetQuotas(int(s.cfg.GetMaxPublishedModels()), int(s.cfg.GetMaxTotalReplicas()))
+	s.Mgr.SetBlackoutWindows(s.cfg.GetBlackoutWindows())
+	s.Mgr.SetMaxConcurrentLoads(int(s.cfg.GetMaxConcurrentLoads()))
+	s.Mgr.SetTransportSecurity(s.cfg.GetTransportSecurity())
+	if s.registry != nil {
+		s.Mgr.SetRegistry(s.registry)
+	}
+
+	served := s.cfg.GetTransportSecurity()
 	go func() {
 		ch, err := config.Watch(ctx, s.saxCell)
 		if err != nil {
@@ -330,25 +901,52 @@ func (s *Server) Start(ctx context.Context) error {
 			s.mu.Lock()
 			s.cfg = cfg
 			s.mu.Unlock()
+			s.Mgr.SetScaleApprovalBaseline(int(cfg.GetScaleApprovalBaseline()))
+			s.Mgr.SetScaleDownStrategy(cfg.GetScaleDownStrategy())
+			s.Mgr.SetQuotas(int(cfg.GetMaxPublishedModels()), int(cfg.GetMaxTotalReplicas()))
+			s.Mgr.SetBlackoutWindows(cfg.GetBlackoutWindows())
+			s.Mgr.SetMaxConcurrentLoads(int(cfg.GetMaxConcurrentLoads()))
+			if cfg.GetTransportSecurity() != served {
+				log.Warningf("Transport security policy changed to %v, but this server accepts connections under %v until it restarts", cfg.GetTransportSecurity(), served)
+			}
+			s.Mgr.SetTransportSecurity(cfg.GetTransportSecurity())
 		}
 	}()
 
-	s.Mgr = mgr.New(state.New(fsPath))
-
 	s.gRPCServer = gRPCServer
 	pbgrpc.RegisterAdminServer(gRPCServer.GRPCServer(), s)
 
-	// Set the admin address for this cell. Block until done.
-	s.addrCloser, err = addr.SetAddr(ctx, s.port, s.saxCell)
-	if err != nil {
-		return fmt.Errorf("addr.SetAddr error: %w", err)
+	// A warm standby mirrors the leader's state until it takes over, and stops mirroring before the
+	// manager starts changing the state on its own.
+	var stopSync func() bool
+	if s.standbySyncPeriod > 0 {
+		promoted := make(chan struct{})
+		synced := make(chan bool, 1)
+		go func() {
+			synced <- s.syncStandby(ctx, promoted)
+		}()
+		stopSync = func() bool {
+			close(promoted)
+			return <-synced
+		}
 	}
 
-	// Start the manager.
-	if err := s.Mgr.Start(ctx); err != nil {
-		return fmt.Errorf("s.Mgr.Start error: %w", err)
+	// Set the admin address for this cell. Block until done.
+	s.addrCloser, err = addr.SetShardAddr(ctx, s.port, s.saxCell, s.shard, s.numShards)
+	start := startManager
+	if stopSync != nil && stopSync() {
+		// The state is in sync already, so the manager doesn't restore it again.
+		start = startSyncedManager
+	}
+	if err != nil {
+		return fmt.Errorf("addr.SetShardAddr error: %w", err)
 	}
+	leaderVar.Set(1)
 
+	// Serve while the manager restores its state, so model servers joining meanwhile are turned away
+	// and prompted to rejoin once it's restored, rather than left out until their next periodic
+	// join. Other RPCs fail as retryable until then instead of seeing a partial state.
+	s.Mgr.MarkRecovering()
 	// This goroutine exits when s.Close is called.
 	go func() {
 		log.Infof("Starting the server on port %v", s.port)
@@ -359,9 +957,43 @@ func (s *Server) Start(ctx context.Context) error {
 		log.Infof("Stopped the server")
 	}()
 
+	// Start the manager.
+	if err := start(s.Mgr, ctx); err != nil {
+		gRPCServer.Stop()
+		close(s.addrCloser)
+		s.addrCloser = nil
+		leaderVar.Set(0)
+		return fmt.Errorf("s.Mgr.Start error: %w", err)
+	}
+
+	return nil
+}
+
+// joinMethod is the full name of the Join RPC, the only one served while the manager is recovering.
+const joinMethod = "/sax.Admin/Join"
+
+// checkRecovered returns an error if method can't be served yet because the manager is recovering.
+func (s *Server) checkRecovered(method string) error {
+	if method != joinMethod && s.Mgr.Recovering() {
+		return fmt.Errorf("admin server is recovering its state, retry later: %w", errors.ErrUnavailable)
+	}
 	return nil
 }
 
+func (s *Server) recoveryUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.checkRecovered(info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) recoveryStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkRecovered(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
 // Close closes a running server.
 func (s *Server) Close() {
 	s.Mgr.Close()
@@ -370,14 +1002,17 @@ func (s *Server) Close() {
 	}
 	if s.addrCloser != nil {
 		close(s.addrCloser)
+		leaderVar.Set(0)
 	}
 }
 
 // NewServer creates an admin server for `saxCell`. `store` is the backing store for server states.
 func NewServer(saxCell string, port int) *Server {
 	return &Server{
-		saxCell:  saxCell,
-		port:     port,
-		serverID: fmt.Sprintf("%s_%016x", net.JoinHostPort(ipaddr.MyIPAddr().String(), strconv.Itoa(port)), rand.Uint64()),
+		saxCell:   saxCell,
+		port:      port,
+		numShards: 1,
+		resolver:  identityResolver{},
+		serverID:  fmt.Sprintf("%s_%016x", net.JoinHostPort(ipaddr.MyIPAddr().String(), strconv.Itoa(port)), rand.Uint64()),
 	}
 }
@@ -0,0 +1,499 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"flag"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/testing/protocmp"
+	"saxml/admin/mgr"
+	"saxml/common/addr"
+	"saxml/common/errors"
+	"saxml/common/naming"
+	"saxml/common/platform/env"
+	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/testutil"
+
+	apb "saxml/protobuf/admin_go_proto_grpc"
+)
+
+func TestStatsCountsVersions(t *testing.T) {
+	joined := func(addr, version string) *apb.JoinedModelServer {
+		return &apb.JoinedModelServer{
+			Address: addr,
+			ModelServer: &apb.ModelServer{
+				ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+				ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+				ServableModelPaths: []string{"saxml.lm.params.Model"},
+				Version:            version,
+			},
+		}
+	}
+	// A fleet half way through a rollout, with one server too old to report its version.
+	servers := []*apb.JoinedModelServer{
+		joined("localhost:10000", "v1"),
+		joined("localhost:10001", "v1"),
+		joined("localhost:10002", "v2"),
+		joined("localhost:10003", ""),
+	}
+
+	got := makeStatsResponse(servers).GetNumServersByVersion()
+	want := map[string]int32{"v1": 2, "v2": 1, "": 1}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NumServersByVersion unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Once every server runs v2, only v2 remains.
+	for _, s := range servers {
+		s.GetModelServer().Version = "v2"
+	}
+	got = makeStatsResponse(servers).GetNumServersByVersion()
+	want = map[string]int32{"v2": 4}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NumServersByVersion after rollout unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckShard(t *testing.T) {
+	const numShards = 3
+	s := NewServer("/sax/test", 10000)
+	s.SetShard(1, numShards)
+	for i := 0; i < 20; i++ {
+		modelID := fmt.Sprintf("/sax/test/model%d", i)
+		err := s.checkShard(modelID)
+		if owned := addr.ShardOf(modelID, numShards) == 1; owned != (err == nil) {
+			t.Errorf("checkShard(%s) error %v, want error: %v", modelID, err, !owned)
+		}
+	}
+
+	// An unsharded server owns every model.
+	s = NewServer("/sax/test", 10000)
+	if err := s.checkShard("/sax/test/model0"); err != nil {
+		t.Errorf("checkShard(/sax/test/model0) on an unsharded server error %v, want no error", err)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	// The cell config overrides some defaults.
+	cfg := &apb.Config{
+		FsRoot:            "/tmp/sax-fs-root",
+		AdminAcl:          "sax-admins",
+		ScaleDownStrategy: apb.Config_SCALE_DOWN_EMPTIEST_SERVER,
+	}
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	flags.Bool("sax_admin_exp_assigner", false, "")
+	flags.Bool("sax_admin_placement_rationale", false, "")
+	flags.Int("v", 0, "")
+	if err := flags.Parse([]string{"--sax_admin_placement_rationale", "--v=2"}); err != nil {
+		t.Fatalf("Parse() error %v, want no error", err)
+	}
+
+	want := []*apb.ConfigValue{
+		{Name: "config.admin_acl", Value: "sax-admins", Source: apb.ConfigValue_SOURCE_CELL_CONFIG},
+		{Name: "config.blackout_windows", Value: "[]", Source: apb.ConfigValue_SOURCE_DEFAULT},
+		{Name: "config.default_request_timeout_ms", Value: "0", Source: apb.ConfigValue_SOURCE_DEFAULT},
+		{Name: "config.fs_root", Value: "/tmp/sax-fs-root", Source: apb.ConfigValue_SOURCE_CELL_CONFIG},
+		{Name: "config.max_concurrent_loads", Value: "0", Source: apb.ConfigValue_SOURCE_DEFAULT},
+		{Name: "config.max_published_models", Value: "0", Source: apb.ConfigValue_SOURCE_DEFAULT},
+		{Name: "config.max_total_replicas", Value: "0", Source: apb.ConfigValue_SOURCE_DEFAULT},
+		{Name: "config.scale_approval_baseline", Value: "0", Source: apb.ConfigValue_SOURCE_DEFAULT},
+		{Name: "config.scale_down_strategy", Value: "SCALE_DOWN_EMPTIEST_SERVER", Source: apb.ConfigValue_SOURCE_CELL_CONFIG},
+		{Name: "config.transport_security", Value: "TRANSPORT_INSECURE", Source: apb.ConfigValue_SOURCE_DEFAULT},
+		{Name: "flag.sax_admin_exp_assigner", Value: "false", Source: apb.ConfigValue_SOURCE_DEFAULT},
+		{Name: "flag.sax_admin_placement_rationale", Value: "true", Source: apb.ConfigValue_SOURCE_FLAG},
+	}
+	if diff := cmp.Diff(want, effectiveConfig(cfg, flags), protocmp.Transform()); diff != "" {
+		t.Errorf("effectiveConfig() unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Without a cell config, everything is a default.
+	for _, v := range effectiveConfig(&apb.Config{}, flag.NewFlagSet("empty", flag.ContinueOnError)) {
+		if v.GetSource() != apb.ConfigValue_SOURCE_DEFAULT {
+			t.Errorf("effectiveConfig() of an empty config has %v, want a default", v)
+		}
+	}
+}
+
+func TestLeaderVar(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-leader-var"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	s := NewServer(saxCell, port)
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error %v, want no error", err)
+	}
+	if got := expvar.Get("sax_admin_leader").String(); got != "1" {
+		t.Errorf("sax_admin_leader = %s after Start, want 1", got)
+	}
+	s.Close()
+	if got := expvar.Get("sax_admin_leader").String(); got != "0" {
+		t.Errorf("sax_admin_leader = %s after Close, want 0", got)
+	}
+}
+
+// aliasResolver resolves checkpoint paths listed in aliases, and fails on paths ending in
+// "/latest" that aren't.
+type aliasResolver struct {
+	aliases map[string]string
+}
+
+func (r *aliasResolver) Resolve(ctx context.Context, path string) (string, error) {
+	if concrete, ok := r.aliases[path]; ok {
+		return concrete, nil
+	}
+	if strings.HasSuffix(path, "/latest") {
+		return "", fmt.Errorf("no checkpoint behind %s: %w", path, errors.ErrNotFound)
+	}
+	return path, nil
+}
+
+func TestCheckpointResolver(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-checkpoint-resolver"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	s := NewServer(saxCell, port)
+	s.SetCheckpointResolver(&aliasResolver{aliases: map[string]string{
+		"/tmp/lm/latest": "/tmp/lm/checkpoint_00012000",
+	}})
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error %v, want no error", err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		id   string
+		path string
+		want string
+		code codes.Code
+	}{
+		{"alias", "/tmp/lm/latest", "/tmp/lm/checkpoint_00012000", codes.OK},
+		{"concrete", "/tmp/lm/checkpoint_00010000", "/tmp/lm/checkpoint_00010000", codes.OK},
+		{"dangling", "/tmp/other/latest", "", codes.NotFound},
+	}
+	for _, tc := range tests {
+		model := &apb.Model{
+			ModelId:              saxCell + "/" + tc.id,
+			ModelPath:            "saxml.server.lm.params.lm_cloud.LmCloudSpmd2B",
+			CheckpointPath:       tc.path,
+			RequestedNumReplicas: 1,
+		}
+		_, err := s.Publish(ctx, &apb.PublishRequest{Model: model})
+		if code := errors.Code(err); code != tc.code {
+			t.Errorf("Publish(%v) error %v, want code %v", model, err, tc.code)
+			continue
+		}
+		fullName, _ := naming.NewModelFullName(model.GetModelId())
+		published := s.Mgr.FindModel(fullName)
+		if tc.code != codes.OK {
+			if published != nil {
+				t.Errorf("Model %v published after failing to resolve its checkpoint", fullName)
+			}
+			continue
+		}
+		if got := published.GetCheckpointPath(); got != tc.want {
+			t.Errorf("Published checkpoint of %v = %s, want %s", fullName, got, tc.want)
+		}
+	}
+}
+
+func TestWarmStandbyTakeover(t *testing.T) {
+	// How soon the standby must serve after the leader dies.
+	const maxTakeover = 2 * time.Second
+	ctx := context.Background()
+	saxCell := "/sax/test-warm-standby"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	// Only the leader restores its state when its manager starts, not the standby taking over.
+	restored := make(chan struct{}, 2)
+	defer func(start func(*mgr.Mgr, context.Context) error) { startManager = start }(startManager)
+	startManager = func(m *mgr.Mgr, ctx context.Context) error {
+		restored <- struct{}{}
+		return m.Start(ctx)
+	}
+
+	leaderPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	leader := NewServer(saxCell, leaderPort)
+	if err := leader.Start(ctx); err != nil {
+		t.Fatalf("Start() of the leader error %v, want no error", err)
+	}
+	model := &apb.Model{
+		ModelId:              saxCell + "/standby",
+		ModelPath:            "saxml.server.lm.params.lm_cloud.LmCloudSpmd2B",
+		CheckpointPath:       "/tmp/checkpoint",
+		RequestedNumReplicas: 1,
+	}
+	if err := leader.Mgr.Publish(model); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", model, err)
+	}
+	if err := leader.Mgr.Save(ctx); err != nil {
+		t.Fatalf("Save() error %v, want no error", err)
+	}
+
+	standbyPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	standby := NewServer(saxCell, standbyPort)
+	standby.EnableWarmStandby(10 * time.Millisecond)
+	started := make(chan error, 1)
+	go func() { started <- standby.Start(ctx) }()
+	select {
+	case err := <-started:
+		t.Fatalf("Start() of the standby returned %v while the leader is alive, want it to block", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// The leader saves a last change and dies.
+	last := &apb.Model{
+		ModelId:              saxCell + "/last",
+		ModelPath:            model.GetModelPath(),
+		CheckpointPath:       model.GetCheckpointPath(),
+		RequestedNumReplicas: 1,
+	}
+	if err := leader.Mgr.Publish(last); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", last, err)
+	}
+	if err := leader.Mgr.Save(ctx); err != nil {
+		t.Fatalf("Save() error %v, want no error", err)
+	}
+	died := time.Now()
+	leader.Close()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Start() of the standby error %v, want no error", err)
+		}
+	case <-time.After(maxTakeover):
+		t.Fatalf("Start() of the standby didn't return within %v of the leader dying", maxTakeover)
+	}
+	defer standby.Close()
+	t.Logf("The standby took over in %v", time.Since(died))
+
+	got, err := addr.FetchAddr(ctx, saxCell)
+	if err != nil {
+		t.Fatalf("FetchAddr(%s) error %v, want no error", saxCell, err)
+	}
+	if want := ":" + strconv.Itoa(standbyPort); !strings.HasSuffix(got, want) {
+		t.Errorf("FetchAddr(%s) = %s, want the standby address ending with %s", saxCell, got, want)
+	}
+	for _, published := range []*apb.Model{model, last} {
+		fullName, _ := naming.NewModelFullName(published.GetModelId())
+		if standby.Mgr.FindModel(fullName) == nil {
+			t.Errorf("FindModel(%v) on the standby found nothing, want the model published on the leader", fullName)
+		}
+	}
+	if got := len(restored); got != 1 {
+		t.Errorf("Managers restored from the backing store when starting = %d, want only the leader's", got)
+	}
+}
+
+func TestServeWhileRecovering(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-serve-while-recovering"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	// Keep the manager recovering until released.
+	release := make(chan struct{})
+	defer func(start func(*mgr.Mgr, context.Context) error) { startManager = start }(startManager)
+	startManager = func(m *mgr.Mgr, ctx context.Context) error {
+		<-release
+		return m.Start(ctx)
+	}
+
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	s := NewServer(saxCell, port)
+	started := make(chan error, 1)
+	go func() { started <- s.Start(ctx) }()
+
+	conn, err := env.Get().DialContext(ctx, fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("DialContext() error %v, want no error", err)
+	}
+	defer conn.Close()
+	client := apb.NewAdminClient(conn)
+
+	modelServerPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubModelServerT(t, modelServerPort)
+	rejoined := make(chan struct{}, 1)
+	if err := testutil.SetStubModelServerRejoin(modelServerPort, func() {
+		select {
+		case rejoined <- struct{}{}:
+		default:
+		}
+	}); err != nil {
+		t.Fatalf("SetStubModelServerRejoin() error %v, want no error", err)
+	}
+	join := &apb.JoinRequest{
+		Address: fmt.Sprintf("localhost:%d", modelServerPort),
+		ModelServer: &apb.ModelServer{
+			ChipType:     apb.ModelServer_CHIP_TYPE_TPU_V4,
+			ChipTopology: apb.ModelServer_CHIP_TOPOLOGY_2X2,
+		},
+	}
+
+	// Joins reach the recovering manager, which turns the model server away, once the server serves.
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := client.Join(ctx, join)
+		if err == nil && resp.GetRecovering() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Join() while recovering = (%v, %v), want the model server turned away", resp, err)
+		}
+	}
+	// Other RPCs wait for the state to be restored.
+	if _, err := client.List(ctx, &apb.ListRequest{}); errors.Code(err) != codes.Unavailable {
+		t.Errorf("List() while recovering error %v, want code %v", err, codes.Unavailable)
+	}
+
+	close(release)
+	if err := <-started; err != nil {
+		t.Fatalf("Start() error %v, want no error", err)
+	}
+	defer s.Close()
+	// The model server turned away is prompted to rejoin right away.
+	select {
+	case <-rejoined:
+	case <-time.After(10 * time.Second):
+		t.Errorf("Model server turned away while recovering not prompted to rejoin")
+	}
+	if _, err := client.List(ctx, &apb.ListRequest{}); err != nil {
+		t.Errorf("List() after recovering error %v, want no error", err)
+	}
+	if resp, err := client.Join(ctx, join); err != nil || resp.GetRecovering() {
+		t.Errorf("Join() after recovering = (%v, %v), want the model server joined", resp, err)
+	}
+}
+
+// auditStream collects the records sent by ExportAudit.
+type auditStream struct {
+	apb.Admin_ExportAuditServer
+	ctx     context.Context
+	records []*apb.AuditRecord
+}
+
+func (s *auditStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *auditStream) Send(resp *apb.ExportAuditResponse) error {
+	s.records = append(s.records, resp.GetRecord())
+	return nil
+}
+
+func TestExportAudit(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-export-audit"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	s := NewServer(saxCell, port)
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error %v, want no error", err)
+	}
+	defer s.Close()
+
+	// Publishes models, returning the time in milliseconds before the first one. Publishes are
+	// spaced out so that no two fall in the same millisecond as a range boundary.
+	publish := func(ids ...string) int64 {
+		t.Helper()
+		time.Sleep(2 * time.Millisecond)
+		start := time.Now().UnixMilli()
+		time.Sleep(2 * time.Millisecond)
+		for _, id := range ids {
+			model := &apb.Model{
+				ModelId:              saxCell + "/" + id,
+				ModelPath:            "saxml.server.lm.params.lm_cloud.LmCloudSpmd2B",
+				CheckpointPath:       "None",
+				RequestedNumReplicas: 0,
+			}
+			if _, err := s.Publish(ctx, &apb.PublishRequest{Model: model}); err != nil {
+				t.Fatalf("Publish(%s) error %v, want no error", id, err)
+			}
+		}
+		return start
+	}
+	publish("before")
+	since := publish("first", "second")
+	until := publish("after")
+
+	stream := &auditStream{ctx: ctx}
+	if err := s.ExportAudit(&apb.ExportAuditRequest{SinceMs: since, UntilMs: until}, stream); err != nil {
+		t.Fatalf("ExportAudit() error %v, want no error", err)
+	}
+	var got []string
+	for _, r := range stream.records {
+		if r.GetType() != "Deploy" {
+			t.Errorf("ExportAudit() record %v, want only Deploy records", r)
+			continue
+		}
+		if r.GetTimeMs() < since || r.GetTimeMs() >= until {
+			t.Errorf("ExportAudit() record %v outside [%d, %d)", r, since, until)
+		}
+		for _, id := range []string{"before", "first", "second", "after"} {
+			if strings.Contains(r.GetArgs()[0], `"`+saxCell+"/"+id+`"`) {
+				got = append(got, id)
+			}
+		}
+	}
+	if diff := cmp.Diff([]string{"first", "second"}, got); diff != "" {
+		t.Errorf("ExportAudit() published models unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Without an end, the export runs up to now.
+	stream = &auditStream{ctx: ctx}
+	if err := s.ExportAudit(&apb.ExportAuditRequest{SinceMs: until}, stream); err != nil {
+		t.Fatalf("ExportAudit() error %v, want no error", err)
+	}
+	if len(stream.records) != 1 {
+		t.Errorf("ExportAudit() since %d got %d records, want 1", until, len(stream.records))
+	}
+
+	err = s.ExportAudit(&apb.ExportAuditRequest{SinceMs: until, UntilMs: since}, &auditStream{ctx: ctx})
+	if errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("ExportAudit() of a reversed range error %v, want %v", err, errors.ErrInvalidArgument)
+	}
+}
@@ -16,15 +16,20 @@
 package assigner
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 
+	log "github.com/golang/glog"
 	"saxml/admin/protobuf"
 	"saxml/admin/utils"
 	"saxml/common/naming"
 	apb "saxml/protobuf/admin_go_proto_grpc"
 )
 
+// maxScoredCandidates is the number of candidate servers whose scores a placement rationale lists.
+const maxScoredCandidates = 5
+
 // ServerAddr represents a model server address. E.g., 1.2.3.4:14001.
 type ServerAddr string
 
@@ -37,7 +42,13 @@ type ServerInfo struct {
 	memoryCapacity    int64
 	servableModelPath []ParamPath
 	tags              map[string]bool
+	role              string
 	loadedModel       map[naming.ModelFullName]protobuf.ModelStatus
+	// If positive, the maximum number of models the server can have.
+	maxModels int
+	// Memory held back for specific models, and the total compute share reserved for them.
+	reservedMemory map[naming.ModelFullName]int64
+	reservedShare  float64
 }
 
 // NewServerInfo constructs a ServerInfo based on the given model
@@ -48,6 +59,18 @@ func NewServerInfo(serverSpec *protobuf.ModelServer) *ServerInfo {
 		servableModelPath: []ParamPath{},
 		tags:              make(map[string]bool),
 		loadedModel:       make(map[naming.ModelFullName]protobuf.ModelStatus),
+		maxModels:         int(serverSpec.MaxModels),
+		reservedMemory:    make(map[naming.ModelFullName]int64),
+		role:              serverSpec.Role,
+	}
+	for _, r := range serverSpec.Reservations {
+		name, err := naming.NewModelFullName(r.ModelID)
+		if err != nil {
+			log.Warningf("Ignoring reservation for invalid model ID %q: %v", r.ModelID, err)
+			continue
+		}
+		s.reservedMemory[name] += r.MinMemoryBytes
+		s.reservedShare += r.MinComputeShare
 	}
 	for _, path := range serverSpec.ServableModelPaths {
 		s.servableModelPath = append(s.servableModelPath, ParamPath(path))
@@ -70,6 +93,7 @@ type ModelInfo struct {
 	neededReplicas int
 	memoryRequired int64
 	constraints    []string
+	role           string
 }
 
 // NewModelInfo constructs a ModelInfo given a model definition.
@@ -79,9 +103,19 @@ func NewModelInfo(spec *apb.Model) *ModelInfo {
 		neededReplicas: int(spec.GetRequestedNumReplicas()),
 		memoryRequired: utils.GetMemoryRequired(spec),
 		constraints:    utils.GetConstraints(spec),
+		role:           spec.GetRole(),
 	}
 }
 
+// Shortfall describes requested replicas of a model the assigner could not place.
+type Shortfall struct {
+	// The number of requested replicas left unassigned.
+	Missing int
+	// The number of servers with enough memory for the model, and meeting its constraints, that
+	// were skipped because they already had their maximum number of models.
+	FullServers int
+}
+
 // Action represents an intention, either load or unload a model onto or from a server.
 type Action struct {
 	Addr  ServerAddr
@@ -119,15 +153,23 @@ type Assigner struct {
 	// Actions to take.
 	toLoad   []Action
 	toUnload []Action
+
+	// Models with fewer replicas than needed after assignment.
+	shortfalls map[naming.ModelFullName]Shortfall
+
+	// Why each server in toLoad was picked for its model.
+	rationale map[naming.ModelFullName]map[ServerAddr]string
 }
 
 // New constructs an Assigner object.
 func New() *Assigner {
 	a := &Assigner{
-		servers:  make(map[ServerAddr]*ServerInfo),
-		models:   make(map[naming.ModelFullName]*ModelInfo),
-		params:   make(map[ParamPath][]ServerAddr),
-		assigned: make(map[naming.ModelFullName][]ServerAddr),
+		servers:    make(map[ServerAddr]*ServerInfo),
+		models:     make(map[naming.ModelFullName]*ModelInfo),
+		params:     make(map[ParamPath][]ServerAddr),
+		assigned:   make(map[naming.ModelFullName][]ServerAddr),
+		shortfalls: make(map[naming.ModelFullName]Shortfall),
+		rationale:  make(map[naming.ModelFullName]map[ServerAddr]string),
 	}
 	return a
 }
@@ -152,13 +194,16 @@ func (a *Assigner) AddModel(name naming.ModelFullName, minfo *ModelInfo) {
 // given server and model information.
 func (a *Assigner) Assign() {
 	// Compute which servers have been assigned to serve a model, for
-	// every model.
+	// every model, and how many models each server keeps.
+	numModels := make(map[ServerAddr]int)
 	for addr, server := range a.servers {
 		for loaded := range server.loadedModel {
 			a.assigned[loaded] = append(a.assigned[loaded], addr)
 			if _, ok := a.models[loaded]; !ok {
 				// The model has been unpublished.
 				a.toUnload = append(a.toUnload, Action{addr, loaded})
+			} else {
+				numModels[addr]++
 			}
 		}
 	}
@@ -199,8 +244,10 @@ func (a *Assigner) Assign() {
 		}
 	}
 
-	// Computes how much memory available in each server.
+	// Computes how much memory available in each server. Memory reserved for a model and not yet
+	// used by it is held back from other models, in heldMem.
 	availMem := make(map[ServerAddr]int64)
+	heldMem := make(map[ServerAddr]map[naming.ModelFullName]int64)
 	for addr, server := range a.servers {
 		avail := server.memoryCapacity
 		for loaded := range server.loadedModel {
@@ -211,6 +258,16 @@ func (a *Assigner) Assign() {
 				// about to be unloaded.
 			}
 		}
+		heldMem[addr] = make(map[naming.ModelFullName]int64)
+		for name, reserved := range server.reservedMemory {
+			if _, ok := server.loadedModel[name]; ok {
+				reserved -= reqMem[name]
+			}
+			if reserved > 0 {
+				heldMem[addr][name] = reserved
+				avail -= reserved
+			}
+		}
 		availMem[addr] = avail
 	}
 
@@ -233,6 +290,7 @@ func (a *Assigner) Assign() {
 			last := len(addrs) - 1
 			addr := addrs[last]
 			a.toUnload = append(a.toUnload, Action{addr, name})
+			numModels[addr]--
 			addrs = addrs[:last]
 		}
 
@@ -250,12 +308,23 @@ func (a *Assigner) Assign() {
 			availMem int64
 		}
 		candidates := []*serverMemItem{}
+		fullServers := 0
 		for _, addr := range a.params[model.modelPath] {
-			avail := availMem[addr]
+			server := a.servers[addr]
+			// Servers of other roles are in a separate pool.
+			if server.role != model.role {
+				continue
+			}
+			// Only the model a reservation is for may use its held memory, and models without a
+			// reservation need some compute share left.
+			_, reserved := server.reservedMemory[name]
+			if !reserved && server.reservedShare >= 1 {
+				continue
+			}
+			avail := availMem[addr] + heldMem[addr][name]
 			if required > avail {
 				continue
 			}
-			server := a.servers[addr]
 			// All constraints need to be met by tags of the server.
 			constrainsMet := true
 			for _, tag := range model.constraints {
@@ -264,24 +333,56 @@ func (a *Assigner) Assign() {
 					break
 				}
 			}
-			if constrainsMet {
-				candidates = append(candidates, &serverMemItem{
-					addr:     addr,
-					availMem: avail,
-				})
+			if !constrainsMet {
+				continue
+			}
+			if server.maxModels > 0 && numModels[addr] >= server.maxModels {
+				fullServers++
+				continue
 			}
+			candidates = append(candidates, &serverMemItem{
+				addr:     addr,
+				availMem: avail,
+			})
 		}
 		sort.Slice(candidates, func(i int, j int) bool {
-			return candidates[i].availMem > candidates[j].availMem
+			if candidates[i].availMem != candidates[j].availMem {
+				return candidates[i].availMem > candidates[j].availMem
+			}
+			return candidates[i].addr < candidates[j].addr
 		})
+		var scores []string
+		for i, item := range candidates {
+			if i == maxScoredCandidates {
+				scores = append(scores, fmt.Sprintf("%d more", len(candidates)-i))
+				break
+			}
+			scores = append(scores, fmt.Sprintf("%s=%d", item.addr, item.availMem))
+		}
+		numCandidates := len(candidates)
 
 		n := model.neededReplicas - len(addrs)
 		if n < len(candidates) {
 			candidates = candidates[:n]
 		}
-		for _, item := range candidates {
+		if len(candidates) > 0 {
+			a.rationale[name] = make(map[ServerAddr]string, len(candidates))
+		}
+		for i, item := range candidates {
 			a.toLoad = append(a.toLoad, Action{item.addr, name})
-			availMem[item.addr] -= required
+			a.rationale[name][item.addr] = fmt.Sprintf("assigner: ranked %d of %d candidates by available memory, %d bytes needed; scores: %s", i+1, numCandidates, required, strings.Join(scores, ", "))
+			held := heldMem[item.addr][name]
+			if required > held {
+				availMem[item.addr] -= required - held
+			}
+			delete(heldMem[item.addr], name)
+			numModels[item.addr]++
+		}
+		if missing := n - len(candidates); missing > 0 {
+			a.shortfalls[name] = Shortfall{Missing: missing, FullServers: fullServers}
+			if fullServers > 0 {
+				log.Warningf("Model %s is short of %d replicas, with %d servers at their maximum number of models", name, missing, fullServers)
+			}
 		}
 	}
 
@@ -326,6 +427,17 @@ func (a *Assigner) GetToLoad() []Action { return a.toLoad }
 // GetToUnload returns a list of actions for unloading models from servers.
 func (a *Assigner) GetToUnload() []Action { return a.toUnload }
 
+// GetShortfalls returns the models that have fewer replicas than needed in the assignment.
+func (a *Assigner) GetShortfalls() map[naming.ModelFullName]Shortfall {
+	return a.shortfalls
+}
+
+// GetRationale returns why each server in GetToLoad was picked for its model, with the available
+// memory of the best candidate servers.
+func (a *Assigner) GetRationale() map[naming.ModelFullName]map[ServerAddr]string {
+	return a.rationale
+}
+
 // GetAssignment returns a model assignment (i.e., model -> server
 // lists) after the computed load and unload actions are executed.
 func (a *Assigner) GetAssignment() map[naming.ModelFullName][]ServerAddr {
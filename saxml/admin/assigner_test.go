@@ -278,3 +278,155 @@ ToLoad
 		}
 	}
 }
+
+func TestMaxModelsPerServer(t *testing.T) {
+	a := New()
+	for _, addr := range []string{"s0", "s1"} {
+		a.AddServer(ServerAddr(addr), &ServerInfo{
+			memoryCapacity:    64 << 30,
+			servableModelPath: []ParamPath{"p0"},
+			loadedModel:       map[naming.ModelFullName]protobuf.ModelStatus{},
+			maxModels:         2,
+		})
+	}
+	for _, name := range []string{"m0", "m1", "m2"} {
+		addModel(t, a, &modelCase{name, "p0", 2, 4})
+	}
+	a.Assign()
+
+	// Memory allows all replicas everywhere, but each server takes only 2 models.
+	perServer := map[ServerAddr]int{}
+	for _, act := range a.GetToLoad() {
+		perServer[act.Addr]++
+	}
+	for addr, n := range perServer {
+		if n > 2 {
+			t.Errorf("Server %s got %d models, want at most 2", addr, n)
+		}
+	}
+	if len(a.GetToLoad()) != 4 {
+		t.Errorf("Assign() loads %d replicas, want 4:\n%s", len(a.GetToLoad()), report(a))
+	}
+
+	// The last model in assignment order, m2, is the one left out.
+	want := map[naming.ModelFullName]Shortfall{
+		naming.NewModelFullNameT(t, "test", "m2"): {Missing: 2, FullServers: 2},
+	}
+	if got := a.GetShortfalls(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("GetShortfalls() = %v, want %v", got, want)
+	}
+}
+
+func TestRationale(t *testing.T) {
+	a := New()
+	for addr, capGB := range map[string]int64{"s0": 16, "s1": 32, "s2": 32} {
+		a.AddServer(ServerAddr(addr), &ServerInfo{
+			memoryCapacity:    capGB << 30,
+			servableModelPath: []ParamPath{"p0"},
+			loadedModel:       map[naming.ModelFullName]protobuf.ModelStatus{},
+		})
+	}
+	addModel(t, a, &modelCase{"m0", "p0", 2, 4})
+	a.Assign()
+
+	// The servers with the most available memory win, ties broken by address.
+	scores := fmt.Sprintf("s1=%d, s2=%d, s0=%d", int64(32)<<30, int64(32)<<30, int64(16)<<30)
+	want := map[naming.ModelFullName]map[ServerAddr]string{
+		naming.NewModelFullNameT(t, "test", "m0"): {
+			"s1": fmt.Sprintf("assigner: ranked 1 of 3 candidates by available memory, %d bytes needed; scores: %s", int64(4)<<30, scores),
+			"s2": fmt.Sprintf("assigner: ranked 2 of 3 candidates by available memory, %d bytes needed; scores: %s", int64(4)<<30, scores),
+		},
+	}
+	if got := a.GetRationale(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("GetRationale() = %v, want %v", got, want)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	m0 := naming.NewModelFullNameT(t, "test", "m0")
+	m1 := naming.NewModelFullNameT(t, "test", "m1")
+	tests := []struct {
+		desc     string
+		server   *ServerInfo
+		models   []modelCase
+		wantLoad string
+	}{
+		{
+			desc: "reserved memory held back",
+			server: &ServerInfo{
+				reservedMemory: map[naming.ModelFullName]int64{m0: 40 << 30},
+			},
+			models:   []modelCase{{"m0", "p0", 1, 30}, {"m1", "p0", 1, 32}},
+			wantLoad: "s0: m0\n",
+		},
+		{
+			desc: "unused part of a loaded model's reservation held back",
+			server: &ServerInfo{
+				loadedModel:    map[naming.ModelFullName]protobuf.ModelStatus{m0: protobuf.Loaded},
+				reservedMemory: map[naming.ModelFullName]int64{m0: 40 << 30},
+			},
+			models:   []modelCase{{"m0", "p0", 1, 30}, {"m1", "p0", 1, 32}},
+			wantLoad: "",
+		},
+		{
+			desc: "unreserved memory still usable",
+			server: &ServerInfo{
+				loadedModel:    map[naming.ModelFullName]protobuf.ModelStatus{m0: protobuf.Loaded},
+				reservedMemory: map[naming.ModelFullName]int64{m0: 40 << 30},
+			},
+			models:   []modelCase{{"m0", "p0", 1, 30}, {"m1", "p0", 1, 20}},
+			wantLoad: "s0: m1\n",
+		},
+		{
+			desc: "all compute reserved",
+			server: &ServerInfo{
+				reservedMemory: map[naming.ModelFullName]int64{m0: 0},
+				reservedShare:  1,
+			},
+			models:   []modelCase{{"m0", "p0", 1, 4}, {"m1", "p0", 1, 4}},
+			wantLoad: "s0: m0\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			a := New()
+			tc.server.memoryCapacity = 64 << 30
+			tc.server.servableModelPath = []ParamPath{"p0"}
+			if tc.server.loadedModel == nil {
+				tc.server.loadedModel = map[naming.ModelFullName]protobuf.ModelStatus{}
+			}
+			a.AddServer("s0", tc.server)
+			for _, m := range tc.models {
+				addModel(t, a, &m)
+			}
+			a.Assign()
+			if got := reportActions(a.GetToLoad()); got != tc.wantLoad {
+				t.Errorf("GetToLoad() = %q, want %q:\n%s", got, tc.wantLoad, report(a))
+			}
+			wantShort := !strings.Contains(tc.wantLoad, "m1")
+			if _, short := a.GetShortfalls()[m1]; short != wantShort {
+				t.Errorf("m1 in GetShortfalls() = %v, want %v", short, wantShort)
+			}
+		})
+	}
+}
+
+func TestNewServerInfoReservations(t *testing.T) {
+	s := NewServerInfo(&protobuf.ModelServer{
+		Reservations: []protobuf.Reservation{
+			{ModelID: "/sax/test/m0", MinMemoryBytes: 1 << 30, MinComputeShare: 0.25},
+			{ModelID: "/sax/test/m1", MinComputeShare: 0.5},
+		},
+	})
+	m0 := naming.NewModelFullNameT(t, "test", "m0")
+	m1 := naming.NewModelFullNameT(t, "test", "m1")
+	if got := s.reservedMemory[m0]; got != 1<<30 {
+		t.Errorf("reservedMemory[m0] = %d, want %d", got, 1<<30)
+	}
+	if got, ok := s.reservedMemory[m1]; !ok || got != 0 {
+		t.Errorf("reservedMemory[m1] = %d, %v, want 0, true", got, ok)
+	}
+	if s.reservedShare != 0.75 {
+		t.Errorf("reservedShare = %v, want 0.75", s.reservedShare)
+	}
+}
@@ -17,8 +17,11 @@ package mgr
 
 import (
 	"context"
+	"expvar"
 	"fmt"
+	"math"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,18 +33,26 @@ import (
 	"saxml/admin/protobuf"
 	"saxml/admin/state"
 	"saxml/admin/validator"
+	"saxml/common/auditlog"
 	"saxml/common/errors"
 	"saxml/common/eventlog"
 	"saxml/common/naming"
 	"saxml/common/platform/env"
+	"saxml/common/transport"
 	"saxml/common/waitable"
 	"saxml/common/watchable"
 
 	apb "saxml/protobuf/admin_go_proto_grpc"
 	cpb "saxml/protobuf/common_go_proto"
 	mpb "saxml/protobuf/modelet_go_proto_grpc"
+	mgrpc "saxml/protobuf/modelet_go_proto_grpc"
 )
 
+// ErrRecovering is returned by Join while Start is restoring the state. The admin server reports
+// it to model servers in JoinResponse.recovering rather than as an error, so that they can tell it
+// apart from an admin server that is unavailable.
+var ErrRecovering = fmt.Errorf("admin server is recovering its state, retry later: %w", errors.ErrUnavailable)
+
 var (
 	// The interval between consecutive Refresh calls, which reassign models to model servers.
 	refreshPeriod = time.Second * 10
@@ -50,7 +61,82 @@ var (
 	// This should be longer than refreshPeriod in the state package.
 	pruneTimeout = time.Second * 25
 
+	// The maximum number of model servers EvacuateLabel drains at a time.
+	evacuateBatchSize = 2
+
+	// The interval at which EvacuateLabel checks whether models have moved off a batch.
+	evacuatePollPeriod = time.Second
+
+	// How long to keep moving models off a model server that reports it is shutting down.
+	shutdownDrainTimeout = time.Minute * 10
+
+	// How long to wait for a model server to accept a rejoin prompt.
+	promptRejoinTimeout = time.Second * 10
+
+	// How long to remember why a model server was removed after its removal.
+	evictionRetention = time.Hour
+
+	// A model server address evicted as unresponsive flapThreshold times within flapWindow is
+	// oscillating between joining and eviction, e.g. because this admin server can't reach a model
+	// server that can reach it. Each further eviction of the address is then put off by
+	// flapBackoff, doubling with every eviction up to flapMaxBackoff, to keep membership stable.
+	flapWindow     = time.Hour
+	flapThreshold  = 3
+	flapBackoff    = time.Minute
+	flapMaxBackoff = time.Minute * 15
+
+	// Latency autoscaling recommends one fewer replica for a model once its p99 latency is under
+	// this fraction of its target.
+	autoscaleDownFraction float32 = 0.5
+
+	// How often at most latency autoscaling changes the replicas of a model. Model servers report
+	// latency over the past minute, so changing more often would react to stale latency.
+	autoscaleCooldown = time.Minute
+
+	// How long a Registry call may take before it is abandoned.
+	registryTimeout = time.Second * 10
+
+	// The most membership changes waiting to be mirrored into a Registry. Changes beyond this are
+	// dropped rather than holding up joins and pruning.
+	registryQueueSize = 1024
+
+	// How often the model servers joined are registered again, and the ones gone deregistered, to
+	// repair membership changes a Registry missed because calls failed or were dropped.
+	registryReconcilePeriod = time.Minute * 5
+
+	// The number of candidate model servers a placement rationale lists.
+	maxRationaleCandidates = 5
+
+	// How long a Join waits for one of the --sax_admin_max_join_work slots before it is shed.
+	joinWorkWait = time.Second * 2
+
 	expAssigner = flag.Bool("sax_admin_exp_assigner", false, "If true, experiments the assigner implementation.")
+
+	placementRationale = flag.Bool("sax_admin_placement_rationale", false, "If true, records why each model server was assigned its model, shown by List.")
+
+	maxJoinWork = flag.Int("sax_admin_max_join_work", 64, "If positive, the most Joins of new or replaced model servers processed at a time. Each opens a connection and a GetStatus stream to the model server. Joins beyond this wait briefly, then fail with a retryable error.")
+
+	auditLogSize = flag.Int("sax_admin_audit_log_size", 10000, "The number of recent events, such as publishes and placement constraint overrides, kept for ExportAudit.")
+
+	refuseAllDraining = flag.Bool("sax_admin_refuse_all_draining", false, "If true, EvacuateLabel and DrainServer refuse to drain model servers holding the last replicas of a model outside draining servers. If false, they drain them and raise an alert, keeping them serving until replacements load.")
+
+	// Model server membership, exported for dashboards that scrape /debug/vars.
+	joinedServersVar = expvar.NewInt("sax_admin_joined_servers")
+	lastJoinTimeVar  = expvar.NewString("sax_admin_last_join_time")
+	// The number of model server addresses oscillating between joining and eviction.
+	oscillatingServersVar = expvar.NewInt("sax_admin_oscillating_servers")
+
+	// The number of models whose every replica is on a draining model server, exported for alerts.
+	allDrainingModelsVar = expvar.NewInt("sax_admin_all_draining_models")
+
+	// The number of membership changes that failed to be mirrored into the Registry or were dropped.
+	registryErrorsVar = expvar.NewInt("sax_admin_registry_errors")
+
+	// The number of Joins turned away because --sax_admin_max_join_work were already in progress.
+	shedJoinsVar = expvar.NewInt("sax_admin_shed_joins")
+
+	// Starts the connection to a newly joined model server. Tests replace it to observe Join work.
+	startModeletState = func(ctx context.Context, s *modeletState, m *Mgr) error { return s.Start(ctx, m) }
 )
 
 // SetOptionsForTesting updates refreshPeriod and pruneTimeout for tests.
@@ -68,6 +154,78 @@ type modelFullName = naming.ModelFullName
 // modeletAddr locates a model server in the form of <ip>:<port>.
 type modeletAddr string
 
+// EvictionReason tells why a model server was removed.
+type EvictionReason int
+
+const (
+	// EvictedUnresponsive means the model server sent no valid status for too long.
+	EvictedUnresponsive EvictionReason = iota
+	// EvictedShutDown means the model server went away after reporting it was shutting down.
+	EvictedShutDown
+	// EvictedEvacuated means the model server went away after EvacuateLabel drained it.
+	EvictedEvacuated
+	// EvictedReplaced means another model server joined from the same address with other specs.
+	EvictedReplaced
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictedUnresponsive:
+		return "unresponsive"
+	case EvictedShutDown:
+		return "shut down"
+	case EvictedEvacuated:
+		return "evacuated"
+	case EvictedReplaced:
+		return "replaced"
+	}
+	return "unknown"
+}
+
+// Eviction records when and why a model server was removed.
+type Eviction struct {
+	Time   time.Time
+	Reason EvictionReason
+}
+
+// Oscillation describes a model server address that keeps being evicted as unresponsive and
+// rejoining.
+type Oscillation struct {
+	Addr string
+	// The number of unresponsive evictions in the last flapWindow.
+	Evictions int
+	// How much longer than usual the model server at Addr may stay unresponsive before it is evicted
+	// again.
+	Backoff time.Duration
+}
+
+// Registry mirrors model server membership into an external service registry, such as Consul or
+// etcd, so that existing infrastructure can discover Sax model servers. The manager makes calls
+// one at a time, in the order model servers join and leave, away from the Join and pruning paths.
+// Failed calls are logged and counted, and never affect the manager's own membership. The
+// registry is periodically reconciled with the model servers joined, so calls must be idempotent.
+type Registry interface {
+	// Register adds the model server at addr, or updates it if it rejoined with other specs.
+	Register(ctx context.Context, addr string, specs *apb.ModelServer) error
+	// Deregister removes the model server at addr.
+	Deregister(ctx context.Context, addr string) error
+}
+
+// registryOp is a membership change to mirror into a Registry. specs is nil for removals.
+type registryOp struct {
+	addr  modeletAddr
+	specs *apb.ModelServer
+}
+
+// incarnation records a model server process that joined from an address.
+type incarnation struct {
+	joined  time.Time
+	version string
+	// The zero time while the process is the model server joined from the address.
+	removed time.Time
+	reason  EvictionReason
+}
+
 // modelState tracks a model's server assignment.
 type modelState struct {
 	// specs is the proto definition of the model.
@@ -78,6 +236,116 @@ type modelState struct {
 
 	// waiter keeps track of requests waiting for certain numbers of replicas to be ready.
 	waiter *waitable.Waitable
+
+	// If positive, the requested number of replicas held back from specs until approved.
+	pendingReplicas int32
+
+	// The placement constraint override in effect, if any. See OverrideConstraints.
+	override *apb.ConstraintOverride
+
+	// Model servers the model was placed on only because of an override. When the override
+	// expires, the model is moved off them.
+	relaxed map[modeletAddr]bool
+
+	// Model servers that asked to have the model moved off them. The model is not placed on them
+	// again until they are pruned. Kept in the backing store, as servers don't report requests
+	// for models they no longer have.
+	avoid map[modeletAddr]bool
+
+	// The staged rollout under way, if any. See PublishStaged.
+	rollout *apb.Rollout
+
+	// The temporary replica increase in effect, if any. See SetReplicasFor.
+	bump *apb.ReplicaBump
+
+	// Data addresses of model servers loading or having loaded the model: those in addrWatcher,
+	// which clients send requests to, and those of warm pool replicas held back from clients.
+	active  map[string]bool
+	standby map[string]bool
+
+	// The number of warm pool replicas promoted to serve traffic. See PromoteWarm.
+	promoted int32
+
+	// When latency autoscaling last changed the requested replicas. See autoscaleByLatency.
+	autoscaled time.Time
+}
+
+// placedReplicas returns the number of model servers to place a model on: its requested replicas,
+// and if it has a warm pool, its promoted replicas and a full warm pool behind them.
+func placedReplicas(model *modelState) int {
+	return int(model.specs.GetRequestedNumReplicas() + warmReplicas(model.specs, model.promoted))
+}
+
+// warmReplicas returns the number of replicas a model with specs and promoted replicas places
+// beyond those it requests: the promoted replicas and a full warm pool behind them.
+func warmReplicas(specs *apb.Model, promoted int32) int32 {
+	if warm := specs.GetWarmPoolSize(); warm > 0 {
+		return promoted + warm
+	}
+	return 0
+}
+
+// addAddr records that the model server at dataAddr is loading or has loaded the model. It serves
+// clients, unless the model has enough serving replicas and keeps it in the warm pool.
+func (model *modelState) addAddr(dataAddr string) {
+	if model.active[dataAddr] || model.standby[dataAddr] {
+		return
+	}
+	if model.standby == nil {
+		model.standby = map[string]bool{}
+	}
+	model.standby[dataAddr] = true
+	model.rebalance()
+}
+
+// delAddr records that the model server at dataAddr no longer has the model, promoting a warm
+// pool replica in its place if it was serving.
+func (model *modelState) delAddr(dataAddr string) {
+	if model.active[dataAddr] {
+		delete(model.active, dataAddr)
+		model.addrWatcher.Del(dataAddr)
+	}
+	delete(model.standby, dataAddr)
+	model.rebalance()
+}
+
+// rebalance promotes warm pool replicas while the model has fewer serving replicas than requested
+// plus promoted, and demotes serving replicas to the warm pool while it has more. Models without a
+// warm pool serve from all their replicas.
+func (model *modelState) rebalance() {
+	if model.active == nil {
+		model.active = map[string]bool{}
+	}
+	target := len(model.active) + len(model.standby)
+	if model.specs.GetWarmPoolSize() > 0 {
+		target = int(model.specs.GetRequestedNumReplicas() + model.promoted)
+	}
+	// Pick replicas in address order, so that the same ones are promoted and demoted every time.
+	for len(model.active) < target && len(model.standby) > 0 {
+		addr := sortedAddrs(model.standby)[0]
+		delete(model.standby, addr)
+		model.active[addr] = true
+		model.addrWatcher.Add(addr)
+	}
+	for len(model.active) > target {
+		addrs := sortedAddrs(model.active)
+		addr := addrs[len(addrs)-1]
+		delete(model.active, addr)
+		if model.standby == nil {
+			model.standby = map[string]bool{}
+		}
+		model.standby[addr] = true
+		model.addrWatcher.Del(addr)
+	}
+}
+
+func sortedAddrs(addrs map[string]bool) []string {
+	sorted := make([]string, 0, len(addrs))
+	for addr := range addrs {
+		sorted = append(sorted, addr)
+	}
+	sort.Strings(sorted)
+	return sorted
 }
 
 // modeletState synchronizes state with the model server.
@@ -105,9 +373,54 @@ type Mgr struct {
 	// join and leave, this map is not kept in sync. Therefore, it should only be used by List* and
 	// Locate* methods for human usage or status page display.
 	assignment map[modelFullName][]modeletAddr
+	// Why each model server in assignment was assigned the model. Only kept if
+	// --sax_admin_placement_rationale is set.
+	rationale map[modelFullName]map[modeletAddr]string
+	// When each model server in assignment was assigned the model.
+	assignedAt map[modelFullName]map[modeletAddr]time.Time
 	// Recently unpublished model full names. They still have pending load/unload ops.
 	// Models cannot be published under any name inside until it's removed from this set.
 	pendingUnpublished map[modelFullName]bool
+	// Model servers being or having been evacuated. They get no new models, and models they have
+	// are placed on other model servers as if they had left. Entries are removed when pruned.
+	cordoned map[modeletAddr]bool
+	// The fraction of client traffic shifted away from partially drained model servers, by address.
+	// Kept for model servers that leave, so a drain outlives restarts. See DrainServer.
+	drains map[modeletAddr]float32
+	// If positive, replica increases beyond this baseline need approval. See holdScaleUpLocked.
+	scaleApprovalBaseline int
+	// If positive, the most models and total requested replicas that can be published.
+	maxModels   int
+	maxReplicas int
+	// How ComputeAssignment picks the replica to drop when a model has too many.
+	scaleDownStrategy apb.Config_ScaleDownStrategy
+	// Windows during which disruptive operations are refused unless forced.
+	blackouts []*apb.BlackoutWindow
+	// Whether Start is restoring the state from the backing store. Joins are turned away meanwhile,
+	// so that a model server's models are matched against the restored models.
+	recovering bool
+	// Addresses of model servers turned away while recovering. Start prompts them to rejoin once
+	// recovery is done, rather than leaving them out until their next periodic join.
+	turnedAway map[modeletAddr]bool
+	// When model servers were pruned in the last evictionRetention, by address. A model server
+	// joining from one of them again is a new incarnation, likely still warming up.
+	pruned map[modeletAddr]time.Time
+	// The most recent removal of model servers removed in the last evictionRetention, by address.
+	evicted map[modeletAddr]Eviction
+	// When model servers were evicted as unresponsive in the last flapWindow, by address, oldest
+	// first.
+	flaps map[modeletAddr][]time.Time
+	// The model server processes that joined from each address, oldest first. The last one is
+	// current while the address is in modelets; the rest are stale until PurgeStaleIncarnations.
+	incarnations map[modeletAddr][]*incarnation
+	// Membership changes waiting to be mirrored into the registry set by SetRegistry, nil if none.
+	registryOps chan registryOp
+	// Slots for Joins of new or replaced model servers in progress, nil if unbounded.
+	joinWork chan struct{}
+	// Bounds model loads running at once across all model servers. Immutable.
+	loadLimiter *state.LoadLimiter
+	// Which connections to model servers the cell allows.
+	transportSecurity apb.Config_TransportSecurity
 
 	// The backing store of this admin server's state.
 	store Store
@@ -117,10 +430,18 @@ type Mgr struct {
 	tickerStop chan bool
 
 	eventLogger eventlog.Logger
+	// Keeps the recent events logged to eventLogger for ExportAudit.
+	audit *auditlog.Logger
 }
 
 // Publish publishes a model.
 func (m *Mgr) Publish(specs *apb.Model) error {
+	return m.publish(specs, nil)
+}
+
+// publish publishes a model. If options has fewer canary replicas than the model requests, the
+// model starts with the canary replicas and keeps the rest staged.
+func (m *Mgr) publish(specs *apb.Model, options *apb.RolloutOptions) error {
 	fullName, err := naming.NewModelFullName(specs.GetModelId())
 	if err != nil {
 		return err
@@ -135,23 +456,55 @@ func (m *Mgr) Publish(specs *apb.Model) error {
 	if _, ok := m.pendingUnpublished[fullName]; ok {
 		return fmt.Errorf("model %s is being unpublished, please retry later: %w", fullName, errors.ErrAlreadyExists)
 	}
+	if m.maxModels > 0 && len(m.models) >= m.maxModels {
+		return fmt.Errorf("cannot publish model %s, the cell already has %d published models, its quota: %w", fullName, len(m.models), errors.ErrResourceExhausted)
+	}
+	if err := m.checkReplicaQuotaLocked(fullName, 0, specs.GetRequestedNumReplicas()+warmReplicas(specs, 0)); err != nil {
+		return err
+	}
 	log.Infof("Published with overrides: %v", specs.GetOverrides())
 
 	specsWithUUID := proto.Clone(specs).(*apb.Model)
 	specsWithUUID.Uuid = uuid.NewRandom()
-	m.models[fullName] = &modelState{
+	model := &modelState{
 		specs:       specsWithUUID,
 		addrWatcher: watchable.New(),
 		waiter:      waitable.New(),
 	}
+	if requested, canaries := specs.GetRequestedNumReplicas(), options.GetCanaryReplicas(); canaries > 0 && canaries < requested {
+		log.Infof("Staging model %s at %d canary replicas out of %d", fullName, canaries, requested)
+		specsWithUUID.RequestedNumReplicas = canaries
+		model.rollout = newRollout(requested, options, nil)
+	}
+	m.holdScaleUpLocked(fullName, model, 0)
+	m.models[fullName] = model
 
 	m.eventLogger.Log(eventlog.Deploy, specsWithUUID)
 
 	return nil
 }
 
-// Update updates a model.
-func (m *Mgr) Update(fullName modelFullName, newSpecs *apb.Model) error {
+// Update updates a model. Lowering the number of replicas is refused during blackout windows,
+// unless forced. During a staged rollout, the model keeps its canary replicas, and the new number
+// of replicas replaces the one staged behind them.
+func (m *Mgr) Update(fullName modelFullName, newSpecs *apb.Model, force bool) error {
+	return m.update(fullName, newSpecs, nil, force)
+}
+
+// UpdateStaged updates a model like Update, but rolls a replica increase out in stages like
+// PublishStaged: the model first gets at most options.canary_replicas replicas on top of those it
+// has, and the rest only once all of them have stayed loaded for options.soak_ms. If the rollout
+// halts, the model definition before the update is restored. An update adding no more replicas
+// than the canaries applies right away.
+func (m *Mgr) UpdateStaged(fullName modelFullName, newSpecs *apb.Model, options *apb.RolloutOptions, force bool) error {
+	if err := validateRolloutOptions(options); err != nil {
+		return err
+	}
+	return m.update(fullName, newSpecs, options, force)
+}
+
+// update updates a model, staging a replica increase behind canary replicas if options is set.
+func (m *Mgr) update(fullName modelFullName, newSpecs *apb.Model, options *apb.RolloutOptions, force bool) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -159,33 +512,85 @@ func (m *Mgr) Update(fullName modelFullName, newSpecs *apb.Model) error {
 	if !ok {
 		return fmt.Errorf("model %s not found: %w", fullName, errors.ErrNotFound)
 	}
+	if existing.rollout != nil && options != nil {
+		return fmt.Errorf("model %s is already being rolled out, please retry later: %w", fullName, errors.ErrFailedPrecondition)
+	}
 	if err := validator.ValidateModelUpdate(existing.specs, newSpecs, fullName.CellFullName()); err != nil {
 		return fmt.Errorf("invalid model update: %w", err)
 	}
+	// During a rollout, the model has the replicas staged behind its canary replicas.
+	current := existing.specs.GetRequestedNumReplicas()
+	if existing.rollout != nil {
+		current = existing.rollout.GetStagedReplicas()
+	}
+	if wanted := newSpecs.GetRequestedNumReplicas(); wanted < current {
+		if err := m.checkBlackoutLocked(force, fmt.Sprintf("scaling model %s down from %d to %d replicas", fullName, current, wanted)); err != nil {
+			return err
+		}
+	}
+	wanted := newSpecs.GetRequestedNumReplicas() + warmReplicas(newSpecs, existing.promoted)
+	if err := m.checkReplicaQuotaLocked(fullName, requestedReplicas(existing), wanted); err != nil {
+		return err
+	}
 
 	// Copy UUID from existing model.
 	specsWithUUID := proto.Clone(newSpecs).(*apb.Model)
 	specsWithUUID.Uuid = existing.specs.Uuid
-	existing.specs = specsWithUUID
+	approved := existing.specs.GetRequestedNumReplicas()
+	requested := newSpecs.GetRequestedNumReplicas()
+	if existing.bump != nil && requested != approved {
+		log.Infof("Dropping the temporary replica increase of model %s replaced by %d replicas", fullName, requested)
+		existing.bump = nil
+	}
+	switch {
+	case existing.rollout != nil:
+		// The canary replicas stay until the rollout passes, unless fewer replicas are requested.
+		existing.rollout.StagedReplicas = requested
+		if requested > approved {
+			specsWithUUID.RequestedNumReplicas = approved
+		}
+		existing.specs = specsWithUUID
+	case options != nil && requested > approved+options.GetCanaryReplicas():
+		canaries := approved + options.GetCanaryReplicas()
+		log.Infof("Staging the update of model %s at %d replicas with canaries out of %d", fullName, canaries, requested)
+		existing.rollout = newRollout(requested, options, proto.Clone(existing.specs).(*apb.Model))
+		specsWithUUID.RequestedNumReplicas = canaries
+		existing.specs = specsWithUUID
+		existing.pendingReplicas = 0
+		m.holdScaleUpLocked(fullName, existing, approved)
+	default:
+		existing.specs = specsWithUUID
+		if requested != approved {
+			// A new replica count replaces any pending one.
+			existing.pendingReplicas = 0
+			m.holdScaleUpLocked(fullName, existing, approved)
+		}
+	}
+	m.updateServersLocked(fullName, newSpecs)
+	return nil
+}
 
+// updateServersLocked sends the new definition of a model to the model servers assigned it.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) updateServersLocked(fullName modelFullName, specs *apb.Model) {
 	addrs, ok := m.assignment[fullName]
 	if !ok {
 		// No server serving this model.
-		return nil
+		return
 	}
 	for _, addr := range addrs {
 		state, ok := m.modelets[modeletAddr(addr)]
 		if ok {
-			state.Update(fullName, newSpecs)
+			state.Update(fullName, specs)
 		} else {
 			log.Errorf("Failed to find the server for addr %v", addr)
 		}
 	}
-	return nil
 }
 
-// Unpublish unpublishes a model.
-func (m *Mgr) Unpublish(fullName modelFullName) error {
+// Unpublish unpublishes a model. It is refused during blackout windows, unless forced.
+func (m *Mgr) Unpublish(fullName modelFullName, force bool) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -193,25 +598,829 @@ func (m *Mgr) Unpublish(fullName modelFullName) error {
 	if !ok {
 		return fmt.Errorf("model %s not found: %w", fullName, errors.ErrNotFound)
 	}
+	if err := m.checkBlackoutLocked(force, fmt.Sprintf("unpublishing model %s", fullName)); err != nil {
+		return err
+	}
+	m.unpublishLocked(fullName, model)
+	return nil
+}
+
+// unpublishLocked removes a published model, to be unloaded from its model servers by Refresh.
+//
+// REQUIRES: m.mu is held for writing.
+func (m *Mgr) unpublishLocked(fullName modelFullName, model *modelState) {
 	m.pendingUnpublished[fullName] = true
 	delete(m.models, fullName)
 	model.addrWatcher.Close()
 	model.waiter.Close()
-	return nil
 }
 
 func (m *Mgr) makePublishedModelLocked(fullName modelFullName, model *apb.Model) *apb.PublishedModel {
 	addrs := []string{}
+	var assignedMs map[string]int64
+	var drains map[string]float32
 	for _, addr := range m.assignment[fullName] {
 		addrs = append(addrs, string(addr))
+		if f, ok := m.drains[addr]; ok {
+			if drains == nil {
+				drains = make(map[string]float32)
+			}
+			drains[string(addr)] = f
+		}
+		if at, ok := m.assignedAt[fullName][addr]; ok {
+			if assignedMs == nil {
+				assignedMs = make(map[string]int64)
+			}
+			assignedMs[string(addr)] = at.UnixMilli()
+		}
 	}
 	cloned := proto.Clone(model).(*apb.Model)
 	// Clean Uuid field to not expose it to users.
 	cloned.Uuid = nil
+	var pending int32
+	if state, ok := m.models[fullName]; ok {
+		pending = state.pendingReplicas
+	}
+	var rationale map[string]string
+	if reasons, ok := m.rationale[fullName]; ok {
+		rationale = make(map[string]string)
+		for addr, reason := range reasons {
+			rationale[string(addr)] = reason
+		}
+	}
+	var override *apb.ConstraintOverride
+	if state, ok := m.models[fullName]; ok && state.override != nil {
+		override = proto.Clone(state.override).(*apb.ConstraintOverride)
+	}
+	var bump *apb.ReplicaBump
+	if state, ok := m.models[fullName]; ok && state.bump != nil {
+		bump = proto.Clone(state.bump).(*apb.ReplicaBump)
+	}
+	var recommended int32
+	if state, ok := m.models[fullName]; ok {
+		recommended = m.recommendedReplicasLocked(fullName, state)
+	}
+	var rollout *apb.Rollout
+	if state, ok := m.models[fullName]; ok && state.rollout != nil {
+		rollout = proto.Clone(state.rollout).(*apb.Rollout)
+	}
 	return &apb.PublishedModel{
-		Model:            cloned,
-		ModeletAddresses: addrs,
+		Model:                  cloned,
+		ModeletAddresses:       addrs,
+		PendingNumReplicas:     pending,
+		PlacementRationale:     rationale,
+		ConstraintOverride:     override,
+		ReplicaBump:            bump,
+		AssignedMs:             assignedMs,
+		RecommendedNumReplicas: recommended,
+		Drains:                 drains,
+		Rollout:                rollout,
+	}
+}
+
+// SetScaleApprovalBaseline sets the number of replicas beyond which increases need approval
+// through ApproveScale. A non-positive baseline applies all increases immediately, but doesn't
+// approve increases already pending.
+func (m *Mgr) SetScaleApprovalBaseline(baseline int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scaleApprovalBaseline = baseline
+}
+
+// SetQuotas sets the most models and total requested replicas that can be published. Non-positive
+// values remove the corresponding quota. Models already published are kept even if they exceed
+// the new quotas.
+func (m *Mgr) SetQuotas(maxModels, maxReplicas int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxModels = maxModels
+	m.maxReplicas = maxReplicas
+}
+
+// SetMaxConcurrentLoads sets the most model loads that can run at once across all model servers,
+// so a rollout to many replicas reads the checkpoint from storage in waves instead of all at once.
+// Non-positive values remove the limit.
+func (m *Mgr) SetMaxConcurrentLoads(maxLoads int) {
+	m.loadLimiter.SetLimit(maxLoads)
+}
+
+// SetTransportSecurity sets which connections to model servers the cell allows. Connections
+// already made are kept.
+func (m *Mgr) SetTransportSecurity(policy apb.Config_TransportSecurity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transportSecurity = policy
+}
+
+func (m *Mgr) getTransportSecurity() apb.Config_TransportSecurity {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.transportSecurity
+}
+
+// SetBlackoutWindows sets the windows during which disruptive operations, such as EvacuateLabel,
+// Unpublish and scale-downs, are refused unless forced.
+func (m *Mgr) SetBlackoutWindows(windows []*apb.BlackoutWindow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blackouts = windows
+}
+
+// ActiveBlackout returns the blackout window in effect, or nil if there is none.
+func (m *Mgr) ActiveBlackout() *apb.BlackoutWindow {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.activeBlackoutLocked(time.Now())
+}
+
+// activeBlackoutLocked returns the blackout window that contains now, or nil if there is none.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) activeBlackoutLocked(now time.Time) *apb.BlackoutWindow {
+	nowMs := now.UnixMilli()
+	for _, window := range m.blackouts {
+		if window.GetStartMs() <= nowMs && nowMs < window.GetEndMs() {
+			return proto.Clone(window).(*apb.BlackoutWindow)
+		}
+	}
+	return nil
+}
+
+// checkBlackoutLocked returns a FailedPrecondition error if a blackout window is in effect and
+// the operation is not forced. op names the refused operation.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) checkBlackoutLocked(force bool, op string) error {
+	if force {
+		return nil
+	}
+	window := m.activeBlackoutLocked(time.Now())
+	if window == nil {
+		return nil
+	}
+	end := time.UnixMilli(window.GetEndMs()).UTC().Format(time.RFC3339)
+	return fmt.Errorf("%s refused during blackout until %s (%s): %w", op, end, window.GetReason(), errors.ErrFailedPrecondition)
+}
+
+// requestedReplicas returns the number of replicas a model counts against the replica quota: those
+// it requests, including an increase held pending approval or staged behind canary replicas, and
+// those of its warm pool.
+func requestedReplicas(model *modelState) int32 {
+	requested := model.specs.GetRequestedNumReplicas()
+	if model.pendingReplicas > requested {
+		requested = model.pendingReplicas
+	}
+	if staged := model.rollout.GetStagedReplicas(); staged > requested {
+		requested = staged
+	}
+	return requested + warmReplicas(model.specs, model.promoted)
+}
+
+// checkReplicaQuotaLocked returns a ResourceExhausted error if changing the number of replicas
+// requested by a model from current to wanted increases it past the total replica quota.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) checkReplicaQuotaLocked(fullName modelFullName, current, wanted int32) error {
+	if m.maxReplicas <= 0 || wanted <= current {
+		return nil
+	}
+	total := 0
+	for _, model := range m.models {
+		total += int(requestedReplicas(model))
+	}
+	if after := total - int(current) + int(wanted); after > m.maxReplicas {
+		return fmt.Errorf("cannot request %d replicas for model %s, the cell would have %d replicas, more than its quota of %d: %w", wanted, fullName, after, m.maxReplicas, errors.ErrResourceExhausted)
+	}
+	return nil
+}
+
+// SetScaleDownStrategy sets how to pick which replica to drop when a model has more replicas
+// than requested.
+func (m *Mgr) SetScaleDownStrategy(strategy apb.Config_ScaleDownStrategy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scaleDownStrategy = strategy
+}
+
+// replicaInfo describes a model replica considered for dropping.
+type replicaInfo struct {
+	// Whether the replica has finished loading.
+	loaded bool
+	// Requests per second the replica is serving, successful or not.
+	load float64
+	// The number of models on the replica's model server.
+	numModels int
+}
+
+// pickScaleDown returns the index of the replica to drop first according to strategy. Replicas
+// not yet loaded go first. Among equally good candidates, the last one is picked.
+func pickScaleDown(strategy apb.Config_ScaleDownStrategy, replicas []replicaInfo) int {
+	better := func(a, b replicaInfo) bool {
+		if a.loaded != b.loaded {
+			return !a.loaded
+		}
+		switch strategy {
+		case apb.Config_SCALE_DOWN_EMPTIEST_SERVER:
+			return a.numModels < b.numModels
+		default:
+			return a.load < b.load
+		}
+	}
+	picked := len(replicas) - 1
+	for i := picked - 1; i >= 0; i-- {
+		if better(replicas[i], replicas[picked]) {
+			picked = i
+		}
+	}
+	return picked
+}
+
+// replicaInfoLocked describes the replica of a model on a model server.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) replicaInfoLocked(fullName modelFullName, addr modeletAddr) replicaInfo {
+	modelet := m.modelets[addr]
+	info := replicaInfo{numModels: len(modelet.WantedModels())}
+	if seen, ok := modelet.SeenModels()[fullName]; ok {
+		info.loaded = seen.Info.Status == protobuf.Loaded
+		for _, stats := range seen.Info.Stats {
+			info.load += float64(stats.SuccessesPerSecond + stats.ErrorsPerSecond)
+		}
+	}
+	return info
+}
+
+// holdScaleUpLocked caps the requested number of replicas in a model's specs at the larger of
+// the scale approval baseline and approved, the number of replicas the model had, and holds the
+// rest of the request pending approval.
+//
+// REQUIRES: m.mu is held for writing.
+func (m *Mgr) holdScaleUpLocked(fullName modelFullName, model *modelState, approved int32) {
+	if m.scaleApprovalBaseline <= 0 {
+		return
+	}
+	limit := approved
+	if baseline := int32(m.scaleApprovalBaseline); baseline > limit {
+		limit = baseline
+	}
+	requested := model.specs.GetRequestedNumReplicas()
+	if requested <= limit {
+		return
 	}
+	log.Infof("Holding the increase of model %s from %d to %d replicas pending approval", fullName, limit, requested)
+	model.specs.RequestedNumReplicas = limit
+	model.pendingReplicas = requested
+}
+
+// ApproveScale applies the pending increase of the number of replicas of a model.
+func (m *Mgr) ApproveScale(fullName modelFullName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	model, ok := m.models[fullName]
+	if !ok {
+		return fmt.Errorf("model %s not found: %w", fullName, errors.ErrNotFound)
+	}
+	if model.pendingReplicas <= 0 {
+		return fmt.Errorf("model %s has no pending replica increase: %w", fullName, errors.ErrFailedPrecondition)
+	}
+	log.Infof("Approved the increase of model %s from %d to %d replicas", fullName, model.specs.GetRequestedNumReplicas(), model.pendingReplicas)
+	specs := proto.Clone(model.specs).(*apb.Model)
+	specs.RequestedNumReplicas = model.pendingReplicas
+	model.specs = specs
+	model.pendingReplicas = 0
+	return nil
+}
+
+// SetReplicasFor raises the number of replicas requested by a model to count for duration, e.g.
+// ahead of a scheduled load spike, and returns the bump with its expiration time set. When the
+// bump expires, Refresh restores the number of replicas the model had before, unless an update
+// has changed it since. Setting a bump while one is in effect replaces it, but keeps the number
+// of replicas to restore.
+//
+// The increase is subject to the replica quota, but not to scale approval. As the model scales
+// back down when the bump expires, SetReplicasFor is refused during blackout windows, unless
+// forced.
+func (m *Mgr) SetReplicasFor(fullName modelFullName, count int32, duration time.Duration, force bool) (*apb.ReplicaBump, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("bump duration %v must be positive: %w", duration, errors.ErrInvalidArgument)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	model, ok := m.models[fullName]
+	if !ok {
+		return nil, fmt.Errorf("model %s not found: %w", fullName, errors.ErrNotFound)
+	}
+	if model.rollout != nil || model.pendingReplicas > 0 {
+		return nil, fmt.Errorf("model %s has a replica increase under way, please retry later: %w", fullName, errors.ErrFailedPrecondition)
+	}
+	if err := m.checkBlackoutLocked(force, fmt.Sprintf("bumping the replicas of model %s", fullName)); err != nil {
+		return nil, err
+	}
+	current := model.specs.GetRequestedNumReplicas()
+	restore := current
+	if model.bump != nil {
+		restore = model.bump.GetRestoreNumReplicas()
+	}
+	if count <= restore {
+		return nil, fmt.Errorf("bump to %d replicas doesn't raise the %d replicas of model %s: %w", count, restore, fullName, errors.ErrInvalidArgument)
+	}
+	if err := m.checkReplicaQuotaLocked(fullName, current, count); err != nil {
+		return nil, err
+	}
+	specs := proto.Clone(model.specs).(*apb.Model)
+	specs.RequestedNumReplicas = count
+	model.specs = specs
+	model.bump = &apb.ReplicaBump{
+		NumReplicas:        count,
+		RestoreNumReplicas: restore,
+		ExpireMs:           time.Now().Add(duration).UnixMilli(),
+	}
+	log.Infof("Raising model %s from %d to %d replicas until %v", fullName, restore, count, time.UnixMilli(model.bump.GetExpireMs()))
+	return proto.Clone(model.bump).(*apb.ReplicaBump), nil
+}
+
+// DrainServer shifts a fraction of the client traffic of the model server at addr to other
+// replicas, for every model it serves, e.g. for load testing or gradual maintenance. Clients pick
+// a server drained by fraction f 1-f times as often as before. Fraction 1 drains the server fully,
+// unless no other replica can serve a call, and fraction 0 restores its full share.
+//
+// Clients watching the models of the server learn the new fraction right away. The drain is kept
+// in the backing store, and applies again if the server leaves and rejoins.
+//
+// Draining a server fully when it holds the last replicas of a model outside draining servers is
+// refused when --sax_admin_refuse_all_draining is set, and otherwise goes ahead with an alert.
+func (m *Mgr) DrainServer(addr string, fraction float32) error {
+	if fraction < 0 || fraction > 1 || math.IsNaN(float64(fraction)) {
+		return fmt.Errorf("drained fraction %v of %s not in [0, 1]: %w", fraction, addr, errors.ErrInvalidArgument)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := modeletAddr(addr)
+	if fraction == 1 {
+		if allDraining := m.allDrainingLocked(map[modeletAddr]bool{key: true}); len(allDraining) > 0 {
+			if *refuseAllDraining {
+				return fmt.Errorf("draining %s would leave models %v with every replica draining: %w", addr, allDraining, errors.ErrFailedPrecondition)
+			}
+			log.Errorf("ALERT: Draining %s leaves models %v with every replica draining; clients keep calling it as a last resort", addr, allDraining)
+		}
+	}
+	if fraction == 0 {
+		delete(m.drains, key)
+	} else {
+		m.drains[key] = fraction
+	}
+	log.Infof("Draining %v of the traffic of model server %s", fraction, addr)
+	modelet, ok := m.modelets[key]
+	if !ok {
+		return nil
+	}
+	// Clients learn drains along with added addresses, so announce the server again to every
+	// watcher of its models.
+	for _, model := range m.models {
+		if model.active[modelet.DataAddr] {
+			model.addrWatcher.Del(modelet.DataAddr)
+			model.addrWatcher.Add(modelet.DataAddr)
+		}
+	}
+	return nil
+}
+
+// PromoteWarm sets how many warm pool replicas of a model serve traffic on top of its requested
+// replicas, e.g. raising it as load rises, up to the size of the warm pool and within the replica
+// quota. Promoted replicas serve right away, and the next Refresh
+// loads new replicas to refill the warm pool behind them. Lowering it, e.g. once load subsides,
+// demotes serving replicas back to the warm pool, and the next Refresh unloads replicas beyond it.
+// PromoteWarm is refused during blackout windows, unless forced.
+func (m *Mgr) PromoteWarm(fullName modelFullName, count int32, force bool) error {
+	if count < 0 {
+		return fmt.Errorf("number of promoted replicas %d must be non-negative: %w", count, errors.ErrInvalidArgument)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	model, ok := m.models[fullName]
+	if !ok {
+		return fmt.Errorf("model %s not found: %w", fullName, errors.ErrNotFound)
+	}
+	if model.specs.GetWarmPoolSize() == 0 && count > 0 {
+		return fmt.Errorf("model %s has no warm pool to promote replicas from: %w", fullName, errors.ErrFailedPrecondition)
+	}
+	if warm := model.specs.GetWarmPoolSize(); count > warm {
+		return fmt.Errorf("cannot promote %d replicas of model %s from a warm pool of %d: %w", count, fullName, warm, errors.ErrInvalidArgument)
+	}
+	if err := m.checkBlackoutLocked(force, fmt.Sprintf("promoting %d warm pool replicas of model %s", count, fullName)); err != nil {
+		return err
+	}
+	// Promoted replicas are refilled in the warm pool, so they count against the replica quota.
+	current := requestedReplicas(model)
+	if err := m.checkReplicaQuotaLocked(fullName, current, current-model.promoted+count); err != nil {
+		return err
+	}
+	log.Infof("Promoting %d warm pool replicas of model %s, previously %d", count, fullName, model.promoted)
+	model.promoted = count
+	model.rebalance()
+	return nil
+}
+
+// rebalanceWarmPools makes every model serve from as many replicas as it currently requests,
+// keeping the others in its warm pool.
+func (m *Mgr) rebalanceWarmPools() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, model := range m.models {
+		model.rebalance()
+	}
+}
+
+// revertExpiredBumps restores the number of replicas of models whose temporary increases expire
+// by now.
+func (m *Mgr) revertExpiredBumps(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for fullName, model := range m.models {
+		if model.bump == nil || model.bump.GetExpireMs() > now.UnixMilli() {
+			continue
+		}
+		restore := model.bump.GetRestoreNumReplicas()
+		model.bump = nil
+		if model.specs.GetRequestedNumReplicas() == restore {
+			continue
+		}
+		log.Infof("Restoring model %s to %d replicas after its temporary increase expired", fullName, restore)
+		specs := proto.Clone(model.specs).(*apb.Model)
+		specs.RequestedNumReplicas = restore
+		model.specs = specs
+	}
+}
+
+// recommendReplicas returns the number of replicas autoscale recommends for a model that requests
+// current replicas, given the highest p99 latency p99 reported by its replicas. If ok is false, no
+// replica reported any latency, e.g. for lack of traffic, and only the bounds apply.
+func recommendReplicas(autoscale *apb.LatencyAutoscale, current int32, p99 float32, ok bool) int32 {
+	recommended := current
+	if ok {
+		target := autoscale.GetTargetP99Seconds()
+		if p99 > target {
+			recommended++
+		} else if p99 < target*autoscaleDownFraction {
+			recommended--
+		}
+	}
+	// Never scale to zero, where no replica reports the latency to scale back up on, even for models
+	// published before the minimum had to be positive.
+	minReplicas := autoscale.GetMinReplicas()
+	if minReplicas < 1 {
+		minReplicas = 1
+	}
+	if recommended < minReplicas {
+		recommended = minReplicas
+	}
+	if maxReplicas := autoscale.GetMaxReplicas(); maxReplicas > 0 && recommended > maxReplicas {
+		recommended = maxReplicas
+	}
+	return recommended
+}
+
+// modelP99Locked returns the highest p99 latency of any method of a model reported by the model
+// servers assigned the model, and false if none has served the model recently.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) modelP99Locked(fullName modelFullName) (float32, bool) {
+	var p99 float32
+	reported := false
+	for _, addr := range m.assignment[fullName] {
+		modelet, ok := m.modelets[addr]
+		if !ok {
+			continue
+		}
+		seen, ok := modelet.SeenModels()[fullName]
+		if !ok {
+			continue
+		}
+		for _, stats := range seen.Info.Stats {
+			if stats.SuccessesPerSecond <= 0 {
+				continue
+			}
+			reported = true
+			if stats.P99LatencyInSeconds > p99 {
+				p99 = stats.P99LatencyInSeconds
+			}
+		}
+	}
+	return p99, reported
+}
+
+// recommendedReplicasLocked returns the number of replicas the latency autoscale config of a model
+// recommends, or 0 if the model has none.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) recommendedReplicasLocked(fullName modelFullName, model *modelState) int32 {
+	autoscale := model.specs.GetLatencyAutoscale()
+	if autoscale == nil {
+		return 0
+	}
+	p99, ok := m.modelP99Locked(fullName)
+	return recommendReplicas(autoscale, model.specs.GetRequestedNumReplicas(), p99, ok)
+}
+
+// autoscaleByLatency applies the latency autoscale recommendation to the models that ask for it,
+// changing each model at most once per autoscaleCooldown. Models with another change of their
+// replicas under way are left alone until it is done.
+func (m *Mgr) autoscaleByLatency(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for fullName, model := range m.models {
+		if !model.specs.GetLatencyAutoscale().GetAutoApply() || now.Sub(model.autoscaled) < autoscaleCooldown {
+			continue
+		}
+		if model.rollout != nil || model.pendingReplicas > 0 || model.bump != nil {
+			continue
+		}
+		current := model.specs.GetRequestedNumReplicas()
+		recommended := m.recommendedReplicasLocked(fullName, model)
+		if recommended == current {
+			continue
+		}
+		if err := m.checkReplicaQuotaLocked(fullName, current, recommended); err != nil {
+			log.Warningf("Not scaling model %s on latency: %v", fullName, err)
+			continue
+		}
+		log.Infof("Scaling model %s from %d to %d replicas on latency, targeting a p99 of %vs", fullName, current, recommended, model.specs.GetLatencyAutoscale().GetTargetP99Seconds())
+		specs := proto.Clone(model.specs).(*apb.Model)
+		specs.RequestedNumReplicas = recommended
+		model.specs = specs
+		model.autoscaled = now
+		m.holdScaleUpLocked(fullName, model, current)
+	}
+}
+
+// PublishStaged publishes a model like Publish, but rolls it out in stages to keep a bad model
+// from breaking all its replicas at once. The model first gets at most options.canary_replicas
+// replicas. Only after all of them have loaded and stayed loaded for options.soak_ms does Refresh
+// give the model the rest of its requested replicas, subject to scale approval like any increase.
+//
+// PublishStaged returns once the model is published. If a canary replica fails, or the canaries
+// haven't passed within options.timeout_ms, Refresh halts the rollout and unpublishes the model.
+// The rollout is kept in the backing store, so it carries on after the admin server restarts.
+func (m *Mgr) PublishStaged(specs *apb.Model, options *apb.RolloutOptions) error {
+	if err := validateRolloutOptions(options); err != nil {
+		return err
+	}
+	return m.publish(specs, options)
+}
+
+// validateRolloutOptions checks the options of a staged rollout.
+func validateRolloutOptions(options *apb.RolloutOptions) error {
+	if canaries := options.GetCanaryReplicas(); canaries <= 0 {
+		return fmt.Errorf("the number of canary replicas must be positive, got %d: %w", canaries, errors.ErrInvalidArgument)
+	}
+	if options.GetSoakMs() < 0 || options.GetTimeoutMs() < 0 {
+		return fmt.Errorf("rollout soak time %dms and timeout %dms must be non-negative: %w", options.GetSoakMs(), options.GetTimeoutMs(), errors.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// newRollout returns a rollout to staged replicas following options. If it halts, previous is
+// restored, or the model is unpublished if previous is nil.
+func newRollout(staged int32, options *apb.RolloutOptions, previous *apb.Model) *apb.Rollout {
+	rollout := &apb.Rollout{
+		StagedReplicas: staged,
+		SoakMs:         options.GetSoakMs(),
+		Previous:       previous,
+	}
+	if timeout := options.GetTimeoutMs(); timeout > 0 {
+		rollout.DeadlineMs = time.Now().UnixMilli() + timeout
+	}
+	return rollout
+}
+
+// advanceRollouts moves staged rollouts along: models whose canary replicas have all stayed loaded
+// for the soak time get the rest of their replicas, and rollouts with a failed canary replica or
+// past their deadline halt.
+func (m *Mgr) advanceRollouts(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for fullName, model := range m.models {
+		rollout := model.rollout
+		if rollout == nil {
+			continue
+		}
+		healthy, err := m.canariesHealthyLocked(fullName, model)
+		if err != nil {
+			m.haltRolloutLocked(fullName, model, err)
+			continue
+		}
+		switch {
+		case !healthy:
+			rollout.HealthySinceMs = 0
+		case rollout.GetHealthySinceMs() == 0:
+			log.Infof("Canary replicas of model %s are healthy, soaking for %v", fullName, time.Duration(rollout.GetSoakMs())*time.Millisecond)
+			rollout.HealthySinceMs = now.UnixMilli()
+		}
+		if since := rollout.GetHealthySinceMs(); since > 0 && now.UnixMilli()-since >= rollout.GetSoakMs() {
+			m.promoteRolloutLocked(fullName, model)
+			continue
+		}
+		if deadline := rollout.GetDeadlineMs(); deadline > 0 && now.UnixMilli() >= deadline {
+			m.haltRolloutLocked(fullName, model, fmt.Errorf("canary replicas didn't pass by %v: %w", time.UnixMilli(deadline), errors.ErrDeadlineExceeded))
+		}
+	}
+}
+
+// canariesHealthyLocked returns true if a model being rolled out has all its requested replicas
+// loaded, and an error if any of them has failed.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) canariesHealthyLocked(fullName modelFullName, model *modelState) (bool, error) {
+	loaded := 0
+	for addr, modelet := range m.modelets {
+		seen, ok := modelet.SeenModels()[fullName]
+		if !ok {
+			continue
+		}
+		switch seen.Info.Status {
+		case protobuf.Failed:
+			return false, fmt.Errorf("canary replica of model %s on %s failed: %w", fullName, addr, errors.ErrFailedPrecondition)
+		case protobuf.Loaded:
+			loaded++
+		}
+	}
+	return loaded >= int(model.specs.GetRequestedNumReplicas()), nil
+}
+
+// promoteRolloutLocked gives a model that passed its canary stage the rest of its requested
+// replicas.
+//
+// REQUIRES: m.mu is held for writing.
+func (m *Mgr) promoteRolloutLocked(fullName modelFullName, model *modelState) {
+	approved := model.specs.GetRequestedNumReplicas()
+	staged := model.rollout.GetStagedReplicas()
+	log.Infof("Rolling out model %s from %d replicas with canaries to %d", fullName, approved, staged)
+	specs := proto.Clone(model.specs).(*apb.Model)
+	specs.RequestedNumReplicas = staged
+	model.specs = specs
+	model.rollout = nil
+	model.pendingReplicas = 0
+	m.holdScaleUpLocked(fullName, model, approved)
+}
+
+// haltRolloutLocked reverts a model whose canary stage didn't pass because of cause: a staged
+// publish is unpublished, and a staged update gets its previous definition back. Halting isn't held
+// up by blackouts.
+//
+// REQUIRES: m.mu is held for writing.
+func (m *Mgr) haltRolloutLocked(fullName modelFullName, model *modelState, cause error) {
+	log.Warningf("Halting the rollout of model %s: %v", fullName, cause)
+	m.eventLogger.Log(eventlog.RolloutHalt, &apb.Model{ModelId: fullName.ModelFullName()}, cause.Error())
+	previous := model.rollout.GetPrevious()
+	model.rollout = nil
+	if previous == nil {
+		m.unpublishLocked(fullName, model)
+		return
+	}
+	model.specs = previous
+	model.pendingReplicas = 0
+	m.updateServersLocked(fullName, previous)
+}
+
+// OverrideConstraints relaxes the placement constraints of a model for duration, replacing any
+// override the model already has, and returns the override with its expiration time set.
+//
+// Only the default assignment algorithm honors overrides. When an override expires, Refresh
+// reverts it and moves the model off model servers it was placed on only because of it.
+func (m *Mgr) OverrideConstraints(fullName modelFullName, override *apb.ConstraintOverride, duration time.Duration) (*apb.ConstraintOverride, error) {
+	if *expAssigner {
+		return nil, fmt.Errorf("the assigner doesn't support constraint overrides: %w", errors.ErrFailedPrecondition)
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("override duration %v must be positive: %w", duration, errors.ErrInvalidArgument)
+	}
+	if !override.GetIgnoreServablePaths() && !override.GetAllowOverpacking() {
+		return nil, fmt.Errorf("override relaxes no constraint: %w", errors.ErrInvalidArgument)
+	}
+	if override.GetReason() == "" {
+		return nil, fmt.Errorf("override has no reason: %w", errors.ErrInvalidArgument)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	model, ok := m.models[fullName]
+	if !ok {
+		return nil, fmt.Errorf("model %s not found: %w", fullName, errors.ErrNotFound)
+	}
+	override = proto.Clone(override).(*apb.ConstraintOverride)
+	override.ExpireMs = time.Now().Add(duration).UnixMilli()
+	model.override = override
+	log.Warningf("Overriding placement constraints of model %s until %v: %v", fullName, time.UnixMilli(override.GetExpireMs()), override)
+	m.eventLogger.Log(eventlog.ConstraintOverride, &apb.Model{ModelId: fullName.ModelFullName()}, override)
+	return proto.Clone(override).(*apb.ConstraintOverride), nil
+}
+
+// revertExpiredOverrides removes placement constraint overrides that expire by now.
+func (m *Mgr) revertExpiredOverrides(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for fullName, model := range m.models {
+		if model.override == nil || model.override.GetExpireMs() > now.UnixMilli() {
+			continue
+		}
+		log.Warningf("Reverting expired placement constraint override of model %s: %v", fullName, model.override)
+		m.eventLogger.Log(eventlog.ConstraintRevert, &apb.Model{ModelId: fullName.ModelFullName()}, model.override)
+		model.override = nil
+	}
+}
+
+// recordReassignRequests remembers model servers that ask to have a model moved off them, so
+// that ComputeAssignment moves the model to other model servers. pruneModelets forgets them.
+// Only the default assignment algorithm honors these requests.
+func (m *Mgr) recordReassignRequests() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, modelet := range m.modelets {
+		for fullName, seen := range modelet.SeenModels() {
+			model, ok := m.models[fullName]
+			if !ok || !seen.Info.ReassignRequested || model.avoid[addr] {
+				continue
+			}
+			log.Warningf("Model server %s asks to have model %s reassigned", addr, fullName)
+			m.eventLogger.Log(eventlog.Reassign, &apb.Model{ModelId: fullName.ModelFullName()}, string(addr))
+			if model.avoid == nil {
+				model.avoid = make(map[modeletAddr]bool)
+			}
+			model.avoid[addr] = true
+		}
+	}
+}
+
+// recordRelaxed remembers which model servers models were placed on only because of their
+// constraint overrides. Models without an override are being moved off such model servers by
+// ComputeAssignment; they are forgotten once the model is gone from them.
+func (m *Mgr) recordRelaxed(relaxed map[modelFullName][]modeletAddr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for fullName, model := range m.models {
+		if model.override == nil {
+			for addr := range model.relaxed {
+				modelet, ok := m.modelets[addr]
+				if !ok {
+					delete(model.relaxed, addr)
+					continue
+				}
+				if _, ok := modelet.WantedModels()[fullName]; !ok {
+					delete(model.relaxed, addr)
+				}
+			}
+			continue
+		}
+		for _, addr := range relaxed[fullName] {
+			if model.relaxed == nil {
+				model.relaxed = map[modeletAddr]bool{}
+			}
+			model.relaxed[addr] = true
+		}
+	}
+}
+
+// reservedForOthers returns true if the model server reserves resources for models, none of them
+// fullName. ComputeAssignment places a single model on each model server, so any other model
+// would leave the reservations unusable.
+func reservedForOthers(specs *protobuf.ModelServer, fullName modelFullName) bool {
+	for _, r := range specs.Reservations {
+		if r.ModelID == fullName.ModelFullName() {
+			return false
+		}
+	}
+	return len(specs.Reservations) > 0
+}
+
+// overrideAllows returns true if override lets a model with the given path be placed on a model
+// server able to serve servable, which already has other models if busy.
+func overrideAllows(override *apb.ConstraintOverride, path string, servable []string, busy bool) bool {
+	if busy && !override.GetAllowOverpacking() {
+		return false
+	}
+	if override.GetIgnoreServablePaths() {
+		return true
+	}
+	for _, p := range servable {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// roleMismatchLocked returns true if any of assigned, the model servers model is assigned to, has
+// a role other than the model's, e.g. because the model server rejoined with a new role.
+func (m *Mgr) roleMismatchLocked(model *modelState, assigned []modeletAddr) bool {
+	for _, addr := range assigned {
+		if m.modelets[addr].Specs.Role != model.specs.GetRole() {
+			return true
+		}
+	}
+	return false
 }
 
 // List returns information about one published model.
@@ -272,26 +1481,114 @@ func (m *Mgr) GetStatsPerModel(addrs map[string]bool) map[naming.ModelFullName]f
 			}
 		}
 	}
-
-	return ret
+
+	return ret
+}
+
+// WatchLoc watches changes of the model server addresses after the given seqno.
+func (m *Mgr) WatchLoc(ctx context.Context, fullName string, seqno int32) (*watchable.WatchResult, error) {
+	modelFullName, err := naming.NewModelFullName(fullName)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	model, ok := m.models[modelFullName]
+	if !ok {
+		m.mu.RUnlock()
+		return nil, fmt.Errorf("model %s not found: %w", fullName, errors.ErrNotFound)
+	}
+	m.mu.RUnlock()
+
+	return model.addrWatcher.Watch(ctx, seqno)
+}
+
+// Zones returns the zones of joined model servers with the given data addresses. Addresses of
+// model servers that have left or have no zone tag are omitted.
+func (m *Mgr) Zones(dataAddrs []string) map[string]string {
+	wanted := make(map[string]bool, len(dataAddrs))
+	for _, addr := range dataAddrs {
+		wanted[addr] = true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	zones := make(map[string]string)
+	for _, modelet := range m.modelets {
+		if !wanted[modelet.DataAddr] {
+			continue
+		}
+		if zone := modelet.Specs.Zone(); zone != "" {
+			zones[modelet.DataAddr] = zone
+		}
+	}
+	return zones
+}
+
+// AssignedMs returns when joined model servers with the given data addresses were assigned the
+// model, in milliseconds since Unix epoch. Addresses of model servers not assigned the model are
+// omitted.
+func (m *Mgr) AssignedMs(fullName modelFullName, dataAddrs []string) map[string]int64 {
+	wanted := make(map[string]bool, len(dataAddrs))
+	for _, addr := range dataAddrs {
+		wanted[addr] = true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	assignedMs := make(map[string]int64)
+	for addr, at := range m.assignedAt[fullName] {
+		modelet, ok := m.modelets[addr]
+		if !ok || !wanted[modelet.DataAddr] {
+			continue
+		}
+		assignedMs[modelet.DataAddr] = at.UnixMilli()
+	}
+	return assignedMs
+}
+
+// Labels returns the labels of joined model servers with the given data addresses. Addresses of
+// model servers that have left or have no labels are omitted.
+func (m *Mgr) Labels(dataAddrs []string) map[string]map[string]string {
+	wanted := make(map[string]bool, len(dataAddrs))
+	for _, addr := range dataAddrs {
+		wanted[addr] = true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	labels := make(map[string]map[string]string)
+	for _, modelet := range m.modelets {
+		if !wanted[modelet.DataAddr] {
+			continue
+		}
+		if l := modelet.Specs.Labels(); len(l) > 0 {
+			labels[modelet.DataAddr] = l
+		}
+	}
+	return labels
 }
 
-// WatchLoc watches changes of the model server addresses after the given seqno.
-func (m *Mgr) WatchLoc(ctx context.Context, fullName string, seqno int32) (*watchable.WatchResult, error) {
-	modelFullName, err := naming.NewModelFullName(fullName)
-	if err != nil {
-		return nil, err
+// Drains returns the drained fractions of joined model servers with the given data addresses.
+// Addresses of model servers that have left or aren't drained are omitted.
+func (m *Mgr) Drains(dataAddrs []string) map[string]float32 {
+	wanted := make(map[string]bool, len(dataAddrs))
+	for _, addr := range dataAddrs {
+		wanted[addr] = true
 	}
 
 	m.mu.RLock()
-	model, ok := m.models[modelFullName]
-	if !ok {
-		m.mu.RUnlock()
-		return nil, fmt.Errorf("model %s not found: %w", fullName, errors.ErrNotFound)
+	defer m.mu.RUnlock()
+	drains := make(map[string]float32)
+	for addr, modelet := range m.modelets {
+		if !wanted[modelet.DataAddr] {
+			continue
+		}
+		if f, ok := m.drains[addr]; ok {
+			drains[modelet.DataAddr] = f
+		}
 	}
-	m.mu.RUnlock()
-
-	return model.addrWatcher.Watch(ctx, seqno)
+	return drains
 }
 
 // WaitForReady returns when the number of loaded replicas reaches the given threshold.
@@ -321,10 +1618,24 @@ func (m *Mgr) FindModel(fullName modelFullName) *apb.Model {
 // Join lets one model server join from an address.
 func (m *Mgr) Join(ctx context.Context, addr, debugAddr, dataAddr string, specs *apb.ModelServer) error {
 	maddr := modeletAddr(addr)
+	m.mu.Lock()
+	recovering := m.recovering
+	if recovering {
+		m.turnedAway[maddr] = true
+	}
+	m.mu.Unlock()
+	if recovering {
+		return ErrRecovering
+	}
 
-	createNewServerState := func() error {
+	createNewServerState := func(rejoined bool) error {
 		modelServer := state.New(addr, debugAddr, dataAddr, protobuf.NewModelServer(specs), m.eventLogger)
-		if err := modelServer.Start(ctx, m); err != nil {
+		if rejoined {
+			modelServer.MarkRejoined()
+		}
+		modelServer.SetLoadLimiter(m.loadLimiter)
+		modelServer.SetTransportSecurity(m.getTransportSecurity())
+		if err := startModeletState(ctx, modelServer, m); err != nil {
 			return fmt.Errorf("failed to start a connection with %v: %w", addr, err)
 		}
 
@@ -332,11 +1643,15 @@ func (m *Mgr) Join(ctx context.Context, addr, debugAddr, dataAddr string, specs
 		_, ok := m.modelets[maddr]
 		if !ok {
 			m.modelets[maddr] = modelServer
+			m.incarnations[maddr] = append(m.incarnations[maddr], &incarnation{joined: time.Now(), version: specs.GetVersion()})
+			m.mirrorLocked(registryOp{addr: maddr, specs: specs})
+			joinedServersVar.Set(int64(len(m.modelets)))
+			lastJoinTimeVar.Set(time.Now().Format(time.RFC3339Nano))
 
 			// Only models loading or loaded should get added to the address watcher.
 			for fullName := range modelServer.WantedModels() {
 				model := m.models[fullName]
-				model.addrWatcher.Add(modelServer.DataAddr)
+				model.addAddr(modelServer.DataAddr)
 			}
 			// Be conservative and only add loaded models to the waiter. Models that are loading, when a
 			// model server joins an admin server, are not counted toward a pending WaitForReady request.
@@ -359,15 +1674,31 @@ func (m *Mgr) Join(ctx context.Context, addr, debugAddr, dataAddr string, specs
 		return nil
 	}
 
+	// Heartbeats are cheap, but new and replaced model servers need a slot. This is only a hint:
+	// should the model server change in between, the work below runs with or without one.
+	m.mu.RLock()
+	existing, ok := m.modelets[maddr]
+	needsWork := !ok || !existing.Specs.Equal(specs)
+	m.mu.RUnlock()
+	if needsWork {
+		release, err := m.acquireJoinWork(ctx, addr)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
 	// Let the server join, heartbeat, or replace an existing one at the same address if any.
 	//
 	// Do all the m.modelets mutation work under the lock, leaving the time-consuming RPC-related
 	// work to after the unlock.
 	m.mu.Lock()
-	existing, ok := m.modelets[maddr]
+	existing, ok = m.modelets[maddr]
 	var same bool // only valid when ok
+	_, rejoined := m.pruned[maddr]
 	if !ok {
 		log.V(4).Infof("Modelet %s, %v has joined", addr, specs)
+		delete(m.pruned, maddr)
 	} else {
 		same = existing.Specs.Equal(specs)
 		if same {
@@ -375,18 +1706,44 @@ func (m *Mgr) Join(ctx context.Context, addr, debugAddr, dataAddr string, specs
 		} else {
 			log.V(4).Infof("Modelet %s, %v has replaced %v", addr, specs, existing.Specs)
 			delete(m.modelets, maddr)
+			m.recordEvictionLocked(maddr, EvictedReplaced)
+			joinedServersVar.Set(int64(len(m.modelets)))
 		}
 	}
 	m.mu.Unlock()
 
 	if !ok {
-		return createNewServerState()
+		return createNewServerState(rejoined)
 	}
 	if same {
 		return nil
 	}
 	existing.Close() // already deleted from m.modelets
-	return createNewServerState()
+	return createNewServerState(true)
+}
+
+// acquireJoinWork takes a slot to process the Join of a new or replaced model server at addr,
+// waiting up to joinWorkWait for one to free up. The returned function gives the slot back.
+func (m *Mgr) acquireJoinWork(ctx context.Context, addr string) (func(), error) {
+	if m.joinWork == nil {
+		return func() {}, nil
+	}
+	timer := time.NewTimer(joinWorkWait)
+	defer timer.Stop()
+	select {
+	case m.joinWork <- struct{}{}:
+		return func() { <-m.joinWork }, nil
+	case <-timer.C:
+		shedJoinsVar.Add(1)
+		return nil, fmt.Errorf("admin server is busy with %d joins, %s should retry later: %w", cap(m.joinWork), addr, errors.ErrUnavailable)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("join of %s canceled while waiting for a slot: %w", addr, ctx.Err())
+	}
+}
+
+// ExportAudit returns the kept events logged at or after since and before until, oldest first.
+func (m *Mgr) ExportAudit(since, until time.Time) []auditlog.Record {
+	return m.audit.Export(since, until)
 }
 
 // GetStatus returns information about one joined model server.
@@ -404,6 +1761,7 @@ func (m *Mgr) GetStatus(ctx context.Context, addr string, full bool) (*mpb.GetSt
 func (m *Mgr) makeJoinedModelServerLocked(addr string, modelet *modeletState) (*apb.JoinedModelServer, error) {
 	statuses := map[string]cpb.ModelStatus{}
 	var successesPerSecond, errorsPerSecond, meanLatencyInSeconds float32 = 0., 0., 0.
+	var meanQueueInSeconds, meanProcessingInSeconds float32 = 0., 0.
 	for fullName, status := range modelet.SeenModels() {
 		s, err := status.Info.Status.ToProto()
 		if err != nil {
@@ -414,24 +1772,43 @@ func (m *Mgr) makeJoinedModelServerLocked(addr string, modelet *modeletState) (*
 			successesPerSecond += stats.SuccessesPerSecond
 			errorsPerSecond += stats.ErrorsPerSecond
 			meanLatencyInSeconds += stats.MeanLatencyInSeconds * stats.SuccessesPerSecond
+			meanQueueInSeconds += stats.MeanQueueInSeconds * stats.SuccessesPerSecond
+			meanProcessingInSeconds += stats.MeanProcessingInSeconds * stats.SuccessesPerSecond
 		}
 	}
 	if successesPerSecond != 0 {
 		meanLatencyInSeconds = meanLatencyInSeconds / successesPerSecond
+		meanQueueInSeconds = meanQueueInSeconds / successesPerSecond
+		meanProcessingInSeconds = meanProcessingInSeconds / successesPerSecond
 	}
 	return &apb.JoinedModelServer{
-		ModelServer:          modelet.Specs.ToProto(),
-		Address:              addr,
-		DebugAddress:         modelet.DebugAddr,
-		DataAddress:          modelet.DataAddr,
-		LastJoinMs:           modelet.LastPing().UnixMilli(),
-		LoadedModels:         statuses,
-		SuccessesPerSecond:   successesPerSecond,
-		ErrorsPerSecond:      errorsPerSecond,
-		MeanLatencyInSeconds: meanLatencyInSeconds,
+		ModelServer:             modelet.Specs.ToProto(),
+		Address:                 addr,
+		DebugAddress:            modelet.DebugAddr,
+		DataAddress:             modelet.DataAddr,
+		LastJoinMs:              modelet.LastPing().UnixMilli(),
+		LoadedModels:            statuses,
+		SuccessesPerSecond:      successesPerSecond,
+		ErrorsPerSecond:         errorsPerSecond,
+		MeanLatencyInSeconds:    meanLatencyInSeconds,
+		MeanQueueInSeconds:      meanQueueInSeconds,
+		MeanProcessingInSeconds: meanProcessingInSeconds,
 	}, nil
 }
 
+// RefreshServer updates the manager's view of the models on one joined model server right away,
+// instead of at its next periodic refresh. It shares the result of a refresh already in progress.
+func (m *Mgr) RefreshServer(ctx context.Context, addr string) error {
+	m.mu.RLock()
+	modelet, ok := m.modelets[modeletAddr(addr)]
+	m.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("model server %v not found: %w", addr, errors.ErrNotFound)
+	}
+	return modelet.Refresh(ctx)
+}
+
 // Locate returns information about one joined model server.
 func (m *Mgr) Locate(addr string) (*apb.JoinedModelServer, error) {
 	m.mu.RLock()
@@ -439,11 +1816,189 @@ func (m *Mgr) Locate(addr string) (*apb.JoinedModelServer, error) {
 
 	modelet, ok := m.modelets[modeletAddr(addr)]
 	if !ok {
+		if eviction, ok := m.evictionLocked(modeletAddr(addr)); ok {
+			if n := len(m.flaps[modeletAddr(addr)]); n >= flapThreshold {
+				return nil, fmt.Errorf("model server %v not found, removed at %v as %v, oscillating between joining and eviction with %d evictions recently: %w", addr, eviction.Time.Format(time.RFC3339), eviction.Reason, n, errors.ErrNotFound)
+			}
+			return nil, fmt.Errorf("model server %v not found, removed at %v as %v: %w", addr, eviction.Time.Format(time.RFC3339), eviction.Reason, errors.ErrNotFound)
+		}
 		return nil, fmt.Errorf("model server %v not found: %w", addr, errors.ErrNotFound)
 	}
 	return m.makeJoinedModelServerLocked(addr, modelet)
 }
 
+// Eviction returns when and why the model server at an address was last removed, if that was
+// within evictionRetention.
+func (m *Mgr) Eviction(addr string) (Eviction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.evictionLocked(modeletAddr(addr))
+}
+
+// evictionLocked is Eviction with m.mu held.
+func (m *Mgr) evictionLocked(addr modeletAddr) (Eviction, bool) {
+	eviction, ok := m.evicted[addr]
+	if !ok || time.Since(eviction.Time) > evictionRetention {
+		return Eviction{}, false
+	}
+	return eviction, true
+}
+
+// recordEvictionLocked remembers that the model server at addr was removed now for reason, and
+// forgets removals older than evictionRetention.
+//
+// REQUIRES: m.mu is held for writing.
+func (m *Mgr) recordEvictionLocked(addr modeletAddr, reason EvictionReason) {
+	now := time.Now()
+	for evicted, eviction := range m.evicted {
+		if now.Sub(eviction.Time) > evictionRetention {
+			delete(m.evicted, evicted)
+		}
+	}
+	log.Infof("Removed model server %v as %v", addr, reason)
+	m.evicted[addr] = Eviction{Time: now, Reason: reason}
+	if incarnations := m.incarnations[addr]; len(incarnations) > 0 {
+		if last := incarnations[len(incarnations)-1]; last.removed.IsZero() {
+			last.removed, last.reason = now, reason
+		}
+	}
+	m.mirrorLocked(registryOp{addr: addr})
+}
+
+// SetRegistry makes the manager mirror model servers joining and leaving into registry. Model
+// servers already joined are registered right away.
+func (m *Mgr) SetRegistry(registry Registry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.registryOps != nil {
+		close(m.registryOps)
+	}
+	ops := make(chan registryOp, registryQueueSize)
+	m.registryOps = ops
+	go mirrorMembership(registry, ops, m.joinedSpecs)
+	for addr, modelet := range m.modelets {
+		m.mirrorLocked(registryOp{addr: addr, specs: modelet.Specs.ToProto()})
+	}
+}
+
+// mirrorLocked queues a membership change for the registry, if there is one. It never blocks.
+//
+// REQUIRES: m.mu is held for writing.
+func (m *Mgr) mirrorLocked(op registryOp) {
+	if m.registryOps == nil {
+		return
+	}
+	select {
+	case m.registryOps <- op:
+	default:
+		registryErrorsVar.Add(1)
+		log.Warningf("Dropped registry update for model server %v, %d updates already queued", op.addr, registryQueueSize)
+	}
+}
+
+// joinedSpecs returns the specs of the model servers joined, keyed by address.
+func (m *Mgr) joinedSpecs() map[modeletAddr]*apb.ModelServer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	specs := make(map[modeletAddr]*apb.ModelServer, len(m.modelets))
+	for addr, modelet := range m.modelets {
+		specs[addr] = modelet.Specs.ToProto()
+	}
+	return specs
+}
+
+// mirrorMembership applies the membership changes received from ops to registry until ops is
+// closed. Every registryReconcilePeriod, it also registers all model servers joined returns and
+// deregisters the ones it registered that are gone.
+func mirrorMembership(registry Registry, ops <-chan registryOp, joined func() map[modeletAddr]*apb.ModelServer) {
+	// Model servers that may be in the registry.
+	registered := map[modeletAddr]bool{}
+	apply := func(op registryOp) {
+		ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+		defer cancel()
+		var err error
+		if op.specs != nil {
+			registered[op.addr] = true
+			err = registry.Register(ctx, string(op.addr), op.specs)
+		} else if err = registry.Deregister(ctx, string(op.addr)); err == nil {
+			delete(registered, op.addr)
+		}
+		if err != nil {
+			registryErrorsVar.Add(1)
+			log.Warningf("Failed to mirror model server %v into the registry (joined: %v): %v", op.addr, op.specs != nil, err)
+		}
+	}
+
+	ticker := time.NewTicker(registryReconcilePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case op, ok := <-ops:
+			if !ok {
+				return
+			}
+			apply(op)
+		case <-ticker.C:
+			specs := joined()
+			for addr, s := range specs {
+				apply(registryOp{addr: addr, specs: s})
+			}
+			for addr := range registered {
+				if _, ok := specs[addr]; !ok {
+					apply(registryOp{addr: addr})
+				}
+			}
+		}
+	}
+}
+
+// Incarnations returns the model server processes that joined from addr, oldest first.
+func (m *Mgr) Incarnations(addr string) []*apb.Incarnation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var res []*apb.Incarnation
+	for _, inc := range m.incarnations[modeletAddr(addr)] {
+		item := &apb.Incarnation{
+			Address: addr,
+			JoinMs:  inc.joined.UnixMilli(),
+			Version: inc.version,
+			Current: inc.removed.IsZero(),
+		}
+		if !item.Current {
+			item.RemovedMs = inc.removed.UnixMilli()
+			item.RemovalReason = inc.reason.String()
+		}
+		res = append(res, item)
+	}
+	return res
+}
+
+// PurgeStaleIncarnations forgets the model server processes that have left, keeping the current
+// one at each address, and returns how many it forgot.
+func (m *Mgr) PurgeStaleIncarnations() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for addr, incarnations := range m.incarnations {
+		var kept []*incarnation
+		for _, inc := range incarnations {
+			if inc.removed.IsZero() {
+				kept = append(kept, inc)
+			}
+		}
+		purged += len(incarnations) - len(kept)
+		if len(kept) == 0 {
+			delete(m.incarnations, addr)
+		} else {
+			m.incarnations[addr] = kept
+		}
+	}
+	log.Infof("Purged %d stale model server incarnations", purged)
+	return purged
+}
+
 // LocateSome returns information about a few joined model servers.
 func (m *Mgr) LocateSome(addrs []string) ([]*apb.JoinedModelServer, error) {
 	m.mu.RLock()
@@ -480,15 +2035,60 @@ func (m *Mgr) LocateAll() ([]*apb.JoinedModelServer, error) {
 	return joinedModelServers, nil
 }
 
-// pruneModelets removes model servers that haven't called Join in the last `timeout` duration.
+// UnreadyReplicas returns the model replicas assigned to joined model servers that aren't loaded,
+// sorted by model and then model server address.
+func (m *Mgr) UnreadyReplicas() ([]*apb.UnreadyReplica, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var unready []*apb.UnreadyReplica
+	for addr, modelet := range m.modelets {
+		seen := modelet.SeenModels()
+		for fullName := range modelet.WantedModels() {
+			status := cpb.ModelStatus_NONE
+			if seenModel, ok := seen[fullName]; ok {
+				if seenModel.Info.Status == protobuf.Loaded {
+					continue
+				}
+				s, err := seenModel.Info.Status.ToProto()
+				if err != nil {
+					return nil, err
+				}
+				status = s
+			}
+			unready = append(unready, &apb.UnreadyReplica{
+				ModelId: fullName.ModelFullName(),
+				Address: string(addr),
+				Status:  status,
+			})
+		}
+	}
+	sort.Slice(unready, func(i, j int) bool {
+		if unready[i].GetModelId() != unready[j].GetModelId() {
+			return unready[i].GetModelId() < unready[j].GetModelId()
+		}
+		return unready[i].GetAddress() < unready[j].GetAddress()
+	})
+	return unready, nil
+}
+
+// pruneModelets removes model servers that haven't called Join in the last `timeout` duration,
+// or longer for oscillating model servers.
 func (m *Mgr) pruneModelets(timeout time.Duration) {
-	cutoff := time.Now().Add(-timeout) // modelets not seen after the cutoff are removed
+	now := time.Now()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.expireFlapsLocked(now)
+	for addr, prunedAt := range m.pruned {
+		if now.Sub(prunedAt) > evictionRetention {
+			delete(m.pruned, addr)
+		}
+	}
 	for addr, modelet := range m.modelets {
 		lastPing := modelet.LastPing()
+		cutoff := now.Add(-timeout - m.flapBackoffLocked(addr)) // modelets not seen after the cutoff are removed
 		if lastPing.After(cutoff) {
 			continue
 		}
@@ -498,7 +2098,7 @@ func (m *Mgr) pruneModelets(timeout time.Duration) {
 		for fullName := range modelet.WantedModels() {
 			model, ok := m.models[fullName]
 			if ok {
-				model.addrWatcher.Del(modelet.DataAddr)
+				model.delAddr(modelet.DataAddr)
 			}
 		}
 		// Subtract models that are loaded from the waiter. Also be conservative and subtract loading
@@ -512,12 +2112,105 @@ func (m *Mgr) pruneModelets(timeout time.Duration) {
 				model.waiter.Add(-1)
 			}
 		}
+		reason := EvictedUnresponsive
+		if m.cordoned[addr] {
+			// Only shutting down and evacuated model servers are cordoned.
+			reason = EvictedEvacuated
+			if modelet.ShuttingDown() {
+				reason = EvictedShutDown
+			}
+		}
 		delete(m.modelets, addr)
+		delete(m.cordoned, addr)
+		for _, model := range m.models {
+			delete(model.avoid, addr)
+		}
+		m.pruned[addr] = now
+		m.recordEvictionLocked(addr, reason)
+		if reason == EvictedUnresponsive {
+			m.recordFlapLocked(addr, now)
+		}
+		joinedServersVar.Set(int64(len(m.modelets)))
 		log.V(2).Infof("Pruned modelet %v with last ping at %v before cutoff %v", addr, lastPing, cutoff)
 		go modelet.Close() // Close() may block for a while.
 	}
 }
 
+// recordFlapLocked remembers that the model server at addr was evicted as unresponsive at now,
+// warning if that makes the address oscillate.
+func (m *Mgr) recordFlapLocked(addr modeletAddr, now time.Time) {
+	m.flaps[addr] = append(m.flaps[addr], now)
+	if n := len(m.flaps[addr]); n >= flapThreshold {
+		log.Warningf("Model server %v was evicted as unresponsive %d times in the last %v and keeps rejoining: this admin server likely can't reach it. Giving it %v more before its next eviction", addr, n, flapWindow, m.flapBackoffLocked(addr))
+	}
+	m.countOscillatingLocked()
+}
+
+// expireFlapsLocked forgets unresponsive evictions older than flapWindow.
+func (m *Mgr) expireFlapsLocked(now time.Time) {
+	for addr, flaps := range m.flaps {
+		i := 0
+		for i < len(flaps) && now.Sub(flaps[i]) > flapWindow {
+			i++
+		}
+		if len(flaps) >= flapThreshold && len(flaps)-i < flapThreshold {
+			log.Infof("Model server %v is no longer oscillating between joining and eviction", addr)
+		}
+		if i == len(flaps) {
+			delete(m.flaps, addr)
+		} else {
+			m.flaps[addr] = flaps[i:]
+		}
+	}
+	m.countOscillatingLocked()
+}
+
+// countOscillatingLocked updates oscillatingServersVar.
+func (m *Mgr) countOscillatingLocked() {
+	oscillating := 0
+	for _, flaps := range m.flaps {
+		if len(flaps) >= flapThreshold {
+			oscillating++
+		}
+	}
+	oscillatingServersVar.Set(int64(oscillating))
+}
+
+// flapBackoffLocked returns how much longer than usual the model server at addr may stay
+// unresponsive before it is evicted: 0 unless the address is oscillating.
+func (m *Mgr) flapBackoffLocked(addr modeletAddr) time.Duration {
+	n := len(m.flaps[addr])
+	if n < flapThreshold {
+		return 0
+	}
+	backoff := flapBackoff
+	for i := flapThreshold; i < n && backoff < flapMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > flapMaxBackoff {
+		backoff = flapMaxBackoff
+	}
+	return backoff
+}
+
+// Oscillations returns the model server addresses oscillating between joining and eviction, sorted
+// by address.
+func (m *Mgr) Oscillations() []Oscillation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireFlapsLocked(time.Now())
+
+	var oscillations []Oscillation
+	for addr, flaps := range m.flaps {
+		if len(flaps) < flapThreshold {
+			continue
+		}
+		oscillations = append(oscillations, Oscillation{Addr: string(addr), Evictions: len(flaps), Backoff: m.flapBackoffLocked(addr)})
+	}
+	sort.Slice(oscillations, func(i, j int) bool { return oscillations[i].Addr < oscillations[j].Addr })
+	return oscillations
+}
+
 // RefreshResult contains the result of a Server.Refresh call.
 type RefreshResult struct {
 	// The total number of model servers requested by all models when Refresh is called
@@ -532,21 +2225,37 @@ type RefreshResult struct {
 	// The new assignment computed by the call to Refresh.
 	NewAssignment map[modelFullName][]modeletAddr
 
-	// Model servers unassigned by the call to Refresh.
-	NewlyUnassigned map[modeletAddr]modelFullName
+	// Models unassigned from each model server by the call to Refresh. A model server may lose
+	// several models in one call, e.g. when it holds more than one.
+	NewlyUnassigned map[modeletAddr][]modelFullName
 
 	// Model servers assigned by the call to Refresh.
 	NewlyAssigned map[modeletAddr]modelFullName
 
 	// Temporarily store data address for later usage by unloadModels
 	DataAddress map[modeletAddr]string
+
+	// Why each model server in NewlyAssigned got its model. Nil unless
+	// --sax_admin_placement_rationale is set.
+	Rationale map[modelFullName]map[modeletAddr]string
+
+	// Model servers assigned only because of constraint overrides.
+	Relaxed map[modelFullName][]modeletAddr
 }
 
 // ComputeAssignment computes new model-to-server assignment.
 func (m *Mgr) ComputeAssignment() RefreshResult {
-	log.V(1).Infof("Assigning model servers to models")
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	return m.computeAssignmentLocked(m.models, *placementRationale)
+}
+
+// computeAssignmentLocked computes a new assignment of model servers to models, which may include
+// models not in m.models. Rationale is filled in only if explain is true.
+//
+// REQUIRES: m.mu is held for reading.
+func (m *Mgr) computeAssignmentLocked(models map[modelFullName]*modelState, explain bool) RefreshResult {
+	log.V(1).Infof("Assigning model servers to models")
 
 	// Current assignment.
 	var totalRequested, alreadyAssigned int
@@ -562,33 +2271,37 @@ func (m *Mgr) ComputeAssignment() RefreshResult {
 
 	// Iterates through model servers in sorted address order.
 	// This way, newAssignment[*] are also sorted and stable.
+	// Cordoned model servers are left out entirely; EvacuateLabel takes care of their models.
 	var addrs []string
 	for addr := range m.modelets {
+		if m.cordoned[addr] {
+			continue
+		}
 		addrs = append(addrs, string(addr))
 	}
 	sort.Strings(addrs)
 	log.V(1).Infof("All model server addresses: %v", addrs)
 
 	// Extract current assignment and gather busy model servers into a set.
-	newlyUnassigned := map[modeletAddr]modelFullName{}
+	newlyUnassigned := map[modeletAddr][]modelFullName{}
 	busy := map[modeletAddr]bool{}
 	for _, addr := range addrs {
 		maddr := modeletAddr(addr)
 		for fullName := range m.modelets[maddr].WantedModels() {
-			if _, ok := m.models[fullName]; ok {
+			if _, ok := models[fullName]; ok {
 				// The model is still published.
 				currentAssignment[fullName] = append(currentAssignment[fullName], maddr)
 				busy[maddr] = true
 			} else {
 				// The model has been unpublished.
-				newlyUnassigned[maddr] = fullName
+				newlyUnassigned[maddr] = append(newlyUnassigned[maddr], fullName)
 			}
 		}
 	}
 
 	// Sort the assignments so that loaded models come first in the list.
-	// Since we unload starting at the end of the list, this encourages us to keep loaded models
-	// and unload models from tasks that are loading/failed/unloading.
+	// This keeps new assignments stable. Which replicas to unload is up to pickScaleDown, which also
+	// prefers keeping loaded models over tasks that are loading/failed/unloading.
 	modelIsLoaded := func(modelName naming.ModelFullName, addr modeletAddr) bool {
 		for seenModelName, modelWithStatus := range m.modelets[addr].SeenModels() {
 			if seenModelName == modelName {
@@ -617,7 +2330,7 @@ func (m *Mgr) ComputeAssignment() RefreshResult {
 	idle := map[string]map[modeletAddr]bool{} // model path -> set of model servers able to serve it
 	var pathAddr []string
 	for addr, state := range m.modelets {
-		if busy[addr] {
+		if busy[addr] || m.cordoned[addr] {
 			continue
 		}
 		for _, path := range state.Specs.ServableModelPaths {
@@ -637,29 +2350,86 @@ func (m *Mgr) ComputeAssignment() RefreshResult {
 	// For each model, greedily assign as many available model servers as possible.
 	newAssignment := map[modelFullName][]modeletAddr{}
 	newlyAssigned := map[modeletAddr]modelFullName{}
-	for fullName, model := range m.models {
+	var rationale map[modelFullName]map[modeletAddr]string
+	if explain {
+		rationale = map[modelFullName]map[modeletAddr]string{}
+	}
+	// Models take idle model servers in name order, and model servers in address order, so that the
+	// same state always gives the same assignment, e.g. in SimulatePlacement and the next Refresh.
+	var names []modelFullName
+	for fullName := range models {
+		names = append(names, fullName)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].ModelFullName() < names[j].ModelFullName() })
+	for _, fullName := range names {
+		model := models[fullName]
 		assigned := currentAssignment[fullName]
-		requested := int(model.specs.GetRequestedNumReplicas())
+		var candidates []modeletAddr
+		for addr := range idle[model.specs.GetModelPath()] {
+			candidates = append(candidates, addr)
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+		// Warm pool replicas are placed like requested ones.
+		requested := placedReplicas(model)
 
-		log.V(1).Infof("Model %s (%s) requests %v modelets", fullName, model.specs.GetModelPath(), model.specs.GetRequestedNumReplicas())
-		totalRequested += int(model.specs.GetRequestedNumReplicas())
+		log.V(1).Infof("Model %s (%s) requests %v modelets", fullName, model.specs.GetModelPath(), requested)
+		totalRequested += requested
 
 		log.V(1).Infof("Model %s has %v model servers already assigned", fullName, len(assigned))
 		alreadyAssigned += len(assigned)
 
+		// Move the model off model servers it got only because of an override that has expired, off
+		// model servers that asked to have it reassigned, and off model servers of another role.
+		if (model.override == nil && len(model.relaxed) > 0) || len(model.avoid) > 0 || m.roleMismatchLocked(model, assigned) {
+			var kept []modeletAddr
+			for _, addr := range assigned {
+				if m.modelets[addr].Specs.Role != model.specs.GetRole() {
+					log.Infof("Dropping replica of model %s on %s, which has role %q instead of %q", fullName, addr, m.modelets[addr].Specs.Role, model.specs.GetRole())
+					newlyUnassigned[addr] = append(newlyUnassigned[addr], fullName)
+					continue
+				}
+				if model.override == nil && model.relaxed[addr] {
+					log.Infof("Dropping replica of model %s on %s placed under an expired constraint override", fullName, addr)
+					newlyUnassigned[addr] = append(newlyUnassigned[addr], fullName)
+					continue
+				}
+				if model.avoid[addr] {
+					log.Infof("Dropping replica of model %s on %s, which asked to have it reassigned", fullName, addr)
+					newlyUnassigned[addr] = append(newlyUnassigned[addr], fullName)
+					continue
+				}
+				kept = append(kept, addr)
+			}
+			assigned = kept
+		}
+
 		// Unassign one replica at a time if fewer are needed.
-		for len(assigned) > requested {
-			last := len(assigned) - 1
-			newlyUnassigned[assigned[last]] = fullName
-			assigned = assigned[:last]
+		if len(assigned) > requested {
+			var replicas []replicaInfo
+			for _, addr := range assigned {
+				replicas = append(replicas, m.replicaInfoLocked(fullName, addr))
+			}
+			for len(assigned) > requested {
+				i := pickScaleDown(m.scaleDownStrategy, replicas)
+				log.V(1).Infof("Dropping replica of model %s on %s (%+v) with strategy %v", fullName, assigned[i], replicas[i], m.scaleDownStrategy)
+				newlyUnassigned[assigned[i]] = append(newlyUnassigned[assigned[i]], fullName)
+				assigned = append(assigned[:i:i], assigned[i+1:]...)
+				replicas = append(replicas[:i:i], replicas[i+1:]...)
+			}
 		}
 
-		// Keep using items from the idle map until either fulfilled or out of items.
+		// Keep using idle model servers until either fulfilled or out of them.
 		taken := []modeletAddr{}
-		for addr := range idle[model.specs.GetModelPath()] {
+		for _, addr := range candidates {
 			if len(assigned) >= requested {
 				break
 			}
+			if model.avoid[addr] || m.modelets[addr].Specs.Role != model.specs.GetRole() {
+				continue
+			}
+			if reservedForOthers(m.modelets[addr].Specs, fullName) {
+				continue
+			}
 			taken = append(taken, addr)
 			maddr := modeletAddr(addr)
 			assigned = append(assigned, maddr)
@@ -675,6 +2445,74 @@ func (m *Mgr) ComputeAssignment() RefreshResult {
 
 		log.V(1).Infof("Model %s is assigned %v new model servers", fullName, len(newlyAssigned))
 		newAssignment[fullName] = assigned
+		if rationale != nil {
+			rationale[fullName] = m.explainPlacementLocked(model.specs.GetModelPath(), assigned, taken, candidates, busy)
+		}
+	}
+
+	// Models with a constraint override that are still short of replicas can also use model
+	// servers the constraints rule out. This runs after every model had its regular placement, so
+	// that overrides don't take model servers away from other models.
+	relaxed := map[modelFullName][]modeletAddr{}
+	// Overpacking never takes a model server past its maximum number of models, if it has one.
+	numModels := map[modeletAddr]int{}
+	for _, assigned := range newAssignment {
+		for _, addr := range assigned {
+			numModels[addr]++
+		}
+	}
+	for _, fullName := range names {
+		model := models[fullName]
+		override := model.override
+		requested := placedReplicas(model)
+		assigned := newAssignment[fullName]
+		if override == nil || len(assigned) >= requested {
+			continue
+		}
+		mine := map[modeletAddr]bool{}
+		for _, addr := range assigned {
+			mine[addr] = true
+		}
+		dropped := map[modeletAddr]bool{}
+		for maddr, names := range newlyUnassigned {
+			for _, name := range names {
+				if name == fullName {
+					dropped[maddr] = true
+				}
+			}
+		}
+		for _, addr := range addrs {
+			if len(assigned) >= requested {
+				break
+			}
+			maddr := modeletAddr(addr)
+			if _, ok := newlyAssigned[maddr]; ok || mine[maddr] || dropped[maddr] || model.avoid[maddr] {
+				continue
+			}
+			// Overrides relax constraints within a role, never across roles, and keep reservations.
+			if m.modelets[maddr].Specs.Role != model.specs.GetRole() || reservedForOthers(m.modelets[maddr].Specs, fullName) {
+				continue
+			}
+			path := model.specs.GetModelPath()
+			if !overrideAllows(override, path, m.modelets[maddr].Specs.ServableModelPaths, busy[maddr]) {
+				continue
+			}
+			if limit := int(m.modelets[maddr].Specs.MaxModels); limit > 0 && numModels[maddr] >= limit {
+				continue
+			}
+			log.Infof("Assigning model %s to %s under constraint override: %v", fullName, addr, override)
+			assigned = append(assigned, maddr)
+			newlyAssigned[maddr] = fullName
+			relaxed[fullName] = append(relaxed[fullName], maddr)
+			numModels[maddr]++
+			for _, path := range m.modelets[maddr].Specs.ServableModelPaths {
+				delete(idle[path], maddr)
+			}
+			if rationale != nil {
+				rationale[fullName][maddr] = fmt.Sprintf("override: %s (ignore servable paths: %v, allow overpacking: %v)", override.GetReason(), override.GetIgnoreServablePaths(), override.GetAllowOverpacking())
+			}
+		}
+		newAssignment[fullName] = assigned
 	}
 	log.V(1).Infof("New assignment: %v", newAssignment)
 
@@ -684,15 +2522,104 @@ func (m *Mgr) ComputeAssignment() RefreshResult {
 	for maddr := range newlyUnassigned {
 		dataAddress[maddr] = m.modelets[maddr].DataAddr
 	}
-
-	return RefreshResult{totalRequested, alreadyAssigned, pendingUnpublished, newAssignment, newlyUnassigned, newlyAssigned, dataAddress}
-}
-
-func (m *Mgr) installAssignment(assignment map[modelFullName][]modeletAddr) {
-	log.V(1).Infof("Install new assignment %v", assignment)
-	m.mu.Lock()
-	defer m.mu.Unlock()
+
+	return RefreshResult{totalRequested, alreadyAssigned, pendingUnpublished, newAssignment, newlyUnassigned, newlyAssigned, dataAddress, rationale, relaxed}
+}
+
+// explainPlacementLocked describes why ComputeAssignment took each model server in taken for a
+// model: it was picked from the idle candidates able to serve the model path after filtering out
+// the others. Model servers that keep their model keep their reason, see installAssignment.
+// candidates must be sorted by address.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) explainPlacementLocked(path string, assigned, taken, candidates []modeletAddr, busy map[modeletAddr]bool) map[modeletAddr]string {
+	reasons := map[modeletAddr]string{}
+	if len(taken) == 0 {
+		return reasons
+	}
+	var listed []string
+	for i, addr := range candidates {
+		if i == maxRationaleCandidates {
+			listed = append(listed, fmt.Sprintf("%d more", len(candidates)-i))
+			break
+		}
+		listed = append(listed, string(addr))
+	}
+	reason := fmt.Sprintf("greedy: picked from %d idle servers able to serve %s, all scored equally (%s); %s", len(candidates), path, strings.Join(listed, ", "), m.filteredOutLocked(path, assigned, busy))
+	for _, addr := range taken {
+		reasons[addr] = reason
+	}
+	return reasons
+}
+
+// filteredOutLocked describes the model servers not in assigned that ComputeAssignment could not
+// use for a model with the given path.
+//
+// REQUIRES: m.mu is held.
+func (m *Mgr) filteredOutLocked(path string, assigned []modeletAddr, busy map[modeletAddr]bool) string {
+	mine := map[modeletAddr]bool{}
+	for _, addr := range assigned {
+		mine[addr] = true
+	}
+	var numCordoned, numBusy, numUnservable int
+	for addr, modelet := range m.modelets {
+		switch {
+		case mine[addr]:
+		case m.cordoned[addr]:
+			numCordoned++
+		case busy[addr]:
+			numBusy++
+		default:
+			servable := false
+			for _, p := range modelet.Specs.ServableModelPaths {
+				servable = servable || p == path
+			}
+			if !servable {
+				numUnservable++
+			}
+		}
+	}
+	return fmt.Sprintf("filtered out %d busy, %d cordoned, %d unable to serve the model path", numBusy, numCordoned, numUnservable)
+}
+
+func (m *Mgr) installAssignment(assignment map[modelFullName][]modeletAddr, rationale map[modelFullName]map[modeletAddr]string) {
+	log.V(1).Infof("Install new assignment %v", assignment)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Keep the assignment time of model servers that stay assigned.
+	now := time.Now()
+	assignedAt := make(map[modelFullName]map[modeletAddr]time.Time, len(assignment))
+	for fullName, addrs := range assignment {
+		assignedAt[fullName] = make(map[modeletAddr]time.Time, len(addrs))
+		for _, addr := range addrs {
+			at, ok := m.assignedAt[fullName][addr]
+			if !ok {
+				at = now
+			}
+			assignedAt[fullName][addr] = at
+		}
+	}
+	if rationale != nil {
+		// Model servers that keep their model keep the reason they got it.
+		for fullName, addrs := range assignment {
+			if rationale[fullName] == nil {
+				rationale[fullName] = make(map[modeletAddr]string, len(addrs))
+			}
+			for _, addr := range addrs {
+				if _, ok := rationale[fullName][addr]; ok {
+					continue
+				}
+				reason, ok := m.rationale[fullName][addr]
+				if !ok {
+					reason = "kept: already assigned"
+				}
+				rationale[fullName][addr] = reason
+			}
+		}
+	}
 	m.assignment = assignment
+	m.rationale = rationale
+	m.assignedAt = assignedAt
 }
 
 // loadModels loads models onto newly assigned modelets in parallel.
@@ -723,7 +2650,7 @@ func (m *Mgr) loadModels(ctx context.Context, newlyAssigned []assigner.Action) {
 		if !ok {
 			return fmt.Errorf("model %v has been unpublished", fullName)
 		}
-		model.addrWatcher.Add(modelet.DataAddr)
+		model.addAddr(modelet.DataAddr)
 		return nil
 	}
 
@@ -755,7 +2682,7 @@ func (m *Mgr) unloadModels(ctx context.Context, newlyUnassigned []assigner.Actio
 			// ComputeAssignment but before unloadModels, modelets may not have addr anymore, but
 			// we still need to remove addr from the address watcher. See the matching comment in
 			// pruneModelets for reference.
-			model.addrWatcher.Del(dataAddr)
+			model.delAddr(dataAddr)
 			waiter = model.waiter
 		}
 		modelet, ok := m.modelets[addr]
@@ -795,22 +2722,401 @@ func (m *Mgr) freeUnpublishedNames(unpublished map[modelFullName]bool) {
 	}
 }
 
+// newAssignerLocked returns an assigner that knows about all joined model servers and published
+// models, together with a map from server addrs to the actual data access host:port addrs.
+//
+// REQUIRES: m.mu is held for reading.
+func (m *Mgr) newAssignerLocked() (*assigner.Assigner, map[modeletAddr]string) {
+	a := assigner.New()
+	dataAddress := map[modeletAddr]string{}
+
+	// Tells the assigner about servers. Cordoned servers are not eligible for any model.
+	for addr, state := range m.modelets {
+		if m.cordoned[addr] {
+			continue
+		}
+		sinfo := assigner.NewServerInfo(state.Specs)
+		wanted := state.WantedModels()
+		seen := state.SeenModels()
+		dataAddress[addr] = state.DataAddr
+		for name := range wanted {
+			var status protobuf.ModelStatus
+			if found, ok := seen[name]; !ok {
+				status = protobuf.None
+			} else {
+				status = found.Info.Status
+			}
+			sinfo.AddLoadedModel(name, status)
+		}
+		a.AddServer(assigner.ServerAddr(addr), sinfo)
+	}
+
+	// Tells the assigner about published models.
+	for fullName, model := range m.models {
+		specs := model.specs
+		if placed := placedReplicas(model); placed != int(specs.GetRequestedNumReplicas()) {
+			specs = proto.Clone(specs).(*apb.Model)
+			specs.RequestedNumReplicas = int32(placed)
+		}
+		a.AddModel(fullName, assigner.NewModelInfo(specs))
+	}
+	return a, dataAddress
+}
+
+// PlacementSimulation is the outcome of placing a model that has not been published.
+type PlacementSimulation struct {
+	// The number of replicas the model would place, including its warm pool.
+	Requested int
+
+	// Model servers the model would be loaded onto, sorted by address.
+	Servers []string
+
+	// Why the model would be loaded onto each model server in Servers, keyed by address.
+	Reasons map[string]string
+
+	// The number of requested replicas that no joined model server can take.
+	Shortfall int
+
+	// Why the model would be short of replicas. Empty if Shortfall is 0.
+	ShortfallReason string
+
+	// The number of model servers that could take a replica but already have their maximum number
+	// of models.
+	FullServers int
+}
+
+// SimulatePlacement computes where a model would be placed if it were published now, without
+// publishing it or changing any manager or model server state.
+//
+// The simulation uses the same assignment algorithm as Refresh: the assigner, which takes memory
+// requirements and constraints into account, if --sax_admin_exp_assigner is set, and greedy
+// assignment otherwise.
+func (m *Mgr) SimulatePlacement(ctx context.Context, specs *apb.Model) (*PlacementSimulation, error) {
+	fullName, err := naming.NewModelFullName(specs.GetModelId())
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.models[fullName]; ok {
+		return nil, fmt.Errorf("model %s already exists: %w", fullName, errors.ErrAlreadyExists)
+	}
+	if _, ok := m.pendingUnpublished[fullName]; ok {
+		return nil, fmt.Errorf("model %s is being unpublished, please retry later: %w", fullName, errors.ErrAlreadyExists)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	model := &modelState{specs: specs}
+	sim := &PlacementSimulation{Requested: placedReplicas(model), Reasons: map[string]string{}}
+	if !*expAssigner {
+		models := make(map[modelFullName]*modelState, len(m.models)+1)
+		for name, other := range m.models {
+			models[name] = other
+		}
+		models[fullName] = model
+		result := m.computeAssignmentLocked(models, true)
+
+		assigned := result.NewAssignment[fullName]
+		for _, addr := range assigned {
+			sim.Servers = append(sim.Servers, string(addr))
+			sim.Reasons[string(addr)] = result.Rationale[fullName][addr]
+		}
+		if len(assigned) < sim.Requested {
+			sim.Shortfall = sim.Requested - len(assigned)
+			busy := map[modeletAddr]bool{}
+			for name, addrs := range result.NewAssignment {
+				if name == fullName {
+					continue
+				}
+				for _, addr := range addrs {
+					busy[addr] = true
+				}
+			}
+			sim.ShortfallReason = fmt.Sprintf("greedy: only %d model servers can take the model; %s", len(assigned), m.filteredOutLocked(specs.GetModelPath(), assigned, busy))
+		}
+		sort.Strings(sim.Servers)
+		return sim, nil
+	}
+
+	a, _ := m.newAssignerLocked()
+	if placed := placedReplicas(model); placed != int(specs.GetRequestedNumReplicas()) {
+		specs = proto.Clone(specs).(*apb.Model)
+		specs.RequestedNumReplicas = int32(placed)
+	}
+	a.AddModel(fullName, assigner.NewModelInfo(specs))
+	a.Assign()
+
+	for _, addr := range a.GetAssignment()[fullName] {
+		sim.Servers = append(sim.Servers, string(addr))
+		sim.Reasons[string(addr)] = a.GetRationale()[fullName][addr]
+	}
+	sort.Strings(sim.Servers)
+	sim.FullServers = a.GetShortfalls()[fullName].FullServers
+	if len(sim.Servers) < sim.Requested {
+		sim.Shortfall = sim.Requested - len(sim.Servers)
+		sim.ShortfallReason = fmt.Sprintf("assigner: not enough servers have enough memory for the model and meet its constraints; %d that do already have their maximum number of models", sim.FullServers)
+	}
+	return sim, nil
+}
+
+// EvacuateLabel drains and removes all model servers tagged "<key>=<value>" from serving, e.g.
+// key "zone" for zonal maintenance.
+//
+// Servers are drained in batches of at most evacuateBatchSize. Each batch is first cordoned, so
+// that Refresh places the models it has on other model servers. Models are unloaded from the
+// batch only after each of them has at least as many loaded replicas elsewhere as it had in total
+// when the batch started, capped at the requested number of replicas. Evacuated servers stay
+// cordoned until they leave.
+//
+// If ctx is done before a batch is drained, the servers not yet drained are uncordoned.
+//
+// EvacuateLabel is refused during blackout windows, unless forced.
+//
+// If the evacuation would leave a model with all its replicas on draining model servers, it is
+// refused when --sax_admin_refuse_all_draining is set, and otherwise goes ahead with an alert.
+// Either way, draining replicas keep serving until replacements load elsewhere.
+func (m *Mgr) EvacuateLabel(ctx context.Context, key, value string, force bool) error {
+	m.mu.Lock()
+	if err := m.checkBlackoutLocked(force, fmt.Sprintf("evacuating %s=%s", key, value)); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	var addrs []string
+	draining := map[modeletAddr]bool{}
+	for addr, modelet := range m.modelets {
+		if m.cordoned[addr] || !modelet.Specs.HasLabel(key, value) {
+			continue
+		}
+		addrs = append(addrs, string(addr))
+		draining[addr] = true
+	}
+	allDraining := m.allDrainingLocked(draining)
+	m.mu.Unlock()
+	if len(addrs) == 0 {
+		return fmt.Errorf("no model server labeled %s=%s to evacuate: %w", key, value, errors.ErrNotFound)
+	}
+	if len(allDraining) > 0 {
+		if *refuseAllDraining {
+			return fmt.Errorf("evacuating %s=%s would leave models %v with every replica draining: %w", key, value, allDraining, errors.ErrFailedPrecondition)
+		}
+		log.Errorf("ALERT: Evacuating %s=%s leaves models %v with every replica draining; they keep serving until replacements load", key, value, allDraining)
+	}
+	sort.Strings(addrs)
+	log.Infof("Evacuating %d model servers labeled %s=%s: %v", len(addrs), key, value, addrs)
+
+	for len(addrs) > 0 {
+		n := evacuateBatchSize
+		if n > len(addrs) {
+			n = len(addrs)
+		}
+		var batch []modeletAddr
+		for _, addr := range addrs[:n] {
+			batch = append(batch, modeletAddr(addr))
+		}
+		if err := m.evacuateBatch(ctx, batch); err != nil {
+			m.uncordon(addrs)
+			return fmt.Errorf("failed to evacuate model servers labeled %s=%s: %w", key, value, err)
+		}
+		addrs = addrs[n:]
+	}
+	log.Infof("Evacuated model servers labeled %s=%s", key, value)
+	return nil
+}
+
+// evacuateBatch cordons a batch of model servers, waits for their models to have enough loaded
+// replicas on other model servers, and then unloads all models from the batch.
+func (m *Mgr) evacuateBatch(ctx context.Context, batch []modeletAddr) error {
+	m.mu.Lock()
+	floors := map[modelFullName]int{}
+	for _, addr := range batch {
+		modelet, ok := m.modelets[addr]
+		if !ok {
+			continue
+		}
+		m.cordoned[addr] = true
+		for fullName := range modelet.WantedModels() {
+			model, ok := m.models[fullName]
+			if !ok {
+				continue
+			}
+			floor := model.waiter.Value()
+			if requested := int(model.specs.GetRequestedNumReplicas()); floor > requested {
+				floor = requested
+			}
+			floors[fullName] = floor
+		}
+	}
+	m.mu.Unlock()
+	log.V(1).Infof("Cordoned model servers %v, waiting for models to reach replica floors %v", batch, floors)
+
+	ticker := time.NewTicker(evacuatePollPeriod)
+	defer ticker.Stop()
+	for !m.evacuationReady(batch, floors) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("model servers %v still hold models: %w", batch, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	m.mu.RLock()
+	var toUnload []assigner.Action
+	dataAddress := map[modeletAddr]string{}
+	for _, addr := range batch {
+		modelet, ok := m.modelets[addr]
+		if !ok {
+			continue
+		}
+		dataAddress[addr] = modelet.DataAddr
+		for fullName := range modelet.WantedModels() {
+			toUnload = append(toUnload, assigner.Action{Addr: assigner.ServerAddr(addr), Model: fullName})
+		}
+	}
+	m.mu.RUnlock()
+	m.unloadModels(ctx, toUnload, dataAddress)
+	log.Infof("Drained model servers %v", batch)
+	return nil
+}
+
+// evacuationReady returns true if every model in floors has enough loaded replicas outside the
+// given batch of model servers.
+func (m *Mgr) evacuationReady(batch []modeletAddr, floors map[modelFullName]int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Replicas on cordoned servers, including the batch, are assumed to be loaded. They are all
+	// draining, so they never count toward the floor.
+	draining := map[modelFullName]int{}
+	for addr, modelet := range m.modelets {
+		if !m.cordoned[addr] {
+			continue
+		}
+		for fullName := range modelet.WantedModels() {
+			draining[fullName]++
+		}
+	}
+	for fullName, floor := range floors {
+		model, ok := m.models[fullName]
+		if !ok {
+			// Unpublished models have no floor to keep.
+			continue
+		}
+		if model.waiter.Value()-draining[fullName] < floor {
+			return false
+		}
+	}
+	return true
+}
+
+// allDrainingLocked returns the names of models with a nonzero replica floor whose replicas would
+// all be on draining model servers, i.e. cordoned ones, fully drained ones, or those in draining.
+//
+// REQUIRES: m.mu is held for reading.
+func (m *Mgr) allDrainingLocked(draining map[modeletAddr]bool) []string {
+	serving := map[modelFullName]bool{}
+	drained := map[modelFullName]bool{}
+	for addr, modelet := range m.modelets {
+		for fullName := range modelet.WantedModels() {
+			if m.cordoned[addr] || draining[addr] || m.drains[addr] == 1 {
+				drained[fullName] = true
+			} else {
+				serving[fullName] = true
+			}
+		}
+	}
+	var names []string
+	for fullName := range drained {
+		model, ok := m.models[fullName]
+		if !ok || serving[fullName] {
+			continue
+		}
+		if model.waiter.Value() == 0 || model.specs.GetRequestedNumReplicas() == 0 {
+			continue
+		}
+		names = append(names, fullName.ModelFullName())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// uncordon makes model servers eligible for models again.
+func (m *Mgr) uncordon(addrs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, addr := range addrs {
+		delete(m.cordoned, modeletAddr(addr))
+	}
+}
+
+// drainShuttingDown cordons model servers that report they are shutting down and drains them in
+// the background, as EvacuateLabel would. Such servers stay cordoned until they leave.
+func (m *Mgr) drainShuttingDown() {
+	m.mu.Lock()
+	var addrs []modeletAddr
+	for addr, modelet := range m.modelets {
+		if m.cordoned[addr] || !modelet.ShuttingDown() {
+			continue
+		}
+		// Cordon now so that the following assignment already avoids the server.
+		m.cordoned[addr] = true
+		addrs = append(addrs, addr)
+	}
+	// Servers shutting down go away regardless, so all-draining models can only be alerted on.
+	allDraining := m.allDrainingLocked(nil)
+	m.mu.Unlock()
+	allDrainingModelsVar.Set(int64(len(allDraining)))
+	if len(addrs) > 0 && len(allDraining) > 0 {
+		log.Errorf("ALERT: Models %v have every replica draining; they keep serving until replacements load", allDraining)
+	}
+
+	for _, addr := range addrs {
+		log.Infof("Draining model server %s, which is shutting down", addr)
+		go func(addr modeletAddr) {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+			defer cancel()
+			if err := m.evacuateBatch(ctx, []modeletAddr{addr}); err != nil {
+				log.Warningf("Failed to drain model server %s before it shuts down: %v", addr, err)
+			}
+		}(addr)
+	}
+}
+
 // Refresh updates manager state by reassigning model servers to models and running tasks to carry
 // out the state change, such as prune dead model servers and load/unload models.
 func (m *Mgr) Refresh(ctx context.Context) {
 	// Remove dead model servers.
 	m.pruneModelets(pruneTimeout)
+	// Move models off model servers that are going away.
+	m.drainShuttingDown()
+	// Apply placement constraints again to models whose overrides have expired.
+	m.revertExpiredOverrides(time.Now())
+	// Scale models back down after their temporary replica increases.
+	m.revertExpiredBumps(time.Now())
+	// Roll models out past their canary replicas, or halt their rollouts.
+	m.advanceRollouts(time.Now())
+	// Scale models with latency autoscaling to keep their p99 latency on target.
+	m.autoscaleByLatency(time.Now())
+	// Move replicas between serving and warm pools after changes to requested replicas.
+	m.rebalanceWarmPools()
+	// Move models off model servers that ask to have them reassigned.
+	m.recordReassignRequests()
 
 	var pendingUnpublished map[modelFullName]bool
 	if !*expAssigner {
 		// Compute new assignment.
 		result := m.ComputeAssignment()
 		// Install the new assignment.
-		m.installAssignment(result.NewAssignment)
+		m.installAssignment(result.NewAssignment, result.Rationale)
+		m.recordRelaxed(result.Relaxed)
 		// Unload models according to assignment results.
 		var toUnload []assigner.Action
-		for addr, name := range result.NewlyUnassigned {
-			toUnload = append(toUnload, assigner.Action{Addr: assigner.ServerAddr(addr), Model: name})
+		for addr, names := range result.NewlyUnassigned {
+			for _, name := range names {
+				toUnload = append(toUnload, assigner.Action{Addr: assigner.ServerAddr(addr), Model: name})
+			}
 		}
 		m.unloadModels(ctx, toUnload, result.DataAddress)
 		// Load models according to assignment results.
@@ -822,11 +3128,8 @@ func (m *Mgr) Refresh(ctx context.Context) {
 
 		pendingUnpublished = result.pendingUnpublished
 	} else {
-		a := assigner.New()
-
-		// From server addrs to the actuall data access host:port addrs.
-		dataAddress := map[modeletAddr]string{}
-		pendingUnpublished = map[modelFullName]bool{}
+		var a *assigner.Assigner
+		var dataAddress map[modeletAddr]string
 		{
 			m.mu.RLock()
 
@@ -835,32 +3138,11 @@ func (m *Mgr) Refresh(ctx context.Context) {
 			// placed in newlyUnassigned. Remove them from
 			// m.pendingUnpublished after these unload ops are
 			// successfully issued.
+			pendingUnpublished = map[modelFullName]bool{}
 			for fullName := range m.pendingUnpublished {
 				pendingUnpublished[fullName] = true
 			}
-
-			// Tells the assigner about servers.
-			for addr, state := range m.modelets {
-				sinfo := assigner.NewServerInfo(state.Specs)
-				wanted := state.WantedModels()
-				seen := state.SeenModels()
-				dataAddress[addr] = state.DataAddr
-				for name := range wanted {
-					var status protobuf.ModelStatus
-					if found, ok := seen[name]; !ok {
-						status = protobuf.None
-					} else {
-						status = found.Info.Status
-					}
-					sinfo.AddLoadedModel(name, status)
-				}
-				a.AddServer(assigner.ServerAddr(addr), sinfo)
-			}
-
-			// Tells the assigner about published models.
-			for fullName, model := range m.models {
-				a.AddModel(fullName, assigner.NewModelInfo(model.specs))
-			}
+			a, dataAddress = m.newAssignerLocked()
 
 			m.mu.RUnlock()
 		}
@@ -876,7 +3158,17 @@ func (m *Mgr) Refresh(ctx context.Context) {
 			}
 			newAssign[fullName] = x
 		}
-		m.installAssignment(newAssign)
+		var rationale map[modelFullName]map[modeletAddr]string
+		if *placementRationale {
+			rationale = map[modelFullName]map[modeletAddr]string{}
+			for fullName, reasons := range a.GetRationale() {
+				rationale[fullName] = make(map[modeletAddr]string, len(reasons))
+				for addr, reason := range reasons {
+					rationale[fullName][modeletAddr(addr)] = reason
+				}
+			}
+		}
+		m.installAssignment(newAssign, rationale)
 		m.unloadModels(ctx, a.GetToUnload(), dataAddress)
 		m.loadModels(ctx, a.GetToLoad())
 	}
@@ -888,6 +3180,10 @@ func (m *Mgr) Refresh(ctx context.Context) {
 }
 
 // Restore restores the manager state from its backing store.
+//
+// Restore can be called repeatedly, e.g. by a standby admin server to mirror the state the leader
+// saves: models gone from the store are dropped, new ones are added, and the others keep their
+// state with their specs updated.
 func (m *Mgr) Restore(ctx context.Context) error {
 	if m.store == nil {
 		return fmt.Errorf("no backing store specified: %w", errors.ErrFailedPrecondition)
@@ -896,24 +3192,83 @@ func (m *Mgr) Restore(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
+	stored := make(map[modelFullName]*apb.Model)
 	for _, model := range state.GetModels() {
 		fullName, err := naming.NewModelFullName(model.GetModelId())
 		if err != nil {
 			return err
 		}
+		stored[fullName] = model
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for fullName, model := range m.models {
+		if _, ok := stored[fullName]; !ok {
+			delete(m.models, fullName)
+			model.addrWatcher.Close()
+			model.waiter.Close()
+		}
+	}
+	for fullName, specs := range stored {
+		bump := state.GetReplicaBumps()[fullName.ModelFullName()]
+		rollout := state.GetRollouts()[fullName.ModelFullName()]
+		pending := state.GetPendingReplicas()[fullName.ModelFullName()]
+		override := state.GetConstraintOverrides()[fullName.ModelFullName()]
+		relaxed := modeletAddrSet(state.GetRelaxedServers()[fullName.ModelFullName()])
+		avoid := modeletAddrSet(state.GetAvoidedServers()[fullName.ModelFullName()])
+		if model, ok := m.models[fullName]; ok {
+			model.specs = specs
+			model.pendingReplicas = pending
+			model.bump = bump
+			model.rollout = rollout
+			model.override = override
+			model.relaxed = relaxed
+			model.avoid = avoid
+			continue
+		}
 		m.models[fullName] = &modelState{
-			specs:       model,
-			addrWatcher: watchable.New(),
-			waiter:      waitable.New(),
+			specs:           specs,
+			addrWatcher:     watchable.New(),
+			waiter:          waitable.New(),
+			pendingReplicas: pending,
+			bump:            bump,
+			rollout:         rollout,
+			override:        override,
+			relaxed:         relaxed,
+			avoid:           avoid,
 		}
 	}
+	m.drains = make(map[modeletAddr]float32)
+	for addr, f := range state.GetServerDrains() {
+		m.drains[modeletAddr(addr)] = f
+	}
 	return nil
 }
 
+// modelServerAddresses returns the sorted addresses of a set of model servers, to be saved.
+func modelServerAddresses(addrs map[modeletAddr]bool) *apb.ModelServerAddresses {
+	saved := &apb.ModelServerAddresses{}
+	for addr := range addrs {
+		saved.Addresses = append(saved.Addresses, string(addr))
+	}
+	sort.Strings(saved.Addresses)
+	return saved
+}
+
+// modeletAddrSet returns the set of saved model server addresses, or nil if there are none.
+func modeletAddrSet(saved *apb.ModelServerAddresses) map[modeletAddr]bool {
+	if len(saved.GetAddresses()) == 0 {
+		return nil
+	}
+	addrs := make(map[modeletAddr]bool)
+	for _, addr := range saved.GetAddresses() {
+		addrs[modeletAddr(addr)] = true
+	}
+	return addrs
+}
+
 // Save saves the manager state to its backing store.
 func (m *Mgr) Save(ctx context.Context) error {
 	if m.store == nil {
@@ -922,8 +3277,50 @@ func (m *Mgr) Save(ctx context.Context) error {
 
 	state := &apb.State{}
 	m.mu.RLock()
-	for _, model := range m.models {
+	for fullName, model := range m.models {
 		state.Models = append(state.Models, proto.Clone(model.specs).(*apb.Model))
+		if model.bump != nil {
+			if state.ReplicaBumps == nil {
+				state.ReplicaBumps = make(map[string]*apb.ReplicaBump)
+			}
+			state.ReplicaBumps[fullName.ModelFullName()] = proto.Clone(model.bump).(*apb.ReplicaBump)
+		}
+		if model.rollout != nil {
+			if state.Rollouts == nil {
+				state.Rollouts = make(map[string]*apb.Rollout)
+			}
+			state.Rollouts[fullName.ModelFullName()] = proto.Clone(model.rollout).(*apb.Rollout)
+		}
+		if model.pendingReplicas > 0 {
+			if state.PendingReplicas == nil {
+				state.PendingReplicas = make(map[string]int32)
+			}
+			state.PendingReplicas[fullName.ModelFullName()] = model.pendingReplicas
+		}
+		if model.override != nil {
+			if state.ConstraintOverrides == nil {
+				state.ConstraintOverrides = make(map[string]*apb.ConstraintOverride)
+			}
+			state.ConstraintOverrides[fullName.ModelFullName()] = proto.Clone(model.override).(*apb.ConstraintOverride)
+		}
+		if len(model.relaxed) > 0 {
+			if state.RelaxedServers == nil {
+				state.RelaxedServers = make(map[string]*apb.ModelServerAddresses)
+			}
+			state.RelaxedServers[fullName.ModelFullName()] = modelServerAddresses(model.relaxed)
+		}
+		if len(model.avoid) > 0 {
+			if state.AvoidedServers == nil {
+				state.AvoidedServers = make(map[string]*apb.ModelServerAddresses)
+			}
+			state.AvoidedServers[fullName.ModelFullName()] = modelServerAddresses(model.avoid)
+		}
+	}
+	if len(m.drains) > 0 {
+		state.ServerDrains = make(map[string]float32, len(m.drains))
+		for addr, f := range m.drains {
+			state.ServerDrains[string(addr)] = f
+		}
 	}
 	m.mu.RUnlock()
 	return m.store.Write(ctx, state)
@@ -931,10 +3328,29 @@ func (m *Mgr) Save(ctx context.Context) error {
 
 // Start starts running the manager.
 func (m *Mgr) Start(ctx context.Context) error {
-	if err := m.Restore(ctx); err != nil {
+	m.setRecovering(true)
+	err := m.Restore(ctx)
+	m.setRecovering(false)
+	if err != nil {
 		return err
 	}
 	log.Infof("Loaded manager state")
+	m.run()
+	return nil
+}
+
+// StartRestored starts running a manager whose state is already restored, e.g. by a warm standby
+// that kept it in sync with the backing store, without restoring it again.
+func (m *Mgr) StartRestored(ctx context.Context) error {
+	m.setRecovering(false)
+	m.run()
+	return nil
+}
+
+// run prompts the model servers turned away while recovering to rejoin, and refreshes and saves
+// the manager state periodically until m.Close is called.
+func (m *Mgr) run() {
+	go m.promptTurnedAway()
 
 	// Start a goroutine that calls refresh periodically, stopping when m.Close is called.
 	log.Infof("Refreshing manager state every %v", refreshPeriod)
@@ -958,27 +3374,97 @@ func (m *Mgr) Start(ctx context.Context) error {
 			}
 		}
 	}()
+}
+
+// MarkRecovering makes Join turn model servers away, and Recovering return true, until Start has
+// restored the state. Servers call it before serving RPCs while Start runs.
+func (m *Mgr) MarkRecovering() {
+	m.setRecovering(true)
+}
+
+// Recovering returns true while the manager state isn't restored yet.
+func (m *Mgr) Recovering() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.recovering
+}
+
+func (m *Mgr) setRecovering(recovering bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recovering = recovering
+}
 
+// PromptRejoin asks the model server at addr to join again right away, instead of at its next
+// periodic join, e.g. because the admin server suspects its view of the model server is stale.
+func (m *Mgr) PromptRejoin(ctx context.Context, addr string) error {
+	conn, err := transport.Dial(ctx, m.getTransportSecurity(), addr)
+	if err != nil {
+		return fmt.Errorf("failed to dial model server %v: %w", addr, err)
+	}
+	defer conn.Close()
+	if _, err := mgrpc.NewModeletClient(conn).Rejoin(ctx, &mpb.RejoinRequest{}); err != nil {
+		return fmt.Errorf("failed to prompt model server %v to rejoin: %w", addr, err)
+	}
 	return nil
 }
 
+// promptTurnedAway prompts model servers turned away while recovering to rejoin.
+func (m *Mgr) promptTurnedAway() {
+	m.mu.Lock()
+	turnedAway := m.turnedAway
+	m.turnedAway = make(map[modeletAddr]bool)
+	m.mu.Unlock()
+
+	for addr := range turnedAway {
+		ctx, cancel := context.WithTimeout(context.Background(), promptRejoinTimeout)
+		if err := m.PromptRejoin(ctx, string(addr)); err != nil {
+			// The model server still rejoins periodically.
+			log.Warningf("%v", err)
+		} else {
+			log.Infof("Prompted model server %v turned away while recovering to rejoin", addr)
+		}
+		cancel()
+	}
+}
+
 // Close closes a running manager.
 func (m *Mgr) Close() {
 	// Don't close the channel here, to prevent the goroutine from seeing an empty action.
 	m.ticker.Stop()
 	m.tickerStop <- true
 	<-m.tickerStop
+	m.mu.Lock()
+	if m.registryOps != nil {
+		close(m.registryOps)
+		m.registryOps = nil
+	}
+	m.mu.Unlock()
 	m.eventLogger.Close()
 }
 
 // New creates an empty manager with a backing store.
 func New(store Store) *Mgr {
-	return &Mgr{
+	audit := auditlog.New(env.Get().NewEventLogger(), *auditLogSize)
+	m := &Mgr{
 		models:             make(map[modelFullName]*modelState),
 		modelets:           make(map[modeletAddr]*modeletState),
 		assignment:         make(map[modelFullName][]modeletAddr),
 		pendingUnpublished: make(map[modelFullName]bool),
+		cordoned:           make(map[modeletAddr]bool),
+		drains:             make(map[modeletAddr]float32),
+		turnedAway:         make(map[modeletAddr]bool),
+		pruned:             make(map[modeletAddr]time.Time),
+		evicted:            make(map[modeletAddr]Eviction),
+		flaps:              make(map[modeletAddr][]time.Time),
+		incarnations:       make(map[modeletAddr][]*incarnation),
+		loadLimiter:        state.NewLoadLimiter(),
 		store:              store,
-		eventLogger:        env.Get().NewEventLogger(),
+		eventLogger:        audit,
+		audit:              audit,
+	}
+	if *maxJoinWork > 0 {
+		m.joinWork = make(chan struct{}, *maxJoinWork)
 	}
+	return m
 }
@@ -0,0 +1,2483 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mgr
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+	"saxml/admin/state"
+	"saxml/admin/utils"
+	"saxml/common/errors"
+	"saxml/common/naming"
+	"saxml/common/platform/env"
+	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/retrier"
+	"saxml/common/testutil"
+	"saxml/common/watchable"
+
+	apb "saxml/protobuf/admin_go_proto_grpc"
+	cpb "saxml/protobuf/common_go_proto"
+)
+
+const testModelPath = "saxml.server.lm.params.lm_cloud.LmCloudSpmd2B"
+
+// startModelServers starts n stub model servers that can serve testModelPath and joins them to m
+// with the given tags. It returns the model server addresses in sorted order.
+func startModelServers(ctx context.Context, t *testing.T, m *Mgr, n int, tags ...string) []string {
+	t.Helper()
+	var addrs []string
+	for i := 0; i < n; i++ {
+		port, err := env.Get().PickUnusedPort()
+		if err != nil {
+			t.Fatalf("PickUnusedPort() error %v, want no error", err)
+		}
+		testutil.StartStubModelServerT(t, port)
+		addr := fmt.Sprintf("localhost:%d", port)
+		specs := &apb.ModelServer{
+			ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+			ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+			ServableModelPaths: []string{testModelPath},
+			Tags:               tags,
+		}
+		if err := m.Join(ctx, addr, "", addr, specs); err != nil {
+			t.Fatalf("Join(%v) error %v, want no error", addr, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func newTestModel(id string, replicas int32) *apb.Model {
+	return &apb.Model{
+		ModelId:              id,
+		ModelPath:            testModelPath,
+		CheckpointPath:       "/tmp/checkpoint",
+		RequestedNumReplicas: replicas,
+	}
+}
+
+// greedyReasons returns the placement rationale of the greedy assignment for model servers in
+// addrs, all taken from idle model servers in candidates.
+func greedyReasons(addrs, candidates []string, filtered string) map[string]string {
+	reason := fmt.Sprintf("greedy: picked from %d idle servers able to serve %s, all scored equally (%s); %s", len(candidates), testModelPath, strings.Join(candidates, ", "), filtered)
+	reasons := map[string]string{}
+	for _, addr := range addrs {
+		reasons[addr] = reason
+	}
+	return reasons
+}
+
+// assignerReasons returns the placement rationale of the assigner for model servers in addrs,
+// ranked in order among candidates, each with the memory of a model server joined by
+// startModelServers.
+func assignerReasons(m *Mgr, addrs, candidates []string) map[string]string {
+	m.mu.RLock()
+	mem := utils.GetServerMemoryCapacity(m.modelets[modeletAddr(candidates[0])].Specs)
+	m.mu.RUnlock()
+	var scores []string
+	for _, addr := range candidates {
+		scores = append(scores, fmt.Sprintf("%s=%d", addr, mem))
+	}
+	reasons := map[string]string{}
+	for i, addr := range addrs {
+		reasons[addr] = fmt.Sprintf("assigner: ranked %d of %d candidates by available memory, %d bytes needed; scores: %s", i+1, len(candidates), mem, strings.Join(scores, ", "))
+	}
+	return reasons
+}
+
+func TestSimulatePlacement(t *testing.T) {
+	defer func(exp bool) { *expAssigner = exp }(*expAssigner)
+	for _, tc := range []struct {
+		name    string
+		exp     bool
+		reasons func(m *Mgr, assigned, addrs []string) map[string]string
+	}{
+		{"greedy", false, func(m *Mgr, assigned, addrs []string) map[string]string {
+			return greedyReasons(assigned, addrs, "filtered out 0 busy, 0 cordoned, 0 unable to serve the model path")
+		}},
+		{"assigner", true, func(m *Mgr, assigned, addrs []string) map[string]string { return assignerReasons(m, assigned, addrs) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			*expAssigner = tc.exp
+
+			// With more model servers than replicas, the simulation must pick the same ones as the
+			// publish.
+			m := New(nil)
+			addrs := startModelServers(ctx, t, m, 4)
+			assigned := addrs[:2]
+
+			specs := newTestModel("/sax/test/simulate", 2)
+			sim, err := m.SimulatePlacement(ctx, specs)
+			if err != nil {
+				t.Fatalf("SimulatePlacement(%v) error %v, want no error", specs, err)
+			}
+			want := &PlacementSimulation{
+				Requested: 2,
+				Servers:   assigned,
+				Reasons:   tc.reasons(m, assigned, addrs),
+			}
+			if diff := cmp.Diff(want, sim); diff != "" {
+				t.Errorf("SimulatePlacement(%v) unexpected diff (-want +got):\n%s", specs, diff)
+			}
+
+			// The simulation must not have published the model.
+			fullName, _ := naming.NewModelFullName(specs.GetModelId())
+			if _, err := m.List(fullName); err == nil {
+				t.Errorf("List(%v) after SimulatePlacement succeeded, want error", fullName)
+			}
+
+			// A real publish ends up with the simulated placement.
+			if err := m.Publish(specs); err != nil {
+				t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+			}
+			m.Refresh(ctx)
+			published, err := m.List(fullName)
+			if err != nil {
+				t.Fatalf("List(%v) error %v, want no error", fullName, err)
+			}
+			got := published.GetModeletAddresses()
+			sort.Strings(got)
+			if diff := cmp.Diff(sim.Servers, got); diff != "" {
+				t.Errorf("Published model servers unexpected diff (-simulated +published):\n%s", diff)
+			}
+
+			if _, err := m.SimulatePlacement(ctx, specs); err == nil {
+				t.Errorf("SimulatePlacement(%v) of a published model succeeded, want error", specs)
+			}
+
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			if _, err := m.SimulatePlacement(canceled, newTestModel("/sax/test/canceled", 1)); err == nil {
+				t.Errorf("SimulatePlacement() with a canceled context succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestAssignedMs(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	first := startModelServers(ctx, t, m, 1)[0]
+
+	specs := newTestModel("/sax/test/assigned-ms", 1)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	firstMs, ok := published.GetAssignedMs()[first]
+	if !ok {
+		t.Fatalf("List(%v) has assignment times %v, want one for %v", fullName, published.GetAssignedMs(), first)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	second := startModelServers(ctx, t, m, 1)[0]
+	specs = newTestModel("/sax/test/assigned-ms", 2)
+	if err := m.Update(fullName, specs, false); err != nil {
+		t.Fatalf("Update(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	published, err = m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	got := published.GetAssignedMs()
+	if got[first] != firstMs {
+		t.Errorf("Assignment time of %v = %d after another replica was added, want %d unchanged", first, got[first], firstMs)
+	}
+	if got[second] <= firstMs {
+		t.Errorf("Assignment time of %v = %d, want later than %d of the replica assigned first", second, got[second], firstMs)
+	}
+
+	// WatchLoc clients get the same times, keyed by data address.
+	want := map[string]int64{first: firstMs, second: got[second]}
+	if diff := cmp.Diff(want, m.AssignedMs(fullName, []string{first, second})); diff != "" {
+		t.Errorf("AssignedMs(%v) unexpected diff (-want +got):\n%s", fullName, diff)
+	}
+}
+
+func TestSimulatePlacementShortfall(t *testing.T) {
+	defer func(exp bool) { *expAssigner = exp }(*expAssigner)
+	for _, tc := range []struct {
+		name             string
+		exp              bool
+		reasons          func(m *Mgr, addrs []string) map[string]string
+		shortfall        string
+		unservableReason string
+	}{
+		{
+			name: "greedy",
+			reasons: func(m *Mgr, addrs []string) map[string]string {
+				return greedyReasons(addrs, addrs, "filtered out 0 busy, 0 cordoned, 0 unable to serve the model path")
+			},
+			shortfall:        "greedy: only 2 model servers can take the model; filtered out 0 busy, 0 cordoned, 0 unable to serve the model path",
+			unservableReason: "greedy: only 0 model servers can take the model; filtered out 0 busy, 0 cordoned, 2 unable to serve the model path",
+		},
+		{
+			name:             "assigner",
+			exp:              true,
+			reasons:          func(m *Mgr, addrs []string) map[string]string { return assignerReasons(m, addrs, addrs) },
+			shortfall:        "assigner: not enough servers have enough memory for the model and meet its constraints; 0 that do already have their maximum number of models",
+			unservableReason: "assigner: not enough servers have enough memory for the model and meet its constraints; 0 that do already have their maximum number of models",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			*expAssigner = tc.exp
+
+			m := New(nil)
+			addrs := startModelServers(ctx, t, m, 2)
+
+			specs := newTestModel("/sax/test/shortfall", 3)
+			sim, err := m.SimulatePlacement(ctx, specs)
+			if err != nil {
+				t.Fatalf("SimulatePlacement(%v) error %v, want no error", specs, err)
+			}
+			want := &PlacementSimulation{
+				Requested:       3,
+				Servers:         addrs,
+				Reasons:         tc.reasons(m, addrs),
+				Shortfall:       1,
+				ShortfallReason: tc.shortfall,
+			}
+			if diff := cmp.Diff(want, sim); diff != "" {
+				t.Errorf("SimulatePlacement(%v) unexpected diff (-want +got):\n%s", specs, diff)
+			}
+
+			// Servers that cannot serve the model path do not count.
+			specs = newTestModel("/sax/test/unservable", 1)
+			specs.ModelPath = "saxml.server.lm.params.lm_cloud.Unknown"
+			sim, err = m.SimulatePlacement(ctx, specs)
+			if err != nil {
+				t.Fatalf("SimulatePlacement(%v) error %v, want no error", specs, err)
+			}
+			want = &PlacementSimulation{
+				Requested:       1,
+				Reasons:         map[string]string{},
+				Shortfall:       1,
+				ShortfallReason: tc.unservableReason,
+			}
+			if diff := cmp.Diff(want, sim); diff != "" {
+				t.Errorf("SimulatePlacement(%v) unexpected diff (-want +got):\n%s", specs, diff)
+			}
+		})
+	}
+}
+
+func TestEvacuateLabel(t *testing.T) {
+	ctx := context.Background()
+	defer func(size int, period time.Duration) {
+		evacuateBatchSize, evacuatePollPeriod = size, period
+	}(evacuateBatchSize, evacuatePollPeriod)
+	evacuateBatchSize, evacuatePollPeriod = 1, 10*time.Millisecond
+
+	m := New(nil)
+	zoneA := startModelServers(ctx, t, m, 2, "zone=a")
+
+	specs := newTestModel("/sax/test/evacuate", 2)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.WaitForReady(waitCtx, fullName, 2); err != nil {
+		t.Fatalf("WaitForReady(%v) error %v, want no error", fullName, err)
+	}
+	zoneB := startModelServers(ctx, t, m, 2, "zone=b")
+
+	done := make(chan error)
+	go func() {
+		evacuateCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		done <- m.EvacuateLabel(evacuateCtx, "zone", "a", false)
+	}()
+
+	// Keep refreshing, as the periodic refresh would, and check the replica floor in between.
+	var err error
+	for evacuating := true; evacuating; {
+		select {
+		case err = <-done:
+			evacuating = false
+		case <-time.After(20 * time.Millisecond):
+			m.Refresh(ctx)
+		}
+		m.mu.RLock()
+		loaded := m.models[fullName].waiter.Value()
+		m.mu.RUnlock()
+		if loaded < 2 {
+			t.Fatalf("Model %v has %d loaded replicas during evacuation, want at least 2", fullName, loaded)
+		}
+	}
+	if err != nil {
+		t.Fatalf("EvacuateLabel(zone, a) error %v, want no error", err)
+	}
+
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	got := published.GetModeletAddresses()
+	sort.Strings(got)
+	if diff := cmp.Diff(zoneB, got); diff != "" {
+		t.Errorf("Model servers after evacuation unexpected diff (-want +got):\n%s", diff)
+	}
+	for _, addr := range zoneA {
+		if wanted := m.modelets[modeletAddr(addr)].WantedModels(); len(wanted) != 0 {
+			t.Errorf("Evacuated model server %v still has models %v", addr, wanted)
+		}
+	}
+
+	// Evacuated servers stay out of placement.
+	m.Refresh(ctx)
+	for _, addr := range zoneA {
+		if wanted := m.modelets[modeletAddr(addr)].WantedModels(); len(wanted) != 0 {
+			t.Errorf("Evacuated model server %v got models %v after Refresh", addr, wanted)
+		}
+	}
+
+	if err := m.EvacuateLabel(ctx, "zone", "c", false); err == nil {
+		t.Errorf("EvacuateLabel(zone, c) succeeded, want error")
+	}
+}
+
+func TestEvacuateLabelBlackout(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	startModelServers(ctx, t, m, 1, "zone=a")
+	startModelServers(ctx, t, m, 1, "zone=b")
+
+	now := time.Now()
+	window := &apb.BlackoutWindow{
+		StartMs: now.Add(-time.Hour).UnixMilli(),
+		EndMs:   now.Add(time.Hour).UnixMilli(),
+		Reason:  "launch",
+	}
+	past := &apb.BlackoutWindow{
+		StartMs: now.Add(-2 * time.Hour).UnixMilli(),
+		EndMs:   now.Add(-time.Hour).UnixMilli(),
+	}
+	m.SetBlackoutWindows([]*apb.BlackoutWindow{past, window})
+	if diff := cmp.Diff(window, m.ActiveBlackout(), protocmp.Transform()); diff != "" {
+		t.Errorf("ActiveBlackout() unexpected diff (-want +got):\n%s", diff)
+	}
+	if err := m.EvacuateLabel(ctx, "zone", "a", false); errors.Code(err) != codes.FailedPrecondition {
+		t.Errorf("EvacuateLabel(zone, a) during a blackout error %v, want %v", err, codes.FailedPrecondition)
+	}
+	if err := m.EvacuateLabel(ctx, "zone", "a", true); err != nil {
+		t.Errorf("EvacuateLabel(zone, a) forced during a blackout error %v, want no error", err)
+	}
+
+	// Other disruptive operations are refused too, unless forced, while scaling up isn't.
+	specs := newTestModel("/sax/test/blackout", 2)
+	specs.WarmPoolSize = 1
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	scaled := func(replicas int32) *apb.Model {
+		scaled := proto.Clone(specs).(*apb.Model)
+		scaled.RequestedNumReplicas = replicas
+		return scaled
+	}
+	for _, op := range []struct {
+		desc string
+		run  func(force bool) error
+	}{
+		{"scale-down", func(force bool) error { return m.Update(fullName, scaled(1), force) }},
+		{"replica bump", func(force bool) error {
+			_, err := m.SetReplicasFor(fullName, 4, time.Hour, force)
+			return err
+		}},
+		{"warm pool promotion", func(force bool) error { return m.PromoteWarm(fullName, 1, force) }},
+		{"unpublish", func(force bool) error { return m.Unpublish(fullName, force) }},
+	} {
+		if err := op.run(false); errors.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s during a blackout error %v, want %v", op.desc, err, codes.FailedPrecondition)
+		}
+		if err := op.run(true); err != nil {
+			t.Errorf("%s forced during a blackout error %v, want no error", op.desc, err)
+		}
+		if op.desc == "scale-down" {
+			if err := m.Update(fullName, scaled(2), false); err != nil {
+				t.Errorf("scale-up during a blackout error %v, want no error", err)
+			}
+		}
+	}
+
+	// Outside blackout windows, disruptive operations run.
+	m.SetBlackoutWindows([]*apb.BlackoutWindow{past})
+	if got := m.ActiveBlackout(); got != nil {
+		t.Errorf("ActiveBlackout() = %v, want nil", got)
+	}
+	if err := m.EvacuateLabel(ctx, "zone", "b", false); err != nil {
+		t.Errorf("EvacuateLabel(zone, b) outside blackouts error %v, want no error", err)
+	}
+}
+
+func TestEvacuateLabelAllDraining(t *testing.T) {
+	ctx := context.Background()
+	defer func(period time.Duration, refuse bool) {
+		evacuatePollPeriod, *refuseAllDraining = period, refuse
+	}(evacuatePollPeriod, *refuseAllDraining)
+	evacuatePollPeriod = 10 * time.Millisecond
+
+	m := New(nil)
+	zoneA := startModelServers(ctx, t, m, 1, "zone=a")
+	specs := newTestModel("/sax/test/alldraining", 1)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.WaitForReady(waitCtx, fullName, 1); err != nil {
+		t.Fatalf("WaitForReady(%v) error %v, want no error", fullName, err)
+	}
+
+	// Draining the only replica is refused outright when configured so.
+	*refuseAllDraining = true
+	if err := m.EvacuateLabel(ctx, "zone", "a", false); errors.Code(err) != codes.FailedPrecondition {
+		t.Errorf("EvacuateLabel(zone, a) of the only replica error %v, want %v", err, codes.FailedPrecondition)
+	}
+	m.mu.RLock()
+	cordoned := m.cordoned[modeletAddr(zoneA[0])]
+	m.mu.RUnlock()
+	if cordoned {
+		t.Errorf("Model server %v is cordoned after a refused evacuation", zoneA[0])
+	}
+
+	// Otherwise the evacuation goes ahead, but the only replica keeps serving without a replacement.
+	*refuseAllDraining = false
+	evacuateCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := m.EvacuateLabel(evacuateCtx, "zone", "a", false); err == nil {
+		t.Errorf("EvacuateLabel(zone, a) without room for a replacement succeeded, want an error")
+	}
+	m.mu.RLock()
+	loaded := m.models[fullName].waiter.Value()
+	cordoned = m.cordoned[modeletAddr(zoneA[0])]
+	m.mu.RUnlock()
+	if loaded != 1 {
+		t.Errorf("Model %v has %d loaded replicas after a timed out evacuation, want 1", fullName, loaded)
+	}
+	if cordoned {
+		t.Errorf("Model server %v is cordoned after a timed out evacuation", zoneA[0])
+	}
+}
+
+func TestDrainShuttingDown(t *testing.T) {
+	ctx := context.Background()
+	defer func(period time.Duration) { evacuatePollPeriod = period }(evacuatePollPeriod)
+	evacuatePollPeriod = 10 * time.Millisecond
+
+	m := New(nil)
+	addrs := startModelServers(ctx, t, m, 2)
+
+	specs := newTestModel("/sax/test/shutdown", 1)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.WaitForReady(waitCtx, fullName, 1); err != nil {
+		t.Fatalf("WaitForReady(%v) error %v, want no error", fullName, err)
+	}
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	from := published.GetModeletAddresses()[0]
+	to := addrs[0]
+	if to == from {
+		to = addrs[1]
+	}
+
+	var port int
+	if _, err := fmt.Sscanf(from, "localhost:%d", &port); err != nil {
+		t.Fatalf("Sscanf(%v) error %v, want no error", from, err)
+	}
+	if err := testutil.SetStubModelServerShuttingDown(port, true); err != nil {
+		t.Fatalf("SetStubModelServerShuttingDown(%v) error %v, want no error", port, err)
+	}
+	if err := m.modelets[modeletAddr(from)].Refresh(ctx); err != nil {
+		t.Fatalf("Refresh(%v) error %v, want no error", from, err)
+	}
+	if !m.modelets[modeletAddr(from)].ShuttingDown() {
+		t.Fatalf("Model server %v doesn't report shutting down", from)
+	}
+
+	// Keep refreshing, as the periodic refresh would, until the model moves.
+	for {
+		m.Refresh(ctx)
+		published, err := m.List(fullName)
+		if err != nil {
+			t.Fatalf("List(%v) error %v, want no error", fullName, err)
+		}
+		if diff := cmp.Diff([]string{to}, published.GetModeletAddresses()); diff == "" {
+			break
+		}
+		select {
+		case <-waitCtx.Done():
+			t.Fatalf("Model %v is still on %v, want it moved to %v", fullName, published.GetModeletAddresses(), to)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	m.mu.RLock()
+	cordoned := m.cordoned[modeletAddr(from)]
+	m.mu.RUnlock()
+	if !cordoned {
+		t.Errorf("Model server %v shutting down is not cordoned", from)
+	}
+	if wanted := m.modelets[modeletAddr(from)].WantedModels(); len(wanted) != 0 {
+		t.Errorf("Model server %v shutting down still has models %v", from, wanted)
+	}
+}
+
+func TestReassignRequest(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{state: &apb.State{}}
+	m := New(store)
+	addrs := startModelServers(ctx, t, m, 2)
+
+	specs := newTestModel("/sax/test/reassign", 1)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.WaitForReady(waitCtx, fullName, 1); err != nil {
+		t.Fatalf("WaitForReady(%v) error %v, want no error", fullName, err)
+	}
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	from := published.GetModeletAddresses()[0]
+	to := addrs[0]
+	if to == from {
+		to = addrs[1]
+	}
+
+	var port int
+	if _, err := fmt.Sscanf(from, "localhost:%d", &port); err != nil {
+		t.Fatalf("Sscanf(%v) error %v, want no error", from, err)
+	}
+	if err := testutil.RequestStubModelServerReassign(port, specs.GetModelId()); err != nil {
+		t.Fatalf("RequestStubModelServerReassign(%v) error %v, want no error", port, err)
+	}
+	if err := m.modelets[modeletAddr(from)].Refresh(ctx); err != nil {
+		t.Fatalf("Refresh(%v) error %v, want no error", from, err)
+	}
+
+	// A single refresh moves the model, and later ones don't bring it back.
+	for i := 0; i < 3; i++ {
+		m.Refresh(ctx)
+		published, err := m.List(fullName)
+		if err != nil {
+			t.Fatalf("List(%v) error %v, want no error", fullName, err)
+		}
+		if diff := cmp.Diff([]string{to}, published.GetModeletAddresses()); diff != "" {
+			t.Fatalf("Refresh #%d: model servers of %v unexpected diff (-want +got):\n%s", i, fullName, diff)
+		}
+	}
+	if _, ok := m.modelets[modeletAddr(from)].WantedModels()[fullName]; ok {
+		t.Errorf("Model server %v asking for reassignment still has model %v", from, fullName)
+	}
+	m.mu.RLock()
+	cordoned := m.cordoned[modeletAddr(from)]
+	m.mu.RUnlock()
+	if cordoned {
+		t.Errorf("Model server %v asking for reassignment of one model is cordoned", from)
+	}
+
+	// The request survives a failover, though the model server no longer reports it, and is
+	// forgotten once the model server is pruned.
+	if err := m.Save(ctx); err != nil {
+		t.Fatalf("Save() error %v, want no error", err)
+	}
+	standby := New(store)
+	if err := standby.Restore(ctx); err != nil {
+		t.Fatalf("Restore() error %v, want no error", err)
+	}
+	standby.mu.RLock()
+	avoided := standby.models[fullName].avoid[modeletAddr(from)]
+	standby.mu.RUnlock()
+	if !avoided {
+		t.Errorf("Restored model %v doesn't avoid model server %v", fullName, from)
+	}
+	m.pruneModelets(0)
+	m.mu.RLock()
+	avoided = m.models[fullName].avoid[modeletAddr(from)]
+	m.mu.RUnlock()
+	if avoided {
+		t.Errorf("Model %v still avoids pruned model server %v", fullName, from)
+	}
+}
+
+// driveRollout keeps refreshing m and the status of its model servers, as the periodic refreshes
+// would, until the staged rollout of a model is over. It returns the most model servers the model
+// was assigned to meanwhile.
+func driveRollout(ctx context.Context, t *testing.T, m *Mgr, fullName modelFullName) int {
+	t.Helper()
+	most := 0
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		m.Refresh(ctx)
+		m.mu.RLock()
+		model, ok := m.models[fullName]
+		if !ok || model.rollout == nil {
+			m.mu.RUnlock()
+			return most
+		}
+		assigned := 0
+		for _, modelet := range m.modelets {
+			if _, ok := modelet.WantedModels()[fullName]; ok {
+				assigned++
+			}
+			if err := modelet.Refresh(ctx); err != nil {
+				t.Errorf("Refresh(%v) error %v, want no error", modelet.Addr, err)
+			}
+		}
+		m.mu.RUnlock()
+		if assigned > most {
+			most = assigned
+		}
+	}
+	t.Fatalf("Rollout of model %v still under way after 10s", fullName)
+	return most
+}
+
+// failModel makes the stub model servers at addrs fail to load a model.
+func failModel(t *testing.T, addrs []string, modelID string) {
+	t.Helper()
+	for _, addr := range addrs {
+		var port int
+		if _, err := fmt.Sscanf(addr, "localhost:%d", &port); err != nil {
+			t.Fatalf("Sscanf(%v) error %v, want no error", addr, err)
+		}
+		if err := testutil.FailStubModelServerModel(port, modelID); err != nil {
+			t.Fatalf("FailStubModelServerModel(%v) error %v, want no error", port, err)
+		}
+	}
+}
+
+func TestPublishStaged(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{state: &apb.State{}}
+	m := New(store)
+	startModelServers(ctx, t, m, 4)
+
+	specs := newTestModel("/sax/test/staged", 3)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	options := &apb.RolloutOptions{CanaryReplicas: 1, SoakMs: 50, TimeoutMs: 10000}
+	if err := m.PublishStaged(specs, options); err != nil {
+		t.Fatalf("PublishStaged(%v) error %v, want no error", specs, err)
+	}
+	// Updates during the rollout replace the replicas staged behind the canary.
+	if err := m.Update(fullName, newTestModel(specs.GetModelId(), 4), false); err != nil {
+		t.Fatalf("Update(%v) during a rollout error %v, want no error", fullName, err)
+	}
+	if err := m.UpdateStaged(fullName, newTestModel(specs.GetModelId(), 4), options, false); errors.Code(err) != codes.FailedPrecondition {
+		t.Errorf("UpdateStaged(%v) during a rollout error %v, want %v", fullName, err, codes.FailedPrecondition)
+	}
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	if got := published.GetModel().GetRequestedNumReplicas(); got != 1 {
+		t.Errorf("Requested replicas during the canary stage = %d, want 1", got)
+	}
+	if got := published.GetRollout().GetStagedReplicas(); got != 4 {
+		t.Errorf("Staged replicas during the canary stage = %d, want 4", got)
+	}
+
+	// The rollout survives a failover.
+	if err := m.Save(ctx); err != nil {
+		t.Fatalf("Save() error %v, want no error", err)
+	}
+	standby := New(store)
+	if err := standby.Restore(ctx); err != nil {
+		t.Fatalf("Restore() error %v, want no error", err)
+	}
+	restored, err := standby.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) on the standby error %v, want no error", fullName, err)
+	}
+	if diff := cmp.Diff(published.GetRollout(), restored.GetRollout(), protocmp.Transform()); diff != "" {
+		t.Errorf("Restored rollout unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if most := driveRollout(ctx, t, m, fullName); most != 1 {
+		t.Errorf("Model %v had %d replicas during its canary stage, want 1", fullName, most)
+	}
+	published, err = m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	if got := published.GetModel().GetRequestedNumReplicas(); got != 4 {
+		t.Errorf("Requested replicas after rollout = %d, want 4", got)
+	}
+	m.Refresh(ctx)
+	published, err = m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	if got := len(published.GetModeletAddresses()); got != 4 {
+		t.Errorf("Model servers after rollout = %d, want 4", got)
+	}
+
+	none := newTestModel("/sax/test/none", 3)
+	if err := m.PublishStaged(none, &apb.RolloutOptions{}); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("PublishStaged(%v) with no canaries error %v, want %v", none, err, codes.InvalidArgument)
+	}
+}
+
+func TestPublishStagedHaltsFailingCanary(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	addrs := startModelServers(ctx, t, m, 3)
+	specs := newTestModel("/sax/test/bad", 3)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	failModel(t, addrs, specs.GetModelId())
+
+	if err := m.PublishStaged(specs, &apb.RolloutOptions{CanaryReplicas: 1, SoakMs: time.Hour.Milliseconds()}); err != nil {
+		t.Fatalf("PublishStaged(%v) error %v, want no error", specs, err)
+	}
+	if most := driveRollout(ctx, t, m, fullName); most != 1 {
+		t.Errorf("Model %v reached %d model servers with a failing canary, want 1", fullName, most)
+	}
+
+	// The halted model is reverted.
+	if _, err := m.List(fullName); err == nil {
+		t.Errorf("List(%v) after a halted rollout succeeded, want error", fullName)
+	}
+	m.Refresh(ctx)
+	for _, addr := range addrs {
+		if wanted := m.modelets[modeletAddr(addr)].WantedModels(); len(wanted) != 0 {
+			t.Errorf("Model server %v still has models %v after a halted rollout", addr, wanted)
+		}
+	}
+}
+
+func TestPublishStagedTimesOut(t *testing.T) {
+	m := New(nil)
+	specs := newTestModel("/sax/test/stuck", 2)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.PublishStaged(specs, &apb.RolloutOptions{CanaryReplicas: 1, TimeoutMs: 1}); err != nil {
+		t.Fatalf("PublishStaged(%v) error %v, want no error", specs, err)
+	}
+	// No model server ever loads the canary.
+	m.advanceRollouts(time.Now().Add(time.Second))
+	if _, err := m.List(fullName); errors.Code(err) != codes.NotFound {
+		t.Errorf("List(%v) after a timed out rollout error %v, want %v", fullName, err, codes.NotFound)
+	}
+}
+
+func TestUpdateStagedHaltsFailingCanary(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	startModelServers(ctx, t, m, 1)
+	specs := newTestModel("/sax/test/grow", 1)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.WaitForReady(waitCtx, fullName, 1); err != nil {
+		t.Fatalf("WaitForReady(%v) error %v, want no error", fullName, err)
+	}
+	failModel(t, startModelServers(ctx, t, m, 3), specs.GetModelId())
+
+	grown := newTestModel(specs.GetModelId(), 4)
+	grown.AdminAcl = "new-admins"
+	if err := m.UpdateStaged(fullName, grown, &apb.RolloutOptions{CanaryReplicas: 1, SoakMs: time.Hour.Milliseconds()}, false); err != nil {
+		t.Fatalf("UpdateStaged(%v) error %v, want no error", fullName, err)
+	}
+	if most := driveRollout(ctx, t, m, fullName); most != 2 {
+		t.Errorf("Model %v reached %d model servers with a failing canary, want 2", fullName, most)
+	}
+
+	// The halted update is reverted, and the model keeps serving.
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) after a halted update error %v, want no error", fullName, err)
+	}
+	if diff := cmp.Diff(specs, published.GetModel(), protocmp.Transform()); diff != "" {
+		t.Errorf("List(%v) after a halted update unexpected diff (-want +got):\n%s", fullName, diff)
+	}
+	if published.GetRollout() != nil {
+		t.Errorf("List(%v) after a halted update has rollout %v, want none", fullName, published.GetRollout())
+	}
+}
+
+func TestMembershipVars(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	before := time.Now()
+	startModelServers(ctx, t, m, 2)
+
+	if got := expvar.Get("sax_admin_joined_servers").String(); got != "2" {
+		t.Errorf("sax_admin_joined_servers = %s after 2 joins, want 2", got)
+	}
+	var lastJoin string
+	if err := json.Unmarshal([]byte(expvar.Get("sax_admin_last_join_time").String()), &lastJoin); err != nil {
+		t.Fatalf("sax_admin_last_join_time is not a JSON string: %v", err)
+	}
+	if joined, err := time.Parse(time.RFC3339Nano, lastJoin); err != nil || joined.Before(before) {
+		t.Errorf("sax_admin_last_join_time = %q, want a time after %v", lastJoin, before)
+	}
+
+	m.pruneModelets(0)
+	if got := expvar.Get("sax_admin_joined_servers").String(); got != "0" {
+		t.Errorf("sax_admin_joined_servers = %s after pruning all, want 0", got)
+	}
+}
+
+func TestEvictionReasons(t *testing.T) {
+	ctx := context.Background()
+	port := func(addr string) int {
+		t.Helper()
+		var port int
+		if _, err := fmt.Sscanf(addr, "localhost:%d", &port); err != nil {
+			t.Fatalf("Sscanf(%v) error %v, want no error", addr, err)
+		}
+		return port
+	}
+	tests := []struct {
+		desc  string
+		evict func(m *Mgr, addr string)
+		want  EvictionReason
+	}{
+		{
+			desc:  "unresponsive",
+			evict: func(m *Mgr, addr string) { m.pruneModelets(0) },
+			want:  EvictedUnresponsive,
+		},
+		{
+			desc: "shut down",
+			evict: func(m *Mgr, addr string) {
+				if err := testutil.SetStubModelServerShuttingDown(port(addr), true); err != nil {
+					t.Fatalf("SetStubModelServerShuttingDown(%v) error %v, want no error", addr, err)
+				}
+				if err := m.modelets[modeletAddr(addr)].Refresh(ctx); err != nil {
+					t.Fatalf("Refresh(%v) error %v, want no error", addr, err)
+				}
+				m.drainShuttingDown()
+				m.pruneModelets(0)
+			},
+			want: EvictedShutDown,
+		},
+		{
+			desc: "evacuated",
+			evict: func(m *Mgr, addr string) {
+				if err := m.EvacuateLabel(ctx, "zone", "a", false); err != nil {
+					t.Fatalf("EvacuateLabel(zone, a) error %v, want no error", err)
+				}
+				m.pruneModelets(0)
+			},
+			want: EvictedEvacuated,
+		},
+		{
+			desc: "replaced",
+			evict: func(m *Mgr, addr string) {
+				specs := &apb.ModelServer{
+					ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+					ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+					ServableModelPaths: []string{testModelPath},
+					Tags:               []string{"zone=b"},
+				}
+				if err := m.Join(ctx, addr, "", addr, specs); err != nil {
+					t.Fatalf("Join(%v) error %v, want no error", addr, err)
+				}
+			},
+			want: EvictedReplaced,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := New(nil)
+			addr := startModelServers(ctx, t, m, 1, "zone=a")[0]
+			if _, ok := m.Eviction(addr); ok {
+				t.Fatalf("Eviction(%v) of a joined model server found, want none", addr)
+			}
+			tc.evict(m, addr)
+			eviction, ok := m.Eviction(addr)
+			if !ok {
+				t.Fatalf("Eviction(%v) found none, want %v", addr, tc.want)
+			}
+			if eviction.Reason != tc.want {
+				t.Errorf("Eviction(%v) reason = %v, want %v", addr, eviction.Reason, tc.want)
+			}
+			if eviction.Time.IsZero() {
+				t.Errorf("Eviction(%v) has no time", addr)
+			}
+		})
+	}
+}
+
+func TestEvictionRetention(t *testing.T) {
+	ctx := context.Background()
+	defer func(retention time.Duration) { evictionRetention = retention }(evictionRetention)
+
+	m := New(nil)
+	addr := startModelServers(ctx, t, m, 1)[0]
+	m.pruneModelets(0)
+	if _, err := m.Locate(addr); errors.Code(err) != codes.NotFound {
+		t.Errorf("Locate(%v) of an evicted model server error %v, want %v", addr, err, codes.NotFound)
+	} else if want := EvictedUnresponsive.String(); !strings.Contains(err.Error(), want) {
+		t.Errorf("Locate(%v) error %v, want it to mention %q", addr, err, want)
+	}
+
+	evictionRetention = 0
+	if eviction, ok := m.Eviction(addr); ok {
+		t.Errorf("Eviction(%v) = %v past retention, want none", addr, eviction)
+	}
+
+	// Pruned addresses are forgotten too, so the next pruning doesn't leave them behind.
+	if _, ok := m.pruned[modeletAddr(addr)]; !ok {
+		t.Fatalf("Pruned model server %v not remembered", addr)
+	}
+	m.pruneModelets(0)
+	if _, ok := m.pruned[modeletAddr(addr)]; ok {
+		t.Errorf("Pruned model server %v remembered past retention", addr)
+	}
+}
+
+func TestOscillation(t *testing.T) {
+	ctx := context.Background()
+	defer func(window time.Duration) { flapWindow = window }(flapWindow)
+
+	m := New(nil)
+	addr := startModelServers(ctx, t, m, 1)[0]
+	specs := &apb.ModelServer{
+		ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+		ServableModelPaths: []string{testModelPath},
+	}
+	// The model server is evicted as unresponsive whenever this admin server looks, and rejoins
+	// right away. Pruning with a timeout of -elapsed looks as if elapsed passed since it joined.
+	evictAndRejoin := func(elapsed time.Duration) {
+		t.Helper()
+		m.pruneModelets(-elapsed)
+		if _, err := m.Locate(addr); errors.Code(err) != codes.NotFound {
+			t.Fatalf("Locate(%v) after pruning error %v, want %v", addr, err, codes.NotFound)
+		}
+		if err := m.Join(ctx, addr, "", addr, specs); err != nil {
+			t.Fatalf("Join(%v) error %v, want no error", addr, err)
+		}
+	}
+	for i := 1; i < flapThreshold; i++ {
+		evictAndRejoin(0)
+	}
+	if got := m.Oscillations(); len(got) != 0 {
+		t.Errorf("Oscillations() after %d evictions = %v, want none", flapThreshold-1, got)
+	}
+
+	// One more cycle makes the address oscillate: the next eviction is put off.
+	evictAndRejoin(0)
+	want := []Oscillation{{Addr: addr, Evictions: flapThreshold, Backoff: flapBackoff}}
+	if diff := cmp.Diff(want, m.Oscillations()); diff != "" {
+		t.Errorf("Oscillations() unexpected diff (-want +got):\n%s", diff)
+	}
+	if got := expvar.Get("sax_admin_oscillating_servers").String(); got != "1" {
+		t.Errorf("sax_admin_oscillating_servers = %s, want 1", got)
+	}
+	m.pruneModelets(0)
+	if _, err := m.Locate(addr); err != nil {
+		t.Errorf("Locate(%v) of an oscillating model server error %v, want it kept", addr, err)
+	}
+
+	// Once the backoff has passed, the model server is evicted again, and the backoff doubles.
+	evictAndRejoin(flapBackoff)
+	want = []Oscillation{{Addr: addr, Evictions: flapThreshold + 1, Backoff: 2 * flapBackoff}}
+	if diff := cmp.Diff(want, m.Oscillations()); diff != "" {
+		t.Errorf("Oscillations() after another eviction unexpected diff (-want +got):\n%s", diff)
+	}
+	m.pruneModelets(0)
+	if _, err := m.Locate(addr); err != nil {
+		t.Errorf("Locate(%v) after another eviction error %v, want it kept", addr, err)
+	}
+
+	// The diagnostic is gone once the evictions fall out of the window.
+	flapWindow = 0
+	if got := m.Oscillations(); len(got) != 0 {
+		t.Errorf("Oscillations() past the window = %v, want none", got)
+	}
+	if got := expvar.Get("sax_admin_oscillating_servers").String(); got != "0" {
+		t.Errorf("sax_admin_oscillating_servers past the window = %s, want 0", got)
+	}
+}
+
+func TestIncarnations(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	addrs := startModelServers(ctx, t, m, 2)
+	restarted, gone := addrs[0], addrs[1]
+	check := func(desc, addr string, wantCurrent []bool) {
+		t.Helper()
+		incarnations := m.Incarnations(addr)
+		var gotCurrent []bool
+		for _, inc := range incarnations {
+			gotCurrent = append(gotCurrent, inc.GetCurrent())
+			if inc.GetCurrent() != (inc.GetRemovedMs() == 0) {
+				t.Errorf("%s: Incarnations(%v) has %v, want a removal time iff stale", desc, addr, inc)
+			}
+		}
+		if diff := cmp.Diff(wantCurrent, gotCurrent); diff != "" {
+			t.Errorf("%s: Incarnations(%v) current flags unexpected diff (-want +got):\n%s", desc, addr, diff)
+		}
+	}
+	check("after join", restarted, []bool{true})
+
+	// Both model servers go away, and one of them comes back.
+	m.pruneModelets(0)
+	specs := &apb.ModelServer{
+		ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+		ServableModelPaths: []string{testModelPath},
+	}
+	if err := m.Join(ctx, restarted, "", restarted, specs); err != nil {
+		t.Fatalf("Join(%v) error %v, want no error", restarted, err)
+	}
+	check("after restart", restarted, []bool{false, true})
+	check("after leaving", gone, []bool{false})
+	if got := m.Incarnations(restarted)[0].GetRemovalReason(); got != EvictedUnresponsive.String() {
+		t.Errorf("Stale incarnation removal reason = %q, want %q", got, EvictedUnresponsive)
+	}
+
+	if got := m.PurgeStaleIncarnations(); got != 2 {
+		t.Errorf("PurgeStaleIncarnations() = %d, want 2", got)
+	}
+	check("after purge", restarted, []bool{true})
+	check("after purge", gone, nil)
+	if got := m.PurgeStaleIncarnations(); got != 0 {
+		t.Errorf("PurgeStaleIncarnations() again = %d, want 0", got)
+	}
+}
+
+func TestPickScaleDown(t *testing.T) {
+	replicas := []replicaInfo{
+		{loaded: true, load: 5, numModels: 1},
+		{loaded: true, load: 1, numModels: 3},
+		{loaded: true, load: 8, numModels: 2},
+	}
+	tests := []struct {
+		desc     string
+		strategy apb.Config_ScaleDownStrategy
+		replicas []replicaInfo
+		want     int
+	}{
+		{"least loaded", apb.Config_SCALE_DOWN_LEAST_LOADED, replicas, 1},
+		{"emptiest server", apb.Config_SCALE_DOWN_EMPTIEST_SERVER, replicas, 0},
+		{
+			"not loaded first",
+			apb.Config_SCALE_DOWN_LEAST_LOADED,
+			append([]replicaInfo{{loaded: false, load: 9, numModels: 9}}, replicas...),
+			0,
+		},
+		{
+			"ties go last",
+			apb.Config_SCALE_DOWN_LEAST_LOADED,
+			[]replicaInfo{{loaded: true}, {loaded: true}, {loaded: true}},
+			2,
+		},
+	}
+	for _, tc := range tests {
+		if got := pickScaleDown(tc.strategy, tc.replicas); got != tc.want {
+			t.Errorf("%s: pickScaleDown(%v) = %d, want %d", tc.desc, tc.replicas, got, tc.want)
+		}
+	}
+}
+
+// memStore is an in-memory backing store.
+type memStore struct {
+	state *apb.State
+}
+
+func (s *memStore) Read(ctx context.Context) (*apb.State, error) {
+	return proto.Clone(s.state).(*apb.State), nil
+}
+
+func (s *memStore) Write(ctx context.Context, state *apb.State) error {
+	s.state = proto.Clone(state).(*apb.State)
+	return nil
+}
+
+func TestRestoreMirrorsStore(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{state: &apb.State{}}
+	leader, standby := New(store), New(store)
+	models := func(m *Mgr) map[string]int32 {
+		got := make(map[string]int32)
+		for _, published := range m.ListAll() {
+			got[published.GetModel().GetModelId()] = published.GetModel().GetRequestedNumReplicas()
+		}
+		return got
+	}
+	mirror := func(desc string) {
+		t.Helper()
+		if err := leader.Save(ctx); err != nil {
+			t.Fatalf("%s: Save() error %v, want no error", desc, err)
+		}
+		if err := standby.Restore(ctx); err != nil {
+			t.Fatalf("%s: Restore() error %v, want no error", desc, err)
+		}
+		if diff := cmp.Diff(models(leader), models(standby)); diff != "" {
+			t.Errorf("%s: standby models unexpected diff (-leader +standby):\n%s", desc, diff)
+		}
+	}
+
+	foo, bar := newTestModel("/sax/test/foo", 1), newTestModel("/sax/test/bar", 1)
+	for _, model := range []*apb.Model{foo, bar} {
+		if err := leader.Publish(model); err != nil {
+			t.Fatalf("Publish(%v) error %v, want no error", model, err)
+		}
+	}
+	mirror("after publishing")
+
+	fooName, _ := naming.NewModelFullName(foo.GetModelId())
+	update := proto.Clone(leader.FindModel(fooName)).(*apb.Model)
+	update.RequestedNumReplicas = 3
+	if err := leader.Update(fooName, update, false); err != nil {
+		t.Fatalf("Update(%v) error %v, want no error", update, err)
+	}
+	mirror("after updating")
+
+	barName, _ := naming.NewModelFullName(bar.GetModelId())
+	if err := leader.Unpublish(barName, false); err != nil {
+		t.Fatalf("Unpublish(%v) error %v, want no error", barName, err)
+	}
+	mirror("after unpublishing")
+}
+
+// slowStore is a memStore whose Read blocks until release is closed.
+type slowStore struct {
+	memStore
+	reading chan bool
+	release chan bool
+}
+
+func (s *slowStore) Read(ctx context.Context) (*apb.State, error) {
+	close(s.reading)
+	<-s.release
+	return s.memStore.Read(ctx)
+}
+
+func TestJoinDuringRecovery(t *testing.T) {
+	ctx := context.Background()
+	store := &slowStore{
+		memStore: memStore{state: &apb.State{}},
+		reading:  make(chan bool),
+		release:  make(chan bool),
+	}
+	m := New(store)
+	started := make(chan error)
+	go func() { started <- m.Start(ctx) }()
+	<-store.reading
+
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubModelServerT(t, port)
+	addr := fmt.Sprintf("localhost:%d", port)
+	specs := &apb.ModelServer{
+		ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+		ServableModelPaths: []string{testModelPath},
+	}
+	join := func() error { return m.Join(ctx, addr, "", addr, specs) }
+
+	if err := join(); err != ErrRecovering {
+		t.Fatalf("Join() during recovery error %v, want %v", err, ErrRecovering)
+	}
+	if _, err := m.Locate(addr); err == nil {
+		t.Errorf("Locate(%v) during recovery succeeded, want an error", addr)
+	}
+
+	joined := make(chan error)
+	go func() { joined <- retrier.Do(ctx, join, func(err error) bool { return err == ErrRecovering }) }()
+	time.Sleep(100 * time.Millisecond)
+	close(store.release)
+	if err := <-started; err != nil {
+		t.Fatalf("Start() error %v, want no error", err)
+	}
+	defer m.Close()
+	if err := <-joined; err != nil {
+		t.Fatalf("Join() retried after recovery error %v, want no error", err)
+	}
+	if _, err := m.Locate(addr); err != nil {
+		t.Errorf("Locate(%v) after recovery error %v, want no error", addr, err)
+	}
+}
+
+func TestOverrideAllows(t *testing.T) {
+	const path = "saxml.server.lm.params.lm_cloud.Model"
+	other := []string{"saxml.server.lm.params.lm_cloud.Other"}
+	tests := []struct {
+		name     string
+		override *apb.ConstraintOverride
+		servable []string
+		busy     bool
+		want     bool
+	}{
+		{"servable idle", &apb.ConstraintOverride{AllowOverpacking: true}, []string{path}, false, true},
+		{"unservable", &apb.ConstraintOverride{AllowOverpacking: true}, other, false, false},
+		{"ignore servable paths", &apb.ConstraintOverride{IgnoreServablePaths: true}, other, false, true},
+		{"busy", &apb.ConstraintOverride{IgnoreServablePaths: true}, []string{path}, true, false},
+		{"overpacking", &apb.ConstraintOverride{AllowOverpacking: true}, []string{path}, true, true},
+		{"overpacking unservable", &apb.ConstraintOverride{AllowOverpacking: true}, other, true, false},
+		{"both", &apb.ConstraintOverride{IgnoreServablePaths: true, AllowOverpacking: true}, other, true, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := overrideAllows(tc.override, path, tc.servable, tc.busy); got != tc.want {
+				t.Errorf("overrideAllows(%v, %v, %v, %v) = %v, want %v", tc.override, path, tc.servable, tc.busy, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOverrideConstraints(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{state: &apb.State{}}
+	m := New(store)
+	addrs := startModelServers(ctx, t, m, 1)
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubModelServerT(t, port)
+	other := fmt.Sprintf("localhost:%d", port)
+	specs := &apb.ModelServer{
+		ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+		ServableModelPaths: []string{"saxml.server.lm.params.lm_cloud.Other"},
+	}
+	if err := m.Join(ctx, other, "", other, specs); err != nil {
+		t.Fatalf("Join(%v) error %v, want no error", other, err)
+	}
+
+	model := newTestModel("/sax/test/override", 2)
+	fullName, _ := naming.NewModelFullName(model.GetModelId())
+	if err := m.Publish(model); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", model, err)
+	}
+	listServers := func() *apb.PublishedModel {
+		t.Helper()
+		published, err := m.List(fullName)
+		if err != nil {
+			t.Fatalf("List(%v) error %v, want no error", fullName, err)
+		}
+		return published
+	}
+	m.Refresh(ctx)
+	if diff := cmp.Diff(addrs, listServers().GetModeletAddresses()); diff != "" {
+		t.Errorf("Model servers before override unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Invalid overrides are rejected.
+	for _, tc := range []struct {
+		override *apb.ConstraintOverride
+		duration time.Duration
+	}{
+		{&apb.ConstraintOverride{IgnoreServablePaths: true, Reason: "outage"}, 0},
+		{&apb.ConstraintOverride{Reason: "outage"}, time.Hour},
+		{&apb.ConstraintOverride{IgnoreServablePaths: true}, time.Hour},
+	} {
+		if _, err := m.OverrideConstraints(fullName, tc.override, tc.duration); errors.Code(err) != codes.InvalidArgument {
+			t.Errorf("OverrideConstraints(%v, %v) error %v, want %v", tc.override, tc.duration, err, errors.ErrInvalidArgument)
+		}
+	}
+
+	// The override lets the model use the model server that can't serve its path.
+	const duration = 500 * time.Millisecond
+	override, err := m.OverrideConstraints(fullName, &apb.ConstraintOverride{IgnoreServablePaths: true, Reason: "outage"}, duration)
+	if err != nil {
+		t.Fatalf("OverrideConstraints(%v) error %v, want no error", fullName, err)
+	}
+	m.Refresh(ctx)
+	published := listServers()
+	want := append([]string{other}, addrs...)
+	sort.Strings(want)
+	got := published.GetModeletAddresses()
+	sort.Strings(got)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Model servers under override unexpected diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(override, published.GetConstraintOverride(), protocmp.Transform()); diff != "" {
+		t.Errorf("Listed override unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// The override, and the model servers it let the model use, survive a failover.
+	if err := m.Save(ctx); err != nil {
+		t.Fatalf("Save() error %v, want no error", err)
+	}
+	standby := New(store)
+	if err := standby.Restore(ctx); err != nil {
+		t.Fatalf("Restore() error %v, want no error", err)
+	}
+	standby.mu.RLock()
+	restored := standby.models[fullName]
+	if diff := cmp.Diff(override, restored.override, protocmp.Transform()); diff != "" {
+		t.Errorf("Restored override unexpected diff (-want +got):\n%s", diff)
+	}
+	if !restored.relaxed[modeletAddr(other)] {
+		t.Errorf("Restored model %v doesn't record %v as placed by its override", fullName, other)
+	}
+	standby.mu.RUnlock()
+
+	// After the override expires, the model moves off that model server.
+	time.Sleep(time.Until(time.UnixMilli(override.GetExpireMs())))
+	m.Refresh(ctx)
+	published = listServers()
+	if diff := cmp.Diff(addrs, published.GetModeletAddresses()); diff != "" {
+		t.Errorf("Model servers after override expiry unexpected diff (-want +got):\n%s", diff)
+	}
+	if published.GetConstraintOverride() != nil {
+		t.Errorf("Listed override %v after expiry, want none", published.GetConstraintOverride())
+	}
+}
+
+func TestPlacementRationale(t *testing.T) {
+	defer func(exp bool) { *expAssigner = exp }(*expAssigner)
+	defer func(enabled bool) { *placementRationale = enabled }(*placementRationale)
+	for _, tc := range []struct {
+		name    string
+		exp     bool
+		reasons func(m *Mgr, assigned, addrs []string) map[string]string
+	}{
+		{"greedy", false, func(m *Mgr, assigned, addrs []string) map[string]string {
+			return greedyReasons(assigned, addrs, "filtered out 0 busy, 0 cordoned, 1 unable to serve the model path")
+		}},
+		{"assigner", true, func(m *Mgr, assigned, addrs []string) map[string]string { return assignerReasons(m, assigned, addrs) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			*expAssigner = tc.exp
+			*placementRationale = true
+
+			m := New(nil)
+			addrs := startModelServers(ctx, t, m, 2)
+			// A model server that can't serve the model.
+			port, err := env.Get().PickUnusedPort()
+			if err != nil {
+				t.Fatalf("PickUnusedPort() error %v, want no error", err)
+			}
+			testutil.StartStubModelServerT(t, port)
+			other := fmt.Sprintf("localhost:%d", port)
+			specs := &apb.ModelServer{
+				ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+				ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+				ServableModelPaths: []string{"saxml.server.lm.params.lm_cloud.Other"},
+			}
+			if err := m.Join(ctx, other, "", other, specs); err != nil {
+				t.Fatalf("Join(%v) error %v, want no error", other, err)
+			}
+
+			model := newTestModel("/sax/test/rationale", 1)
+			fullName, _ := naming.NewModelFullName(model.GetModelId())
+			if err := m.Publish(model); err != nil {
+				t.Fatalf("Publish(%v) error %v, want no error", model, err)
+			}
+			m.Refresh(ctx)
+			published, err := m.List(fullName)
+			if err != nil {
+				t.Fatalf("List(%v) error %v, want no error", fullName, err)
+			}
+			assigned := published.GetModeletAddresses()
+			if len(assigned) != 1 {
+				t.Fatalf("List(%v) got model servers %v, want 1 of %v", fullName, assigned, addrs)
+			}
+			want := tc.reasons(m, assigned, addrs)
+			if diff := cmp.Diff(want, published.GetPlacementRationale()); diff != "" {
+				t.Errorf("Placement rationale unexpected diff (-want +got):\n%s", diff)
+			}
+
+			// Model servers that keep their model keep the reason they got it.
+			m.Refresh(ctx)
+			published, err = m.List(fullName)
+			if err != nil {
+				t.Fatalf("List(%v) error %v, want no error", fullName, err)
+			}
+			if diff := cmp.Diff(want, published.GetPlacementRationale()); diff != "" {
+				t.Errorf("Placement rationale after another Refresh unexpected diff (-want +got):\n%s", diff)
+			}
+
+			// Without the flag, no rationale is kept.
+			*placementRationale = false
+			m.Refresh(ctx)
+			published, err = m.List(fullName)
+			if err != nil {
+				t.Fatalf("List(%v) error %v, want no error", fullName, err)
+			}
+			if got := published.GetPlacementRationale(); len(got) != 0 {
+				t.Errorf("Placement rationale %v with the flag unset, want none", got)
+			}
+		})
+	}
+}
+
+func TestOverridesKeepMaxModels(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	joinWithMaxModels := func(maxModels int32) string {
+		t.Helper()
+		port, err := env.Get().PickUnusedPort()
+		if err != nil {
+			t.Fatalf("PickUnusedPort() error %v, want no error", err)
+		}
+		testutil.StartStubModelServerT(t, port)
+		addr := fmt.Sprintf("localhost:%d", port)
+		specs := &apb.ModelServer{
+			ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+			ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+			ServableModelPaths: []string{testModelPath},
+			MaxModels:          maxModels,
+		}
+		if err := m.Join(ctx, addr, "", addr, specs); err != nil {
+			t.Fatalf("Join(%v) error %v, want no error", addr, err)
+		}
+		return addr
+	}
+	full := joinWithMaxModels(1)
+	roomy := joinWithMaxModels(2)
+
+	first := newTestModel("/sax/test/maxmodels0", 2)
+	if err := m.Publish(first); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", first, err)
+	}
+	m.Refresh(ctx)
+
+	// Overpacking places the second model only on the model server with room for another model.
+	second := newTestModel("/sax/test/maxmodels1", 2)
+	fullName, _ := naming.NewModelFullName(second.GetModelId())
+	if err := m.Publish(second); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", second, err)
+	}
+	if _, err := m.OverrideConstraints(fullName, &apb.ConstraintOverride{AllowOverpacking: true, Reason: "outage"}, time.Hour); err != nil {
+		t.Fatalf("OverrideConstraints(%v) error %v, want no error", fullName, err)
+	}
+	m.Refresh(ctx)
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	if diff := cmp.Diff([]string{roomy}, published.GetModeletAddresses()); diff != "" {
+		t.Errorf("Model servers of %v unexpected diff (-want +got), %v is full:\n%s", fullName, full, diff)
+	}
+
+	// Unpublishing both models unloads both from the model server holding them.
+	numWanted := func() int {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return len(m.modelets[modeletAddr(roomy)].WantedModels())
+	}
+	for deadline := time.Now().Add(10 * time.Second); numWanted() < 2; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Model server %v has %d models, want 2", roomy, numWanted())
+		}
+	}
+	firstName, _ := naming.NewModelFullName(first.GetModelId())
+	for _, name := range []modelFullName{firstName, fullName} {
+		if err := m.Unpublish(name, false); err != nil {
+			t.Fatalf("Unpublish(%v) error %v, want no error", name, err)
+		}
+	}
+	got := m.ComputeAssignment().NewlyUnassigned[modeletAddr(roomy)]
+	sortNames := cmpopts.SortSlices(func(a, b modelFullName) bool { return a.ModelFullName() < b.ModelFullName() })
+	if diff := cmp.Diff([]modelFullName{firstName, fullName}, got, sortNames); diff != "" {
+		t.Errorf("Models unassigned from %v unexpected diff (-want +got):\n%s", roomy, diff)
+	}
+}
+
+func TestApproveScale(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{state: &apb.State{}}
+	m := New(store)
+	m.SetScaleApprovalBaseline(1)
+	startModelServers(ctx, t, m, 3)
+
+	specs := newTestModel("/sax/test/approve", 2)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	check := func(desc string, wantRequested, wantPending int32, wantAssigned int) {
+		t.Helper()
+		m.Refresh(ctx)
+		published, err := m.List(fullName)
+		if err != nil {
+			t.Fatalf("%s: List(%v) error %v, want no error", desc, fullName, err)
+		}
+		if got := published.GetModel().GetRequestedNumReplicas(); got != wantRequested {
+			t.Errorf("%s: requested %d replicas, want %d", desc, got, wantRequested)
+		}
+		if got := published.GetPendingNumReplicas(); got != wantPending {
+			t.Errorf("%s: pending %d replicas, want %d", desc, got, wantPending)
+		}
+		if got := len(published.GetModeletAddresses()); got != wantAssigned {
+			t.Errorf("%s: assigned %d model servers, want %d", desc, got, wantAssigned)
+		}
+	}
+
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	check("publish above baseline", 1, 2, 1)
+
+	specs.RequestedNumReplicas = 3
+	if err := m.Update(fullName, specs, false); err != nil {
+		t.Fatalf("Update(%v) error %v, want no error", specs, err)
+	}
+	check("update above baseline", 1, 3, 1)
+
+	// The pending increase survives a failover.
+	if err := m.Save(ctx); err != nil {
+		t.Fatalf("Save() error %v, want no error", err)
+	}
+	standby := New(store)
+	if err := standby.Restore(ctx); err != nil {
+		t.Fatalf("Restore() error %v, want no error", err)
+	}
+	if published, err := standby.List(fullName); err != nil || published.GetPendingNumReplicas() != 3 {
+		t.Errorf("List(%v) on the standby = %v, %v, want 3 pending replicas", fullName, published, err)
+	}
+
+	if err := m.ApproveScale(fullName); err != nil {
+		t.Fatalf("ApproveScale(%v) error %v, want no error", fullName, err)
+	}
+	check("approved", 3, 0, 3)
+	if err := m.ApproveScale(fullName); err == nil {
+		t.Errorf("ApproveScale(%v) without a pending increase succeeded, want error", fullName)
+	}
+
+	// Decreases apply immediately.
+	specs.RequestedNumReplicas = 2
+	if err := m.Update(fullName, specs, false); err != nil {
+		t.Fatalf("Update(%v) error %v, want no error", specs, err)
+	}
+	check("decrease", 2, 0, 2)
+}
+
+func TestRecommendReplicas(t *testing.T) {
+	bounded := &apb.LatencyAutoscale{TargetP99Seconds: 1, MinReplicas: 2, MaxReplicas: 5}
+	unbounded := &apb.LatencyAutoscale{TargetP99Seconds: 1}
+	tests := []struct {
+		desc      string
+		autoscale *apb.LatencyAutoscale
+		current   int32
+		p99       float32
+		reported  bool
+		want      int32
+	}{
+		{"over target", bounded, 3, 1.5, true, 4},
+		{"well under target", bounded, 3, 0.2, true, 2},
+		{"slightly under target", bounded, 3, 0.8, true, 3},
+		{"over target at maximum", bounded, 5, 1.5, true, 5},
+		{"well under target at minimum", bounded, 2, 0.2, true, 2},
+		{"no latency under minimum", bounded, 1, 0, false, 2},
+		{"no latency over maximum", bounded, 7, 0, false, 5},
+		{"over target without maximum", unbounded, 10, 1.5, true, 11},
+		{"well under target at one replica without minimum", unbounded, 1, 0.2, true, 1},
+		{"no latency at zero replicas without minimum", unbounded, 0, 0, false, 1},
+	}
+	for _, tc := range tests {
+		if got := recommendReplicas(tc.autoscale, tc.current, tc.p99, tc.reported); got != tc.want {
+			t.Errorf("%s: recommendReplicas(%v, %d, %v, %v) = %d, want %d", tc.desc, tc.autoscale, tc.current, tc.p99, tc.reported, got, tc.want)
+		}
+	}
+}
+
+func TestAutoscaleByLatency(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	addrs := startModelServers(ctx, t, m, 3)
+	specs := newTestModel("/sax/test/autoscale", 1)
+	specs.LatencyAutoscale = &apb.LatencyAutoscale{TargetP99Seconds: 0.5, MinReplicas: 1, MaxReplicas: 2, AutoApply: true}
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	waitForReplicas := func(n int) {
+		t.Helper()
+		m.Refresh(ctx)
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := m.WaitForReady(waitCtx, fullName, n); err != nil {
+			t.Fatalf("WaitForReady(%v, %d) error %v, want no error", fullName, n, err)
+		}
+	}
+	// Model servers report serving with the given p99 latency, as seen by the next refresh.
+	setLatency := func(seconds float32) {
+		t.Helper()
+		for _, addr := range addrs {
+			var port int
+			if _, err := fmt.Sscanf(addr, "localhost:%d", &port); err != nil {
+				t.Fatalf("Sscanf(%v) error %v, want no error", addr, err)
+			}
+			if err := testutil.SetStubModelServerP99Latency(port, seconds); err != nil {
+				t.Fatalf("SetStubModelServerP99Latency(%v) error %v, want no error", port, err)
+			}
+			if err := m.modelets[modeletAddr(addr)].Refresh(ctx); err != nil {
+				t.Fatalf("Refresh(%v) error %v, want no error", addr, err)
+			}
+		}
+	}
+	check := func(desc string, wantRequested, wantRecommended int32) {
+		t.Helper()
+		published, err := m.List(fullName)
+		if err != nil {
+			t.Fatalf("%s: List(%v) error %v, want no error", desc, fullName, err)
+		}
+		if got := published.GetModel().GetRequestedNumReplicas(); got != wantRequested {
+			t.Errorf("%s: requested replicas = %d, want %d", desc, got, wantRequested)
+		}
+		if got := published.GetRecommendedNumReplicas(); got != wantRecommended {
+			t.Errorf("%s: recommended replicas = %d, want %d", desc, got, wantRecommended)
+		}
+	}
+	waitForReplicas(1)
+	check("without latency", 1, 1)
+
+	// Over the target, the model scales up to its maximum and stays there.
+	setLatency(1)
+	check("over target", 1, 2)
+	now := time.Now()
+	m.autoscaleByLatency(now)
+	check("scaled up", 2, 2)
+	waitForReplicas(2)
+	setLatency(1)
+	check("over target at maximum", 2, 2)
+
+	// Well under the target, it scales down to its minimum, no sooner than the cooldown allows.
+	setLatency(0.1)
+	check("well under target", 2, 1)
+	m.autoscaleByLatency(now.Add(autoscaleCooldown / 2))
+	check("within cooldown", 2, 1)
+	m.autoscaleByLatency(now.Add(autoscaleCooldown))
+	check("scaled down", 1, 1)
+	m.autoscaleByLatency(now.Add(3 * autoscaleCooldown))
+	check("well under target at minimum", 1, 1)
+}
+
+func TestSetReplicasFor(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{state: &apb.State{}}
+	m := New(store)
+	startModelServers(ctx, t, m, 3)
+
+	specs := newTestModel("/sax/test/bump", 1)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	check := func(desc string, m *Mgr, wantRequested int32, wantBump bool, wantAssigned int) {
+		t.Helper()
+		published, err := m.List(fullName)
+		if err != nil {
+			t.Fatalf("%s: List(%v) error %v, want no error", desc, fullName, err)
+		}
+		if got := published.GetModel().GetRequestedNumReplicas(); got != wantRequested {
+			t.Errorf("%s: requested %d replicas, want %d", desc, got, wantRequested)
+		}
+		if got := published.GetReplicaBump() != nil; got != wantBump {
+			t.Errorf("%s: bump %v, want a bump: %v", desc, published.GetReplicaBump(), wantBump)
+		}
+		if got := len(published.GetModeletAddresses()); got != wantAssigned {
+			t.Errorf("%s: assigned %d model servers, want %d", desc, got, wantAssigned)
+		}
+	}
+
+	if _, err := m.SetReplicasFor(fullName, 1, time.Hour, false); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("SetReplicasFor(%v, 1) error %v, want %v", fullName, err, codes.InvalidArgument)
+	}
+	m.SetQuotas(0, 2)
+	if _, err := m.SetReplicasFor(fullName, 3, time.Hour, false); errors.Code(err) != codes.ResourceExhausted {
+		t.Errorf("SetReplicasFor(%v, 3) over quota error %v, want %v", fullName, err, codes.ResourceExhausted)
+	}
+	m.SetQuotas(0, 0)
+
+	bump, err := m.SetReplicasFor(fullName, 3, time.Hour, false)
+	if err != nil {
+		t.Fatalf("SetReplicasFor(%v, 3) error %v, want no error", fullName, err)
+	}
+	if bump.GetRestoreNumReplicas() != 1 || bump.GetExpireMs() <= time.Now().UnixMilli() {
+		t.Errorf("SetReplicasFor(%v, 3) = %v, want to restore 1 replica later", fullName, bump)
+	}
+	m.Refresh(ctx)
+	check("bumped", m, 3, true, 3)
+
+	// The pending revert survives a failover.
+	if err := m.Save(ctx); err != nil {
+		t.Fatalf("Save() error %v, want no error", err)
+	}
+	standby := New(store)
+	if err := standby.Restore(ctx); err != nil {
+		t.Fatalf("Restore() error %v, want no error", err)
+	}
+	check("restored", standby, 3, true, 0)
+	standby.revertExpiredBumps(time.UnixMilli(bump.GetExpireMs()))
+	check("restored after expiry", standby, 1, false, 0)
+
+	m.revertExpiredBumps(time.UnixMilli(bump.GetExpireMs() - 1))
+	check("before expiry", m, 3, true, 3)
+	m.revertExpiredBumps(time.UnixMilli(bump.GetExpireMs()))
+	m.Refresh(ctx)
+	check("after expiry", m, 1, false, 1)
+
+	// An update replaces the bump.
+	if _, err := m.SetReplicasFor(fullName, 3, time.Hour, false); err != nil {
+		t.Fatalf("SetReplicasFor(%v, 3) error %v, want no error", fullName, err)
+	}
+	specs.RequestedNumReplicas = 2
+	if err := m.Update(fullName, specs, false); err != nil {
+		t.Fatalf("Update(%v) error %v, want no error", specs, err)
+	}
+	m.revertExpiredBumps(time.Now().Add(2 * time.Hour))
+	m.Refresh(ctx)
+	check("updated", m, 2, false, 2)
+}
+
+func TestDrainServerAllDraining(t *testing.T) {
+	ctx := context.Background()
+	defer func(refuse bool) { *refuseAllDraining = refuse }(*refuseAllDraining)
+
+	m := New(nil)
+	addr := startModelServers(ctx, t, m, 1)[0]
+	specs := newTestModel("/sax/test/alldrained", 1)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.WaitForReady(waitCtx, fullName, 1); err != nil {
+		t.Fatalf("WaitForReady(%v) error %v, want no error", fullName, err)
+	}
+
+	// Partial drains leave the server serving, so they are never refused.
+	*refuseAllDraining = true
+	if err := m.DrainServer(addr, 0.5); err != nil {
+		t.Errorf("DrainServer(%v, 0.5) error %v, want no error", addr, err)
+	}
+	// Draining the only replica fully is refused when configured so.
+	if err := m.DrainServer(addr, 1); errors.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DrainServer(%v, 1) of the only replica error %v, want %v", addr, err, codes.FailedPrecondition)
+	}
+	if diff := cmp.Diff(map[string]float32{addr: 0.5}, m.Drains([]string{addr})); diff != "" {
+		t.Errorf("Drains(%v) after a refused drain unexpected diff (-want +got):\n%s", addr, diff)
+	}
+
+	// Otherwise the drain goes ahead, and the model counts as all draining.
+	*refuseAllDraining = false
+	if err := m.DrainServer(addr, 1); err != nil {
+		t.Errorf("DrainServer(%v, 1) error %v, want no error", addr, err)
+	}
+	m.Refresh(ctx)
+	if got := allDrainingModelsVar.Value(); got != 1 {
+		t.Errorf("sax_admin_all_draining_models = %d, want 1", got)
+	}
+
+	// Another replica outside draining servers lets the server drain fully.
+	startModelServers(ctx, t, m, 1)
+	if err := m.DrainServer(addr, 0); err != nil {
+		t.Fatalf("DrainServer(%v, 0) error %v, want no error", addr, err)
+	}
+	if err := m.Update(fullName, newTestModel(specs.GetModelId(), 2), false); err != nil {
+		t.Fatalf("Update(%v) error %v, want no error", fullName, err)
+	}
+	m.Refresh(ctx)
+	if err := m.WaitForReady(waitCtx, fullName, 2); err != nil {
+		t.Fatalf("WaitForReady(%v) error %v, want no error", fullName, err)
+	}
+	*refuseAllDraining = true
+	if err := m.DrainServer(addr, 1); err != nil {
+		t.Errorf("DrainServer(%v, 1) with another replica error %v, want no error", addr, err)
+	}
+	m.Refresh(ctx)
+	if got := allDrainingModelsVar.Value(); got != 0 {
+		t.Errorf("sax_admin_all_draining_models = %d, want 0", got)
+	}
+}
+
+func TestDrainServer(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{state: &apb.State{}}
+	m := New(store)
+	addr := startModelServers(ctx, t, m, 1)[0]
+
+	specs := newTestModel("/sax/test/drain", 1)
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	m.Refresh(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.WaitForReady(waitCtx, fullName, 1); err != nil {
+		t.Fatalf("WaitForReady(%v) error %v, want no error", fullName, err)
+	}
+	res, err := m.WatchLoc(waitCtx, fullName.ModelFullName(), 0)
+	if err != nil {
+		t.Fatalf("WatchLoc(%v) error %v, want no error", fullName, err)
+	}
+
+	if err := m.DrainServer(addr, 1.5); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("DrainServer(%v, 1.5) error %v, want %v", addr, err, codes.InvalidArgument)
+	}
+	if err := m.DrainServer(addr, 0.25); err != nil {
+		t.Fatalf("DrainServer(%v, 0.25) error %v, want no error", addr, err)
+	}
+
+	// Watching clients see the server added again, and get its drain along with it.
+	res, err = m.WatchLoc(waitCtx, fullName.ModelFullName(), res.Next)
+	if err != nil {
+		t.Fatalf("WatchLoc(%v) error %v, want no error", fullName, err)
+	}
+	want := watchable.ChangeLog{{Kind: watchable.Del, Val: addr}, {Kind: watchable.Add, Val: addr}}
+	if diff := cmp.Diff(want, res.Log); diff != "" {
+		t.Errorf("WatchLoc(%v) after DrainServer unexpected diff (-want +got):\n%s", fullName, diff)
+	}
+	if diff := cmp.Diff(map[string]float32{addr: 0.25}, m.Drains([]string{addr})); diff != "" {
+		t.Errorf("Drains(%v) unexpected diff (-want +got):\n%s", addr, diff)
+	}
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	if diff := cmp.Diff(map[string]float32{addr: 0.25}, published.GetDrains()); diff != "" {
+		t.Errorf("List(%v) drains unexpected diff (-want +got):\n%s", fullName, diff)
+	}
+
+	// The drain survives a failover.
+	if err := m.Save(ctx); err != nil {
+		t.Fatalf("Save() error %v, want no error", err)
+	}
+	standby := New(store)
+	if err := standby.Restore(ctx); err != nil {
+		t.Fatalf("Restore() error %v, want no error", err)
+	}
+	standby.mu.RLock()
+	got := standby.drains[modeletAddr(addr)]
+	standby.mu.RUnlock()
+	if got != 0.25 {
+		t.Errorf("Restored drained fraction of %v = %v, want 0.25", addr, got)
+	}
+
+	if err := m.DrainServer(addr, 0); err != nil {
+		t.Fatalf("DrainServer(%v, 0) error %v, want no error", addr, err)
+	}
+	if got := m.Drains([]string{addr}); len(got) != 0 {
+		t.Errorf("Drains(%v) after restoring its share = %v, want none", addr, got)
+	}
+}
+
+func TestWarmPool(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	startModelServers(ctx, t, m, 3)
+
+	specs := newTestModel("/sax/test/warm", 1)
+	specs.WarmPoolSize = 1
+	fullName, _ := naming.NewModelFullName(specs.GetModelId())
+	if err := m.Publish(specs); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+	}
+	check := func(desc string, wantAssigned, wantServing, wantStandby int) {
+		t.Helper()
+		published, err := m.List(fullName)
+		if err != nil {
+			t.Fatalf("%s: List(%v) error %v, want no error", desc, fullName, err)
+		}
+		if got := len(published.GetModeletAddresses()); got != wantAssigned {
+			t.Errorf("%s: %d model servers assigned, want %d", desc, got, wantAssigned)
+		}
+		res, err := m.WatchLoc(ctx, fullName.ModelFullName(), 0)
+		if err != nil {
+			t.Fatalf("%s: WatchLoc(%v) error %v, want no error", desc, fullName, err)
+		}
+		serving := watchable.NewDataSet()
+		if res.Data != nil {
+			serving = res.Data
+		}
+		serving.Apply(res.Log)
+		if got := serving.Size(); got != wantServing {
+			t.Errorf("%s: %d model servers seen by clients, want %d", desc, got, wantServing)
+		}
+		m.mu.RLock()
+		standby := len(m.models[fullName].standby)
+		m.mu.RUnlock()
+		if standby != wantStandby {
+			t.Errorf("%s: %d warm pool replicas, want %d", desc, standby, wantStandby)
+		}
+	}
+	waitForReady := func(replicas int) {
+		t.Helper()
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := m.WaitForReady(waitCtx, fullName, replicas); err != nil {
+			t.Fatalf("WaitForReady(%v, %d) error %v, want no error", fullName, replicas, err)
+		}
+	}
+
+	// The warm pool replica is loaded but held back from clients.
+	m.Refresh(ctx)
+	waitForReady(2)
+	check("after publish", 2, 1, 1)
+
+	// A promoted replica serves right away, and the warm pool is refilled behind it.
+	if err := m.PromoteWarm(fullName, 1, false); err != nil {
+		t.Fatalf("PromoteWarm(%v, 1) error %v, want no error", fullName, err)
+	}
+	check("after promotion", 2, 2, 0)
+	m.Refresh(ctx)
+	waitForReady(3)
+	check("after refill", 3, 2, 1)
+
+	// Once demoted, the replica beyond the warm pool is unloaded.
+	if err := m.PromoteWarm(fullName, 0, false); err != nil {
+		t.Fatalf("PromoteWarm(%v, 0) error %v, want no error", fullName, err)
+	}
+	m.Refresh(ctx)
+	published, err := m.List(fullName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", fullName, err)
+	}
+	if got := len(published.GetModeletAddresses()); got != 2 {
+		t.Errorf("%d model servers assigned after demotion, want 2", got)
+	}
+
+	if err := m.PromoteWarm(fullName, -1, false); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("PromoteWarm(%v, -1) error %v, want %v", fullName, err, codes.InvalidArgument)
+	}
+	if err := m.PromoteWarm(fullName, 2, false); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("PromoteWarm(%v, 2) past the warm pool size error %v, want %v", fullName, err, codes.InvalidArgument)
+	}
+	cold := newTestModel("/sax/test/cold", 1)
+	coldName, _ := naming.NewModelFullName(cold.GetModelId())
+	if err := m.Publish(cold); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", cold, err)
+	}
+	if err := m.PromoteWarm(coldName, 1, false); errors.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PromoteWarm(%v, 1) without a warm pool error %v, want %v", coldName, err, codes.FailedPrecondition)
+	}
+}
+
+func TestUnreadyReplicas(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	fast := startModelServers(ctx, t, m, 1)[0]
+
+	// A model server that takes a while to load models.
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	closer, err := testutil.StartStubModelServer(testutil.Language, port, 0, "", 3*time.Second)
+	if err != nil {
+		t.Fatalf("StartStubModelServer() error %v, want no error", err)
+	}
+	t.Cleanup(func() { close(closer) })
+	slow := fmt.Sprintf("localhost:%d", port)
+	specs := &apb.ModelServer{
+		ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+		ServableModelPaths: []string{testModelPath},
+	}
+	if err := m.Join(ctx, slow, "", slow, specs); err != nil {
+		t.Fatalf("Join(%v) error %v, want no error", slow, err)
+	}
+
+	unready, err := m.UnreadyReplicas()
+	if err != nil {
+		t.Fatalf("UnreadyReplicas() error %v, want no error", err)
+	}
+	if len(unready) != 0 {
+		t.Errorf("UnreadyReplicas() without models = %v, want none", unready)
+	}
+
+	model := newTestModel("/sax/test/unready", 2)
+	fullName, _ := naming.NewModelFullName(model.GetModelId())
+	if err := m.Publish(model); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", model, err)
+	}
+	m.Refresh(ctx)
+	waitForReady := func(replicas int) {
+		t.Helper()
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := m.WaitForReady(waitCtx, fullName, replicas); err != nil {
+			t.Fatalf("WaitForReady(%v, %d) error %v, want no error", fullName, replicas, err)
+		}
+	}
+
+	// Only the replica on the slow model server is still loading.
+	waitForReady(1)
+	unready, err = m.UnreadyReplicas()
+	if err != nil {
+		t.Fatalf("UnreadyReplicas() error %v, want no error", err)
+	}
+	if len(unready) != 1 || unready[0].GetModelId() != model.GetModelId() || unready[0].GetAddress() != slow || unready[0].GetStatus() == cpb.ModelStatus_LOADED {
+		t.Errorf("UnreadyReplicas() while %v loads = %v, want the replica on %v only, not on %v", slow, unready, slow, fast)
+	}
+
+	waitForReady(2)
+	unready, err = m.UnreadyReplicas()
+	if err != nil {
+		t.Fatalf("UnreadyReplicas() error %v, want no error", err)
+	}
+	if len(unready) != 0 {
+		t.Errorf("UnreadyReplicas() once loaded = %v, want none", unready)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	for _, exp := range []bool{false, true} {
+		t.Run(fmt.Sprintf("expAssigner=%v", exp), func(t *testing.T) {
+			defer func(exp bool) { *expAssigner = exp }(*expAssigner)
+			*expAssigner = exp
+
+			ctx := context.Background()
+			m := New(nil)
+			roles := map[string]string{}
+			for _, role := range []string{"serving", "eval", "eval"} {
+				port, err := env.Get().PickUnusedPort()
+				if err != nil {
+					t.Fatalf("PickUnusedPort() error %v, want no error", err)
+				}
+				testutil.StartStubModelServerT(t, port)
+				addr := fmt.Sprintf("localhost:%d", port)
+				specs := &apb.ModelServer{
+					ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+					ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+					ServableModelPaths: []string{testModelPath},
+					Role:               role,
+				}
+				if err := m.Join(ctx, addr, "", addr, specs); err != nil {
+					t.Fatalf("Join(%v) error %v, want no error", addr, err)
+				}
+				roles[addr] = role
+			}
+			publish := func(id, role string, replicas int32) modelFullName {
+				t.Helper()
+				specs := newTestModel(id, replicas)
+				specs.Role = role
+				if err := m.Publish(specs); err != nil {
+					t.Fatalf("Publish(%v) error %v, want no error", specs, err)
+				}
+				fullName, _ := naming.NewModelFullName(id)
+				return fullName
+			}
+			served := publish("/sax/test/served", "serving", 2)
+			evaluated := publish("/sax/test/evaluated", "eval", 1)
+			unlabeled := publish("/sax/test/unlabeled", "", 1)
+			m.Refresh(ctx)
+
+			check := func(fullName modelFullName, wantRole string, wantReplicas int) {
+				t.Helper()
+				published, err := m.List(fullName)
+				if err != nil {
+					t.Fatalf("List(%v) error %v, want no error", fullName, err)
+				}
+				addrs := published.GetModeletAddresses()
+				if len(addrs) != wantReplicas {
+					t.Errorf("%v is on %d model servers, want %d", fullName, len(addrs), wantReplicas)
+				}
+				for _, addr := range addrs {
+					if roles[addr] != wantRole {
+						t.Errorf("%v is on %s with role %q, want role %q", fullName, addr, roles[addr], wantRole)
+					}
+				}
+			}
+			// The serving model gets only the serving server, even with an eval server left idle.
+			check(served, "serving", 1)
+			check(evaluated, "eval", 1)
+			// Servers without a role are a pool of their own, and there are none.
+			check(unlabeled, "", 0)
+		})
+	}
+}
+
+func TestReservations(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubModelServerT(t, port)
+	reserved := fmt.Sprintf("localhost:%d", port)
+	specs := &apb.ModelServer{
+		ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+		ServableModelPaths: []string{testModelPath},
+		Reservations:       []*apb.ResourceReservation{{ModelId: "/sax/test/reserved", MinComputeShare: 0.5}},
+	}
+	if err := m.Join(ctx, reserved, "", reserved, specs); err != nil {
+		t.Fatalf("Join(%v) error %v, want no error", reserved, err)
+	}
+	shared := startModelServers(ctx, t, m, 1)[0]
+
+	other := newTestModel("/sax/test/other", 2)
+	if err := m.Publish(other); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", other, err)
+	}
+	m.Refresh(ctx)
+	otherName, _ := naming.NewModelFullName(other.GetModelId())
+	published, err := m.List(otherName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", otherName, err)
+	}
+	// The model without a reservation stays short of a replica rather than take the reserved server.
+	if diff := cmp.Diff([]string{shared}, published.GetModeletAddresses()); diff != "" {
+		t.Errorf("Model servers of %v unexpected diff (-want +got):\n%s", otherName, diff)
+	}
+
+	specsReserved := newTestModel("/sax/test/reserved", 1)
+	if err := m.Publish(specsReserved); err != nil {
+		t.Fatalf("Publish(%v) error %v, want no error", specsReserved, err)
+	}
+	m.Refresh(ctx)
+	reservedName, _ := naming.NewModelFullName(specsReserved.GetModelId())
+	published, err = m.List(reservedName)
+	if err != nil {
+		t.Fatalf("List(%v) error %v, want no error", reservedName, err)
+	}
+	if diff := cmp.Diff([]string{reserved}, published.GetModeletAddresses()); diff != "" {
+		t.Errorf("Model servers of %v unexpected diff (-want +got):\n%s", reservedName, diff)
+	}
+}
+
+func TestJoinWorkBound(t *testing.T) {
+	defer func(limit int, wait time.Duration, start func(context.Context, *modeletState, *Mgr) error) {
+		*maxJoinWork, joinWorkWait, startModeletState = limit, wait, start
+	}(*maxJoinWork, joinWorkWait, startModeletState)
+	*maxJoinWork = 2
+	joinWorkWait = time.Minute
+
+	// Join work blocks until release is closed, tracking how much of it runs at a time.
+	var mu sync.Mutex
+	inProgress, peak := 0, 0
+	var release chan struct{}
+	startModeletState = func(ctx context.Context, s *modeletState, m *Mgr) error {
+		mu.Lock()
+		inProgress++
+		if inProgress > peak {
+			peak = inProgress
+		}
+		wait := release
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inProgress--
+			mu.Unlock()
+		}()
+		<-wait
+		return s.Start(ctx, m)
+	}
+	waitInProgress := func(want int) {
+		t.Helper()
+		for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+			mu.Lock()
+			got := inProgress
+			mu.Unlock()
+			if got == want {
+				return
+			}
+		}
+		t.Fatalf("Join work in progress never reached %d", want)
+	}
+
+	ctx := context.Background()
+	m := New(nil)
+	specs := &apb.ModelServer{
+		ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_2X2,
+		ServableModelPaths: []string{testModelPath},
+	}
+	newServer := func() string {
+		t.Helper()
+		port, err := env.Get().PickUnusedPort()
+		if err != nil {
+			t.Fatalf("PickUnusedPort() error %v, want no error", err)
+		}
+		testutil.StartStubModelServerT(t, port)
+		return fmt.Sprintf("localhost:%d", port)
+	}
+	joinAll := func(addrs []string) chan error {
+		errs := make(chan error, len(addrs))
+		for _, addr := range addrs {
+			go func(addr string) { errs <- m.Join(ctx, addr, "", addr, specs) }(addr)
+		}
+		return errs
+	}
+
+	// A burst of Joins queues up behind the bound.
+	release = make(chan struct{})
+	var burst []string
+	for i := 0; i < 5; i++ {
+		burst = append(burst, newServer())
+	}
+	errs := joinAll(burst)
+	waitInProgress(2)
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	for range burst {
+		if err := <-errs; err != nil {
+			t.Errorf("Join() during a burst error %v, want no error", err)
+		}
+	}
+	if peak != 2 {
+		t.Errorf("Join work peaked at %d at a time, want 2", peak)
+	}
+	if got := len(m.modelets); got != len(burst) {
+		t.Errorf("%d model servers joined after a burst, want %d", got, len(burst))
+	}
+
+	// Once saturated, heartbeats still go through, but new model servers are shed.
+	joinWorkWait = 50 * time.Millisecond
+	release = make(chan struct{})
+	errs = joinAll([]string{newServer(), newServer()})
+	waitInProgress(2)
+	if err := m.Join(ctx, burst[0], "", burst[0], specs); err != nil {
+		t.Errorf("Join(%v) heartbeat while saturated error %v, want no error", burst[0], err)
+	}
+	shed := shedJoinsVar.Value()
+	addr := newServer()
+	if err := m.Join(ctx, addr, "", addr, specs); errors.Code(err) != codes.Unavailable {
+		t.Errorf("Join(%v) of a new model server while saturated error %v, want %v", addr, err, codes.Unavailable)
+	}
+	if got := shedJoinsVar.Value() - shed; got != 1 {
+		t.Errorf("%d Joins shed, want 1", got)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Join() after saturation error %v, want no error", err)
+		}
+	}
+	if peak != 2 {
+		t.Errorf("Join work peaked at %d at a time, want 2", peak)
+	}
+}
+
+// fakeRegistry is an external service registry whose calls fail while failing is set.
+type fakeRegistry struct {
+	mu         sync.Mutex
+	registered map[string]bool
+	failing    bool
+	calls      int
+}
+
+func (r *fakeRegistry) Register(ctx context.Context, addr string, specs *apb.ModelServer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.failing {
+		return fmt.Errorf("registry down: %w", errors.ErrUnavailable)
+	}
+	r.registered[addr] = true
+	return nil
+}
+
+func (r *fakeRegistry) Deregister(ctx context.Context, addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.failing {
+		return fmt.Errorf("registry down: %w", errors.ErrUnavailable)
+	}
+	delete(r.registered, addr)
+	return nil
+}
+
+func (r *fakeRegistry) setFailing(failing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failing = failing
+}
+
+// waitFor waits until the registry has received calls calls and has registered want.
+func (r *fakeRegistry) waitFor(t *testing.T, calls int, want []string) {
+	t.Helper()
+	var got []string
+	var gotCalls int
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		r.mu.Lock()
+		got = nil
+		for addr := range r.registered {
+			got = append(got, addr)
+		}
+		gotCalls = r.calls
+		r.mu.Unlock()
+		sort.Strings(got)
+		if gotCalls >= calls && cmp.Equal(want, got, cmpopts.EquateEmpty()) {
+			return
+		}
+	}
+	t.Fatalf("Registry has %v registered after %d calls, want %v after %d", got, gotCalls, want, calls)
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	m := New(nil)
+	first := startModelServers(ctx, t, m, 1)[0]
+
+	// Model servers already joined are registered with the registry.
+	registry := &fakeRegistry{registered: map[string]bool{}}
+	m.SetRegistry(registry)
+	registry.waitFor(t, 1, []string{first})
+	second := startModelServers(ctx, t, m, 1)[0]
+	want := []string{first, second}
+	sort.Strings(want)
+	registry.waitFor(t, 2, want)
+
+	// Registry failures don't keep model servers from joining.
+	registry.setFailing(true)
+	errs := registryErrorsVar.Value()
+	startModelServers(ctx, t, m, 1)
+	registry.waitFor(t, 3, want)
+	if got := registryErrorsVar.Value() - errs; got != 1 {
+		t.Errorf("%d registry errors, want 1", got)
+	}
+	m.mu.RLock()
+	joined := len(m.modelets)
+	m.mu.RUnlock()
+	if joined != 3 {
+		t.Errorf("%d model servers joined with the registry down, want 3", joined)
+	}
+
+	// Model servers leaving are deregistered.
+	registry.setFailing(false)
+	m.pruneModelets(0)
+	registry.waitFor(t, 6, nil)
+}
+
+func TestRegistryReconcile(t *testing.T) {
+	ctx := context.Background()
+	defer func(period time.Duration) { registryReconcilePeriod = period }(registryReconcilePeriod)
+	registryReconcilePeriod = 100 * time.Millisecond
+
+	m := New(nil)
+	registry := &fakeRegistry{registered: map[string]bool{}, failing: true}
+	m.SetRegistry(registry)
+	defer m.Close()
+
+	// Model servers that failed to register are registered once the registry is back.
+	addrs := startModelServers(ctx, t, m, 2)
+	registry.waitFor(t, 2, nil)
+	registry.setFailing(false)
+	registry.waitFor(t, 4, addrs)
+
+	// So are the ones that failed to deregister.
+	registry.setFailing(true)
+	m.pruneModelets(0)
+	registry.mu.Lock()
+	calls := registry.calls
+	registry.mu.Unlock()
+	registry.setFailing(false)
+	registry.waitFor(t, calls+2, nil)
+}
+
+func TestQuotas(t *testing.T) {
+	m := New(nil)
+	m.SetQuotas(2, 5)
+
+	update := func(id string, replicas int32) error {
+		specs := newTestModel(id, replicas)
+		fullName, _ := naming.NewModelFullName(specs.GetModelId())
+		return m.Update(fullName, specs, false)
+	}
+	wantExhausted := func(desc string, err error) {
+		t.Helper()
+		if errors.Code(err) != codes.ResourceExhausted {
+			t.Errorf("%s: error %v, want ResourceExhausted", desc, err)
+		}
+	}
+
+	for _, specs := range []*apb.Model{newTestModel("/sax/test/quota0", 2), newTestModel("/sax/test/quota1", 3)} {
+		if err := m.Publish(specs); err != nil {
+			t.Fatalf("Publish(%v) within quota error %v, want no error", specs, err)
+		}
+	}
+	wantExhausted("publish past the model quota", m.Publish(newTestModel("/sax/test/quota2", 0)))
+	wantExhausted("update past the replica quota", update("/sax/test/quota1", 4))
+	if err := update("/sax/test/quota1", 1); err != nil {
+		t.Errorf("Update to fewer replicas error %v, want no error", err)
+	}
+	if err := update("/sax/test/quota0", 4); err != nil {
+		t.Errorf("Update to exactly the replica quota error %v, want no error", err)
+	}
+
+	// Lowering quotas keeps published models but blocks increases.
+	m.SetQuotas(1, 3)
+	wantExhausted("update past a lowered replica quota", update("/sax/test/quota1", 2))
+	if err := update("/sax/test/quota0", 3); err != nil {
+		t.Errorf("Update to fewer replicas above a lowered quota error %v, want no error", err)
+	}
+
+	// Warm pools and the replicas promoted from them count against the replica quota.
+	m.SetQuotas(2, 5)
+	warm := newTestModel("/sax/test/quota0", 2)
+	warmName, _ := naming.NewModelFullName(warm.GetModelId())
+	warm.WarmPoolSize = 3
+	wantExhausted("update to a warm pool past the replica quota", m.Update(warmName, warm, false))
+	warm.WarmPoolSize = 2
+	if err := m.Update(warmName, warm, false); err != nil {
+		t.Fatalf("Update to a warm pool within the replica quota error %v, want no error", err)
+	}
+	wantExhausted("promotion past the replica quota", m.PromoteWarm(warmName, 1, false))
+
+	// Removing quotas lifts the limits.
+	m.SetQuotas(0, 0)
+	if err := m.Publish(newTestModel("/sax/test/quota2", 10)); err != nil {
+		t.Errorf("Publish without quotas error %v, want no error", err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	// Disable automatic refresh and pruning so tests drive all state changes.
+	SetOptionsForTesting(time.Hour, time.Hour)
+	state.SetOptionsForTesting(time.Hour)
+	os.Exit(m.Run())
+}
@@ -98,12 +98,26 @@ type ChipType apb.ModelServer_ChipType
 // ChipTopology represents the topology of chips in the model server.
 type ChipTopology apb.ModelServer_ChipTopology
 
+// ZoneTagPrefix prefixes the model server tag naming the zone the server runs in.
+const ZoneTagPrefix = "zone="
+
+// Reservation represents resources a model server reserves for one model.
+type Reservation struct {
+	ModelID         string
+	MinMemoryBytes  int64
+	MinComputeShare float64
+}
+
 // ModelServer represents the specifications of a model server.
 type ModelServer struct {
 	ChipType           ChipType
 	ChipTopology       ChipTopology
 	ServableModelPaths []string
 	Tags               []string
+	MaxModels          int32
+	Version            string
+	Reservations       []Reservation
+	Role               string
 }
 
 // NewModelServer converts a proto value to a ModelServer value.
@@ -113,11 +127,23 @@ func NewModelServer(m *apb.ModelServer) *ModelServer {
 	tags := make([]string, len(m.GetTags()))
 	copy(tags, m.GetTags())
 	sort.Strings(tags)
+	var reservations []Reservation
+	for _, r := range m.GetReservations() {
+		reservations = append(reservations, Reservation{
+			ModelID:         r.GetModelId(),
+			MinMemoryBytes:  r.GetMinMemoryBytes(),
+			MinComputeShare: r.GetMinComputeShare(),
+		})
+	}
 	return &ModelServer{
 		ChipType:           ChipType(m.GetChipType()),
 		ChipTopology:       ChipTopology(m.GetChipTopology()),
 		ServableModelPaths: paths,
 		Tags:               tags,
+		MaxModels:          m.GetMaxModels(),
+		Version:            m.GetVersion(),
+		Reservations:       reservations,
+		Role:               m.GetRole(),
 	}
 }
 
@@ -127,11 +153,23 @@ func (m *ModelServer) ToProto() *apb.ModelServer {
 	copy(paths, m.ServableModelPaths)
 	tags := make([]string, len(m.Tags))
 	copy(tags, m.Tags)
+	var reservations []*apb.ResourceReservation
+	for _, r := range m.Reservations {
+		reservations = append(reservations, &apb.ResourceReservation{
+			ModelId:         r.ModelID,
+			MinMemoryBytes:  r.MinMemoryBytes,
+			MinComputeShare: r.MinComputeShare,
+		})
+	}
 	return &apb.ModelServer{
 		ChipType:           apb.ModelServer_ChipType(m.ChipType),
 		ChipTopology:       apb.ModelServer_ChipTopology(m.ChipTopology),
 		ServableModelPaths: paths,
 		Tags:               tags,
+		MaxModels:          m.MaxModels,
+		Version:            m.Version,
+		Reservations:       reservations,
+		Role:               m.Role,
 	}
 }
 
@@ -143,6 +181,15 @@ func (m *ModelServer) Equal(other *apb.ModelServer) bool {
 	if m.ChipTopology != ChipTopology(other.GetChipTopology()) {
 		return false
 	}
+	if m.MaxModels != other.GetMaxModels() {
+		return false
+	}
+	if m.Version != other.GetVersion() {
+		return false
+	}
+	if m.Role != other.GetRole() {
+		return false
+	}
 	if len(m.ServableModelPaths) != len(other.GetServableModelPaths()) {
 		return false
 	}
@@ -151,9 +198,55 @@ func (m *ModelServer) Equal(other *apb.ModelServer) bool {
 			return false
 		}
 	}
+	if len(m.Reservations) != len(other.GetReservations()) {
+		return false
+	}
+	for i, r := range m.Reservations {
+		o := other.GetReservations()[i]
+		if r.ModelID != o.GetModelId() || r.MinMemoryBytes != o.GetMinMemoryBytes() || r.MinComputeShare != o.GetMinComputeShare() {
+			return false
+		}
+	}
 	return true
 }
 
+// Zone returns the zone named by the first zone tag, or an empty string if there is none.
+func (m *ModelServer) Zone() string {
+	for _, tag := range m.Tags {
+		if strings.HasPrefix(tag, ZoneTagPrefix) {
+			return strings.TrimPrefix(tag, ZoneTagPrefix)
+		}
+	}
+	return ""
+}
+
+// HasLabel returns true if the model server has a "<key>=<value>" tag.
+func (m *ModelServer) HasLabel(key, value string) bool {
+	label := key + "=" + value
+	for _, tag := range m.Tags {
+		if tag == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Labels returns the "<key>=<value>" tags as a map. If a key appears in several tags, the last
+// one in sorted order wins, whatever the order of m.Tags. Tags without "=" are not labels and are
+// skipped.
+func (m *ModelServer) Labels() map[string]string {
+	tags := make([]string, len(m.Tags))
+	copy(tags, m.Tags)
+	sort.Strings(tags)
+	labels := make(map[string]string)
+	for _, tag := range tags {
+		if key, value, ok := strings.Cut(tag, "="); ok && key != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
 // String returns a human-readable string for debugging.
 func (m *ModelServer) String() string {
 	return fmt.Sprintf("Model server with chip type: %v, chip topology: %v, servable model paths: %v",
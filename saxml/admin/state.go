@@ -18,6 +18,7 @@ package state
 import (
 	"bytes"
 	"context"
+	"expvar"
 	"fmt"
 	"sync"
 	"time"
@@ -25,10 +26,11 @@ import (
 	log "github.com/golang/glog"
 	"google.golang.org/grpc"
 	"saxml/admin/protobuf"
+	"saxml/common/blob"
 	"saxml/common/errors"
 	"saxml/common/eventlog"
 	"saxml/common/naming"
-	"saxml/common/platform/env"
+	"saxml/common/transport"
 	"saxml/common/waitable"
 
 	apb "saxml/protobuf/admin_go_proto_grpc"
@@ -45,14 +47,91 @@ var (
 	// Various RPC timeout thresholds.
 	dialTimeout      = time.Second * 10
 	getStatusTimeout = time.Second * 10
+
+	// Model servers that support WatchStatus send a status at least this often. A stream without
+	// any status for twice as long is considered broken.
+	watchKeepalive = time.Second * 10
+
+	// For this long after a restarted model server rejoins, GetStatus calls are made at most once
+	// every rejoinProbeBackoff refresh periods, and consecutive loads are at least
+	// rejoinLoadStagger apart, to avoid slowing down a server that is likely still warming up.
+	rejoinGracePeriod  = time.Second * 30
+	rejoinProbeBackoff = 3
+	rejoinLoadStagger  = time.Second * 2
+	// GetStatus calls to rejoined model servers are never skipped for this long. This should be
+	// shorter than pruneTimeout in the mgr package, so throttling doesn't get model servers pruned.
+	maxRejoinProbeGap = time.Second * 15
 )
 
+// coalescedProbes counts GetStatus probes that shared a probe already in flight to the same model
+// server instead of sending their own.
+var coalescedProbes = expvar.NewInt("sax_admin_coalesced_status_probes")
+
 // SetOptionsForTesting updates refreshPeriod so that during tests, the state issues more frequent
 // GetStatus calls.
 func SetOptionsForTesting(refresh time.Duration) {
 	refreshPeriod = refresh
 }
 
+// LoadLimiter bounds how many model loads run at once on the model servers sharing it, so a
+// rollout to many replicas doesn't have all of them read the checkpoint from storage together.
+//
+// All methods on LoadLimiter are thread-safe.
+type LoadLimiter struct {
+	mu sync.Mutex
+	// The most loads that can run at once, or no limit if not positive.
+	limit   int
+	running int
+	// Closed and replaced whenever a load finishes or the limit changes, to wake up waiting loads.
+	changed chan struct{}
+}
+
+// NewLoadLimiter creates a LoadLimiter with no limit.
+func NewLoadLimiter() *LoadLimiter {
+	return &LoadLimiter{changed: make(chan struct{})}
+}
+
+// SetLimit sets the most loads that can run at once. Non-positive values remove the limit. Loads
+// already running when the limit is lowered keep running.
+func (l *LoadLimiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.notifyLocked()
+}
+
+// acquire blocks until a load can start or ctx is done. Call release when a successfully started
+// load finishes.
+func (l *LoadLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.limit <= 0 || l.running < l.limit {
+			l.running++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (l *LoadLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	l.notifyLocked()
+}
+
+func (l *LoadLimiter) notifyLocked() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
 // ModelFinder returns model information given a model full name.
 type ModelFinder interface {
 	FindModel(naming.ModelFullName) *apb.Model
@@ -65,6 +144,8 @@ type Model struct {
 	Acls       map[string]string
 	Overrides  map[string]string
 	UUID       []byte
+	// Config blob names to digests.
+	ConfigBlobs map[string]string
 }
 
 func cloneAcls(src map[string]string) map[string]string {
@@ -85,21 +166,23 @@ func cloneOverrides(src map[string]string) map[string]string {
 
 func newModel(spec *apb.Model) *Model {
 	return &Model{
-		Path:       spec.GetModelPath(),
-		Checkpoint: spec.GetCheckpointPath(),
-		Acls:       cloneAcls(spec.GetAcls().GetItems()),
-		Overrides:  spec.GetOverrides(),
-		UUID:       spec.GetUuid(),
+		Path:        spec.GetModelPath(),
+		Checkpoint:  spec.GetCheckpointPath(),
+		Acls:        cloneAcls(spec.GetAcls().GetItems()),
+		Overrides:   spec.GetOverrides(),
+		UUID:        spec.GetUuid(),
+		ConfigBlobs: spec.GetConfigBlobs(),
 	}
 }
 
 func (m *Model) clone() *Model {
 	return &Model{
-		Path:       m.Path,
-		Checkpoint: m.Checkpoint,
-		Acls:       cloneAcls(m.Acls),
-		Overrides:  cloneOverrides(m.Overrides),
-		UUID:       bytes.Clone(m.UUID),
+		Path:        m.Path,
+		Checkpoint:  m.Checkpoint,
+		Acls:        cloneAcls(m.Acls),
+		Overrides:   cloneOverrides(m.Overrides),
+		UUID:        bytes.Clone(m.UUID),
+		ConfigBlobs: cloneOverrides(m.ConfigBlobs),
 	}
 }
 
@@ -108,12 +191,19 @@ type MethodStats struct {
 	SuccessesPerSecond   float32
 	ErrorsPerSecond      float32
 	MeanLatencyInSeconds float32
+	// MeanLatencyInSeconds split into time spent queued and processing, if reported.
+	MeanQueueInSeconds      float32
+	MeanProcessingInSeconds float32
+	// The 99th percentile latency of succeeded requests, if reported.
+	P99LatencyInSeconds float32
 }
 
 // ModelInfo represents the status of a model and method stats reported by a server.
 type ModelInfo struct {
 	Status protobuf.ModelStatus // Loaded, unloaded, etc.
 	Stats  map[string]MethodStats
+	// Whether the server asks for the model to be moved to other servers.
+	ReassignRequested bool
 }
 
 // ModelWithStatus represents a model's static + dynamic state.
@@ -175,6 +265,8 @@ type State struct {
 	seen map[naming.ModelFullName]*ModelWithStatus
 	// Eventually loaded models when all pending actions finish.
 	wanted map[naming.ModelFullName]*Model
+	// Whether the most recent status says the server is shutting down gracefully.
+	shuttingDown bool
 
 	// Requested actions that haven't been sent to the server yet but already reflected in wanted.
 	queue     chan *action
@@ -187,9 +279,38 @@ type State struct {
 	// Last successful refresh time.
 	muLastPing sync.Mutex
 	lastPing   time.Time
+	// Whether the server has sent status over a WatchStatus stream, in which case the ticker doesn't
+	// call GetStatus. Guarded by muLastPing.
+	watching bool
+
+	// Stops the goroutine consuming the WatchStatus stream.
+	watchCancel context.CancelFunc
+	watchStop   chan bool
 
 	// Logger to log events such as publish, unpublish, etc.
 	eventLogger eventlog.Logger
+
+	// When the grace window of a restarted server ends. Set before Start and immutable afterwards.
+	graceUntil time.Time
+	// The earliest time the next load can be sent during the grace window. Only accessed by the
+	// goroutine draining the action queue.
+	nextLoad time.Time
+	// If not nil, loads wait for it to let them start. Set before Start and immutable afterwards.
+	loadLimiter *LoadLimiter
+	// Which connections to the model server the cell allows. Set before Start and immutable
+	// afterwards.
+	transportSecurity apb.Config_TransportSecurity
+
+	// The GetStatus probe in flight, if any. Concurrent probes wait for its result.
+	muProbe sync.Mutex
+	probe   *statusProbe
+}
+
+// statusProbe is the result of a GetStatus probe, set before done is closed.
+type statusProbe struct {
+	done chan struct{}
+	seen map[naming.ModelFullName]*ModelInfo
+	err  error
 }
 
 // SeenModels returns a copy of the reported server state.
@@ -270,10 +391,73 @@ func (s *State) Unload(ctx context.Context, fullName naming.ModelFullName, waite
 	return fmt.Errorf("model %v is never loaded: %w", fullName, errors.ErrNotFound)
 }
 
+// MarkRejoined tells the state that the model server has restarted since it last joined, so
+// GetStatus calls and loads are throttled for a grace window starting now.
+//
+// REQUIRES: Start has not been called.
+func (s *State) MarkRejoined() {
+	s.graceUntil = time.Now().Add(rejoinGracePeriod)
+	log.Infof("Throttling rejoined model server %v until %v", s.Addr, s.graceUntil)
+}
+
+// SetLoadLimiter makes loads onto the model server wait for limiter, which is usually shared with
+// other model servers.
+//
+// REQUIRES: Start has not been called.
+func (s *State) SetLoadLimiter(limiter *LoadLimiter) {
+	s.loadLimiter = limiter
+}
+
+// SetTransportSecurity makes Start connect to the model server only in ways policy allows.
+//
+// REQUIRES: Start has not been called.
+func (s *State) SetTransportSecurity(policy apb.Config_TransportSecurity) {
+	s.transportSecurity = policy
+}
+
+// rejoinProbeGap returns the shortest time between GetStatus calls during the grace window.
+func rejoinProbeGap() time.Duration {
+	gap := time.Duration(rejoinProbeBackoff) * refreshPeriod
+	if gap > maxRejoinProbeGap {
+		gap = maxRejoinProbeGap
+	}
+	return gap
+}
+
+// inGrace returns true if t falls in the grace window after a rejoin.
+func (s *State) inGrace(t time.Time) bool {
+	return t.Before(s.graceUntil)
+}
+
+// loadDelay returns how long a load taken at now should wait to keep loads staggered during the
+// grace window.
+func (s *State) loadDelay(now time.Time) time.Duration {
+	if !s.inGrace(now) {
+		return 0
+	}
+	slot := s.nextLoad
+	if slot.Before(now) {
+		slot = now
+	}
+	s.nextLoad = slot.Add(rejoinLoadStagger)
+	return slot.Sub(now)
+}
+
 // act takes an action.
 func (s *State) act(a *action) {
 	switch a.kind {
 	case load:
+		if delay := s.loadDelay(time.Now()); delay > 0 {
+			log.V(2).Infof("Delaying loading model %v onto rejoined server %v by %v", a.fullName, s.Addr, delay)
+			time.Sleep(delay)
+		}
+		if s.loadLimiter != nil {
+			if err := s.loadLimiter.acquire(a.ctx); err != nil {
+				log.Warningf("Failed to load model %v onto server %v while waiting for other loads: %v", a.fullName, s.Addr, err)
+				return
+			}
+			defer s.loadLimiter.release()
+		}
 		log.V(0).Infof("Loading model %v onto server %v with overrides %v", a.fullName, s.Addr, a.model.Overrides)
 		req := &mpb.LoadRequest{
 			ModelKey:       a.fullName.ModelFullName(),
@@ -284,6 +468,17 @@ func (s *State) act(a *action) {
 			},
 			Overrides: a.model.Overrides,
 		}
+		if len(a.model.ConfigBlobs) > 0 {
+			req.ConfigBlobPaths = make(map[string]string, len(a.model.ConfigBlobs))
+			for name, digest := range a.model.ConfigBlobs {
+				path, err := blob.Path(a.ctx, a.fullName.CellFullName(), digest)
+				if err != nil {
+					log.Warningf("Failed to locate config blob %s of model %v: %v", name, a.fullName, err)
+					continue
+				}
+				req.ConfigBlobPaths[name] = path
+			}
+		}
 		if _, err := s.client.Load(a.ctx, req); err == nil {
 			if a.waiter != nil {
 				a.waiter.Add(1)
@@ -348,8 +543,36 @@ func (s *State) GetStatus(ctx context.Context, full bool) (*mpb.GetStatusRespons
 	return s.client.GetStatus(ctx, &mpb.GetStatusRequest{IncludeFailureReasons: full})
 }
 
-// getStatus calls GetStatus on the server and returns the response in an internal format.
+// getStatus calls GetStatus on the server and returns the response in an internal format. Calls
+// made while another is in flight, e.g. a periodic refresh and an on-demand one, share its result
+// instead of probing the server again.
 func (s *State) getStatus(ctx context.Context) (map[naming.ModelFullName]*ModelInfo, error) {
+	s.muProbe.Lock()
+	p := s.probe
+	if p != nil {
+		s.muProbe.Unlock()
+		coalescedProbes.Add(1)
+		select {
+		case <-p.done:
+			return p.seen, p.err
+		case <-ctx.Done():
+			return nil, fmt.Errorf("getStatus wait error: %w", ctx.Err())
+		}
+	}
+	p = &statusProbe{done: make(chan struct{})}
+	s.probe = p
+	s.muProbe.Unlock()
+
+	p.seen, p.err = s.probeStatus(ctx)
+	s.muProbe.Lock()
+	s.probe = nil
+	s.muProbe.Unlock()
+	close(p.done)
+	return p.seen, p.err
+}
+
+// probeStatus implements getStatus with a single GetStatus call.
+func (s *State) probeStatus(ctx context.Context) (map[naming.ModelFullName]*ModelInfo, error) {
 	if s.client == nil {
 		return nil, fmt.Errorf("no model server client: %w", errors.ErrFailedPrecondition)
 	}
@@ -360,7 +583,30 @@ func (s *State) getStatus(ctx context.Context) (map[naming.ModelFullName]*ModelI
 	if err != nil {
 		return nil, fmt.Errorf("getStatus RPC error: %w", err)
 	}
+	s.setShuttingDown(res.GetShuttingDown())
+	return parseStatus(res)
+}
+
+// setShuttingDown records whether the server reports it is shutting down.
+func (s *State) setShuttingDown(shuttingDown bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if shuttingDown && !s.shuttingDown {
+		log.Infof("Model server %s reports it is shutting down", s.Addr)
+	}
+	s.shuttingDown = shuttingDown
+}
 
+// ShuttingDown returns true if the server has reported it is shutting down, in which case it
+// should get no new models.
+func (s *State) ShuttingDown() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shuttingDown
+}
+
+// parseStatus converts a GetStatus response to an internal format.
+func parseStatus(res *mpb.GetStatusResponse) (map[naming.ModelFullName]*ModelInfo, error) {
 	seen := make(map[naming.ModelFullName]*ModelInfo)
 	for _, model := range res.GetModels() {
 		fullName, err := naming.NewModelFullName(model.GetModelKey())
@@ -375,12 +621,15 @@ func (s *State) getStatus(ctx context.Context) (map[naming.ModelFullName]*ModelI
 		methodStats := make(map[string]MethodStats)
 		for _, stats := range model.GetMethodStats() {
 			methodStats[stats.GetMethod()] = MethodStats{
-				SuccessesPerSecond:   stats.GetSuccessesPerSecond(),
-				ErrorsPerSecond:      stats.GetErrorsPerSecond(),
-				MeanLatencyInSeconds: stats.GetMeanLatencyOnSuccessPerSecond(),
+				SuccessesPerSecond:      stats.GetSuccessesPerSecond(),
+				ErrorsPerSecond:         stats.GetErrorsPerSecond(),
+				MeanLatencyInSeconds:    stats.GetMeanLatencyOnSuccessPerSecond(),
+				MeanQueueInSeconds:      stats.GetMeanQueueSecondsOnSuccess(),
+				MeanProcessingInSeconds: stats.GetMeanProcessingSecondsOnSuccess(),
+				P99LatencyInSeconds:     stats.GetP99LatencyOnSuccessPerSecond(),
 			}
 		}
-		seen[fullName] = &ModelInfo{Status: status, Stats: methodStats}
+		seen[fullName] = &ModelInfo{Status: status, Stats: methodStats, ReassignRequested: model.GetReassignRequested()}
 	}
 	return seen, nil
 }
@@ -426,7 +675,12 @@ func (s *State) Refresh(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	s.merge(seen)
+	return nil
+}
 
+// merge updates seen models to the given server status and records the time as the last ping.
+func (s *State) merge(seen map[naming.ModelFullName]*ModelInfo) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -465,7 +719,6 @@ func (s *State) Refresh(ctx context.Context) error {
 	s.muLastPing.Lock()
 	s.lastPing = time.Now()
 	s.muLastPing.Unlock()
-	return nil
 }
 
 // LastPing returns when the last successful initialize or refresh call happened.
@@ -481,7 +734,7 @@ func (s *State) Start(ctx context.Context, modelFinder ModelFinder) error {
 	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
 	defer cancel()
 	var err error
-	s.conn, err = env.Get().DialContext(ctx, s.Addr)
+	s.conn, err = transport.Dial(ctx, s.transportSecurity, s.Addr)
 	if err != nil {
 		return fmt.Errorf("Start failed to create a client for model server %v: %w", s.Addr, err)
 	}
@@ -511,17 +764,99 @@ func (s *State) Start(ctx context.Context, modelFinder ModelFinder) error {
 		return fmt.Errorf("Start failed to initialize state: %w", err)
 	}
 
-	// Start a goroutine that calls refresh periodically, stopping when s.Close is called.
+	s.startRefreshing()
+	return nil
+}
+
+// isWatching returns true if the server has sent status over a WatchStatus stream.
+func (s *State) isWatching() bool {
+	s.muLastPing.Lock()
+	defer s.muLastPing.Unlock()
+	return s.watching
+}
+
+// watch merges the status the server sends over a WatchStatus stream until ctx is cancelled or the
+// stream breaks.
+func (s *State) watch(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := s.client.WatchStatus(ctx, &mpb.WatchStatusRequest{
+		IncludeMethodStats: true,
+		KeepaliveMs:        int32(watchKeepalive.Milliseconds()),
+	})
+	if err != nil {
+		return fmt.Errorf("WatchStatus RPC error: %w", err)
+	}
+	// Break the stream if the server stops sending status without closing it.
+	timer := time.AfterFunc(2*watchKeepalive, cancel)
+	defer timer.Stop()
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("WatchStatus stream error: %w", err)
+		}
+		timer.Reset(2 * watchKeepalive)
+		s.setShuttingDown(res.GetShuttingDown())
+		seen, err := parseStatus(res)
+		if err != nil {
+			return err
+		}
+		s.muLastPing.Lock()
+		s.watching = true
+		s.muLastPing.Unlock()
+		s.merge(seen)
+	}
+}
+
+// startWatching starts a goroutine that consumes a WatchStatus stream, stopping when s.Close is
+// called. If the stream breaks after the server has sent status on it, the server is considered
+// dead until it joins again.
+func (s *State) startWatching() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.watchCancel = cancel
+	s.watchStop = make(chan bool)
+	go func() {
+		defer close(s.watchStop)
+		err := s.watch(ctx)
+		switch {
+		case ctx.Err() != nil:
+		case s.isWatching():
+			log.Warningf("Lost the status stream of model server %s: %v", s.Addr, err)
+			s.muLastPing.Lock()
+			s.lastPing = time.Time{}
+			s.muLastPing.Unlock()
+		case errors.IsUnimplemented(err):
+			log.Infof("Model server %s doesn't support WatchStatus, calling GetStatus instead", s.Addr)
+		default:
+			log.Warningf("Failed to watch model server %s status, calling GetStatus instead: %v", s.Addr, err)
+		}
+	}()
+}
+
+// startRefreshing starts goroutines that keep seen models up to date, stopping when s.Close is
+// called. GetStatus is called periodically until the server sends status over a WatchStatus
+// stream.
+func (s *State) startRefreshing() {
+	s.startWatching()
 	log.Infof("Refreshing model server state every %v", refreshPeriod)
 	s.ticker = time.NewTicker(refreshPeriod)
 	s.tickerStop = make(chan bool)
 	go func() {
+		lastProbe := time.Now()
 		for {
 			select {
 			case <-s.tickerStop:
 				close(s.tickerStop)
 				return
 			case t := <-s.ticker.C:
+				if s.isWatching() {
+					continue
+				}
+				if s.inGrace(t) && t.Sub(lastProbe) < rejoinProbeGap() {
+					log.V(3).Infof("Skipped refreshing rejoined model server %v at %v", s.Addr, t)
+					continue
+				}
+				lastProbe = t
 				if err := s.Refresh(context.TODO()); err != nil {
 					log.Warningf("Failed to refresh model server (%s) state: %v", s.Addr, err)
 				} else {
@@ -530,8 +865,6 @@ func (s *State) Start(ctx context.Context, modelFinder ModelFinder) error {
 			}
 		}
 	}()
-
-	return nil
 }
 
 // Close stops synchronization and closes the connection to the model server.
@@ -544,6 +877,9 @@ func (s *State) Close() {
 	s.tickerStop <- true
 	<-s.tickerStop
 
+	s.watchCancel()
+	<-s.watchStop
+
 	s.conn.Close()
 }
 
@@ -0,0 +1,368 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"saxml/admin/protobuf"
+	"saxml/common/basiceventlogger"
+	"saxml/common/errors"
+	"saxml/common/naming"
+
+	apb "saxml/protobuf/admin_go_proto_grpc"
+	cpb "saxml/protobuf/common_go_proto"
+	mgrpc "saxml/protobuf/modelet_go_proto_grpc"
+	mpb "saxml/protobuf/modelet_go_proto_grpc"
+)
+
+// countingClient counts GetStatus calls, like an older model server without WatchStatus. Other
+// modelet methods are not implemented.
+type countingClient struct {
+	mgrpc.ModeletClient
+
+	mu        sync.Mutex
+	getStatus int
+}
+
+func (c *countingClient) GetStatus(ctx context.Context, in *mpb.GetStatusRequest, opts ...grpc.CallOption) (*mpb.GetStatusResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.getStatus++
+	return &mpb.GetStatusResponse{}, nil
+}
+
+func (c *countingClient) WatchStatus(ctx context.Context, in *mpb.WatchStatusRequest, opts ...grpc.CallOption) (mgrpc.Modelet_WatchStatusClient, error) {
+	return nil, errors.ErrUnimplemented
+}
+
+func (c *countingClient) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getStatus
+}
+
+// countProbes returns the number of GetStatus calls made to a model server over a duration.
+func countProbes(t *testing.T, rejoined bool, duration time.Duration) int {
+	t.Helper()
+	client := &countingClient{}
+	s := New("localhost:10000", "", "", nil, nil)
+	s.client = client
+	if rejoined {
+		s.MarkRejoined()
+	}
+	s.startRefreshing()
+	time.Sleep(duration)
+	stopRefreshing(s)
+	return client.count()
+}
+
+// stopRefreshing stops the goroutines started by startRefreshing.
+func stopRefreshing(s *State) {
+	s.ticker.Stop()
+	s.tickerStop <- true
+	<-s.tickerStop
+	s.watchCancel()
+	<-s.watchStop
+}
+
+func TestRejoinGraceProbes(t *testing.T) {
+	defer func(refresh, grace time.Duration) {
+		refreshPeriod, rejoinGracePeriod = refresh, grace
+	}(refreshPeriod, rejoinGracePeriod)
+	refreshPeriod, rejoinGracePeriod = 20*time.Millisecond, time.Hour
+
+	normal := countProbes(t, false, time.Second)
+	throttled := countProbes(t, true, time.Second)
+	if normal == 0 {
+		t.Fatalf("No GetStatus calls to a model server in %v", time.Second)
+	}
+	if throttled == 0 || throttled*2 > normal {
+		t.Errorf("Rejoined model server got %d GetStatus calls, want between 1 and half of %d", throttled, normal)
+	}
+
+	// Throttling never skips probes for as long as would get the model server pruned.
+	defer func(gap time.Duration) { maxRejoinProbeGap = gap }(maxRejoinProbeGap)
+	maxRejoinProbeGap = refreshPeriod
+	if got := countProbes(t, true, time.Second); got*2 < normal {
+		t.Errorf("Rejoined model server with a capped probe gap got %d GetStatus calls, want about %d", got, normal)
+	}
+
+	// Probes return to normal frequency after the grace window.
+	rejoinGracePeriod = 0
+	if got := countProbes(t, true, time.Second); got*2 < normal {
+		t.Errorf("Model server after the grace window got %d GetStatus calls, want about %d", got, normal)
+	}
+}
+
+func TestRejoinGraceLoadDelay(t *testing.T) {
+	s := New("localhost:10000", "", "", nil, nil)
+	now := time.Now()
+	if got := s.loadDelay(now); got != 0 {
+		t.Errorf("loadDelay() for a first joined server = %v, want 0", got)
+	}
+
+	s.MarkRejoined()
+	for i := 0; i < 3; i++ {
+		if got, want := s.loadDelay(now), time.Duration(i)*rejoinLoadStagger; got != want {
+			t.Errorf("loadDelay() #%d during the grace window = %v, want %v", i, got, want)
+		}
+	}
+	if got := s.loadDelay(s.graceUntil); got != 0 {
+		t.Errorf("loadDelay() after the grace window = %v, want 0", got)
+	}
+}
+
+// statusStream returns the status sent on a channel, and io.EOF once the channel is closed.
+type statusStream struct {
+	grpc.ClientStream
+
+	ctx      context.Context
+	statuses chan *mpb.GetStatusResponse
+}
+
+func (s *statusStream) Recv() (*mpb.GetStatusResponse, error) {
+	select {
+	case res, ok := <-s.statuses:
+		if !ok {
+			return nil, io.EOF
+		}
+		return res, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+// watchingClient is a model server that pushes status sent on a channel over WatchStatus.
+type watchingClient struct {
+	countingClient
+
+	statuses chan *mpb.GetStatusResponse
+}
+
+func (c *watchingClient) WatchStatus(ctx context.Context, in *mpb.WatchStatusRequest, opts ...grpc.CallOption) (mgrpc.Modelet_WatchStatusClient, error) {
+	return &statusStream{ctx: ctx, statuses: c.statuses}, nil
+}
+
+// waitFor waits up to a second for cond to become true.
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %s", desc)
+}
+
+func TestWatchStatusPushesChanges(t *testing.T) {
+	// Status changes must arrive long before the next GetStatus call.
+	defer func(refresh time.Duration) { refreshPeriod = refresh }(refreshPeriod)
+	refreshPeriod = time.Hour
+
+	client := &watchingClient{statuses: make(chan *mpb.GetStatusResponse)}
+	s := New("localhost:10000", "", "", nil, nil)
+	s.client = client
+	fullName := naming.NewModelFullNameT(t, "test", "model")
+	s.wanted[fullName] = newModel(&apb.Model{ModelId: fullName.ModelFullName()})
+	s.startRefreshing()
+	defer stopRefreshing(s)
+
+	for _, status := range []cpb.ModelStatus{cpb.ModelStatus_LOADING, cpb.ModelStatus_LOADED} {
+		client.statuses <- &mpb.GetStatusResponse{
+			Models: []*mpb.GetStatusResponse_ModelWithStatus{
+				{ModelKey: fullName.ModelFullName(), ModelStatus: status},
+			},
+		}
+		want, err := protobuf.NewModelStatus(status)
+		if err != nil {
+			t.Fatalf("NewModelStatus(%v) error %v, want no error", status, err)
+		}
+		waitFor(t, "model status "+want.String(), func() bool {
+			seen, ok := s.SeenModels()[fullName]
+			return ok && seen.Info.Status == want
+		})
+	}
+	if got := client.count(); got != 0 {
+		t.Errorf("Watched model server got %d GetStatus calls, want 0", got)
+	}
+}
+
+func TestWatchStatusDropIsLivenessFailure(t *testing.T) {
+	defer func(refresh time.Duration) { refreshPeriod = refresh }(refreshPeriod)
+	refreshPeriod = 20 * time.Millisecond
+
+	client := &watchingClient{statuses: make(chan *mpb.GetStatusResponse)}
+	s := New("localhost:10000", "", "", nil, nil)
+	s.client = client
+	s.startRefreshing()
+	defer stopRefreshing(s)
+
+	client.statuses <- &mpb.GetStatusResponse{}
+	waitFor(t, "the first status", s.isWatching)
+
+	close(client.statuses)
+	waitFor(t, "the last ping to reset", func() bool { return s.LastPing().IsZero() })
+
+	// GetStatus calls must not make the server look alive again.
+	time.Sleep(10 * refreshPeriod)
+	if !s.LastPing().IsZero() {
+		t.Errorf("LastPing() = %v after the stream broke, want zero", s.LastPing())
+	}
+}
+
+func TestWatchStatusFallback(t *testing.T) {
+	defer func(refresh time.Duration) { refreshPeriod = refresh }(refreshPeriod)
+	refreshPeriod = 20 * time.Millisecond
+
+	if got := countProbes(t, false, 200*time.Millisecond); got == 0 {
+		t.Errorf("Model server without WatchStatus got no GetStatus calls, want some")
+	}
+}
+
+// loadingClient takes loadTime to load a model, and tracks how many loads run at once across all
+// model servers sharing it.
+type loadingClient struct {
+	mgrpc.ModeletClient
+	loadTime time.Duration
+
+	mu      sync.Mutex
+	running int
+	peak    int
+	loaded  int
+}
+
+func (c *loadingClient) Load(ctx context.Context, in *mpb.LoadRequest, opts ...grpc.CallOption) (*mpb.LoadResponse, error) {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.peak {
+		c.peak = c.running
+	}
+	c.mu.Unlock()
+	time.Sleep(c.loadTime)
+	c.mu.Lock()
+	c.running--
+	c.loaded++
+	c.mu.Unlock()
+	return &mpb.LoadResponse{}, nil
+}
+
+func TestLoadLimiter(t *testing.T) {
+	const numServers, maxLoads = 8, 2
+	client := &loadingClient{loadTime: 50 * time.Millisecond}
+	limiter := NewLoadLimiter()
+	limiter.SetLimit(maxLoads)
+	fullName := naming.NewModelFullNameT(t, "test", "model")
+	model := newModel(&apb.Model{ModelId: fullName.ModelFullName()})
+
+	// Roll the model out to all model servers at once.
+	var wg sync.WaitGroup
+	for i := 0; i < numServers; i++ {
+		s := New("localhost:10000", "", "", nil, basiceventlogger.New())
+		s.client = client
+		s.SetLoadLimiter(limiter)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.act(&action{load, context.Background(), fullName, model.clone(), nil})
+		}()
+	}
+	wg.Wait()
+	if client.loaded != numServers {
+		t.Errorf("Loaded the model on %d model servers, want %d", client.loaded, numServers)
+	}
+	if client.peak != maxLoads {
+		t.Errorf("Peak concurrent loads = %d, want %d", client.peak, maxLoads)
+	}
+
+	// Loads waiting for a slot give up when their context is done.
+	s := New("localhost:10000", "", "", nil, basiceventlogger.New())
+	s.client = client
+	s.SetLoadLimiter(limiter)
+	limiter.SetLimit(1)
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire() error %v, want no error", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.act(&action{load, ctx, fullName, model.clone(), nil})
+	if client.loaded != numServers {
+		t.Errorf("Loaded the model %d times after a load gave up waiting, want %d", client.loaded, numServers)
+	}
+
+	// Removing the limit lets waiting loads start.
+	done := make(chan struct{})
+	go func() {
+		s.act(&action{load, context.Background(), fullName, model.clone(), nil})
+		close(done)
+	}()
+	limiter.SetLimit(0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Load still waiting after the limit was removed")
+	}
+	limiter.release()
+}
+
+// blockingClient blocks GetStatus calls until release is closed.
+type blockingClient struct {
+	countingClient
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingClient) GetStatus(ctx context.Context, in *mpb.GetStatusRequest, opts ...grpc.CallOption) (*mpb.GetStatusResponse, error) {
+	c.countingClient.GetStatus(ctx, in, opts...)
+	c.entered <- struct{}{}
+	<-c.release
+	return &mpb.GetStatusResponse{}, nil
+}
+
+func TestCoalescedProbes(t *testing.T) {
+	client := &blockingClient{entered: make(chan struct{}, 2), release: make(chan struct{})}
+	s := New("localhost:10000", "", "", nil, nil)
+	s.client = client
+
+	// A refresh started while another is in flight waits for its probe instead of sending one.
+	before := coalescedProbes.Value()
+	errs := make(chan error, 2)
+	go func() { errs <- s.Refresh(context.Background()) }()
+	<-client.entered
+	go func() { errs <- s.Refresh(context.Background()) }()
+	waitFor(t, "coalesced probe", func() bool { return coalescedProbes.Value() == before+1 })
+	close(client.release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Refresh() error %v, want no error", err)
+		}
+	}
+	if got := client.count(); got != 1 {
+		t.Errorf("GetStatus calls for concurrent refreshes = %d, want 1", got)
+	}
+
+	// Refreshes after the probe completes send their own.
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Errorf("Refresh() error %v, want no error", err)
+	}
+	if got := client.count(); got != 2 {
+		t.Errorf("GetStatus calls after a later refresh = %d, want 2", got)
+	}
+}
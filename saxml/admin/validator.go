@@ -72,6 +72,11 @@ func ValidateConfigProto(cfg *pb.Config) error {
 			return err
 		}
 	}
+	for _, window := range cfg.GetBlackoutWindows() {
+		if window.GetEndMs() <= window.GetStartMs() {
+			return fmt.Errorf("blackout window %v must end after it starts: %w", window, errors.ErrInvalidArgument)
+		}
+	}
 	return nil
 }
 
@@ -112,11 +117,38 @@ func ValidateModelProto(model *pb.Model, saxCell string) error {
 	if model.GetRequestedNumReplicas() < 0 {
 		return fmt.Errorf("number of replicas %d must be non-negative: %w", model.GetRequestedNumReplicas(), errors.ErrInvalidArgument)
 	}
+	if model.GetWarmPoolSize() < 0 {
+		return fmt.Errorf("warm pool size %d must be non-negative: %w", model.GetWarmPoolSize(), errors.ErrInvalidArgument)
+	}
 	if aclname := model.GetAdminAcl(); aclname != "" {
 		if err := env.Get().ValidateACLName(aclname); err != nil {
 			return err
 		}
 	}
+	if autoscale := model.GetLatencyAutoscale(); autoscale != nil {
+		if autoscale.GetTargetP99Seconds() <= 0 {
+			return fmt.Errorf("latency autoscale target %v must be positive: %w", autoscale.GetTargetP99Seconds(), errors.ErrInvalidArgument)
+		}
+		if autoscale.GetMinReplicas() < 1 {
+			return fmt.Errorf("latency autoscale minimum %d must be at least 1: %w", autoscale.GetMinReplicas(), errors.ErrInvalidArgument)
+		}
+		if maxReplicas := autoscale.GetMaxReplicas(); maxReplicas != 0 && maxReplicas < autoscale.GetMinReplicas() {
+			return fmt.Errorf("latency autoscale maximum %d must be 0 or at least the minimum %d: %w", maxReplicas, autoscale.GetMinReplicas(), errors.ErrInvalidArgument)
+		}
+	}
+	var totalWeight int64
+	for version, weight := range model.GetTrafficSplit() {
+		if err := ValidateModelFullName(version, saxCell); err != nil {
+			return fmt.Errorf("invalid traffic split version: %w", err)
+		}
+		if weight < 0 {
+			return fmt.Errorf("traffic split weight %d of %s must be non-negative: %w", weight, version, errors.ErrInvalidArgument)
+		}
+		totalWeight += int64(weight)
+	}
+	if len(model.GetTrafficSplit()) > 0 && totalWeight == 0 {
+		return fmt.Errorf("traffic split weights must not all be zero: %w", errors.ErrInvalidArgument)
+	}
 	if model.GetAcls() != nil && model.GetAcls().GetItems() != nil {
 		for method, aclname := range model.GetAcls().GetItems() {
 			if _, ok := validMethodName[method]; !ok {
@@ -180,5 +212,23 @@ func ValidateJoinRequest(req *pb.JoinRequest) error {
 		return fmt.Errorf("Modelet.chip_topology cannot be unknown: %w", errors.ErrInvalidArgument)
 	}
 
+	var totalShare float64
+	for _, r := range modelet.GetReservations() {
+		if _, err := naming.NewModelFullName(r.GetModelId()); err != nil {
+			return fmt.Errorf("Modelet.reservations has an invalid model ID %q: %w", r.GetModelId(), errors.ErrInvalidArgument)
+		}
+		if r.GetMinMemoryBytes() < 0 {
+			return fmt.Errorf("Modelet.reservations.min_memory_bytes for %s cannot be negative: %w", r.GetModelId(), errors.ErrInvalidArgument)
+		}
+		share := r.GetMinComputeShare()
+		if share < 0 || share > 1 {
+			return fmt.Errorf("Modelet.reservations.min_compute_share for %s must be in [0, 1]: %w", r.GetModelId(), errors.ErrInvalidArgument)
+		}
+		totalShare += share
+	}
+	if totalShare > 1 {
+		return fmt.Errorf("Modelet.reservations reserve %v of the compute, more than all of it: %w", totalShare, errors.ErrInvalidArgument)
+	}
+
 	return nil
 }
@@ -51,6 +51,11 @@ func (c *testConfig) withAdminACL(adminACL string) *testConfig {
 	return c
 }
 
+func (c *testConfig) withBlackout(startMs, endMs int64) *testConfig {
+	c.config.BlackoutWindows = append(c.config.BlackoutWindows, &apb.BlackoutWindow{StartMs: startMs, EndMs: endMs})
+	return c
+}
+
 func TestCheckConfigProto(t *testing.T) {
 	tests := []struct {
 		desc    string
@@ -112,6 +117,16 @@ func TestCheckConfigProto(t *testing.T) {
 			validConfig().withAdminACL(env.Get().RequiredACLNamePrefixList()[0] + "does-not-exist-dev-group"),
 			cmpopts.AnyError,
 		},
+		{
+			"blackout window ok",
+			validConfig().withBlackout(1000, 2000),
+			nil,
+		},
+		{
+			"blackout window ending before it starts not ok",
+			validConfig().withBlackout(2000, 1000),
+			cmpopts.AnyError,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -208,11 +223,26 @@ func (m *testModel) withRequestedNumReplicas(num int32) *testModel {
 	return m
 }
 
+func (m *testModel) withWarmPoolSize(size int32) *testModel {
+	m.model.WarmPoolSize = size
+	return m
+}
+
+func (m *testModel) withLatencyAutoscale(target float32, minReplicas, maxReplicas int32) *testModel {
+	m.model.LatencyAutoscale = &apb.LatencyAutoscale{TargetP99Seconds: target, MinReplicas: minReplicas, MaxReplicas: maxReplicas}
+	return m
+}
+
 func (m *testModel) withSaxCell(saxCell string) *testModel {
 	m.saxCell = saxCell
 	return m
 }
 
+func (m *testModel) withTrafficSplit(split map[string]int32) *testModel {
+	m.model.TrafficSplit = split
+	return m
+}
+
 func (m *testModel) withACL(method, aclname string) *testModel {
 	m.model.GetAcls().GetItems()[method] = aclname
 	return m
@@ -299,11 +329,66 @@ func TestCheckModelProto(t *testing.T) {
 			validModel().withRequestedNumReplicas(-1),
 			cmpopts.AnyError,
 		},
+		{
+			"ok warm pool size",
+			validModel().withWarmPoolSize(2),
+			nil,
+		},
+		{
+			"invalid warm pool size",
+			validModel().withWarmPoolSize(-1),
+			cmpopts.AnyError,
+		},
+		{
+			"ok latency autoscale",
+			validModel().withLatencyAutoscale(0.5, 1, 4),
+			nil,
+		},
+		{
+			"ok latency autoscale without maximum",
+			validModel().withLatencyAutoscale(0.5, 1, 0),
+			nil,
+		},
+		{
+			"invalid latency autoscale target",
+			validModel().withLatencyAutoscale(0, 1, 4),
+			cmpopts.AnyError,
+		},
+		{
+			"invalid latency autoscale minimum",
+			validModel().withLatencyAutoscale(0.5, 0, 4),
+			cmpopts.AnyError,
+		},
+		{
+			"invalid latency autoscale bounds",
+			validModel().withLatencyAutoscale(0.5, 4, 1),
+			cmpopts.AnyError,
+		},
 		{
 			"invalid sax cell",
 			validModel().withSaxCell("/sax/baz"),
 			cmpopts.AnyError,
 		},
+		{
+			"ok traffic split",
+			validModel().withTrafficSplit(map[string]int32{"/sax/bar/foo": 95, "/sax/bar/foo_v2": 5}),
+			nil,
+		},
+		{
+			"invalid traffic split version",
+			validModel().withTrafficSplit(map[string]int32{"/sax/baz/foo_v2": 5}),
+			cmpopts.AnyError,
+		},
+		{
+			"invalid traffic split weight",
+			validModel().withTrafficSplit(map[string]int32{"/sax/bar/foo": 5, "/sax/bar/foo_v2": -1}),
+			cmpopts.AnyError,
+		},
+		{
+			"invalid traffic split total weight",
+			validModel().withTrafficSplit(map[string]int32{"/sax/bar/foo_v2": 0}),
+			cmpopts.AnyError,
+		},
 		{
 			"invalid method name",
 			validModel().withACL("never.method", env.Get().RequiredACLNamePrefixList()[0]+"all"),
@@ -405,6 +490,30 @@ func TestValidateJoinRequest(t *testing.T) {
 	if err := validator.ValidateJoinRequest(req); err != nil {
 		t.Errorf("Join(%v) err %v, want no error", req, err)
 	}
+
+	req.GetModelServer().Reservations = []*apb.ResourceReservation{
+		{ModelId: "/sax/test/foo", MinMemoryBytes: 1 << 30, MinComputeShare: 0.5},
+		{ModelId: "/sax/test/bar", MinComputeShare: 0.5},
+	}
+	if err := validator.ValidateJoinRequest(req); err != nil {
+		t.Errorf("Join(%v) err %v, want no error", req, err)
+	}
+
+	req.GetModelServer().Reservations[1].MinComputeShare = 0.6
+	if err := validator.ValidateJoinRequest(req); err == nil {
+		t.Errorf("Join(%v) no error, want some error for reserving more than all compute", req)
+	}
+
+	req.GetModelServer().Reservations[1].MinComputeShare = 0.5
+	req.GetModelServer().Reservations[1].MinMemoryBytes = -1
+	if err := validator.ValidateJoinRequest(req); err == nil {
+		t.Errorf("Join(%v) no error, want some error for negative reserved memory", req)
+	}
+
+	req.GetModelServer().Reservations[1] = &apb.ResourceReservation{ModelId: "bar"}
+	if err := validator.ValidateJoinRequest(req); err == nil {
+		t.Errorf("Join(%v) no error, want some error for an invalid reserved model ID", req)
+	}
 }
 
 func TestMain(m *testing.M) {
@@ -20,6 +20,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"flag"
 	log "github.com/golang/glog"
@@ -27,11 +28,17 @@ import (
 	"saxml/admin/admin"
 	"saxml/common/platform/env"
 	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/transport"
 )
 
 var (
 	saxCell = flag.String("sax_cell", "", "Sax cell, e.g., /sax/test")
 	port    = flag.Int("port", 10000, "server port")
+
+	shard     = flag.Int("shard", 0, "The admin shard this server owns, out of num_shards")
+	numShards = flag.Int("num_shards", 1, "The number of admin shards the cell's model namespace is partitioned into")
+
+	standbySyncPeriod = flag.Duration("standby_sync_period", 0, "If positive, while waiting to become the leader, sync the state saved by the leader this often, so that takeover is fast")
 )
 
 func main() {
@@ -41,6 +48,9 @@ func main() {
 	if *saxCell == "" {
 		log.Fatal("The sax_cell flag must be set")
 	}
+	if err := transport.LoadFlags(); err != nil {
+		log.Fatalf("Failed to load transport credentials: %v", err)
+	}
 	log.Info("Starting the server")
 
 	// If the running server receives a Ctrl+C during interactive local runs or an eviction SIGTERM
@@ -49,6 +59,10 @@ func main() {
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
 	adminServer := admin.NewServer(*saxCell, *port)
+	adminServer.SetShard(*shard, *numShards)
+	if *standbySyncPeriod > 0 {
+		adminServer.EnableWarmStandby(*standbySyncPeriod)
+	}
 	adminServer.EnableStatusPages()
 	if err := adminServer.Start(ctx); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
@@ -26,6 +26,7 @@ import (
 	"saxml/bin/saxcommand"
 	"saxml/common/platform/env"
 	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/transport"
 )
 
 const (
@@ -46,6 +47,7 @@ func main() {
 	subcommands.Register(&saxcommand.GetACLCmd{}, "")
 	subcommands.Register(&saxcommand.SetACLCmd{}, "")
 	subcommands.Register(&saxcommand.UnpublishCmd{}, "")
+	subcommands.Register(&saxcommand.EvacuateCmd{}, "")
 	subcommands.Register(&saxcommand.WatchCmd{}, "")
 
 	// export commands.
@@ -67,6 +69,9 @@ func main() {
 
 	ctx := context.Background()
 	env.Get().Init(ctx)
+	if err := transport.LoadFlags(); err != nil {
+		log.Fatalf("Failed to load transport credentials: %v", err)
+	}
 
 	go http.ListenAndServe(fmt.Sprintf(":%d", port), nil) // to get /rpcz, etc. for debugging RPCs
 	status := subcommands.Execute(ctx)
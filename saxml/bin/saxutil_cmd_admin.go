@@ -21,10 +21,10 @@ import (
 	"io"
 	"math/rand"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"flag"
 	log "github.com/golang/glog"
@@ -100,16 +100,17 @@ func (c *CreateCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any) s
 		log.Errorf("Failed to get path for Sax cell %s: %v", saxCell, err)
 		return subcommands.ExitFailure
 	}
-	fname := filepath.Join(path, addr.LocationFile)
 	location := &apb.Location{Location: addr.LocationFileInitialContent}
 	content, err := proto.Marshal(location)
 	if err != nil {
 		log.Errorf("Failed to marshal location: %v", err)
 		return subcommands.ExitFailure
 	}
-	if err := env.Get().WriteFile(ctx, fname, adminACL, content); err != nil {
-		log.Errorf("Failed to write location file: %v", err)
-		return subcommands.ExitFailure
+	for _, fname := range addr.LocationFiles(ctx, path) {
+		if err := env.Get().WriteFile(ctx, fname, adminACL, content); err != nil {
+			log.Errorf("Failed to write location file %s: %v", fname, err)
+			return subcommands.ExitFailure
+		}
 	}
 
 	return subcommands.ExitSuccess
@@ -311,6 +312,22 @@ func (c *ListCmd) handleSaxModel(ctx context.Context, modelFullName naming.Model
 		table.SetHeader([]string{"Model", "Model Path", "Checkpoint Path", "# of Replicas", "(Selected) ReplicaAddress"})
 		table.Append([]string{modelFullName.ModelName(), model.GetModelPath(), model.GetCheckpointPath(), strconv.Itoa(len(publishedModel.GetModeletAddresses())), randomSelectedAddress})
 		table.Render()
+		if pending := publishedModel.GetPendingNumReplicas(); pending > 0 {
+			fmt.Printf("Increase to %d replicas pending approval\n", pending)
+		}
+		if rationale := publishedModel.GetPlacementRationale(); len(rationale) > 0 {
+			addrs := make([]string, 0, len(rationale))
+			for addr := range rationale {
+				addrs = append(addrs, addr)
+			}
+			sort.Strings(addrs)
+			table := NewResultRenderer(os.Stdout, c.outputCsv)
+			table.SetHeader([]string{"Replica Address", "Placement Rationale"})
+			for _, addr := range addrs {
+				table.Append([]string{addr, rationale[addr]})
+			}
+			table.Render()
+		}
 	}
 
 	if c.methodAcls {
@@ -360,6 +377,32 @@ func (c *ListCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any) sub
 // PublishCmd is the command for Publish.
 type PublishCmd struct {
 	// Internal storage setting
+	rollout rolloutFlags
+}
+
+// rolloutFlags are the flags of commands that can roll a model out in stages.
+type rolloutFlags struct {
+	canaries int
+	soak     time.Duration
+	timeout  time.Duration
+}
+
+func (r *rolloutFlags) setFlags(f *flag.FlagSet) {
+	f.IntVar(&r.canaries, "canaries", 0, "If positive, roll the model out to this many canary replicas first.")
+	f.DurationVar(&r.soak, "soak", 10*time.Minute, "How long canary replicas must stay loaded before the rest of the replicas roll out.")
+	f.DurationVar(&r.timeout, "rollout_timeout", time.Hour, "How long canary replicas have to pass before the rollout halts. If 0, they have no deadline.")
+}
+
+// options returns the options of a staged rollout, or nil if the flags don't ask for one.
+func (r *rolloutFlags) options() *apb.RolloutOptions {
+	if r.canaries <= 0 {
+		return nil
+	}
+	return &apb.RolloutOptions{
+		CanaryReplicas: int32(r.canaries),
+		SoakMs:         r.soak.Milliseconds(),
+		TimeoutMs:      r.timeout.Milliseconds(),
+	}
 }
 
 // Name returns the name of PublishCmd.
@@ -370,16 +413,21 @@ func (*PublishCmd) Synopsis() string { return "Publish a model." }
 
 // Usage returns the full usage of PublishCmd.
 func (*PublishCmd) Usage() string {
-	return `publish <model ID> <model path> <checkpoint path> <num of replicas> [override_key=override_val]*:
+	return `publish [-canaries=<num> [-soak=<duration>] [-rollout_timeout=<duration>]] <model ID> <model path> <checkpoint path> <num of replicas> [override_key=override_val]*:
 	Publish a model using the given number of server replicas.
 
 	override_val should be a JSON. This means strings must be in double quotes.
+
+	With -canaries, the model is served by that many canary replicas first, and
+	by the rest once the canaries have stayed loaded for -soak. A failing canary
+	unpublishes the model. List shows the rollout while it is under way.
 `
 }
 
 // SetFlags sets flags for PublishCmd.
 func (c *PublishCmd) SetFlags(f *flag.FlagSet) {
 	// Internal storage flag configuration
+	c.rollout.setFlags(f)
 }
 
 // Execute executes PublishCmd.
@@ -418,7 +466,19 @@ func (c *PublishCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any)
 
 	ctx, cancel := context.WithTimeout(ctx, *cmdTimeout)
 	defer cancel()
-	if err := admin.Publish(ctx, modelID.ModelFullName(), modelPath, ckptPath, numReplicas, overrides); err != nil {
+	if options := c.rollout.options(); options != nil {
+		model := &apb.Model{
+			ModelId:              modelID.ModelFullName(),
+			ModelPath:            modelPath,
+			CheckpointPath:       ckptPath,
+			RequestedNumReplicas: int32(numReplicas),
+			Overrides:            overrides,
+		}
+		err = admin.PublishStaged(ctx, model, options)
+	} else {
+		err = admin.Publish(ctx, modelID.ModelFullName(), modelPath, ckptPath, numReplicas, overrides)
+	}
+	if err != nil {
 		log.Errorf("Failed to publish model: %v", err)
 		return subcommands.ExitFailure
 	}
@@ -427,7 +487,9 @@ func (c *PublishCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any)
 }
 
 // UnpublishCmd is the command for Unpublish.
-type UnpublishCmd struct{}
+type UnpublishCmd struct {
+	force bool
+}
 
 // Name returns the name of UnpublishCmd.
 func (*UnpublishCmd) Name() string { return "unpublish" }
@@ -437,13 +499,15 @@ func (*UnpublishCmd) Synopsis() string { return "Unpublish a model." }
 
 // Usage returns the full usage of UnpublishCmd.
 func (*UnpublishCmd) Usage() string {
-	return `unpublish <model ID>:
+	return `unpublish [-force] <model ID>:
 	Unpublish a published model.
 `
 }
 
 // SetFlags sets flags for UnpublishCmd.
-func (c *UnpublishCmd) SetFlags(f *flag.FlagSet) {}
+func (c *UnpublishCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.force, "force", false, "Unpublish even during a blackout window of the cell.")
+}
 
 // Execute executes UnpublishCmd.
 func (c *UnpublishCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any) subcommands.ExitStatus {
@@ -461,6 +525,9 @@ func (c *UnpublishCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any
 
 	ctx, cancel := context.WithTimeout(ctx, *cmdTimeout)
 	defer cancel()
+	if c.force {
+		ctx = saxadmin.WithForce(ctx)
+	}
 	if err := admin.Unpublish(ctx, modelID.ModelFullName()); err != nil {
 		log.Errorf("Failed to unpublish model: %v", err)
 		return subcommands.ExitFailure
@@ -472,6 +539,8 @@ func (c *UnpublishCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any
 // UpdateCmd is the command for Update.
 type UpdateCmd struct {
 	numReplicas int
+	force       bool
+	rollout     rolloutFlags
 }
 
 // Name returns the name of UpdateCmd.
@@ -482,14 +551,20 @@ func (*UpdateCmd) Synopsis() string { return "Update a model." }
 
 // Usage returns the full usage of UpdateCmd.
 func (*UpdateCmd) Usage() string {
-	return `update [-replicas=<num>]  <model ID>:
+	return `update [-replicas=<num>] [-force] [-canaries=<num> [-soak=<duration>] [-rollout_timeout=<duration>]] <model ID>:
 	Update a published model.
+
+	With -canaries, a replica increase goes to that many canary replicas first,
+	and to the rest once the canaries have stayed loaded for -soak. A failing
+	canary restores the previous model definition.
 `
 }
 
 // SetFlags sets flags for UpdateCmd.
 func (c *UpdateCmd) SetFlags(f *flag.FlagSet) {
 	f.IntVar(&c.numReplicas, "replicas", -1, "Number of replicas for this model.")
+	f.BoolVar(&c.force, "force", false, "Lower the number of replicas even during a blackout window of the cell.")
+	c.rollout.setFlags(f)
 }
 
 // Execute executes UpdateCmd.
@@ -524,7 +599,15 @@ func (c *UpdateCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any) s
 
 	ctx, cancel := context.WithTimeout(ctx, *cmdTimeout)
 	defer cancel()
-	if err := admin.Update(ctx, model); err != nil {
+	if c.force {
+		ctx = saxadmin.WithForce(ctx)
+	}
+	if options := c.rollout.options(); options != nil {
+		err = admin.UpdateStaged(ctx, model, options)
+	} else {
+		err = admin.Update(ctx, model)
+	}
+	if err != nil {
 		log.Errorf("Failed to update model: %v", err)
 		return subcommands.ExitFailure
 	}
@@ -767,6 +850,63 @@ func (c *GetACLCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any) s
 	return subcommands.ExitFailure
 }
 
+// EvacuateCmd is the command for EvacuateLabel.
+type EvacuateCmd struct {
+	force bool
+}
+
+// Name returns the name of EvacuateCmd.
+func (*EvacuateCmd) Name() string { return "evacuate" }
+
+// Synopsis returns the synopsis of EvacuateCmd.
+func (*EvacuateCmd) Synopsis() string { return "Move all models off labeled model servers." }
+
+// Usage returns the full usage of EvacuateCmd.
+func (*EvacuateCmd) Usage() string {
+	return `evacuate [-force] <cell ID> <key>=<value>:
+	Move all models off the model servers tagged <key>=<value>, e.g., zone=a for
+	zonal maintenance, and wait until they are drained. Evacuations can take
+	a while, so consider a longer --sax_timeout.
+`
+}
+
+// SetFlags sets flags for EvacuateCmd.
+func (c *EvacuateCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.force, "force", false, "Evacuate even during a blackout window of the cell.")
+}
+
+// Execute executes EvacuateCmd.
+func (c *EvacuateCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if len(f.Args()) != 2 {
+		log.Errorf("Provide a cell ID and a label.")
+		return subcommands.ExitUsageError
+	}
+	cellFullName, err := naming.NewCellFullName(f.Args()[0])
+	if err != nil {
+		log.Errorf("Invalid cell ID %s, should be /sax/<cell>: %v", f.Args()[0], err)
+		return subcommands.ExitFailure
+	}
+	key, value, found := strings.Cut(f.Args()[1], "=")
+	if !found || key == "" {
+		log.Errorf("The label should be of the form key=value")
+		return subcommands.ExitUsageError
+	}
+
+	admin := saxadmin.Open(cellFullName.CellFullName())
+
+	ctx, cancel := context.WithTimeout(ctx, *cmdTimeout)
+	defer cancel()
+	if c.force {
+		ctx = saxadmin.WithForce(ctx)
+	}
+	if err := admin.EvacuateLabel(ctx, key, value); err != nil {
+		log.Errorf("Failed to evacuate model servers labeled %s=%s: %v", key, value, err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
 // WatchCmd is the command for WatchAddresses. Useful for debugging.
 type WatchCmd struct{}
 
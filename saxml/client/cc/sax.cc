@@ -943,6 +943,21 @@ MultimodalModel* Model::MM() {
 
 void StartDebugPort(int port) { go_start_debug(port); }
 
+absl::Status SetTransportFiles(absl::string_view cert_file,
+                               absl::string_view key_file,
+                               absl::string_view ca_file) {
+  char* errMsgStr = nullptr;
+  int errCode = 0;
+  go_set_transport_files(const_cast<char*>(cert_file.data()), cert_file.size(),
+                         const_cast<char*>(key_file.data()), key_file.size(),
+                         const_cast<char*>(ca_file.data()), ca_file.size(),
+                         &errMsgStr, &errCode);
+  if (errCode != 0) {
+    return CreateErrorAndFree(errCode, errMsgStr);
+  }
+  return absl::OkStatus();
+}
+
 absl::Status Publish(absl::string_view id, absl::string_view model_path,
                      absl::string_view checkpoint_path, int num_replicas) {
   return Publish(AdminOptions(), id, model_path, checkpoint_path, num_replicas,
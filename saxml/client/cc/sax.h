@@ -541,6 +541,17 @@ class Model {
 // Starts a debugging http server at the given `port`. For debugging only.
 void StartDebugPort(int port);
 
+// Secures connections to Sax components, as required by the transport security
+// policy of their cell, with the PEM certificate in `cert_file` and its private
+// key in `key_file`, verifying servers against the CA certificates in
+// `ca_file`, or the host's root CAs if empty. The certificate is only needed in
+// cells requiring mTLS. Connections already made are kept.
+//
+// On success, returns OK; Otherwise, returns an error.
+absl::Status SetTransportFiles(absl::string_view cert_file,
+                               absl::string_view key_file,
+                               absl::string_view ca_file);
+
 struct AdminOptions {
   // Timeout in seconds. Negative values indicate no timeout.
   float timeout = -1;
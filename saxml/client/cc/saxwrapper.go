@@ -40,6 +40,7 @@ import (
 	"saxml/common/errors"
 	"saxml/common/naming"
 	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/transport"
 
 	apb "saxml/protobuf/admin_go_proto_grpc"
 	ampb "saxml/protobuf/audio_go_proto_grpc"
@@ -1204,6 +1205,21 @@ func go_custom(ptr C.long, timeout C.float, requestData unsafe.Pointer, requestS
 	buildReturnValues(outData, outSize, errMsg, errCode, &content, nil)
 }
 
+//////////////////////////////////////////////////////////////////////////
+// Transport methods
+//////////////////////////////////////////////////////////////////////////
+
+//export go_set_transport_files
+func go_set_transport_files(certData *C.char, certSize C.int, keyData *C.char, keySize C.int, caData *C.char, caSize C.int, errMsg **C.char, errCode *C.int) {
+	certFile := C.GoStringN(certData, certSize)
+	keyFile := C.GoStringN(keyData, keySize)
+	caFile := C.GoStringN(caData, caSize)
+	if err := transport.LoadFiles(certFile, keyFile, caFile); err != nil {
+		*errMsg = C.CString(err.Error())
+		*errCode = C.int(int32(errors.Code(err)))
+	}
+}
+
 //////////////////////////////////////////////////////////////////////////
 // Debugging methods
 //////////////////////////////////////////////////////////////////////////
@@ -20,16 +20,21 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash/maphash"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	log "github.com/golang/glog"
 	"google.golang.org/grpc"
 	"saxml/common/addr"
+	"saxml/common/blob"
 	"saxml/common/errors"
-	"saxml/common/platform/env"
 	"saxml/common/retrier"
 	"saxml/common/skiplist"
+	"saxml/common/transport"
 	"saxml/common/watchable"
 
 	pb "saxml/protobuf/admin_go_proto_grpc"
@@ -46,13 +51,35 @@ const (
 	// Inserts these many points into the consistent hash ring for
 	// each server address.
 	numVirtualReplicas = 8
+	// Bounds of the delay between WatchLoc reconnection attempts after
+	// an unexpected error.
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	// Replica sets returned by Replicas are refetched from the admin
+	// server after this much time, if not invalidated earlier.
+	replicaCacheTTL = 30 * time.Second
 )
 
-// Create Admin server connection.
-func establishAdminConn(address string) (*grpc.ClientConn, error) {
+var (
+	// A replica's circuit breaker opens after this many consecutive
+	// failed calls, and lets a call through to retest the replica
+	// after breakerCooldown.
+	breakerThreshold = 5
+	breakerCooldown  = 10 * time.Second
+
+	// A session's affinity to a replica is released after the session
+	// makes no call for sessionTTL. Each model keeps at most
+	// maxSessions affinities, releasing the least recently used ones
+	// beyond that.
+	sessionTTL  = 10 * time.Minute
+	maxSessions = 10000
+)
+
+// Create Admin server connection, following the transport security policy of saxCell.
+func establishAdminConn(saxCell, address string) (*grpc.ClientConn, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	conn, err := env.Get().DialContext(ctx, address)
+	conn, err := transport.DialCell(ctx, saxCell, address)
 	if errors.IsDeadlineExceeded(err) {
 		err = fmt.Errorf("Dial to admin failed: %w", errors.ErrUnavailable)
 	}
@@ -64,6 +91,26 @@ type Admin struct {
 	saxCell string // E.g., /sax/bar
 
 	mu sync.Mutex
+	// shards has a connection to each admin shard used so far, keyed by
+	// shard. A cell that isn't sharded only has shard 0.
+	shards map[int]*adminConn
+
+	// addrs maintains an addrReplica for every model seen by this
+	// admin through FindAdddress(). Each addrReplica is the set of
+	// model server addresses for the model. The set is lazily
+	// replicated from the admin server through WatchAddresses().
+	addrs map[string]*addrReplica
+
+	// zoneWeights biases FindAddress toward replicas in some zones. See
+	// SetZoneWeights().
+	zoneWeights map[string]float64
+
+	// replicas caches replica sets returned by Replicas().
+	replicas *replicaCache
+}
+
+// adminConn is a connection to one admin shard.
+type adminConn struct {
 	// dialing is true if and only if there is an attempt ongoing to open a
 	// network connection to the admin server.
 	dialing bool
@@ -72,59 +119,61 @@ type Admin struct {
 	conn *grpc.ClientConn
 	// client is the admin service client.
 	client pbgrpc.AdminClient
-
-	// addrs maintains an addrReplica for every model seen by this
-	// admin through FindAdddress(). Each addrReplica is the set of
-	// model server addresses for the model. The set is lazily
-	// replicated from the admin server through WatchAddresses().
-	addrs map[string]*addrReplica
 }
 
 // TODO(zhifengc): consider abstracting out module providing a
 // resettable sync.Once interface, which can be tested separatedly.
-func (a *Admin) getAdminClient(ctx context.Context) (pbgrpc.AdminClient, error) {
+func (a *Admin) getAdminClient(ctx context.Context, shard int) (pbgrpc.AdminClient, error) {
 	a.mu.Lock()
-	// A quick check if a.client is established already.
-	if a.client != nil {
+	c, ok := a.shards[shard]
+	if !ok {
+		c = &adminConn{}
+		a.shards[shard] = c
+	}
+	// A quick check if c.client is established already.
+	if c.client != nil {
 		defer a.mu.Unlock()
-		return a.client, nil
+		return c.client, nil
 	}
 	// Makes sure there is only one thread attempting to dial to the admin server.
-	if a.dialing {
+	if c.dialing {
 		defer a.mu.Unlock()
 		return nil, fmt.Errorf("Dialing to admin: %w", errors.ErrResourceExhausted)
 	}
-	a.dialing = true
+	c.dialing = true
 	a.mu.Unlock()
 
-	// Ensures a.dialing is set to false when this function ends.
+	// Ensures c.dialing is set to false when this function ends.
 	defer func() {
 		a.mu.Lock()
 		defer a.mu.Unlock()
-		a.dialing = false
+		c.dialing = false
 	}()
 
-	addr, err := addr.FetchAddr(ctx, a.saxCell)
+	addr, err := addr.FetchShardAddr(ctx, a.saxCell, shard)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := establishAdminConn(addr)
+	conn, err := establishAdminConn(a.saxCell, addr)
 	if err != nil {
 		return nil, err
 	}
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.conn, a.client = conn, pbgrpc.NewAdminClient(conn)
-	return a.client, nil
+	c.conn, c.client = conn, pbgrpc.NewAdminClient(conn)
+	return c.client, nil
 }
 
-func (a *Admin) poison() {
+func (a *Admin) poison(shard int) {
 	a.mu.Lock()
-	conn := a.conn
-	a.conn = nil
-	a.client = nil
+	var conn *grpc.ClientConn
+	if c, ok := a.shards[shard]; ok {
+		conn = c.conn
+		c.conn = nil
+		c.client = nil
+	}
 	a.mu.Unlock()
 
 	if conn != nil {
@@ -132,18 +181,48 @@ func (a *Admin) poison() {
 	}
 }
 
-func (a *Admin) retry(ctx context.Context, callback func(client pbgrpc.AdminClient) error) error {
-	action := func() error {
-		client, err := a.getAdminClient(ctx)
-		if err == nil {
-			err = callback(client)
-		}
-		if errors.AdminShouldPoison(err) {
-			a.poison()
-		}
+// tryShard calls callback once with a client of an admin shard picked
+// by shard.
+func (a *Admin) tryShard(ctx context.Context, shard func() (int, error), callback func(client pbgrpc.AdminClient) error) error {
+	s, err := shard()
+	if err != nil {
 		return err
 	}
-	return retrier.Do(ctx, action, errors.AdminShouldRetry)
+	client, err := a.getAdminClient(ctx, s)
+	if err == nil {
+		err = callback(client)
+	}
+	if errors.AdminShouldPoison(err) {
+		a.poison(s)
+	}
+	return err
+}
+
+// retryShard calls callback with a client of an admin shard until it
+// succeeds or fails with an error not worth retrying. shard is called
+// before each attempt to pick the shard.
+func (a *Admin) retryShard(ctx context.Context, shard func() (int, error), callback func(client pbgrpc.AdminClient) error) error {
+	return retrier.Do(ctx, func() error { return a.tryShard(ctx, shard, callback) }, errors.AdminShouldRetry)
+}
+
+// retry calls callback with a client of admin shard 0, which serves the
+// whole cell if it isn't sharded.
+func (a *Admin) retry(ctx context.Context, callback func(client pbgrpc.AdminClient) error) error {
+	return a.retryShard(ctx, func() (int, error) { return 0, nil }, callback)
+}
+
+// modelShard returns the admin shard owning a model.
+func (a *Admin) modelShard(ctx context.Context, modelID string) (int, error) {
+	n, err := addr.NumShards(ctx, a.saxCell)
+	if err != nil {
+		return 0, err
+	}
+	return addr.ShardOf(modelID, n), nil
+}
+
+// retryModel calls callback with a client of the admin shard owning a model.
+func (a *Admin) retryModel(ctx context.Context, modelID string, callback func(client pbgrpc.AdminClient) error) error {
+	return a.retryShard(ctx, func() (int, error) { return a.modelShard(ctx, modelID) }, callback)
 }
 
 // Publish publishes a model.
@@ -158,27 +237,295 @@ func (a *Admin) Publish(ctx context.Context, modelID, modelPath, checkpointPath
 		},
 	}
 
-	return a.retry(ctx, func(client pbgrpc.AdminClient) error {
+	return a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
 		_, err := client.Publish(ctx, req)
 		return err
 	})
 }
 
+// PublishModel publishes a model with a full model definition, e.g., one referencing config blobs
+// uploaded with UploadBlob.
+func (a *Admin) PublishModel(ctx context.Context, model *pb.Model) error {
+	req := &pb.PublishRequest{Model: model}
+	return a.retryModel(ctx, model.GetModelId(), func(client pbgrpc.AdminClient) error {
+		_, err := client.Publish(ctx, req)
+		return err
+	})
+}
+
+// PublishStaged publishes a model with a full model definition like PublishModel, but rolls it
+// out to options.CanaryReplicas replicas first. The rest of its replicas follow once the canaries
+// have stayed loaded for options.SoakMs, and a failing canary unpublishes the model. It returns
+// once the model is published; List shows the rollout while it is under way.
+func (a *Admin) PublishStaged(ctx context.Context, model *pb.Model, options *pb.RolloutOptions) error {
+	req := &pb.PublishStagedRequest{Model: model, Options: options}
+	return a.retryModel(ctx, model.GetModelId(), func(client pbgrpc.AdminClient) error {
+		_, err := client.PublishStaged(ctx, req)
+		return err
+	})
+}
+
+// SimulatePlacement returns where a model would be placed if it were published now, and why,
+// without publishing it. The cell places it with the same algorithm it uses for published models.
+func (a *Admin) SimulatePlacement(ctx context.Context, model *pb.Model) (*pb.SimulatePlacementResponse, error) {
+	req := &pb.SimulatePlacementRequest{Model: model}
+	var res *pb.SimulatePlacementResponse
+	err := a.retryModel(ctx, model.GetModelId(), func(client pbgrpc.AdminClient) error {
+		var err error
+		res, err = client.SimulatePlacement(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+// UploadBlob uploads a large model config blob to the cell and returns its digest, to be used as a
+// value in Model.ConfigBlobs. The blob is compressed and sent in chunks, so it can be larger than
+// the maximum gRPC message size.
+func (a *Admin) UploadBlob(ctx context.Context, data []byte) (string, error) {
+	compressed, err := blob.Compress(data)
+	if err != nil {
+		return "", err
+	}
+
+	var digest string
+	err = a.retry(ctx, func(client pbgrpc.AdminClient) error {
+		stream, err := client.UploadBlob(ctx)
+		if err != nil {
+			return err
+		}
+		for rest := compressed; len(rest) > 0; {
+			n := blob.ChunkSize
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if err := stream.Send(&pb.UploadBlobRequest{Chunk: rest[:n]}); err != nil {
+				// The actual error is returned by CloseAndRecv.
+				break
+			}
+			rest = rest[n:]
+		}
+		resp, err := stream.CloseAndRecv()
+		if err != nil {
+			return err
+		}
+		digest = resp.GetDigest()
+		return nil
+	})
+	return digest, err
+}
+
 // Update updates the model definition of a published model.
 func (a *Admin) Update(ctx context.Context, model *pb.Model) error {
-	req := &pb.UpdateRequest{Model: model}
-	return a.retry(ctx, func(client pbgrpc.AdminClient) error {
+	req := &pb.UpdateRequest{Model: model, Force: forceFrom(ctx)}
+	return a.retryModel(ctx, model.GetModelId(), func(client pbgrpc.AdminClient) error {
+		_, err := client.Update(ctx, req)
+		return err
+	})
+}
+
+// UpdateStaged updates the model definition of a published model like Update, but rolls a replica
+// increase out to options.CanaryReplicas additional replicas first. If a canary fails, the previous
+// model definition is restored.
+func (a *Admin) UpdateStaged(ctx context.Context, model *pb.Model, options *pb.RolloutOptions) error {
+	req := &pb.UpdateRequest{Model: model, Force: forceFrom(ctx), Rollout: options}
+	return a.retryModel(ctx, model.GetModelId(), func(client pbgrpc.AdminClient) error {
 		_, err := client.Update(ctx, req)
 		return err
 	})
 }
 
+// SetTrafficSplit updates the traffic split among versions of a published model. Each key of split
+// is the ID of a published model serving one version and each value is its relative weight. An
+// empty split sends all traffic to the model itself.
+func (a *Admin) SetTrafficSplit(ctx context.Context, modelID string, split map[string]int32) error {
+	published, err := a.List(ctx, modelID)
+	if err != nil {
+		return err
+	}
+	model := published.GetModel()
+	model.TrafficSplit = split
+	return a.Update(ctx, model)
+}
+
+// ApproveScale applies a pending increase of the number of replicas of a
+// published model, shown in PublishedModel.PendingNumReplicas by List.
+func (a *Admin) ApproveScale(ctx context.Context, modelID string) error {
+	req := &pb.ApproveScaleRequest{ModelId: modelID}
+	return a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
+		_, err := client.ApproveScale(ctx, req)
+		return err
+	})
+}
+
+// OverrideConstraints relaxes the placement constraints of a published model
+// for duration, e.g. to restore service quickly during an incident, and
+// returns the override with its expiration time. The override reverts
+// automatically when it expires.
+func (a *Admin) OverrideConstraints(ctx context.Context, modelID string, override *pb.ConstraintOverride, duration time.Duration) (*pb.ConstraintOverride, error) {
+	req := &pb.OverrideConstraintsRequest{
+		ModelId:    modelID,
+		Override:   override,
+		DurationMs: duration.Milliseconds(),
+	}
+	var res *pb.OverrideConstraintsResponse
+	err := a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
+		var err error
+		res, err = client.OverrideConstraints(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.GetOverride(), nil
+}
+
+// SetReplicasFor raises the number of replicas of a published model to
+// count for duration, e.g. ahead of a scheduled load spike, and returns
+// the bump with its expiration time. The model goes back to its previous
+// number of replicas when the bump expires.
+func (a *Admin) SetReplicasFor(ctx context.Context, modelID string, count int, duration time.Duration) (*pb.ReplicaBump, error) {
+	req := &pb.SetReplicasForRequest{
+		ModelId:     modelID,
+		NumReplicas: int32(count),
+		DurationMs:  duration.Milliseconds(),
+		Force:       forceFrom(ctx),
+	}
+	var res *pb.SetReplicasForResponse
+	err := a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
+		var err error
+		res, err = client.SetReplicasFor(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.GetBump(), nil
+}
+
+// PromoteWarm sets how many warm pool replicas of a published model serve
+// traffic on top of its requested number of replicas, e.g., raising it as
+// load rises and lowering it back as load subsides.
+func (a *Admin) PromoteWarm(ctx context.Context, modelID string, count int) error {
+	req := &pb.PromoteWarmRequest{
+		ModelId:     modelID,
+		NumPromoted: int32(count),
+		Force:       forceFrom(ctx),
+	}
+	return a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
+		_, err := client.PromoteWarm(ctx, req)
+		return err
+	})
+}
+
+// ListIncarnations returns the model server processes that joined from
+// ipPort, oldest first, including stale ones that have since left.
+func (a *Admin) ListIncarnations(ctx context.Context, ipPort string) ([]*pb.Incarnation, error) {
+	req := &pb.ListIncarnationsRequest{Address: ipPort}
+	var res *pb.ListIncarnationsResponse
+	// Model servers join the admin shard their address maps to, like models do.
+	shard := func() (int, error) { return a.modelShard(ctx, ipPort) }
+	err := a.retryShard(ctx, shard, func(client pbgrpc.AdminClient) error {
+		var err error
+		res, err = client.ListIncarnations(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.GetIncarnations(), nil
+}
+
+// PurgeStaleIncarnations makes every admin shard forget the model server
+// processes that have left, keeping the current one at each address, and
+// returns how many records were purged across shards.
+func (a *Admin) PurgeStaleIncarnations(ctx context.Context) (int, error) {
+	n, err := addr.NumShards(ctx, a.saxCell)
+	if err != nil {
+		return 0, err
+	}
+	req := &pb.PurgeStaleIncarnationsRequest{}
+	purged := 0
+	for shard := 0; shard < n; shard++ {
+		var res *pb.PurgeStaleIncarnationsResponse
+		err := a.retryShard(ctx, func() (int, error) { return shard, nil }, func(client pbgrpc.AdminClient) error {
+			var err error
+			res, err = client.PurgeStaleIncarnations(ctx, req)
+			return err
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += int(res.GetNumPurged())
+	}
+	return purged, nil
+}
+
+// GetEffectiveConfig returns the configuration settings in force in the
+// admin server, or in shard 0 if the cell is sharded, and where each one
+// comes from.
+func (a *Admin) GetEffectiveConfig(ctx context.Context) ([]*pb.ConfigValue, error) {
+	req := &pb.GetEffectiveConfigRequest{}
+	var res *pb.GetEffectiveConfigResponse
+	err := a.retry(ctx, func(client pbgrpc.AdminClient) error {
+		var err error
+		res, err = client.GetEffectiveConfig(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.GetValues(), nil
+}
+
+// ExportAudit returns the audit records every admin shard keeps for the
+// time range [since, until), oldest first. A zero until means up to now.
+func (a *Admin) ExportAudit(ctx context.Context, since, until time.Time) ([]*pb.AuditRecord, error) {
+	n, err := addr.NumShards(ctx, a.saxCell)
+	if err != nil {
+		return nil, err
+	}
+	req := &pb.ExportAuditRequest{SinceMs: since.UnixMilli()}
+	if !until.IsZero() {
+		req.UntilMs = until.UnixMilli()
+	}
+	var records []*pb.AuditRecord
+	for shard := 0; shard < n; shard++ {
+		var shardRecords []*pb.AuditRecord
+		err := a.retryShard(ctx, func() (int, error) { return shard, nil }, func(client pbgrpc.AdminClient) error {
+			// Start over if the stream fails, so a retry doesn't export records twice.
+			shardRecords = nil
+			stream, err := client.ExportAudit(ctx, req)
+			if err != nil {
+				return err
+			}
+			for {
+				resp, err := stream.Recv()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				shardRecords = append(shardRecords, resp.GetRecord())
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, shardRecords...)
+	}
+	// Each shard's records are in order already; interleave them.
+	sort.SliceStable(records, func(i, j int) bool { return records[i].GetTimeMs() < records[j].GetTimeMs() })
+	return records, nil
+}
+
 // Unpublish unpublishes a model.
 func (a *Admin) Unpublish(ctx context.Context, modelID string) error {
 	req := &pb.UnpublishRequest{
 		ModelId: modelID,
+		Force:   forceFrom(ctx),
 	}
-	return a.retry(ctx, func(client pbgrpc.AdminClient) error {
+	return a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
 		var err error
 		_, err = client.Unpublish(ctx, req)
 		return err
@@ -191,7 +538,7 @@ func (a *Admin) List(ctx context.Context, modelID string) (*pb.PublishedModel, e
 		ModelId: modelID,
 	}
 	var res *pb.ListResponse
-	err := a.retry(ctx, func(client pbgrpc.AdminClient) error {
+	err := a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
 		var err error
 		res, err = client.List(ctx, req)
 		return err
@@ -205,28 +552,92 @@ func (a *Admin) List(ctx context.Context, modelID string) (*pb.PublishedModel, e
 	return res.GetPublishedModels()[0], nil
 }
 
-// ListAll lists the status of all published models.
+// Replicas returns the model server addresses of a published model.
+//
+// Results are cached for a short while, so repeated calls don't reach the
+// admin server. A model's cached result is dropped early when FindAddress
+// sees its address set change. FindAddress also routes with the result
+// until the address watch of a model reports its replicas, e.g., on first
+// use.
+func (a *Admin) Replicas(ctx context.Context, modelID string) ([]string, error) {
+	return a.replicas.Get(ctx, modelID)
+}
+
+// ListAll lists the status of all published models, across all admin
+// shards if the cell is sharded.
 func (a *Admin) ListAll(ctx context.Context) (*pb.ListResponse, error) {
+	n, err := addr.NumShards(ctx, a.saxCell)
+	if err != nil {
+		return nil, err
+	}
 	req := &pb.ListRequest{}
-	var res *pb.ListResponse
-	err := a.retry(ctx, func(client pbgrpc.AdminClient) error {
-		var err error
-		res, err = client.List(ctx, req)
-		return err
-	})
+	all := &pb.ListResponse{}
+	for shard := 0; shard < n; shard++ {
+		var res *pb.ListResponse
+		err := a.retryShard(ctx, func() (int, error) { return shard, nil }, func(client pbgrpc.AdminClient) error {
+			var err error
+			res, err = client.List(ctx, req)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		all.PublishedModels = append(all.PublishedModels, res.GetPublishedModels()...)
+	}
+	return all, nil
+}
+
+// UnreadyReplicas returns the model replicas assigned to joined model
+// servers that aren't loaded yet, across all admin shards if the cell is
+// sharded.
+func (a *Admin) UnreadyReplicas(ctx context.Context) ([]*pb.UnreadyReplica, error) {
+	n, err := addr.NumShards(ctx, a.saxCell)
 	if err != nil {
 		return nil, err
 	}
-	return res, nil
+	req := &pb.ServingReadyRequest{}
+	var unready []*pb.UnreadyReplica
+	for shard := 0; shard < n; shard++ {
+		var res *pb.ServingReadyResponse
+		err := a.retryShard(ctx, func() (int, error) { return shard, nil }, func(client pbgrpc.AdminClient) error {
+			var err error
+			res, err = client.ServingReady(ctx, req)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		unready = append(unready, res.GetUnreadyReplicas()...)
+	}
+	return unready, nil
 }
 
-// Stats returns the status of the cell
+// CellServingReady returns whether every model replica assigned to the
+// model servers joined to a cell is loaded, e.g., to gate declaring a
+// deployment healthy, together with the replicas that aren't.
+func CellServingReady(ctx context.Context, saxCell string) (bool, []*pb.UnreadyReplica, error) {
+	unready, err := Open(saxCell).UnreadyReplicas(ctx)
+	if err != nil {
+		return false, nil, err
+	}
+	return len(unready) == 0, unready, nil
+}
+
+// Stats returns the status of the cell, or of the model servers of a
+// model if modelID is not empty. In a sharded cell, the former only covers
+// the model servers of admin shard 0.
 func (a *Admin) Stats(ctx context.Context, modelID string) (*pb.StatsResponse, error) {
 	req := &pb.StatsRequest{
 		ModelId: modelID,
 	}
 	var res *pb.StatsResponse
-	err := a.retry(ctx, func(client pbgrpc.AdminClient) error {
+	shard := func() (int, error) {
+		if modelID == "" {
+			return 0, nil
+		}
+		return a.modelShard(ctx, modelID)
+	}
+	err := a.retryShard(ctx, shard, func(client pbgrpc.AdminClient) error {
 		var err error
 		res, err = client.Stats(ctx, req)
 		return err
@@ -245,11 +656,41 @@ type addrReplica struct {
 	mu  sync.Mutex
 	err error
 
+	// updated is true once Update has applied a result from the admin
+	// server. Until then, the address set may be seeded from the cached
+	// replica set. See seed.
+	updated bool
+
 	// All replica addresses (strings) are hashed uniformly into [0,
 	// uint64max]. These hashes are kept in order in 'hash'.  For each
 	// hash value h in 'hash', addr[h] maps it back to the address.
 	addr map[uint64]string
 	hash *skiplist.T[uint64]
+
+	// zone maps a replica address to the zone it runs in, as reported
+	// by the admin server. Replicas without a known zone are absent.
+	zone map[string]string
+
+	// labels maps a replica address to its server labels, as reported by
+	// the admin server. Replicas without labels are absent.
+	labels map[string]map[string]string
+
+	// breakers tracks failing replicas by address. Replicas whose calls
+	// have all succeeded since they last failed are absent.
+	breakers map[string]*replicaBreaker
+
+	// sessions maps a session ID to the replica its calls stick to. See
+	// PickSession.
+	sessions map[string]*sessionAffinity
+
+	// drains maps a partially drained replica address to the fraction of
+	// its traffic shifted to other replicas, as reported by the admin
+	// server. Replicas not drained are absent.
+	drains map[string]float64
+
+	// onChange, if not nil, is called after the address set changes.
+	// Set before Update is called.
+	onChange func()
 }
 
 func intcmp(a *uint64, b *uint64) (cmp int) {
@@ -265,6 +706,8 @@ func newAddrReplica(model string) *addrReplica {
 	a := &addrReplica{
 		modelID:  model,
 		hashSeed: maphash.MakeSeed(),
+		breakers: make(map[string]*replicaBreaker),
+		sessions: make(map[string]*sessionAffinity),
 	}
 	a.reset(nil)
 	return a
@@ -276,6 +719,9 @@ func (a *addrReplica) reset(addrs []string) {
 	a.err = nil
 	a.addr = make(map[uint64]string)
 	a.hash = skiplist.New[uint64](intcmp)
+	a.zone = make(map[string]string)
+	a.labels = make(map[string]map[string]string)
+	a.drains = make(map[string]float64)
 	if addrs != nil {
 		for _, addr := range addrs {
 			a.addLocked(addr)
@@ -323,9 +769,89 @@ func (a *addrReplica) addLocked(addr string) {
 	}
 }
 
+// needsSeed returns true if the address set is empty because Update
+// hasn't applied any result from the admin server yet.
+func (a *addrReplica) needsSeed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.updated && a.err == nil && a.hash.Count() == 0
+}
+
+// seed adds addrs, e.g., a replica set returned by Admin.Replicas, to
+// the address set, unless Update has applied a result from the admin
+// server since needsSeed was called. It returns true if addrs were
+// added. The first full set Update receives replaces them.
+func (a *addrReplica) seed(addrs []string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.updated || a.err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		a.addLocked(addr)
+	}
+	return len(addrs) > 0
+}
+
+func (a *addrReplica) setZones(zones map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for addr, zone := range zones {
+		a.zone[addr] = zone
+	}
+}
+
+// setDrains records the drained fractions of the replicas at added, as
+// reported along with them. Replicas of added missing from drains are no
+// longer drained.
+func (a *addrReplica) setDrains(added []string, drains map[string]float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, addr := range added {
+		if f, ok := drains[addr]; ok && f > 0 {
+			a.drains[addr] = f
+		} else {
+			delete(a.drains, addr)
+		}
+	}
+}
+
+// skipDrainedLocked returns true if a pick for seed landing on the
+// replica at addr should move on to another replica. A replica with
+// drained fraction f is skipped for a fraction f of all seeds.
+func (a *addrReplica) skipDrainedLocked(seed uint64, addr string) bool {
+	f := a.drains[addr]
+	if f <= 0 {
+		return false
+	}
+	return float64(a.hashAddr(addr, seed)>>11)/(1<<53) < f
+}
+
+func (a *addrReplica) setLabels(labels map[string]map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for addr, l := range labels {
+		a.labels[addr] = l
+	}
+}
+
+// matchesLocked returns true if the replica at addr has every label in selector.
+func (a *addrReplica) matchesLocked(addr string, selector map[string]string) bool {
+	labels := a.labels[addr]
+	for key, value := range selector {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
 func (a *addrReplica) del(addr string) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	delete(a.zone, addr)
+	delete(a.labels, addr)
+	delete(a.breakers, addr)
 	for i := uint64(0); i < numVirtualReplicas; i++ {
 		h := a.hashAddr(addr, i)
 		if a.hash.Remove(&h) {
@@ -334,33 +860,112 @@ func (a *addrReplica) del(addr string) {
 	}
 }
 
+// replicaBreaker is a circuit breaker for calls to one replica. It is
+// closed while the replica has fewer than breakerThreshold consecutive
+// failures. Then it opens, keeping the replica from being picked, until
+// breakerCooldown passes. Then it half-opens, letting one call through:
+// success closes the breaker, and failure opens it again.
+type replicaBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// allows returns true if the replica may be picked at now. A nil
+// breaker is closed.
+func (b *replicaBreaker) allows(now time.Time) bool {
+	return b == nil || b.failures < breakerThreshold || !now.Before(b.openUntil)
+}
+
+// admit records that the replica was picked at now. If the breaker is
+// half-open, it stays open for another cooldown so that only this call
+// retests the replica.
+func (b *replicaBreaker) admit(now time.Time) {
+	if b != nil && b.failures >= breakerThreshold {
+		b.openUntil = now.Add(breakerCooldown)
+	}
+}
+
+// reportResult records the outcome of a call to the replica at addr.
+// Errors that suggest another replica may do better count as failures;
+// other errors mean the replica answered, and count as successes.
+func (a *addrReplica) reportResult(addr string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err == nil || !errors.ServerShouldPoison(err) {
+		delete(a.breakers, addr)
+		return
+	}
+	if !a.knownLocked(addr) {
+		return
+	}
+	b, ok := a.breakers[addr]
+	if !ok {
+		b = &replicaBreaker{}
+		a.breakers[addr] = b
+	}
+	b.failures++
+	if b.failures == breakerThreshold {
+		log.Warningf("Opening the circuit breaker of replica %s of %s after %d consecutive failures: %v", addr, a.modelID, b.failures, err)
+	}
+	if b.failures >= breakerThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+// knownLocked returns true if addr is in the address set.
+func (a *addrReplica) knownLocked(addr string) bool {
+	h := a.hashAddr(addr, 0)
+	return a.addr[h] == addr
+}
+
 // Update updates the set of server addresses according to the
 // incremental updates sent back from the admin server through
 // chanWatchResult.
+//
+// Update returns when the model is not found. Other errors, e.g., the
+// admin server being unavailable, leave the address set as it is, so
+// the model keeps being served by the replicas known so far until
+// the watch recovers.
 func (a *addrReplica) Update(chanWatchResult chan *WatchResult) error {
 	for wr := range chanWatchResult {
 		log.Infof("addrReplica.Update(%s) %v", a.modelID, wr)
 		if wr.Err != nil {
+			if !errors.IsNotFound(wr.Err) {
+				log.Warningf("Serving %s from cached addresses while its watch fails: %v", a.modelID, wr.Err)
+				continue
+			}
 			a.setError(wr.Err)
 			return wr.Err
 		}
+		var added []string
 		if wr.Result.Data != nil {
 			// After a long network partition or the first time using
 			// the model, the client may get a full set from the admin
 			// server. It should happen rarely.
 			log.Infof("Receive a full set for %s: %v", a.modelID, wr)
-			a.reset(wr.Result.Data.ToList())
+			added = wr.Result.Data.ToList()
+			a.reset(added)
 		}
 		for _, m := range wr.Result.Log {
 			switch m.Kind {
 			case watchable.Add:
 				a.add(m.Val)
+				added = append(added, m.Val)
 			case watchable.Del:
 				a.del(m.Val)
 			default:
 				log.Warningf("Unexpected Kind: %v", m.Kind)
 			}
 		}
+		a.setZones(wr.Zones)
+		a.setLabels(wr.Labels)
+		a.setDrains(added, wr.Drains)
+		a.mu.Lock()
+		a.updated = true
+		a.mu.Unlock()
+		if a.onChange != nil && (wr.Result.Data != nil || len(wr.Result.Log) > 0) {
+			a.onChange()
+		}
 	}
 	return nil
 }
@@ -370,6 +975,10 @@ func (a *addrReplica) Update(chanWatchResult chan *WatchResult) error {
 func (a *addrReplica) Pick(seed uint64) (string, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	return a.pickLocked(seed)
+}
+
+func (a *addrReplica) pickLocked(seed uint64) (string, error) {
 	addr, err := "", a.err
 	if err == nil && a.hash.Count() == 0 {
 		err = errors.ErrUnavailable
@@ -388,8 +997,219 @@ func (a *addrReplica) Pick(seed uint64) (string, error) {
 	return addr, err
 }
 
+// PickWeighted is like Pick, but biased toward replicas in zones with
+// larger weights. It first picks a zone with a probability proportional
+// to the zone's weight times the number of replicas in it, and then
+// picks an address in that zone using consistent hashing. Zones missing
+// from weights, including the empty zone of replicas without a known
+// zone, have weight 1. With no weights, or if all present zones have
+// zero weight, it behaves like Pick.
+func (a *addrReplica) PickWeighted(seed uint64, weights map[string]float64) (string, error) {
+	return a.PickSelected(seed, weights, nil)
+}
+
+// PickSelected is like PickWeighted, but only considers replicas whose
+// server labels include every key-value pair in selector. An empty
+// selector matches all replicas. If replicas exist but none matches,
+// it returns a FailedPrecondition error naming the selector.
+//
+// Replicas whose circuit breaker is open (see reportResult) are skipped,
+// unless every replica that could be picked has an open breaker, in
+// which case trying one of them beats failing outright.
+func (a *addrReplica) PickSelected(seed uint64, weights map[string]float64, selector map[string]string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pickHealthyLocked(seed, weights, selector, "")
+}
+
+// pickHealthyLocked implements PickSelected, also skipping the replica
+// at avoid, if not empty, unless no other replica can be picked.
+func (a *addrReplica) pickHealthyLocked(seed uint64, weights map[string]float64, selector map[string]string, avoid string) (string, error) {
+	if (len(a.breakers) > 0 || avoid != "") && a.err == nil && a.hash.Count() > 0 {
+		now := time.Now()
+		addr, err := a.pickSelectedLocked(seed, weights, selector, func(addr string) bool {
+			return addr != avoid && a.breakers[addr].allows(now)
+		})
+		if err == nil {
+			a.breakers[addr].admit(now)
+			return addr, nil
+		}
+	}
+	return a.pickSelectedLocked(seed, weights, selector, nil)
+}
+
+// sessionAffinity pins a session to a replica.
+type sessionAffinity struct {
+	addr     string
+	lastUsed time.Time
+}
+
+// PickSession is like PickSelected, but sticks all calls of a session
+// to one replica for as long as it stays in the address set, matches
+// selector, and has no failed call since its last successful one (see
+// reportResult). Otherwise, the session moves to another replica and
+// sticks to it instead. Affinities unused for sessionTTL are released.
+func (a *addrReplica) PickSession(session string, weights map[string]float64, selector map[string]string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return "", a.err
+	}
+	now := time.Now()
+	s, ok := a.sessions[session]
+	if ok && now.Sub(s.lastUsed) > sessionTTL {
+		delete(a.sessions, session)
+		ok = false
+	}
+	var avoid string
+	if ok {
+		_, failing := a.breakers[s.addr]
+		if !failing && a.knownLocked(s.addr) && a.matchesLocked(s.addr, selector) {
+			s.lastUsed = now
+			return s.addr, nil
+		}
+		if failing {
+			avoid = s.addr
+		}
+	}
+	addr, err := a.pickHealthyLocked(maphash.String(a.hashSeed, session), weights, selector, avoid)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		log.V(1).Infof("Moving session %s of %s from replica %s to %s", session, a.modelID, s.addr, addr)
+		s.addr, s.lastUsed = addr, now
+		return addr, nil
+	}
+	a.releaseSessionsLocked(now)
+	a.sessions[session] = &sessionAffinity{addr: addr, lastUsed: now}
+	return addr, nil
+}
+
+// releaseSessionsLocked releases affinities unused for sessionTTL, and
+// then the least recently used ones until there is room for another.
+func (a *addrReplica) releaseSessionsLocked(now time.Time) {
+	for session, s := range a.sessions {
+		if now.Sub(s.lastUsed) > sessionTTL {
+			delete(a.sessions, session)
+		}
+	}
+	for len(a.sessions) >= maxSessions {
+		var oldest string
+		for session, s := range a.sessions {
+			if oldest == "" || s.lastUsed.Before(a.sessions[oldest].lastUsed) {
+				oldest = session
+			}
+		}
+		delete(a.sessions, oldest)
+	}
+}
+
+// pickSelectedLocked implements PickSelected, only considering replicas
+// accepted by usable if it is not nil.
+func (a *addrReplica) pickSelectedLocked(seed uint64, weights map[string]float64, selector map[string]string, usable func(addr string) bool) (string, error) {
+	eligible := func(addr string) bool {
+		return a.matchesLocked(addr, selector) && (usable == nil || usable(addr))
+	}
+	if (len(weights) == 0 && len(selector) == 0 && usable == nil && len(a.drains) == 0) || a.err != nil || a.hash.Count() == 0 {
+		return a.pickLocked(seed)
+	}
+	// Weigh each zone by the number of matching replicas in it.
+	zoneWeight := make(map[string]float64)
+	seen := make(map[string]bool)
+	matched := false
+	for _, addr := range a.addr {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		if !eligible(addr) {
+			continue
+		}
+		matched = true
+		zone := a.zone[addr]
+		w, ok := weights[zone]
+		if !ok {
+			w = 1
+		}
+		if w > 0 {
+			zoneWeight[zone] += w
+		}
+	}
+	if !matched {
+		return "", fmt.Errorf("no replica of %s matches label selector %v: %w", a.modelID, selector, errors.ErrFailedPrecondition)
+	}
+	var zones []string
+	var total float64
+	for zone, w := range zoneWeight {
+		zones = append(zones, zone)
+		total += w
+	}
+	if total == 0 {
+		return a.walkUndrainedLocked(seed, eligible)
+	}
+	sort.Strings(zones)
+
+	// Use a hash independent of the one used for the ring position to
+	// pick the zone.
+	x := float64(a.hashUint64(^seed)>>11) / (1 << 53) * total
+	picked := zones[len(zones)-1]
+	for _, zone := range zones {
+		if x < zoneWeight[zone] {
+			picked = zone
+			break
+		}
+		x -= zoneWeight[zone]
+	}
+
+	addr, err := a.walkUndrainedLocked(seed, func(addr string) bool {
+		return a.zone[addr] == picked && eligible(addr)
+	})
+	if err != nil {
+		return "", fmt.Errorf("no replica found in zone %q: %w", picked, err)
+	}
+	return addr, nil
+}
+
+// walkLocked walks the ring from the seed's position to the first
+// address accepted by accept.
+func (a *addrReplica) walkLocked(seed uint64, accept func(addr string) bool) (string, error) {
+	h := a.hashUint64(seed)
+	it := a.hash.LowerBound(&h)
+	for i := 0; i < a.hash.Count(); i++ {
+		if it.IsNil() {
+			it = a.hash.First()
+		}
+		if addr := a.addr[*it.Value()]; accept(addr) {
+			return addr, nil
+		}
+		it = it.Next()
+	}
+	return "", errors.ErrUnavailable
+}
+
+// walkUndrainedLocked is like walkLocked, but moves past partially
+// drained replicas as skipDrainedLocked says, so their traffic spreads
+// over the replicas that follow them on the ring. If every accepted
+// replica is skipped, it falls back to walkLocked.
+func (a *addrReplica) walkUndrainedLocked(seed uint64, accept func(addr string) bool) (string, error) {
+	if len(a.drains) > 0 {
+		addr, err := a.walkLocked(seed, func(addr string) bool {
+			return accept(addr) && !a.skipDrainedLocked(seed, addr)
+		})
+		if err == nil {
+			return addr, nil
+		}
+	}
+	return a.walkLocked(seed, accept)
+}
+
 // FindAddress queries the local replica of the server address set to
-// get one server address randomly. Seed specifies the random seed.
+// get one server address randomly. Seed specifies the random seed. If
+// ctx carries a label selector (see WithLabelSelector), only replicas
+// matching it are considered. If ctx carries a session ID (see
+// WithSession), the seed is ignored and calls of the session stick to
+// one replica.
 func (a *Admin) FindAddress(ctx context.Context, model string, seed uint64) (string, error) {
 	a.mu.Lock()
 	ar, ok := a.addrs[model]
@@ -397,6 +1217,7 @@ func (a *Admin) FindAddress(ctx context.Context, model string, seed uint64) (str
 		// First time to access the model, setup the addrReplica and
 		// arrange a background go routine to keep it updated.
 		ar = newAddrReplica(model)
+		ar.onChange = func() { a.replicas.Invalidate(model) }
 		a.addrs[model] = ar
 		chanWatchResult := make(chan *WatchResult)
 		go a.WatchAddresses(context.Background(), model, chanWatchResult)
@@ -418,9 +1239,157 @@ func (a *Admin) FindAddress(ctx context.Context, model string, seed uint64) (str
 			})
 		}()
 	}
+	weights := a.zoneWeights
+	a.mu.Unlock()
+
+	pick := func() (string, error) {
+		if session := sessionFrom(ctx); session != "" {
+			return ar.PickSession(session, weights, labelSelectorFrom(ctx))
+		}
+		return ar.PickSelected(seed, weights, labelSelectorFrom(ctx))
+	}
+	addr, err := pick()
+	if err != nil && ar.needsSeed() {
+		// The address watch hasn't reported the model's replicas yet, e.g.,
+		// on first use. Route with its cached replica set meanwhile.
+		replicas, rerr := a.replicas.Get(ctx, model)
+		if rerr != nil {
+			log.V(1).Infof("Replicas(%s) error while waiting for its address watch: %v", model, rerr)
+			return addr, err
+		}
+		if ar.seed(replicas) {
+			return pick()
+		}
+	}
+	return addr, err
+}
+
+type labelSelectorKey struct{}
+
+// WithLabelSelector returns a copy of ctx that restricts FindAddress to
+// replicas whose model servers carry a "<key>=<value>" tag for every
+// entry in selector. An empty selector removes the restriction.
+func WithLabelSelector(ctx context.Context, selector map[string]string) context.Context {
+	copied := make(map[string]string, len(selector))
+	for key, value := range selector {
+		copied[key] = value
+	}
+	return context.WithValue(ctx, labelSelectorKey{}, copied)
+}
+
+func labelSelectorFrom(ctx context.Context) map[string]string {
+	selector, _ := ctx.Value(labelSelectorKey{}).(map[string]string)
+	return selector
+}
+
+type forceKey struct{}
+
+// WithForce returns a copy of ctx that lets disruptive admin operations,
+// e.g., Unpublish or lowering the number of replicas with Update, run
+// during blackout windows of the cell.
+func WithForce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey{}, true)
+}
+
+func forceFrom(ctx context.Context) bool {
+	force, _ := ctx.Value(forceKey{}).(bool)
+	return force
+}
+
+type sessionKey struct{}
+
+// WithSession returns a copy of ctx that makes FindAddress keep
+// returning the same replica for all calls carrying the same session
+// ID, e.g., to reach server-side session state, until that replica
+// fails or goes away. An empty ID removes the affinity.
+func WithSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+func sessionFrom(ctx context.Context) string {
+	session, _ := ctx.Value(sessionKey{}).(string)
+	return session
+}
+
+// ReportResult tells the client how a call to the replica of model at
+// addr went, feeding the replica's circuit breaker. FindAddress routes
+// around replicas whose calls keep failing, and retests them after a
+// cooldown.
+func (a *Admin) ReportResult(model, addr string, err error) {
+	a.mu.Lock()
+	ar, ok := a.addrs[model]
 	a.mu.Unlock()
+	if ok {
+		ar.reportResult(addr, err)
+	}
+}
+
+// SetZoneWeights biases FindAddress toward replicas in some zones, e.g.,
+// to keep traffic in the client's own zone while still spilling over to
+// other zones. A zone with weight w gets traffic proportional to w times
+// the number of replicas in it. Zones not in weights have weight 1, and
+// zones with weight 0 get no traffic unless every zone has weight 0.
+// Zones come from "zone=<name>" tags of model servers. Passing nil
+// removes the bias.
+func (a *Admin) SetZoneWeights(weights map[string]float64) {
+	copied := make(map[string]float64, len(weights))
+	for zone, w := range weights {
+		copied[zone] = w
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.zoneWeights = copied
+}
 
-	return ar.Pick(seed)
+// DrainServer shifts a fraction of the traffic of the model server at
+// addr to other replicas, for every model it serves, e.g., for load
+// testing or gradual maintenance. With fraction f, clients pick the
+// server 1-f times as often as before. Fraction 1 drains the server
+// fully, unless no other replica can serve a call, and fraction 0
+// restores its full share. The drain is kept by the admin server, and
+// reaches every client watching the server's models.
+func (a *Admin) DrainServer(ctx context.Context, addr string, fraction float64) error {
+	if fraction < 0 || fraction > 1 || math.IsNaN(fraction) {
+		return fmt.Errorf("drained fraction %v of %s not in [0, 1]: %w", fraction, addr, errors.ErrInvalidArgument)
+	}
+	req := &pb.DrainServerRequest{Address: addr, Fraction: float32(fraction)}
+	// Model servers join the admin shard their address maps to, like models do.
+	shard := func() (int, error) { return a.modelShard(ctx, addr) }
+	return a.retryShard(ctx, shard, func(client pbgrpc.AdminClient) error {
+		_, err := client.DrainServer(ctx, req)
+		return err
+	})
+}
+
+// EvacuateLabel moves every model off the model servers tagged
+// "<key>=<value>", e.g., key "zone" for zonal maintenance, and returns once
+// every admin shard has drained its servers. Models keep as many loaded
+// replicas as they had while their servers drain. Evacuations are refused
+// during blackout windows of the cell, unless ctx carries WithForce.
+func (a *Admin) EvacuateLabel(ctx context.Context, key, value string) error {
+	n, err := addr.NumShards(ctx, a.saxCell)
+	if err != nil {
+		return err
+	}
+	req := &pb.EvacuateLabelRequest{Key: key, Value: value, Force: forceFrom(ctx)}
+	// Model servers join the shards their addresses map to, so any shard may have labeled ones.
+	notFound := 0
+	for shard := 0; shard < n; shard++ {
+		err := a.retryShard(ctx, func() (int, error) { return shard, nil }, func(client pbgrpc.AdminClient) error {
+			_, err := client.EvacuateLabel(ctx, req)
+			return err
+		})
+		switch {
+		case errors.IsNotFound(err):
+			notFound++
+		case err != nil:
+			return err
+		}
+	}
+	if notFound == n {
+		return fmt.Errorf("no model server labeled %s=%s to evacuate: %w", key, value, errors.ErrNotFound)
+	}
+	return nil
 }
 
 // WatchResult encapsulates the changes to the server addresses for a
@@ -430,14 +1399,101 @@ type WatchResult struct {
 	Err error
 	// Result represents the changes to the server addresses if Err is nil.
 	Result *watchable.WatchResult
+	// Zones maps server addresses added by Result to their zones.
+	Zones map[string]string
+	// Labels maps server addresses added by Result to their server labels.
+	Labels map[string]map[string]string
+	// AssignedMs maps server addresses added by Result to when they were
+	// assigned the model, in milliseconds since Unix epoch.
+	AssignedMs map[string]int64
+	// Drains maps server addresses added by Result to the fraction of
+	// their traffic shifted away. Servers not drained are absent.
+	Drains map[string]float64
+}
+
+func newWatchResult(resp *pb.WatchLocResponse) *WatchResult {
+	labels := make(map[string]map[string]string, len(resp.GetLabels()))
+	for addr, l := range resp.GetLabels() {
+		labels[addr] = l.GetLabels()
+	}
+	drains := make(map[string]float64, len(resp.GetDrains()))
+	for addr, f := range resp.GetDrains() {
+		drains[addr] = float64(f)
+	}
+	return &WatchResult{
+		Result:     watchable.FromProto(resp.GetResult()),
+		Zones:      resp.GetZones(),
+		Labels:     labels,
+		AssignedMs: resp.GetAssignedMs(),
+		Drains:     drains,
+	}
+}
+
+// reconnectBackoff computes the delay before re-establishing a watch
+// after an unexpected error. Delays double on each consecutive failure
+// up to maxDelay, and are jittered so that many clients disconnected
+// by the same admin restart do not reconnect in lockstep.
+type reconnectBackoff struct {
+	minDelay time.Duration
+	maxDelay time.Duration
+	// current is the un-jittered delay to use for the next failure. It
+	// is zero until the first failure after a reset.
+	current time.Duration
+	// jitter returns a random value in [0, n).
+	jitter func(n int64) int64
+}
+
+func newReconnectBackoff() *reconnectBackoff {
+	return &reconnectBackoff{
+		minDelay: minReconnectDelay,
+		maxDelay: maxReconnectDelay,
+		jitter:   rand.Int63n,
+	}
+}
+
+// Next returns the delay to wait before the next reconnection attempt.
+// The returned value is uniformly distributed in [d/2, d], where d is the
+// current capped exponential delay.
+func (b *reconnectBackoff) Next() time.Duration {
+	if b.current == 0 {
+		b.current = b.minDelay
+	} else {
+		b.current *= 2
+	}
+	if b.current > b.maxDelay {
+		b.current = b.maxDelay
+	}
+	half := b.current / 2
+	return half + time.Duration(b.jitter(int64(b.current-half)+1))
+}
+
+// Reset is called after a successful call so that the next failure
+// starts again from the minimum delay.
+func (b *reconnectBackoff) Reset() {
+	b.current = 0
 }
 
 // WatchAddresses replicates the changes to the model's server addresses.
 //
 // The caller of WatchAddresses() receives all the changes though the
 // chanWatchResult.  WatchAddresses intentionally never stops until
-// the model is unpublished.
+// the model is unpublished or ctx is done. Every failed WatchLoc call,
+// e.g. during an admin server outage, is reported on chanWatchResult
+// and retried after a growing delay.
 func (a *Admin) WatchAddresses(ctx context.Context, model string, chanWatchResult chan *WatchResult) {
+	watchLoc := func(req *pb.WatchLocRequest) (*pb.WatchLocResponse, error) {
+		var resp *pb.WatchLocResponse
+		err := a.tryShard(ctx, func() (int, error) { return a.modelShard(ctx, model) }, func(client pbgrpc.AdminClient) error {
+			var err error
+			resp, err = client.WatchLoc(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+	watchAddresses(ctx, model, chanWatchResult, watchLoc, newReconnectBackoff())
+}
+
+func watchAddresses(ctx context.Context, model string, chanWatchResult chan *WatchResult, watchLoc func(req *pb.WatchLocRequest) (*pb.WatchLocResponse, error), backoff *reconnectBackoff) {
 	var serverID string
 	var seqno int32
 	for {
@@ -446,27 +1502,62 @@ func (a *Admin) WatchAddresses(ctx context.Context, model string, chanWatchResul
 			AdminServerId: serverID,
 			Seqno:         seqno,
 		}
-		var resp *pb.WatchLocResponse
-		err := a.retry(ctx, func(client pbgrpc.AdminClient) error {
-			var err error
-			resp, err = client.WatchLoc(ctx, req)
-			return err
-		})
+		resp, err := watchLoc(req)
 		if err != nil {
 			chanWatchResult <- &WatchResult{Err: err}
 			if errors.IsNotFound(err) {
 				return
 			}
-			// For other errors, we reset the process.
-			log.Errorf("Unexpected WatchLoc rpc call error: %v", err)
-			serverID, seqno = "", 0
-			time.Sleep(time.Second)
+			if errors.AdminShouldRetry(err) {
+				log.Warningf("WatchLoc rpc call error, retrying: %v", err)
+			} else {
+				// For other errors, we reset the process.
+				log.Errorf("Unexpected WatchLoc rpc call error: %v", err)
+				serverID, seqno = "", 0
+			}
+			timer := time.NewTimer(backoff.Next())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
 			continue
 		}
+		backoff.Reset()
 		serverID = resp.GetAdminServerId()
-		w := watchable.FromProto(resp.GetResult())
-		chanWatchResult <- &WatchResult{Result: w}
-		seqno = w.Next
+		wr := newWatchResult(resp)
+		chanWatchResult <- wr
+		seqno = wr.Result.Next
+	}
+}
+
+// WatchModel streams the changes to a model's server addresses to
+// chanWatchResult: first the full set, then each change as it happens.
+// Unlike WatchAddresses, it holds a single stream open and doesn't
+// reconnect: it returns when ctx is done or the stream fails, e.g. with a
+// NotFound error once the model is unpublished.
+func (a *Admin) WatchModel(ctx context.Context, modelID string, chanWatchResult chan<- *WatchResult) error {
+	req := &pb.WatchModelRequest{ModelId: modelID}
+	var stream pbgrpc.Admin_WatchModelClient
+	err := a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
+		var err error
+		stream, err = client.WatchModel(ctx, req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		select {
+		case chanWatchResult <- newWatchResult(resp):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
 
@@ -476,13 +1567,81 @@ func (a *Admin) WaitForReady(ctx context.Context, modelID string, numReplicas in
 		ModelId:     modelID,
 		NumReplicas: int32(numReplicas),
 	}
-	err := a.retry(ctx, func(client pbgrpc.AdminClient) error {
+	err := a.retryModel(ctx, modelID, func(client pbgrpc.AdminClient) error {
 		_, err := client.WaitForReady(ctx, req)
 		return err
 	})
 	return err
 }
 
+// cachedReplicas is a replica set fetched at some point in time, or a
+// marker of when the replica set was invalidated.
+type cachedReplicas struct {
+	addrs       []string
+	fetched     time.Time
+	invalidated bool
+}
+
+// replicaCache is a read-through cache of model replica sets.
+type replicaCache struct {
+	ttl   time.Duration
+	fetch func(ctx context.Context, modelID string) ([]string, error)
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cachedReplicas
+}
+
+func newReplicaCache(ttl time.Duration, fetch func(ctx context.Context, modelID string) ([]string, error)) *replicaCache {
+	return &replicaCache{
+		ttl:     ttl,
+		fetch:   fetch,
+		now:     time.Now,
+		entries: make(map[string]*cachedReplicas),
+	}
+}
+
+// Get returns the replica set of a model, fetching it on a cache miss or
+// after the cached entry expires. Errors are not cached.
+//
+// If the fetch fails for any reason other than the model not being
+// found, e.g., during an admin server outage, Get returns the expired
+// replica set, if any.
+func (c *replicaCache) Get(ctx context.Context, modelID string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[modelID]
+	if ok && !entry.invalidated && c.now().Sub(entry.fetched) < c.ttl {
+		c.mu.Unlock()
+		return append([]string(nil), entry.addrs...), nil
+	}
+	c.mu.Unlock()
+
+	fetched := c.now()
+	addrs, err := c.fetch(ctx, modelID)
+	if err != nil {
+		if ok && !entry.invalidated && !errors.IsNotFound(err) {
+			log.Warningf("Using replicas of %s fetched at %v: %v", modelID, entry.fetched, err)
+			return append([]string(nil), entry.addrs...), nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	// Don't overwrite a result fetched, or an invalidation that happened,
+	// after this fetch started.
+	if entry, ok := c.entries[modelID]; !ok || entry.fetched.Before(fetched) {
+		c.entries[modelID] = &cachedReplicas{addrs: addrs, fetched: fetched}
+	}
+	c.mu.Unlock()
+	return append([]string(nil), addrs...), nil
+}
+
+// Invalidate drops the cached replica set of a model, if any.
+func (c *replicaCache) Invalidate(modelID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[modelID] = &cachedReplicas{fetched: c.now(), invalidated: true}
+}
+
 type openedAdmin struct {
 	mu     sync.Mutex
 	admins map[string]*Admin
@@ -496,8 +1655,16 @@ func (o *openedAdmin) Get(saxCell string) *Admin {
 	}
 	ret := &Admin{
 		saxCell: saxCell,
+		shards:  make(map[int]*adminConn),
 		addrs:   make(map[string]*addrReplica),
 	}
+	ret.replicas = newReplicaCache(replicaCacheTTL, func(ctx context.Context, modelID string) ([]string, error) {
+		published, err := ret.List(ctx, modelID)
+		if err != nil {
+			return nil, err
+		}
+		return published.GetModeletAddresses(), nil
+	})
 	o.admins[saxCell] = ret
 	return ret
 }
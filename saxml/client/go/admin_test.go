@@ -15,11 +15,25 @@
 package saxadmin
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"math"
+	"math/rand"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"saxml/common/blob"
 	"saxml/common/errors"
+	"saxml/common/platform/env"
+	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/testutil"
+	"saxml/common/watchable"
+
+	pb "saxml/protobuf/admin_go_proto_grpc"
 )
 
 func TestEmpty(t *testing.T) {
@@ -109,3 +123,703 @@ func TestLoadBalancing(t *testing.T) {
 		t.Errorf("Too big varaince of the load")
 	}
 }
+
+func TestReconnectBackoff(t *testing.T) {
+	b := newReconnectBackoff()
+	// Always pick the upper end so the delays are deterministic.
+	b.jitter = func(n int64) int64 { return n - 1 }
+
+	var got []time.Duration
+	for i := 0; i < 12; i++ {
+		got = append(got, b.Next())
+	}
+	if got[0] != minReconnectDelay {
+		t.Errorf("Next() = %v for the first failure, want %v", got[0], minReconnectDelay)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			t.Errorf("Next() = %v after %v, want non-decreasing delays", got[i], got[i-1])
+		}
+		if got[i] > maxReconnectDelay {
+			t.Errorf("Next() = %v, want at most %v", got[i], maxReconnectDelay)
+		}
+	}
+	if last := got[len(got)-1]; last != maxReconnectDelay {
+		t.Errorf("Next() = %v after many failures, want %v", last, maxReconnectDelay)
+	}
+
+	b.Reset()
+	if d := b.Next(); d != minReconnectDelay {
+		t.Errorf("Next() = %v after Reset(), want %v", d, minReconnectDelay)
+	}
+}
+
+func TestReconnectBackoffJitter(t *testing.T) {
+	b := newReconnectBackoff()
+	for i := 0; i < 100; i++ {
+		d := b.Next()
+		if d < b.current/2 || d > b.current {
+			t.Errorf("Next() = %v, want in [%v, %v]", d, b.current/2, b.current)
+		}
+	}
+}
+
+func TestWatchAddressesDuringAdminOutage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The admin server answers the first call, fails the next outage calls, and answers again.
+	const outage = 5
+	type call struct {
+		at  time.Time
+		req *pb.WatchLocRequest
+	}
+	calls := make(chan call, 100)
+	n := 0
+	watchLoc := func(req *pb.WatchLocRequest) (*pb.WatchLocResponse, error) {
+		calls <- call{time.Now(), req}
+		n++
+		switch {
+		case n == 1 || n == outage+2:
+			return &pb.WatchLocResponse{AdminServerId: "admin", Result: &pb.WatchResult{NextSeqno: int32(n)}}, nil
+		case n <= outage+1:
+			return nil, errors.ErrUnavailable
+		default:
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+	}
+	b := newReconnectBackoff()
+	b.minDelay, b.maxDelay = 20*time.Millisecond, 80*time.Millisecond
+	// Always pick the lower end, so delays are half of 20, 40, 80, 80, ... ms.
+	b.jitter = func(int64) int64 { return 0 }
+	results := make(chan *WatchResult)
+	done := make(chan struct{})
+	go func() {
+		watchAddresses(ctx, "/sax/test/model", results, watchLoc, b)
+		close(done)
+	}()
+
+	if wr := <-results; wr.Err != nil {
+		t.Fatalf("WatchAddresses() error %v, want a result", wr.Err)
+	}
+	// Every failed call during the outage is reported, and retried after a growing delay from the
+	// same position in the watch.
+	last := <-calls
+	for i, want := range []time.Duration{0, 10, 20, 40, 40} {
+		if wr := <-results; errors.Code(wr.Err) != codes.Unavailable {
+			t.Fatalf("WatchAddresses() during the outage error %v, want %v", wr.Err, errors.ErrUnavailable)
+		}
+		c := <-calls
+		if gap := c.at.Sub(last.at); gap < want*time.Millisecond {
+			t.Errorf("WatchLoc call %d came %v after the previous one, want at least %v", i+2, gap, want*time.Millisecond)
+		}
+		if c.req.GetAdminServerId() != "admin" || c.req.GetSeqno() != 1 {
+			t.Errorf("WatchLoc call %d request = %v, want admin server ID admin and seqno 1", i+2, c.req)
+		}
+		last = c
+	}
+	// The watch resumes once the admin server is back.
+	if wr := <-results; wr.Err != nil {
+		t.Fatalf("WatchAddresses() after the outage error %v, want a result", wr.Err)
+	}
+	if c := <-calls; c.at.Sub(last.at) < 40*time.Millisecond {
+		t.Errorf("WatchLoc call after the outage came %v after the previous one, want at least 40ms", c.at.Sub(last.at))
+	}
+
+	// Waiting on the admin server stops with ctx.
+	cancel()
+	<-results
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchAddresses() didn't return after its context was cancelled")
+	}
+}
+
+func TestZoneWeights(t *testing.T) {
+	zones := map[string]string{}
+	var addrs []string
+	for i := 0; i < 8; i++ {
+		addr := fmt.Sprintf("%08d", i)
+		addrs = append(addrs, addr)
+		if i < 4 {
+			zones[addr] = "in"
+		} else {
+			zones[addr] = "out"
+		}
+	}
+
+	tests := []struct {
+		desc    string
+		weights map[string]float64
+		wantIn  float64
+	}{
+		{"no weights", nil, 0.5},
+		{"in-zone preferred", map[string]float64{"in": 3}, 0.75},
+		{"in-zone only", map[string]float64{"in": 1, "out": 0}, 1},
+		{"all zero", map[string]float64{"in": 0, "out": 0}, 0.5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			// Simulates many clients, each picking a few replicas.
+			n, l := 4096, 4
+			in := 0
+			for i := 0; i < n; i++ {
+				ar := newAddrReplica("/sax/foo/bar")
+				ar.reset(addrs)
+				ar.setZones(zones)
+				for j := 0; j < l; j++ {
+					addr, err := ar.PickWeighted(uint64(j), tc.weights)
+					if err != nil {
+						t.Fatalf("PickWeighted(%d, %v) error %v", j, tc.weights, err)
+					}
+					if zones[addr] == "in" {
+						in++
+					}
+				}
+			}
+			got := float64(in) / float64(n*l)
+			if math.Abs(got-tc.wantIn) > 0.03 {
+				t.Errorf("In-zone share = %.3f, want %.3f", got, tc.wantIn)
+			}
+		})
+	}
+}
+
+func TestZoneWeightsSpill(t *testing.T) {
+	ar := newAddrReplica("/sax/foo/bar")
+	ar.reset([]string{"a", "b"})
+	ar.setZones(map[string]string{"a": "out", "b": "out"})
+	weights := map[string]float64{"in": 100}
+	// Without in-zone replicas, all traffic spills to the other zone.
+	for seed := uint64(0); seed < 16; seed++ {
+		if _, err := ar.PickWeighted(seed, weights); err != nil {
+			t.Errorf("PickWeighted(%d, %v) error %v, want no error", seed, weights, err)
+		}
+	}
+
+	// Once an in-zone replica shows up, it gets almost all the traffic.
+	ar.add("c")
+	ar.setZones(map[string]string{"c": "in"})
+	counts := map[string]int{}
+	for seed := uint64(0); seed < 1000; seed++ {
+		addr, err := ar.PickWeighted(seed, weights)
+		if err != nil {
+			t.Fatalf("PickWeighted(%d, %v) error %v, want no error", seed, weights, err)
+		}
+		counts[addr]++
+	}
+	if counts["c"] < 950 {
+		t.Errorf("In-zone replica picked %d out of 1000 times, want at least 950", counts["c"])
+	}
+
+	// Deleting a replica forgets its zone.
+	ar.del("c")
+	if _, ok := ar.zone["c"]; ok {
+		t.Errorf("Zone of deleted replica c is still known")
+	}
+}
+
+func TestDrainServer(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 8; i++ {
+		addrs = append(addrs, fmt.Sprintf("%08d", i))
+	}
+	drained := addrs[0]
+	// share simulates many clients, each picking a few replicas, and returns the drained server's
+	// share of the picks.
+	share := func(drains map[string]float64) float64 {
+		t.Helper()
+		n, l := 4096, 4
+		picked := 0
+		for i := 0; i < n; i++ {
+			ar := newAddrReplica("/sax/foo/bar")
+			ar.reset(addrs)
+			ar.setDrains(addrs, drains)
+			for j := 0; j < l; j++ {
+				addr, err := ar.PickSelected(uint64(j), nil, nil)
+				if err != nil {
+					t.Fatalf("PickSelected(%d) with drains %v error %v", j, drains, err)
+				}
+				if addr == drained {
+					picked++
+				}
+			}
+		}
+		return float64(picked) / float64(n*l)
+	}
+
+	before := share(nil)
+	if got := share(map[string]float64{drained: 0.5}); math.Abs(got/before-0.5) > 0.1 {
+		t.Errorf("Share of a 50%%-drained server = %.3f, want about half of %.3f", got, before)
+	}
+	if got := share(map[string]float64{drained: 1}); got != 0 {
+		t.Errorf("Share of a fully drained server = %.3f, want 0", got)
+	}
+
+	// A fully drained server still serves if no other replica can.
+	ar := newAddrReplica("/sax/foo/bar")
+	ar.reset([]string{drained})
+	ar.setDrains([]string{drained}, map[string]float64{drained: 1})
+	if addr, err := ar.PickSelected(0, nil, nil); err != nil || addr != drained {
+		t.Errorf("PickSelected() with only a drained replica = (%s, %v), want (%s, nil)", addr, err, drained)
+	}
+
+	// Drains come from the admin server along with added replicas. A replica added again without
+	// one is no longer drained.
+	ar = newAddrReplica("/sax/foo/bar")
+	ch := make(chan *WatchResult)
+	done := make(chan error)
+	go func() { done <- ar.Update(ch) }()
+	data := watchable.NewDataSet()
+	for _, addr := range addrs {
+		data.Add(addr)
+	}
+	ch <- &WatchResult{Result: &watchable.WatchResult{Data: data}, Drains: map[string]float64{drained: 0.25}}
+	ch <- &WatchResult{Result: &watchable.WatchResult{}} // Waits for the full set to be applied.
+	ar.mu.Lock()
+	got := ar.drains[drained]
+	ar.mu.Unlock()
+	if got != 0.25 {
+		t.Errorf("Drained fraction of %s = %v, want 0.25", drained, got)
+	}
+	ch <- &WatchResult{Result: &watchable.WatchResult{Log: watchable.ChangeLog{
+		{Kind: watchable.Del, Val: drained},
+		{Kind: watchable.Add, Val: drained},
+	}}}
+	ch <- &WatchResult{Err: errors.ErrNotFound}
+	<-done
+	if len(ar.drains) != 0 {
+		t.Errorf("Drains after restoring %s = %v, want none", drained, ar.drains)
+	}
+
+	a := &Admin{}
+	if err := a.DrainServer(context.Background(), drained, 1.5); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("DrainServer(%s, 1.5) error %v, want %v", drained, err, errors.ErrInvalidArgument)
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	ar := newAddrReplica("/sax/foo/bar")
+	ar.reset([]string{"a", "b", "c", "d"})
+	ar.setZones(map[string]string{"a": "in", "b": "in", "c": "out"})
+	ar.setLabels(map[string]map[string]string{
+		"a": {"pool": "canary", "tier": "gold"},
+		"b": {"pool": "prod"},
+		"c": {"pool": "canary"},
+	})
+
+	tests := []struct {
+		desc     string
+		weights  map[string]float64
+		selector map[string]string
+		want     map[string]bool
+	}{
+		{"no selector", nil, nil, map[string]bool{"a": true, "b": true, "c": true, "d": true}},
+		{"one label", nil, map[string]string{"pool": "canary"}, map[string]bool{"a": true, "c": true}},
+		{"two labels", nil, map[string]string{"pool": "canary", "tier": "gold"}, map[string]bool{"a": true}},
+		{"with zone weights", map[string]float64{"in": 0}, map[string]string{"pool": "canary"}, map[string]bool{"c": true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := map[string]bool{}
+			for seed := uint64(0); seed < 256; seed++ {
+				addr, err := ar.PickSelected(seed, tc.weights, tc.selector)
+				if err != nil {
+					t.Fatalf("PickSelected(%d, %v, %v) error %v, want no error", seed, tc.weights, tc.selector, err)
+				}
+				got[addr] = true
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Picked replicas mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	selector := map[string]string{"pool": "staging"}
+	_, err := ar.PickSelected(0, nil, selector)
+	if errors.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("PickSelected(0, nil, %v) error %v, want FailedPrecondition", selector, err)
+	}
+	if !strings.Contains(err.Error(), "staging") {
+		t.Errorf("PickSelected(0, nil, %v) error %q does not name the selector", selector, err)
+	}
+
+	// Deleting a replica forgets its labels.
+	ar.del("a")
+	if _, ok := ar.labels["a"]; ok {
+		t.Errorf("Labels of deleted replica a are still known")
+	}
+}
+
+func TestWithLabelSelector(t *testing.T) {
+	selector := map[string]string{"pool": "canary"}
+	ctx := WithLabelSelector(context.Background(), selector)
+	selector["pool"] = "prod"
+	if diff := cmp.Diff(map[string]string{"pool": "canary"}, labelSelectorFrom(ctx)); diff != "" {
+		t.Errorf("labelSelectorFrom() mismatch (-want +got):\n%s", diff)
+	}
+	if got := labelSelectorFrom(context.Background()); len(got) != 0 {
+		t.Errorf("labelSelectorFrom(background) = %v, want empty", got)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	defer func(threshold int, cooldown time.Duration) {
+		breakerThreshold, breakerCooldown = threshold, cooldown
+	}(breakerThreshold, breakerCooldown)
+	breakerThreshold, breakerCooldown = 3, 50*time.Millisecond
+
+	ar := newAddrReplica("/sax/foo/bar")
+	ar.reset([]string{"a", "b", "c"})
+	picked := func() map[string]int {
+		counts := map[string]int{}
+		for seed := uint64(0); seed < 256; seed++ {
+			addr, err := ar.PickSelected(seed, nil, nil)
+			if err != nil {
+				t.Fatalf("PickSelected(%d) error %v, want no error", seed, err)
+			}
+			counts[addr]++
+		}
+		return counts
+	}
+	fail := func(addr string) {
+		for i := 0; i < breakerThreshold; i++ {
+			ar.reportResult(addr, errors.ErrUnavailable)
+		}
+	}
+
+	// Failures short of the threshold, or that the replica can't help, don't trip the breaker.
+	ar.reportResult("a", errors.ErrUnavailable)
+	ar.reportResult("a", errors.ErrInvalidArgument)
+	ar.reportResult("a", errors.ErrUnavailable)
+	if counts := picked(); counts["a"] == 0 {
+		t.Fatalf("Replica a skipped before its breaker trips: %v", counts)
+	}
+
+	fail("a")
+	if counts := picked(); counts["a"] != 0 {
+		t.Errorf("Replica a picked %d times with an open breaker, want 0", counts["a"])
+	}
+
+	// After the cooldown, a single call retests the replica. Failing it opens the breaker again.
+	time.Sleep(2 * breakerCooldown)
+	if counts := picked(); counts["a"] != 1 {
+		t.Errorf("Replica a picked %d times with a half-open breaker, want 1", counts["a"])
+	}
+	ar.reportResult("a", errors.ErrUnavailable)
+	if counts := picked(); counts["a"] != 0 {
+		t.Errorf("Replica a picked %d times after failing its retest, want 0", counts["a"])
+	}
+
+	// Once the replica recovers, it is picked again.
+	time.Sleep(2 * breakerCooldown)
+	picked()
+	ar.reportResult("a", nil)
+	if counts := picked(); counts["a"] == 0 {
+		t.Errorf("Replica a skipped after recovering: %v", counts)
+	}
+
+	// With every breaker open, replicas are still picked.
+	fail("a")
+	fail("b")
+	fail("c")
+	if counts := picked(); len(counts) == 0 {
+		t.Errorf("No replica picked with every breaker open")
+	}
+}
+
+func TestSessionAffinity(t *testing.T) {
+	ar := newAddrReplica("/sax/foo/bar")
+	ar.reset([]string{"a", "b", "c"})
+	pick := func(session string) string {
+		t.Helper()
+		addr, err := ar.PickSession(session, nil, nil)
+		if err != nil {
+			t.Fatalf("PickSession(%s) error %v, want no error", session, err)
+		}
+		return addr
+	}
+
+	// Repeated calls of a session hit the same replica, and sessions spread over replicas.
+	used := map[string]bool{}
+	for i := 0; i < 32; i++ {
+		session := fmt.Sprintf("s%d", i)
+		pinned := pick(session)
+		for j := 0; j < 8; j++ {
+			if addr := pick(session); addr != pinned {
+				t.Fatalf("PickSession(%s) = %s after %s, want the same replica", session, addr, pinned)
+			}
+		}
+		used[pinned] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("Sessions all stick to replicas %v, want them spread", used)
+	}
+
+	// A failure moves the session, and it stays on the new replica after the old one recovers.
+	pinned := pick("s0")
+	ar.reportResult(pinned, errors.ErrUnavailable)
+	moved := pick("s0")
+	if moved == pinned {
+		t.Fatalf("PickSession(s0) = %s after it failed, want another replica", moved)
+	}
+	ar.reportResult(pinned, nil)
+	if addr := pick("s0"); addr != moved {
+		t.Errorf("PickSession(s0) = %s after %s recovered, want %s", addr, pinned, moved)
+	}
+
+	// Errors the replica can't help don't move the session.
+	ar.reportResult(moved, errors.ErrInvalidArgument)
+	if addr := pick("s0"); addr != moved {
+		t.Errorf("PickSession(s0) = %s after an invalid argument error, want %s", addr, moved)
+	}
+
+	// So does the replica going away.
+	ar.del(moved)
+	if addr := pick("s0"); addr == moved {
+		t.Errorf("PickSession(s0) = %s after it went away, want another replica", addr)
+	}
+}
+
+func TestSessionRelease(t *testing.T) {
+	defer func(ttl time.Duration, most int) { sessionTTL, maxSessions = ttl, most }(sessionTTL, maxSessions)
+	sessionTTL, maxSessions = 50*time.Millisecond, 2
+
+	ar := newAddrReplica("/sax/foo/bar")
+	ar.reset([]string{"a", "b", "c"})
+	for _, session := range []string{"s0", "s1", "s2"} {
+		if _, err := ar.PickSession(session, nil, nil); err != nil {
+			t.Fatalf("PickSession(%s) error %v, want no error", session, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := ar.sessions["s0"]; ok || len(ar.sessions) != 2 {
+		t.Errorf("Sessions %v beyond the limit, want s0 released", ar.sessions)
+	}
+
+	time.Sleep(2 * sessionTTL)
+	if _, err := ar.PickSession("s3", nil, nil); err != nil {
+		t.Fatalf("PickSession(s3) error %v, want no error", err)
+	}
+	if _, ok := ar.sessions["s3"]; !ok || len(ar.sessions) != 1 {
+		t.Errorf("Sessions %v after the others expired, want only s3", ar.sessions)
+	}
+}
+
+func TestWithSession(t *testing.T) {
+	ctx := WithSession(context.Background(), "chat-1")
+	if got := sessionFrom(ctx); got != "chat-1" {
+		t.Errorf("sessionFrom() = %q, want chat-1", got)
+	}
+	if got := sessionFrom(context.Background()); got != "" {
+		t.Errorf("sessionFrom(background) = %q, want empty", got)
+	}
+}
+
+func TestUploadBlob(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-upload-blob"
+	testutil.SetUp(ctx, t, saxCell, "")
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubAdminServerT(t, port, nil, saxCell)
+
+	// Random bytes don't compress, so the upload exceeds the default 4 MiB gRPC message size limit.
+	data := make([]byte, 5<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	digest, err := Open(saxCell).UploadBlob(ctx, data)
+	if err != nil {
+		t.Fatalf("UploadBlob() error %v, want no error", err)
+	}
+	if want := blob.Digest(data); digest != want {
+		t.Errorf("UploadBlob() = %v, want %v", digest, want)
+	}
+
+	// Model servers resolve the blob from the cell storage.
+	got, err := blob.Read(ctx, saxCell, digest)
+	if err != nil {
+		t.Fatalf("Read(%v) error %v, want no error", digest, err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Read(%v) returned %d different bytes, want the uploaded %d bytes", digest, len(got), len(data))
+	}
+}
+
+func TestReplicaCache(t *testing.T) {
+	ctx := context.Background()
+	model := "/sax/foo/bar"
+	now := time.Unix(0, 0)
+	fetches := 0
+	addrs := []string{"1.2.3.4:5555"}
+	c := newReplicaCache(time.Minute, func(ctx context.Context, modelID string) ([]string, error) {
+		fetches++
+		return addrs, nil
+	})
+	c.now = func() time.Time { return now }
+	check := func(desc string, wantFetches int) {
+		t.Helper()
+		got, err := c.Get(ctx, model)
+		if err != nil {
+			t.Fatalf("%s: Get() error %v, want no error", desc, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(addrs) {
+			t.Errorf("%s: Get() = %v, want %v", desc, got, addrs)
+		}
+		if fetches != wantFetches {
+			t.Errorf("%s: %d fetches, want %d", desc, fetches, wantFetches)
+		}
+	}
+
+	// Steady state reads hit the cache.
+	for i := 0; i < 5; i++ {
+		check("steady state", 1)
+		now = now.Add(time.Second)
+	}
+
+	// Invalidation triggers a refetch.
+	addrs = []string{"1.2.3.4:5555", "5.6.7.8:5555"}
+	c.Invalidate(model)
+	now = now.Add(time.Second)
+	check("after invalidation", 2)
+	check("after refetch", 2)
+
+	// So does expiry.
+	now = now.Add(time.Minute)
+	check("after expiry", 3)
+}
+
+func TestReplicaCacheInvalidatedByWatch(t *testing.T) {
+	ar := newAddrReplica("/sax/foo/bar")
+	changes := 0
+	ar.onChange = func() { changes++ }
+
+	ch := make(chan *WatchResult)
+	done := make(chan error)
+	go func() { done <- ar.Update(ch) }()
+	ch <- &WatchResult{Result: &watchable.WatchResult{}}
+	ch <- &WatchResult{Result: &watchable.WatchResult{Log: watchable.ChangeLog{{Kind: watchable.Add, Val: "1.2.3.4:5555"}}}}
+	close(ch)
+	if err := <-done; err != nil {
+		t.Fatalf("Update() error %v, want no error", err)
+	}
+	if changes != 1 {
+		t.Errorf("onChange called %d times, want 1", changes)
+	}
+}
+
+func TestReplicaCacheDuringAdminOutage(t *testing.T) {
+	ctx := context.Background()
+	model := "/sax/foo/bar"
+	now := time.Unix(0, 0)
+	addrs := []string{"1.2.3.4:5555"}
+	var fetchErr error
+	c := newReplicaCache(time.Minute, func(ctx context.Context, modelID string) ([]string, error) {
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return addrs, nil
+	})
+	c.now = func() time.Time { return now }
+	if _, err := c.Get(ctx, model); err != nil {
+		t.Fatalf("Get() error %v, want no error", err)
+	}
+
+	// The expired replica set keeps being used while the admin server is down.
+	fetchErr = errors.ErrUnavailable
+	now = now.Add(time.Hour)
+	got, err := c.Get(ctx, model)
+	if err != nil {
+		t.Fatalf("Get() during outage error %v, want no error", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(addrs) {
+		t.Errorf("Get() during outage = %v, want %v", got, addrs)
+	}
+
+	// But not once the model is known to be gone.
+	fetchErr = errors.ErrNotFound
+	if _, err := c.Get(ctx, model); !errors.IsNotFound(err) {
+		t.Errorf("Get() of an unpublished model error %v, want %v", err, errors.ErrNotFound)
+	}
+
+	// Nor after the replica set is known to have changed.
+	fetchErr = errors.ErrUnavailable
+	c.Invalidate(model)
+	if _, err := c.Get(ctx, model); err == nil {
+		t.Errorf("Get() of an invalidated model during outage got no error, want %v", errors.ErrUnavailable)
+	}
+}
+
+func TestFindAddressBeforeWatch(t *testing.T) {
+	ctx := context.Background()
+	model := "/sax/foo/bar"
+	ar := newAddrReplica(model)
+	a := &Admin{addrs: map[string]*addrReplica{model: ar}}
+	fetches := 0
+	a.replicas = newReplicaCache(time.Minute, func(ctx context.Context, modelID string) ([]string, error) {
+		fetches++
+		return []string{"1.2.3.4:5555"}, nil
+	})
+
+	// Until the address watch reports the replicas, the cached replica set is used.
+	for seed := uint64(0); seed < 3; seed++ {
+		if addr, err := a.FindAddress(ctx, model, seed); err != nil || addr != "1.2.3.4:5555" {
+			t.Errorf("FindAddress(%d) before the watch = (%v, %v), want (1.2.3.4:5555, nil)", seed, addr, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("%d replica set fetches, want 1", fetches)
+	}
+
+	// The first full set from the watch replaces it.
+	ch := make(chan *WatchResult)
+	done := make(chan error)
+	go func() { done <- ar.Update(ch) }()
+	data := watchable.NewDataSet()
+	data.Add("5.6.7.8:5555")
+	ch <- &WatchResult{Result: &watchable.WatchResult{Data: data}}
+	ch <- &WatchResult{Result: &watchable.WatchResult{}} // Waits for the full set to be applied.
+	if addr, err := a.FindAddress(ctx, model, 0); err != nil || addr != "5.6.7.8:5555" {
+		t.Errorf("FindAddress(0) after the watch = (%v, %v), want (5.6.7.8:5555, nil)", addr, err)
+	}
+	close(ch)
+	if err := <-done; err != nil {
+		t.Fatalf("Update() error %v, want no error", err)
+	}
+}
+
+func TestServeDuringAdminOutage(t *testing.T) {
+	ar := newAddrReplica("/sax/foo/bar")
+	ch := make(chan *WatchResult)
+	done := make(chan error)
+	go func() { done <- ar.Update(ch) }()
+	ch <- &WatchResult{Result: &watchable.WatchResult{Log: watchable.ChangeLog{{Kind: watchable.Add, Val: "1.2.3.4:5555"}}}}
+
+	// The watch keeps failing while the admin server is down.
+	for i := 0; i < 3; i++ {
+		ch <- &WatchResult{Err: errors.ErrUnavailable}
+	}
+	for seed := uint64(0); seed < 10; seed++ {
+		if addr, err := ar.Pick(seed); err != nil || addr != "1.2.3.4:5555" {
+			t.Errorf("Pick(%d) during outage = (%v, %v), want (1.2.3.4:5555, nil)", seed, addr, err)
+		}
+	}
+
+	// A full set is received once the admin server is back.
+	data := watchable.NewDataSet()
+	data.Add("5.6.7.8:5555")
+	ch <- &WatchResult{Result: &watchable.WatchResult{Data: data}}
+	ch <- &WatchResult{Result: &watchable.WatchResult{}} // Waits for the full set to be applied.
+	if addr, err := ar.Pick(0); err != nil || addr != "5.6.7.8:5555" {
+		t.Errorf("Pick(0) after outage = (%v, %v), want (5.6.7.8:5555, nil)", addr, err)
+	}
+	ch <- &WatchResult{Err: errors.ErrNotFound}
+	if err := <-done; !errors.IsNotFound(err) {
+		t.Fatalf("Update() error %v, want %v", err, errors.ErrNotFound)
+	}
+	if _, err := ar.Pick(0); !errors.IsNotFound(err) {
+		t.Errorf("Pick(0) of an unpublished model error %v, want %v", err, errors.ErrNotFound)
+	}
+}
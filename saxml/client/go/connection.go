@@ -27,6 +27,7 @@ import (
 	"saxml/client/go/location"
 	"saxml/common/errors"
 	"saxml/common/platform/env"
+	"saxml/common/transport"
 )
 
 const (
@@ -99,7 +100,9 @@ func (t *connTable) checkAndGet(addr string) (*grpc.ClientConn, bool) {
 	return nil, false
 }
 
-func (t *connTable) getOrCreate(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+// getOrCreate returns the connection to addr, creating it if there is none. New connections follow
+// the transport security policy of saxCell, or are made by the platform if saxCell is empty.
+func (t *connTable) getOrCreate(ctx context.Context, saxCell, addr string) (*grpc.ClientConn, error) {
 	existingClient, found := t.checkAndGet(addr)
 	if found && existingClient != nil {
 		return existingClient, nil
@@ -110,7 +113,11 @@ func (t *connTable) getOrCreate(ctx context.Context, addr string) (*grpc.ClientC
 	var err error
 	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
 	defer cancel()
-	newClient, err = env.Get().DialContext(ctx, addr)
+	if saxCell != "" {
+		newClient, err = transport.DialCell(ctx, saxCell, addr)
+	} else {
+		newClient, err = env.Get().DialContext(ctx, addr)
+	}
 	if err != nil || newClient == nil {
 		log.V(3).Infof("getOrCreate create connection for %s failed due to %v\n", addr, err)
 		if errors.IsDeadlineExceeded(err) {
@@ -140,20 +147,37 @@ type Factory interface {
 	GetOrCreate(ctx context.Context) (*grpc.ClientConn, error)
 }
 
+// Reporter is implemented by factories that pick among servers and want to know how calls to the
+// picked servers went.
+type Reporter interface {
+	// Report tells how a call to the server at addr, the target of a connection returned by
+	// GetOrCreate, went.
+	Report(addr string, err error)
+}
+
 // SaxConnectionFactory resolves backends via SAX admin server and connects to them in a round-robin fashion.
 type SaxConnectionFactory struct {
 	Location *location.Table // Keeps track a list of addresses for this model.
+	SaxCell  string          // The cell of this model, whose transport security policy connections follow.
 }
 
 // GetOrCreate selects a server and returns a connection to it.
 func (f SaxConnectionFactory) GetOrCreate(ctx context.Context) (conn *grpc.ClientConn, err error) {
 	addr, err := f.Location.Pick(ctx)
 	if err == nil {
-		conn, err = globalConnTable.getOrCreate(ctx, addr)
+		conn, err = globalConnTable.getOrCreate(ctx, f.SaxCell, addr)
+		if err != nil {
+			f.Location.Report(addr, err)
+		}
 	}
 	return conn, err
 }
 
+// Report tells the location table how a call to the server at addr went.
+func (f SaxConnectionFactory) Report(addr string, err error) {
+	f.Location.Report(addr, err)
+}
+
 // DirectConnectionFactory connects to the given address directly.
 type DirectConnectionFactory struct {
 	Address    string
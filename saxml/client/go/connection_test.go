@@ -51,7 +51,7 @@ func TestConnection(t *testing.T) {
 	for i := 0; i < 3; i++ {
 		for j := 0; j < 2; j++ {
 			connTable := newConnTable()
-			conn, err := connTable.getOrCreate(context.Background(), addresses[j])
+			conn, err := connTable.getOrCreate(context.Background(), "", addresses[j])
 			if err != nil {
 				t.Fatalf("Creating connection for address %s failed with %v\n", addresses[j], err)
 			}
@@ -83,7 +83,7 @@ func TestFail(t *testing.T) {
 	}
 	addr := "localhost:" + strconv.Itoa(port)
 	connTable := newConnTable()
-	conn, err := connTable.getOrCreate(context.Background(), addr)
+	conn, err := connTable.getOrCreate(context.Background(), "", addr)
 	if err == nil {
 		t.Fatalf("Creating connection for address %s should fail but conn = [%v] is returned\n", addr, conn)
 	}
@@ -104,7 +104,7 @@ func TestBrokenConnection(t *testing.T) {
 	}
 	addr := "localhost:" + strconv.Itoa(port)
 	connTable := newConnTable()
-	conn, err := connTable.getOrCreate(ctx, addr)
+	conn, err := connTable.getOrCreate(ctx, "", addr)
 	if err != nil {
 		t.Fatalf("Creating connection for address %s failed with %v\n", addr, err)
 	}
@@ -115,7 +115,7 @@ func TestBrokenConnection(t *testing.T) {
 	time.Sleep(3 * time.Second)
 
 	// We should still be able to get a cached connection.
-	conn, err = connTable.getOrCreate(context.Background(), addr)
+	conn, err = connTable.getOrCreate(context.Background(), "", addr)
 	if err != nil {
 		t.Fatalf("Getting connection for address %s failed with %v\n", addr, err)
 	}
@@ -42,6 +42,12 @@ func (t *Table) Pick(ctx context.Context) (string, error) {
 	return addr, err
 }
 
+// Report tells how a call to the server at addr went, so that Pick can route around servers
+// whose calls keep failing.
+func (t *Table) Report(addr string, err error) {
+	t.admin.ReportResult(t.model, addr, err)
+}
+
 // NewLocationTable create a new Table for a model.
 func NewLocationTable(admin *saxadmin.Admin, name string, numConn int) *Table {
 	return &Table{
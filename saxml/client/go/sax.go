@@ -17,19 +17,28 @@ package sax
 
 import (
 	"context"
+	"expvar"
 	"fmt"
+	"math"
+	"math/rand"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/golang/glog"
 	"github.com/cenkalti/backoff"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 	"saxml/client/go/connection"
 	"saxml/client/go/location"
 	"saxml/client/go/saxadmin"
+	"saxml/common/config"
 	"saxml/common/errors"
 	"saxml/common/naming"
 	"saxml/common/platform/env"
@@ -38,8 +47,16 @@ import (
 	pb "saxml/protobuf/common_go_proto"
 )
 
-// RPC timeout, only intended for admin methods. Data methods have no timeout in general.
-const timeout = 10 * time.Second
+const (
+	// RPC timeout, only intended for admin methods. Data methods have no timeout in general.
+	timeout = 10 * time.Second
+	// How long a model's traffic split is used before it is fetched from the admin server again.
+	trafficSplitTTL = 10 * time.Second
+	// How long a cell's default request timeout is used before it is loaded from the cell config
+	// again, and how soon a failed load is retried.
+	requestTimeoutTTL         = time.Minute
+	requestTimeoutRetryPeriod = 5 * time.Second
+)
 
 // Model represents a published model in the sax system.
 // It's the entry point for creating task specific models such as `LanguageModel`.
@@ -53,12 +70,293 @@ type Model struct {
 	modelID           string
 	connectionFactory connection.Factory
 	retryingBehavior  func(err error) bool
+
+	// split is not nil iff requests follow the traffic split published with the model.
+	split *trafficSplit
+	// fallback is not nil iff requests may go to fallback models when this one has no replicas.
+	fallback *fallbackChain
+	// requestTimeout is not nil iff the model is in a Sax cell, whose config may set a default
+	// request timeout.
+	requestTimeout *requestTimeout
+	// options are the options the model was opened with, used to open its versions.
+	options []OptionSetter
+	// fallbackResponse is not nil iff requests are answered by it when the model is unavailable.
+	fallbackResponse FallbackResponse
+	// costs adds up the costs model servers reported for queries sent through this model.
+	costs costTotals
+
+	mu sync.Mutex
+	// versions keeps opened models serving versions of this model in its traffic split.
+	versions map[string]*Model
+}
+
+// version returns the model that should serve the next request: the first fallback model with
+// replicas if this one has none, or else a version following the traffic split if there is one.
+func (m *Model) version(ctx context.Context) *Model {
+	var id string
+	if m.fallback != nil {
+		id = m.fallback.pick(ctx)
+	}
+	if id == "" && m.split != nil {
+		id = m.split.pick(ctx)
+	}
+	if id == "" || id == m.modelID {
+		return m
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if found, ok := m.versions[id]; ok {
+		return found
+	}
+	// Versions and fallback models don't follow traffic splits or fall back on their own.
+	options := append(append([]OptionSetter{}, m.options...), WithTrafficSplit(false), WithFallbacks())
+	opened, err := Open(id, options...)
+	if err != nil {
+		log.Warningf("Failed to open %s for model %s, using the model: %v", id, m.modelID, err)
+		return m
+	}
+	m.versions[id] = opened
+	return opened
+}
+
+// withDefaultTimeout returns a copy of ctx with the cell's default request timeout if ctx has no
+// deadline, and ctx itself otherwise.
+func (m *Model) withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || m.requestTimeout == nil {
+		return ctx, func() {}
+	}
+	if d := m.requestTimeout.get(ctx); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return ctx, func() {}
+}
+
+// requestTimeout is the default request timeout of a Sax cell, loaded from the cell config when
+// it's needed and reloaded periodically, so that config changes and failed loads are picked up.
+type requestTimeout struct {
+	load  func(ctx context.Context) (time.Duration, error)
+	ttl   time.Duration
+	retry time.Duration
+
+	mu sync.Mutex
+	// next is when the value is loaded again.
+	next time.Time
+	// loading is true while a load is in flight, so concurrent calls use the last known value.
+	loading bool
+	value   time.Duration
+}
+
+func newRequestTimeout(load func(ctx context.Context) (time.Duration, error)) *requestTimeout {
+	return &requestTimeout{load: load, ttl: requestTimeoutTTL, retry: requestTimeoutRetryPeriod}
+}
+
+// get returns the default request timeout, or zero if there is none or it has never been loaded.
+func (t *requestTimeout) get(ctx context.Context) time.Duration {
+	t.mu.Lock()
+	reload := !t.loading && !time.Now().Before(t.next)
+	if reload {
+		t.loading = true
+	}
+	t.mu.Unlock()
+
+	if reload {
+		loadCtx, cancel := context.WithTimeout(ctx, timeout)
+		value, err := t.load(loadCtx)
+		cancel()
+		t.mu.Lock()
+		t.loading = false
+		if err != nil {
+			log.Warningf("Failed to load the default request timeout, using the last known one and retrying in %v: %v", t.retry, err)
+			t.next = time.Now().Add(t.retry)
+		} else {
+			t.value = value
+			t.next = time.Now().Add(t.ttl)
+		}
+		t.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.value
+}
+
+// trafficSplit picks versions of a model according to the model's traffic split, periodically
+// fetched from the admin server.
+type trafficSplit struct {
+	fetch func(ctx context.Context) (map[string]int32, error)
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	fetched time.Time
+	// fetching is true while a fetch is in flight, so concurrent picks use the last known split.
+	fetching bool
+	// versions are the sorted IDs of versions with positive weights.
+	versions []string
+	// cumulative[i] is the total weight of versions[0..i]. Totals can exceed the weights' int32.
+	cumulative []int64
+	// intn returns a random value in [0, n).
+	intn func(n int64) int64
+}
+
+func newTrafficSplit(fetch func(ctx context.Context) (map[string]int32, error)) *trafficSplit {
+	return &trafficSplit{fetch: fetch, ttl: trafficSplitTTL, intn: rand.Int63n}
+}
+
+func (s *trafficSplit) setLocked(split map[string]int32) {
+	s.versions = s.versions[:0]
+	for version, weight := range split {
+		if weight > 0 {
+			s.versions = append(s.versions, version)
+		}
+	}
+	sort.Strings(s.versions)
+	s.cumulative = s.cumulative[:0]
+	var total int64
+	for _, version := range s.versions {
+		total += int64(split[version])
+		s.cumulative = append(s.cumulative, total)
+	}
+}
+
+// pick returns the ID of the version that should serve the next request, or an empty string if
+// there is no traffic split. If the split can't be fetched, the last known one is used.
+func (s *trafficSplit) pick(ctx context.Context) string {
+	s.mu.Lock()
+	now := time.Now()
+	refetch := !s.fetching && now.Sub(s.fetched) >= s.ttl
+	if refetch {
+		// Don't ask the admin server again for a while even if this fetch fails.
+		s.fetched = now
+		s.fetching = true
+	}
+	s.mu.Unlock()
+
+	if refetch {
+		fetchCtx, cancel := context.WithTimeout(ctx, timeout)
+		split, err := s.fetch(fetchCtx)
+		cancel()
+		s.mu.Lock()
+		s.fetching = false
+		if err != nil {
+			log.Warningf("Failed to fetch the traffic split, using the last known one: %v", err)
+		} else {
+			s.setLocked(split)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.versions) == 0 || s.cumulative[len(s.cumulative)-1] <= 0 {
+		return ""
+	}
+	x := s.intn(s.cumulative[len(s.cumulative)-1])
+	i := sort.Search(len(s.cumulative), func(i int) bool { return x < s.cumulative[i] })
+	return s.versions[i]
+}
+
+// fallbackChain picks a fallback model when a model has no replicas, checking fallbacks in order.
+type fallbackChain struct {
+	primary   string
+	fallbacks []string
+	// hasReplicas returns false if a model has no replicas to serve requests.
+	hasReplicas func(ctx context.Context, id string) bool
+}
+
+func newFallbackChain(primary string, fallbacks []string, hasReplicas func(ctx context.Context, id string) bool) *fallbackChain {
+	return &fallbackChain{primary: primary, fallbacks: fallbacks, hasReplicas: hasReplicas}
+}
+
+// pick returns the ID of the first fallback model with replicas if the primary model has none, or
+// an empty string if the primary model should serve the next request. The primary model also
+// serves requests if no fallback model has replicas.
+func (c *fallbackChain) pick(ctx context.Context) string {
+	if c.hasReplicas(ctx, c.primary) {
+		return ""
+	}
+	for _, id := range c.fallbacks {
+		if c.hasReplicas(ctx, id) {
+			log.V(1).Infof("Model %s has no replicas, falling back to %s", c.primary, id)
+			return id
+		}
+	}
+	return ""
 }
 
 // QueryCost represents the cost of the query.
 type QueryCost struct {
 	// Cost measured in TPU milliseconds
 	TpuMs int
+	// Number of tokens processed, or 0 if the model server doesn't report it.
+	Tokens int
+}
+
+// QueryCostTotals adds up the costs of queries sent through a model.
+type QueryCostTotals struct {
+	// Number of queries whose model server reported a cost.
+	Queries int64
+	// Total cost measured in TPU milliseconds.
+	TpuMs int64
+	// Total number of tokens processed.
+	Tokens int64
+}
+
+// Total costs model servers reported for queries sent through models opened in this process, keyed
+// by model ID. Queries sent to a version of a model in its traffic split count for the model.
+var (
+	queryCostQueriesVar = expvar.NewMap("sax_client_query_cost_queries")
+	queryCostTpuMsVar   = expvar.NewMap("sax_client_query_cost_tpu_ms")
+	queryCostTokensVar  = expvar.NewMap("sax_client_query_cost_tokens")
+)
+
+// costTotals accumulates query costs. The zero value is ready to use.
+type costTotals struct {
+	mu     sync.Mutex
+	totals QueryCostTotals
+}
+
+func (c *costTotals) add(cost QueryCost) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totals.Queries++
+	c.totals.TpuMs += int64(cost.TpuMs)
+	c.totals.Tokens += int64(cost.Tokens)
+}
+
+func (c *costTotals) get() QueryCostTotals {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.totals
+}
+
+// QueryCosts returns the total cost model servers reported for queries sent through this model
+// since it was opened. Queries answered without a reported cost, e.g. failed queries, are not
+// counted.
+func (m *Model) QueryCosts() QueryCostTotals {
+	return m.costs.get()
+}
+
+// extractQueryCost extracts the query cost from the trailer of a query sent through m, adds it to
+// m's totals, and sets it in opts if requested.
+func (m *Model) extractQueryCost(opts *ModelOptions, md *metadata.MD) error {
+	cost, ok, err := parseQueryCost(md)
+	if err != nil && opts.queryCost == nil {
+		// Queries whose caller didn't ask for the cost don't fail because of it.
+		log.Warningf("Model %s: %v", m.modelID, err)
+		return nil
+	}
+	if err != nil || !ok {
+		return err
+	}
+	m.costs.add(cost)
+	queryCostQueriesVar.Add(m.modelID, 1)
+	queryCostTpuMsVar.Add(m.modelID, int64(cost.TpuMs))
+	queryCostTokensVar.Add(m.modelID, int64(cost.Tokens))
+	if opts.queryCost != nil {
+		*opts.queryCost = cost
+	}
+	return nil
 }
 
 // run runs a callback function (`callMethod`) against sax system with retries through gRPC.
@@ -68,14 +366,32 @@ func (m *Model) run(ctx context.Context, methodName string, callMethod func(conn
 	makeQuery := func() error {
 		modelServerConn, err := m.connectionFactory.GetOrCreate(ctx)
 		if err == nil {
+			sampled := routingLog.sample()
+			var start time.Time
+			if sampled {
+				start = time.Now()
+			}
 			err = callMethod(modelServerConn)
+			if sampled {
+				log.Infof("Routed %s() of model %s to %s, took %v: %v", methodName, m.modelID, modelServerConn.Target(), time.Since(start), err)
+			}
+			if reporter, ok := m.connectionFactory.(connection.Reporter); ok {
+				reporter.Report(modelServerConn.Target(), err)
+			}
 		} else if errors.IsNotFound(err) {
 			// If the model does not exist anymore, no point to retry.
 			err = backoff.Permanent(err)
 		}
 		return err
 	}
-	err := retrier.Do(ctx, makeQuery, m.retryingBehavior)
+	retriable, policy := m.retryingBehavior, retrier.Policy{}
+	if p, ok := retryPolicyOf(m.modelID); ok {
+		policy = retrier.Policy{MaxAttempts: p.MaxAttempts, InitialInterval: p.InitialBackoff, MaxInterval: p.MaxBackoff}
+		if p.RetryableCodes != nil {
+			retriable = p.retriable
+		}
+	}
+	err := retrier.DoWithPolicy(ctx, makeQuery, retriable, policy)
 	if err != nil {
 		log.V(1).Infof("%s() failed: %s", methodName, err)
 		return err
@@ -127,6 +443,12 @@ type Options struct {
 	proxyAddr string
 	// `failFast` disables some retrying behavior when true. Useful for when the model servers are unresponsive.
 	failFast bool
+	// `trafficSplit` sends requests to versions of the model according to its traffic split when true.
+	trafficSplit bool
+	// `fallbacks` are the IDs of models to send requests to, in order, when the model has no replicas.
+	fallbacks []string
+	// `fallbackResponse` answers requests the model is unavailable for, instead of an error.
+	fallbackResponse FallbackResponse
 	// Add other possible options.
 }
 
@@ -154,6 +476,165 @@ func WithFailFast(failFast bool) OptionSetter {
 	}
 }
 
+// WithTrafficSplit makes data methods honor the traffic split published with the model, sending
+// each request to a version of the model picked according to the split's weights.
+func WithTrafficSplit(enabled bool) OptionSetter {
+	return func(o *Options) {
+		o.trafficSplit = enabled
+	}
+}
+
+// WithFallbacks sets an ordered list of models, e.g. smaller configs of the same model, that data
+// methods use when the model has no replicas. Each request goes to the first of them with
+// replicas, trading quality for availability. Calling it with no IDs disables fallbacks.
+func WithFallbacks(ids ...string) OptionSetter {
+	return func(o *Options) {
+		o.fallbacks = ids
+	}
+}
+
+// FallbackResponse returns a degraded response to a request the model couldn't serve. request is
+// the request sent to model servers, e.g. a *lmpb.GenerateRequest, and err is the final error. The
+// response must be of the matching type, e.g. a *lmpb.GenerateResponse. Returning an error fails
+// the request with it.
+type FallbackResponse func(ctx context.Context, request proto.Message, err error) (proto.Message, error)
+
+// WithFallbackResponse makes unary data methods answer with respond rather than fail when the
+// model is unavailable, i.e. once retries and fallback models are exhausted and the final error is
+// one a model server would be retried for, or the deadline. Other errors, e.g. invalid arguments,
+// are returned as is.
+func WithFallbackResponse(respond FallbackResponse) OptionSetter {
+	return func(o *Options) {
+		o.fallbackResponse = respond
+	}
+}
+
+// orFallback returns resp and err of a request to model as is, unless the model is unavailable
+// and was opened with WithFallbackResponse, in which case it returns the fallback response.
+func orFallback[Resp proto.Message](ctx context.Context, model *Model, req proto.Message, resp Resp, err error) (Resp, error) {
+	if err == nil || model.fallbackResponse == nil || !(errors.ServerShouldRetry(err) || errors.IsDeadlineExceeded(err)) {
+		return resp, err
+	}
+	fallback, fallbackErr := model.fallbackResponse(ctx, req, err)
+	if fallbackErr != nil {
+		return resp, fallbackErr
+	}
+	typed, ok := fallback.(Resp)
+	if !ok {
+		log.Errorf("Fallback response %T to %T of model %s has the wrong type, want %T", fallback, req, model.modelID, resp)
+		return resp, err
+	}
+	log.V(1).Infof("Answered %T of model %s with the fallback response: %v", req, model.modelID, err)
+	return typed, nil
+}
+
+// RetryPolicy controls how data methods of a model retry failed calls, e.g. to let idempotent
+// embedding calls retry freely while stateful generation calls don't. Zero fields keep the
+// defaults.
+type RetryPolicy struct {
+	// MaxAttempts caps the number of calls, including the first one. If not positive, calls are
+	// retried until their context is done.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. Delays then grow exponentially.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff time.Duration
+	// RetryableCodes are the codes of errors worth retrying. If nil, the errors retried are those
+	// retried without a policy. If empty, no error is retried.
+	RetryableCodes []codes.Code
+}
+
+func (p RetryPolicy) retriable(err error) bool {
+	code := errors.Code(err)
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	muRetryPolicies sync.RWMutex
+	// retryPolicies are retry policies keyed by model ID.
+	retryPolicies = make(map[string]RetryPolicy)
+)
+
+// SetRetryPolicy sets the retry policy of data methods of the model with the given ID, replacing
+// any policy set before. It applies to the model whether it is already opened or not, but not to
+// other versions of the model in a traffic split or to its fallback models, which have their own
+// IDs. SetRetryPolicy(id, RetryPolicy{}) restores the default behavior.
+func SetRetryPolicy(modelID string, policy RetryPolicy) {
+	muRetryPolicies.Lock()
+	defer muRetryPolicies.Unlock()
+	retryPolicies[modelID] = policy
+}
+
+// retryPolicyOf returns the retry policy set for a model, if any.
+func retryPolicyOf(modelID string) (RetryPolicy, bool) {
+	muRetryPolicies.RLock()
+	defer muRetryPolicies.RUnlock()
+	policy, ok := retryPolicies[modelID]
+	return policy, ok
+}
+
+// routingSampler decides which data method calls log their routing decision. It is a single
+// atomic load per call when sampling is off.
+type routingSampler struct {
+	// The fraction of calls to log, as math.Float64bits. Zero turns logging off.
+	rate uint64
+
+	mu sync.Mutex
+	// At most maxPerSecond calls are logged in each one-second window starting at windowStart.
+	maxPerSecond int
+	windowStart  time.Time
+	logged       int
+
+	// Overridden by tests.
+	now    func() time.Time
+	random func() float64
+}
+
+func newRoutingSampler() *routingSampler {
+	return &routingSampler{now: time.Now, random: rand.Float64}
+}
+
+var routingLog = newRoutingSampler()
+
+// SetRoutingLogSampling makes data method calls log a fraction rate of their routing decisions,
+// with the model, the replica picked, and the latency and outcome of the call, e.g. to find
+// replicas drawing more than their share of traffic. At most maxPerSecond calls are logged each
+// second regardless of traffic. A rate of 0, the default, turns logging off.
+func SetRoutingLogSampling(rate float64, maxPerSecond int) {
+	routingLog.set(rate, maxPerSecond)
+}
+
+func (s *routingSampler) set(rate float64, maxPerSecond int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxPerSecond = maxPerSecond
+	s.windowStart, s.logged = time.Time{}, 0
+	atomic.StoreUint64(&s.rate, math.Float64bits(rate))
+}
+
+// sample returns whether to log the routing decision of the next call.
+func (s *routingSampler) sample() bool {
+	rate := math.Float64frombits(atomic.LoadUint64(&s.rate))
+	if rate <= 0 || s.random() >= rate {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.now(); now.Sub(s.windowStart) >= time.Second {
+		s.windowStart, s.logged = now, 0
+	}
+	if s.logged >= s.maxPerSecond {
+		return false
+	}
+	s.logged++
+	return true
+}
+
 // ModelOptions contains options for model methods.
 type ModelOptions struct {
 	kv        map[string]float32
@@ -176,20 +657,41 @@ func (mo *ModelOptions) ExtractQueryCost(md *metadata.MD) error {
 	if mo.queryCost == nil {
 		return nil
 	}
-	if tr := md.Get("query_cost_v0"); mo.queryCost != nil && len(tr) > 0 {
-		queryCost, err := strconv.Atoi(tr[0])
-		if err != nil {
-			return fmt.Errorf("metadata.Get(query_cost_v0) expected int, got %s", tr[0])
-		}
-		(*mo.queryCost).TpuMs = queryCost
+	cost, ok, err := parseQueryCost(md)
+	if err != nil {
+		return err
+	}
+	if ok {
+		*mo.queryCost = cost
 	}
 	return nil
 }
 
+// parseQueryCost parses the query cost a model server sets in the trailer of its response. ok is
+// false if the trailer has no cost.
+func parseQueryCost(md *metadata.MD) (cost QueryCost, ok bool, err error) {
+	tr := md.Get("query_cost_v0")
+	if len(tr) == 0 {
+		return QueryCost{}, false, nil
+	}
+	if cost.TpuMs, err = strconv.Atoi(tr[0]); err != nil {
+		return QueryCost{}, false, fmt.Errorf("metadata.Get(query_cost_v0) expected int, got %s", tr[0])
+	}
+	if tr := md.Get("query_tokens_v0"); len(tr) > 0 {
+		if cost.Tokens, err = strconv.Atoi(tr[0]); err != nil {
+			return QueryCost{}, false, fmt.Errorf("metadata.Get(query_tokens_v0) expected int, got %s", tr[0])
+		}
+	}
+	return cost, true, nil
+}
+
 // ModelOptionSetter are setters for sax options.
 type ModelOptionSetter func(*ModelOptions)
 
 // WithExtraInput sets options (key-value pairs) for the query.
+//
+// Extra inputs are passed to the model server as is. The model server fails the query with an
+// InvalidArgument error if the model method doesn't define an extra input with the same name.
 func WithExtraInput(name string, value float32) ModelOptionSetter {
 	return func(o *ModelOptions) {
 		o.kv[name] = value
@@ -217,6 +719,40 @@ func WithQueryCost(cost *QueryCost) ModelOptionSetter {
 	}
 }
 
+// idempotencyKeyHeader is the gRPC metadata key model servers read idempotency keys from.
+const idempotencyKeyHeader = "sax-idempotency-key"
+
+// WithIdempotencyKey returns a copy of ctx that makes unary model method calls carry the given
+// idempotency key.
+//
+// A model server that has recently answered a call with the same key, method, model and user
+// returns that answer again instead of recomputing it, so retrying a call with the same key is
+// safe. Streaming calls ignore the key.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, idempotencyKeyHeader, key)
+}
+
+// hasIdempotencyKey reports whether calls made with ctx carry an idempotency key.
+func hasIdempotencyKey(ctx context.Context) bool {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	return ok && len(md.Get(idempotencyKeyHeader)) > 0
+}
+
+// WithLabelSelector returns a copy of ctx that routes model method calls only to replicas whose
+// model servers are tagged "<key>=<value>" for every entry in selector. Calls fail with a
+// FailedPrecondition error when no replica matches. An empty selector routes to any replica.
+func WithLabelSelector(ctx context.Context, selector map[string]string) context.Context {
+	return saxadmin.WithLabelSelector(ctx, selector)
+}
+
+// WithSession returns a copy of ctx that routes all model method calls carrying the same session
+// ID to the same replica, e.g., for models keeping conversation state on the server. If that
+// replica fails or goes away, the session moves to another replica and sticks to it instead.
+// Sessions without calls for a while are forgotten.
+func WithSession(ctx context.Context, session string) context.Context {
+	return saxadmin.WithSession(ctx, session)
+}
+
 // NewModelOptions creates a ModelOption by applying a list of key value pairs.
 func NewModelOptions(setters ...ModelOptionSetter) *ModelOptions {
 	opts := &ModelOptions{
@@ -269,6 +805,7 @@ func Open(id string, options ...OptionSetter) (*Model, error) {
 			modelID:           id,
 			connectionFactory: &connection.DirectConnectionFactory{Address: opts.proxyAddr},
 			retryingBehavior:  retryingBehavior,
+			fallbackResponse:  opts.fallbackResponse,
 		}
 		return model, nil
 	}
@@ -278,6 +815,7 @@ func Open(id string, options ...OptionSetter) (*Model, error) {
 			modelID:           id,
 			connectionFactory: &connection.DirectConnectionFactory{Address: id},
 			retryingBehavior:  retryingBehavior,
+			fallbackResponse:  opts.fallbackResponse,
 		}
 		return model, nil
 	}
@@ -288,8 +826,43 @@ func Open(id string, options ...OptionSetter) (*Model, error) {
 	admin := saxadmin.Open(modelID.CellFullName())
 	model := &Model{
 		modelID:           id,
-		connectionFactory: connection.SaxConnectionFactory{Location: location.NewLocationTable(admin, id, opts.numConn)},
+		connectionFactory: connection.SaxConnectionFactory{Location: location.NewLocationTable(admin, id, opts.numConn), SaxCell: modelID.CellFullName()},
 		retryingBehavior:  retryingBehavior,
+		options:           options,
+		fallbackResponse:  opts.fallbackResponse,
+		versions:          make(map[string]*Model),
+		requestTimeout: newRequestTimeout(func(ctx context.Context) (time.Duration, error) {
+			cfg, err := config.Load(ctx, modelID.CellFullName())
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(cfg.GetDefaultRequestTimeoutMs()) * time.Millisecond, nil
+		}),
+	}
+	if len(opts.fallbacks) > 0 {
+		model.fallback = newFallbackChain(id, opts.fallbacks, func(ctx context.Context, id string) bool {
+			fullName, err := naming.NewModelFullName(id)
+			if err != nil {
+				return false
+			}
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			addrs, err := saxadmin.Open(fullName.CellFullName()).Replicas(ctx, id)
+			if errors.IsNotFound(err) {
+				return false
+			}
+			// Don't fall back just because the admin server can't be reached.
+			return err != nil || len(addrs) > 0
+		})
+	}
+	if opts.trafficSplit {
+		model.split = newTrafficSplit(func(ctx context.Context) (map[string]int32, error) {
+			published, err := admin.List(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			return published.GetModel().GetTrafficSplit(), nil
+		})
 	}
 	return model, nil
 }
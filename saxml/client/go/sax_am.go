@@ -18,6 +18,7 @@ import (
 	"context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	pb "saxml/protobuf/audio_go_proto_grpc"
 	pbgrpc "saxml/protobuf/audio_go_proto_grpc"
@@ -46,23 +47,31 @@ func extractAsrResponse(res *pb.AsrResponse) []AsrHypothesis {
 
 // Recognize against an ASR model.
 func (m *AudioModel) Recognize(ctx context.Context, audioBytes []byte, options ...ModelOptionSetter) ([]AsrHypothesis, error) {
+	model := m.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.AsrRequest{
-		ModelKey:    m.model.modelID,
+		ModelKey:    model.modelID,
 		AudioBytes:  audioBytes,
 		AudioFormat: pb.AsrRequest_WAVEFORM_FILE,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.AsrResponse
-	err := m.model.run(ctx, "SpeechRecognition", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "SpeechRecognition", func(conn *grpc.ClientConn) error {
 		var asrErr error
-		resp, asrErr = pbgrpc.NewAudioServiceClient(conn).Recognize(ctx, req)
+		resp, asrErr = pbgrpc.NewAudioServiceClient(conn).Recognize(ctx, req, grpc.Trailer(&trailer))
 		return asrErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := m.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	res := extractAsrResponse(resp)
 	return res, nil
 }
@@ -18,6 +18,7 @@ import (
 	"context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	pb "saxml/protobuf/custom_go_proto_grpc"
 	pbgrpc "saxml/protobuf/custom_go_proto_grpc"
@@ -31,22 +32,30 @@ type CustomModel struct {
 
 // Custom call against a Custom model.
 func (m *CustomModel) Custom(ctx context.Context, request []byte, methodName string, options ...ModelOptionSetter) ([]byte, error) {
+	model := m.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.CustomRequest{
-		ModelKey:    m.model.modelID,
+		ModelKey:    model.modelID,
 		Request:     request,
 		ExtraInputs: opts.ExtraInputs(),
 		MethodName:  methodName,
 	}
 
 	var resp *pb.CustomResponse
-	err := m.model.run(ctx, "CustomCall", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "CustomCall", func(conn *grpc.ClientConn) error {
 		var customCallErr error
-		resp, customCallErr = pbgrpc.NewCustomServiceClient(conn).Custom(ctx, req)
+		resp, customCallErr = pbgrpc.NewCustomServiceClient(conn).Custom(ctx, req, grpc.Trailer(&trailer))
 		return customCallErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return []byte{}, err
 	}
+	if err := m.model.extractQueryCost(opts, &trailer); err != nil {
+		return []byte{}, err
+	}
 	return resp.Response, nil
 }
@@ -16,11 +16,16 @@ package sax
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	log "github.com/golang/glog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"saxml/common/errors"
 	"saxml/common/retrier"
 
 	pb "saxml/protobuf/lm_go_proto_grpc"
@@ -36,9 +41,12 @@ type LanguageModel struct {
 // Score performs scoring for a `prefix`, `suffix` pair on a language model.
 // Note: Score() does not manipulate prefix or suffix; users add <EOS> explicitly if needed.
 func (l *LanguageModel) Score(ctx context.Context, prefix string, suffix []string, options ...ModelOptionSetter) ([]float64, error) {
+	model := l.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.ScoreRequest{
-		ModelKey:    l.model.modelID,
+		ModelKey:    model.modelID,
 		Suffix:      suffix,
 		Prefix:      prefix,
 		ExtraInputs: opts.ExtraInputs(),
@@ -46,15 +54,16 @@ func (l *LanguageModel) Score(ctx context.Context, prefix string, suffix []strin
 
 	var resp *pb.ScoreResponse
 	var trailer metadata.MD
-	err := l.model.run(ctx, "Score", func(conn *grpc.ClientConn) error {
+	err := model.run(ctx, "Score", func(conn *grpc.ClientConn) error {
 		var scoreErr error
 		resp, scoreErr = pbgrpc.NewLMServiceClient(conn).Score(ctx, req, grpc.Trailer(&trailer))
 		return scoreErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return []float64{0.0}, err
 	}
-	if err := opts.ExtractQueryCost(&trailer); err != nil {
+	if err := l.model.extractQueryCost(opts, &trailer); err != nil {
 		return nil, err
 	}
 
@@ -62,6 +71,164 @@ func (l *LanguageModel) Score(ctx context.Context, prefix string, suffix []strin
 	return logP, nil
 }
 
+// A batched Score call is sent this long before the earliest deadline of the calls in it, so the
+// model server has time to answer.
+var scoreBatchDeadlineSlack = 20 * time.Millisecond
+
+// ScoreBatcher coalesces concurrent Score calls with the same prefix and extra inputs into one
+// Score call, whose suffixes are the concatenation of the calls' suffixes, and splits the scores
+// back to the callers. It trades at most a batching window of latency for fewer RPCs, e.g. for
+// high-throughput workloads of small Score calls.
+//
+// A batch is sent when it reaches its maximum number of suffixes, when its window passes, or early
+// enough to meet the earliest deadline of the calls in it, whichever comes first. The batched call
+// carries the context values, e.g. a label selector or session, of the first call in the batch.
+// Calls extracting query costs bypass batching, since a batch's cost can't be split among callers.
+// So do calls carrying an idempotency key, since the model server would cache the batch's answer
+// under one caller's key. Public methods are thread safe.
+type ScoreBatcher struct {
+	score       func(ctx context.Context, prefix string, suffix []string, options ...ModelOptionSetter) ([]float64, error)
+	window      time.Duration
+	maxSuffixes int
+
+	mu      sync.Mutex
+	pending map[string]*scoreBatch // keyed by prefix and extra inputs
+}
+
+type scoreResult struct {
+	logP []float64
+	err  error
+}
+
+type scoreCall struct {
+	suffix []string
+	result chan scoreResult // buffered, receives exactly one result
+}
+
+type scoreBatch struct {
+	ctx         context.Context // of the first call
+	prefix      string
+	options     []ModelOptionSetter // of the first call
+	calls       []*scoreCall
+	numSuffixes int
+	flushAt     time.Time
+	deadline    time.Time // earliest deadline of the calls, zero if none has one
+	timer       *time.Timer
+}
+
+// NewScoreBatcher returns a ScoreBatcher for l that batches calls arriving within window of the
+// first call of a batch, up to maxSuffixes suffixes per batch. maxSuffixes <= 0 means no limit.
+func (l *LanguageModel) NewScoreBatcher(window time.Duration, maxSuffixes int) *ScoreBatcher {
+	return newScoreBatcher(l.Score, window, maxSuffixes)
+}
+
+func newScoreBatcher(score func(ctx context.Context, prefix string, suffix []string, options ...ModelOptionSetter) ([]float64, error), window time.Duration, maxSuffixes int) *ScoreBatcher {
+	return &ScoreBatcher{
+		score:       score,
+		window:      window,
+		maxSuffixes: maxSuffixes,
+		pending:     make(map[string]*scoreBatch),
+	}
+}
+
+// Score is like LanguageModel.Score, but may share one Score call with concurrent calls.
+func (b *ScoreBatcher) Score(ctx context.Context, prefix string, suffix []string, options ...ModelOptionSetter) ([]float64, error) {
+	opts := NewModelOptions(options...)
+	if opts.queryCost != nil || len(suffix) == 0 || hasIdempotencyKey(ctx) {
+		return b.score(ctx, prefix, suffix, options...)
+	}
+	extraInputs, err := proto.MarshalOptions{Deterministic: true}.Marshal(opts.ExtraInputs())
+	if err != nil {
+		return b.score(ctx, prefix, suffix, options...)
+	}
+	key := fmt.Sprintf("%d:%s%s", len(prefix), prefix, extraInputs)
+
+	call := &scoreCall{suffix: suffix, result: make(chan scoreResult, 1)}
+	b.add(ctx, key, prefix, options, call)
+	select {
+	case r := <-call.result:
+		return r.logP, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *ScoreBatcher) add(ctx context.Context, key, prefix string, options []ModelOptionSetter, call *scoreCall) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &scoreBatch{ctx: ctx, prefix: prefix, options: options, flushAt: now.Add(b.window)}
+		batch.timer = time.AfterFunc(b.window, func() { b.flush(key, batch) })
+		b.pending[key] = batch
+	}
+	batch.calls = append(batch.calls, call)
+	batch.numSuffixes += len(call.suffix)
+
+	if d, ok := ctx.Deadline(); ok && (batch.deadline.IsZero() || d.Before(batch.deadline)) {
+		batch.deadline = d
+		if at := d.Add(-scoreBatchDeadlineSlack); at.Before(batch.flushAt) {
+			// A timer that already fired makes flush run again, which is a no-op.
+			batch.flushAt = at
+			batch.timer.Reset(at.Sub(now))
+		}
+	}
+	if b.maxSuffixes > 0 && batch.numSuffixes >= b.maxSuffixes {
+		batch.timer.Stop()
+		delete(b.pending, key)
+		go b.send(batch)
+	}
+}
+
+// flush sends batch, unless it has been sent already.
+func (b *ScoreBatcher) flush(key string, batch *scoreBatch) {
+	b.mu.Lock()
+	if b.pending[key] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+func (b *ScoreBatcher) send(batch *scoreBatch) {
+	// Callers giving up must not cancel the call for the others in the batch.
+	ctx := context.Context(valuesOnly{batch.ctx})
+	if !batch.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, batch.deadline)
+		defer cancel()
+	}
+	suffixes := make([]string, 0, batch.numSuffixes)
+	for _, call := range batch.calls {
+		suffixes = append(suffixes, call.suffix...)
+	}
+	logP, err := b.score(ctx, batch.prefix, suffixes, batch.options...)
+	if err == nil && len(logP) != len(suffixes) {
+		err = fmt.Errorf("batched Score returned %d scores for %d suffixes: %w", len(logP), len(suffixes), errors.ErrInternal)
+	}
+	for _, call := range batch.calls {
+		if err != nil {
+			call.result <- scoreResult{err: err}
+			continue
+		}
+		n := len(call.suffix)
+		call.result <- scoreResult{logP: logP[:n:n]}
+		logP = logP[n:]
+	}
+}
+
+// valuesOnly keeps the values of a context, but not its deadline or cancellation.
+type valuesOnly struct {
+	context.Context
+}
+
+func (valuesOnly) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesOnly) Done() <-chan struct{}       { return nil }
+func (valuesOnly) Err() error                  { return nil }
+
 // GenerateResult is a tuple of text and score as the result for generate operation.
 type GenerateResult struct {
 	Text  string
@@ -79,25 +246,29 @@ func extractGenerateResponse(res *pb.GenerateResponse) []GenerateResult {
 
 // Generate performs sampling decoding for `text` on a language model.
 func (l *LanguageModel) Generate(ctx context.Context, text string, options ...ModelOptionSetter) ([]GenerateResult, error) {
+	model := l.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.GenerateRequest{
-		ModelKey:    l.model.modelID,
+		ModelKey:    model.modelID,
 		Text:        text,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.GenerateResponse
 	var trailer metadata.MD
-	err := l.model.run(ctx, "generate", func(conn *grpc.ClientConn) error {
+	err := model.run(ctx, "generate", func(conn *grpc.ClientConn) error {
 		var sampleErr error
 		resp, sampleErr = pbgrpc.NewLMServiceClient(conn).Generate(ctx, req, grpc.Trailer(&trailer))
 		return sampleErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
-	if err := opts.ExtractQueryCost(&trailer); err != nil {
-		log.Errorf("ExtractQueryCost: %v", err)
+	if err := l.model.extractQueryCost(opts, &trailer); err != nil {
+		log.Errorf("extractQueryCost: %v", err)
 	}
 	res := extractGenerateResponse(resp)
 	return res, nil
@@ -161,9 +332,10 @@ func extractGenerateStreamResponse(res *pb.GenerateStreamResponse) []GenerateStr
 //			}
 //		}
 func (l *LanguageModel) GenerateStream(ctx context.Context, text string, options ...ModelOptionSetter) chan StreamResult {
+	model := l.model.version(ctx)
 	opts := NewModelOptions(options...)
 	req := &pb.GenerateRequest{
-		ModelKey:    l.model.modelID,
+		ModelKey:    model.modelID,
 		Text:        text,
 		ExtraInputs: opts.ExtraInputs(),
 	}
@@ -171,15 +343,12 @@ func (l *LanguageModel) GenerateStream(ctx context.Context, text string, options
 	res := make(chan StreamResult)
 	go func() {
 		var trailer metadata.MD
-		err := l.model.run(ctx, "generateStream", func(conn *grpc.ClientConn) error {
+		err := model.run(ctx, "generateStream", func(conn *grpc.ClientConn) error {
 			client := pbgrpc.NewLMServiceClient(conn)
 			stream, err := client.GenerateStream(ctx, req, grpc.Trailer(&trailer))
 			if err != nil {
 				return err
 			}
-			if err := opts.ExtractQueryCost(&trailer); err != nil {
-				log.Errorf("ExtractQueryCost: %v", err)
-			}
 			// If the model doesn't exist or is being loaded on the model server, the GenerateStream call
 			// above doesn't return any error. Instead, the first Recv call below returns a NotFound
 			// error. That's why we need special handling for the first Recv return value.
@@ -194,6 +363,10 @@ func (l *LanguageModel) GenerateStream(ctx context.Context, text string, options
 					continue
 				}
 				if err == io.EOF {
+					// The trailer carrying the query cost is only available once the stream ends.
+					if err := l.model.extractQueryCost(opts, &trailer); err != nil {
+						log.Errorf("extractQueryCost: %v", err)
+					}
 					// On successful completion of streaming, send io.EOF to the res channel and return
 					// success (nil) to the retrier.
 					res <- StreamResult{Err: err}
@@ -220,24 +393,28 @@ func (l *LanguageModel) GenerateStream(ctx context.Context, text string, options
 
 // Embed performs embedding for a text.
 func (l *LanguageModel) Embed(ctx context.Context, text string, options ...ModelOptionSetter) ([]float64, error) {
+	model := l.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.EmbedRequest{
-		ModelKey:    l.model.modelID,
+		ModelKey:    model.modelID,
 		Text:        text,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.EmbedResponse
 	var trailer metadata.MD
-	err := l.model.run(ctx, "Embed", func(conn *grpc.ClientConn) error {
+	err := model.run(ctx, "Embed", func(conn *grpc.ClientConn) error {
 		var embErr error
 		resp, embErr = pbgrpc.NewLMServiceClient(conn).Embed(ctx, req, grpc.Trailer(&trailer))
 		return embErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
-	if err := opts.ExtractQueryCost(&trailer); err != nil {
+	if err := l.model.extractQueryCost(opts, &trailer); err != nil {
 		return nil, err
 	}
 	return resp.GetEmbedding(), nil
@@ -245,9 +422,12 @@ func (l *LanguageModel) Embed(ctx context.Context, text string, options ...Model
 
 // Gradient performs gradient for a `prefix`, `suffix` pair on a language model `__call__`.
 func (l *LanguageModel) Gradient(ctx context.Context, prefix string, suffix string, options ...ModelOptionSetter) ([]float64, map[string][]float64, error) {
+	model := l.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.GradientRequest{
-		ModelKey:    l.model.modelID,
+		ModelKey:    model.modelID,
 		Suffix:      suffix,
 		Prefix:      prefix,
 		ExtraInputs: opts.ExtraInputs(),
@@ -255,15 +435,16 @@ func (l *LanguageModel) Gradient(ctx context.Context, prefix string, suffix stri
 
 	var resp *pb.GradientResponse
 	var trailer metadata.MD
-	err := l.model.run(ctx, "Gradient", func(conn *grpc.ClientConn) error {
+	err := model.run(ctx, "Gradient", func(conn *grpc.ClientConn) error {
 		var gradientErr error
 		resp, gradientErr = pbgrpc.NewLMServiceClient(conn).Gradient(ctx, req, grpc.Trailer(&trailer))
 		return gradientErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, nil, err
 	}
-	if err := opts.ExtractQueryCost(&trailer); err != nil {
+	if err := l.model.extractQueryCost(opts, &trailer); err != nil {
 		return nil, nil, err
 	}
 
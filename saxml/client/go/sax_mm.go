@@ -18,6 +18,7 @@ import (
 	"context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	mmpb "saxml/protobuf/multimodal_go_proto_grpc"
 	pbgrpc "saxml/protobuf/multimodal_go_proto_grpc"
@@ -31,42 +32,58 @@ type MultimodalModel struct {
 
 // Generate performs generation for `dataItems` on a multimodal model.
 func (m *MultimodalModel) Generate(ctx context.Context, req *mmpb.GenerateRequest, options ...ModelOptionSetter) (*mmpb.GenerateResponse, error) {
+	model := m.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	rpcReq := &mmpb.GenerateRpcRequest{
-		ModelKey:    m.model.modelID,
+		ModelKey:    model.modelID,
 		Request:     req,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var rpcResp *mmpb.GenerateRpcResponse
-	err := m.model.run(ctx, "Generate", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "Generate", func(conn *grpc.ClientConn) error {
 		var genErr error
-		rpcResp, genErr = pbgrpc.NewMultimodalServiceClient(conn).Generate(ctx, rpcReq)
+		rpcResp, genErr = pbgrpc.NewMultimodalServiceClient(conn).Generate(ctx, rpcReq, grpc.Trailer(&trailer))
 		return genErr
 	})
+	rpcResp, err = orFallback(ctx, model, rpcReq, rpcResp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := m.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	return rpcResp.GetResponse(), nil
 }
 
 // Score performs scoring for `prefixItems` and `suffixItems` on a multimodal model.
 func (m *MultimodalModel) Score(ctx context.Context, req *mmpb.ScoreRequest, options ...ModelOptionSetter) (*mmpb.ScoreResponse, error) {
+	model := m.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	rpcReq := &mmpb.ScoreRpcRequest{
-		ModelKey:    m.model.modelID,
+		ModelKey:    model.modelID,
 		Request:     req,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var rpcResp *mmpb.ScoreRpcResponse
-	err := m.model.run(ctx, "Score", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "Score", func(conn *grpc.ClientConn) error {
 		var scoreErr error
-		rpcResp, scoreErr = pbgrpc.NewMultimodalServiceClient(conn).Score(ctx, rpcReq)
+		rpcResp, scoreErr = pbgrpc.NewMultimodalServiceClient(conn).Score(ctx, rpcReq, grpc.Trailer(&trailer))
 		return scoreErr
 	})
+	rpcResp, err = orFallback(ctx, model, rpcReq, rpcResp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := m.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	return rpcResp.GetResponse(), nil
 }
@@ -0,0 +1,720 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sax
+
+import (
+	"context"
+	"expvar"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"saxml/common/errors"
+	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/testutil"
+
+	lmpb "saxml/protobuf/lm_go_proto_grpc"
+)
+
+func TestScoreExtraInputs(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-extra-inputs"
+	testutil.NewCluster(saxCell).Start(ctx, t)
+
+	model, err := Open(saxCell + "/lm")
+	if err != nil {
+		t.Fatalf("Open() error %v, want no error", err)
+	}
+	lm := model.LM()
+
+	tests := []struct {
+		desc     string
+		options  []ModelOptionSetter
+		want     float64
+		wantCode codes.Code
+	}{
+		{
+			desc: "default",
+			want: 0.4,
+		},
+		{
+			desc:    "known extra input",
+			options: []ModelOptionSetter{WithExtraInput("temperature", 2)},
+			want:    0.8,
+		},
+		{
+			desc:     "unknown extra input",
+			options:  []ModelOptionSetter{WithExtraInput("no_such_option", 2)},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			desc:     "unknown extra input string",
+			options:  []ModelOptionSetter{WithExtraInput("temperature", 2), WithExtraInputString("no_such_option", "x")},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			logP, err := lm.Score(ctx, "ab", []string{"cd"}, tc.options...)
+			if code := errors.Code(err); code != tc.wantCode {
+				t.Fatalf("Score() error %v, want code %v", err, tc.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if len(logP) != 1 || math.Abs(logP[0]-tc.want) > 1e-6 {
+				t.Errorf("Score() = %v, want [%v]", logP, tc.want)
+			}
+		})
+	}
+}
+
+func TestTrafficSplit(t *testing.T) {
+	ctx := context.Background()
+	split := map[string]int32{"/sax/test/v1": 95, "/sax/test/v2": 5}
+	fetches := 0
+	s := newTrafficSplit(func(ctx context.Context) (map[string]int32, error) {
+		fetches++
+		return split, nil
+	})
+	s.ttl = time.Hour
+
+	countPicks := func(n int) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			counts[s.pick(ctx)]++
+		}
+		return counts
+	}
+
+	n := 10000
+	counts := countPicks(n)
+	if got := float64(counts["/sax/test/v2"]) / float64(n); math.Abs(got-0.05) > 0.01 {
+		t.Errorf("Share of v2 = %.3f, want 0.05", got)
+	}
+	if got := counts["/sax/test/v1"] + counts["/sax/test/v2"]; got != n {
+		t.Errorf("Picked known versions %d times, want %d", got, n)
+	}
+	if fetches != 1 {
+		t.Errorf("Fetched the split %d times, want 1", fetches)
+	}
+
+	// An updated split takes effect after the current one expires.
+	split = map[string]int32{"/sax/test/v1": 1, "/sax/test/v2": 3}
+	s.ttl = 0
+	counts = countPicks(n)
+	if got := float64(counts["/sax/test/v2"]) / float64(n); math.Abs(got-0.75) > 0.02 {
+		t.Errorf("Share of v2 = %.3f after update, want 0.75", got)
+	}
+
+	// Versions with zero weight get no traffic.
+	split = map[string]int32{"/sax/test/v1": 0, "/sax/test/v2": 1}
+	counts = countPicks(100)
+	if counts["/sax/test/v2"] != 100 {
+		t.Errorf("Picked v2 %d times out of 100, want 100", counts["/sax/test/v2"])
+	}
+
+	// Weights summing beyond int32 keep their shares.
+	split = map[string]int32{"/sax/test/v1": math.MaxInt32, "/sax/test/v2": math.MaxInt32}
+	counts = countPicks(n)
+	if got := float64(counts["/sax/test/v2"]) / float64(n); math.Abs(got-0.5) > 0.03 {
+		t.Errorf("Share of v2 = %.3f with maximum weights, want 0.5", got)
+	}
+	if got := counts["/sax/test/v1"] + counts["/sax/test/v2"]; got != n {
+		t.Errorf("Picked known versions %d times with maximum weights, want %d", got, n)
+	}
+
+	// No split means no version is picked.
+	split = nil
+	if got := s.pick(ctx); got != "" {
+		t.Errorf("pick() = %q without a split, want empty", got)
+	}
+}
+
+func TestTrafficSplitFetchError(t *testing.T) {
+	ctx := context.Background()
+	var err error
+	s := newTrafficSplit(func(ctx context.Context) (map[string]int32, error) {
+		return map[string]int32{"/sax/test/v2": 1}, err
+	})
+	s.ttl = 0
+	if got := s.pick(ctx); got != "/sax/test/v2" {
+		t.Fatalf("pick() = %q, want %q", got, "/sax/test/v2")
+	}
+
+	// The last known split keeps being used while the admin server is unreachable.
+	err = errors.ErrUnavailable
+	if got := s.pick(ctx); got != "/sax/test/v2" {
+		t.Errorf("pick() = %q after a fetch error, want %q", got, "/sax/test/v2")
+	}
+}
+
+func TestTrafficSplitConcurrentFetch(t *testing.T) {
+	ctx := context.Background()
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	blocking := false
+	s := newTrafficSplit(func(ctx context.Context) (map[string]int32, error) {
+		if blocking {
+			entered <- struct{}{}
+			<-release
+		}
+		return map[string]int32{"/sax/test/v2": 1}, nil
+	})
+	s.ttl = 0
+	if got := s.pick(ctx); got != "/sax/test/v2" {
+		t.Fatalf("pick() = %q, want %q", got, "/sax/test/v2")
+	}
+
+	// Picks don't wait for a slow fetch in flight, using the last known split instead.
+	blocking = true
+	done := make(chan struct{})
+	go func() {
+		s.pick(ctx)
+		close(done)
+	}()
+	<-entered
+	if got := s.pick(ctx); got != "/sax/test/v2" {
+		t.Errorf("pick() = %q during a fetch, want %q", got, "/sax/test/v2")
+	}
+	close(release)
+	<-done
+}
+
+func TestFallbackChain(t *testing.T) {
+	ctx := context.Background()
+	replicas := map[string]int{"/sax/test/big": 0, "/sax/test/medium": 0, "/sax/test/small": 2}
+	c := newFallbackChain("/sax/test/big", []string{"/sax/test/medium", "/sax/test/small"}, func(ctx context.Context, id string) bool {
+		return replicas[id] > 0
+	})
+
+	if got := c.pick(ctx); got != "/sax/test/small" {
+		t.Errorf("pick() = %q, want the first fallback with replicas %q", got, "/sax/test/small")
+	}
+	replicas["/sax/test/medium"] = 1
+	if got := c.pick(ctx); got != "/sax/test/medium" {
+		t.Errorf("pick() = %q, want the first fallback with replicas %q", got, "/sax/test/medium")
+	}
+	replicas["/sax/test/big"] = 1
+	if got := c.pick(ctx); got != "" {
+		t.Errorf("pick() = %q with replicas of the primary model, want empty", got)
+	}
+	replicas = nil
+	if got := c.pick(ctx); got != "" {
+		t.Errorf("pick() = %q with no replicas anywhere, want empty", got)
+	}
+}
+
+func TestFallbackVersion(t *testing.T) {
+	ctx := context.Background()
+	m := &Model{
+		modelID: "/sax/test/big",
+		fallback: newFallbackChain("/sax/test/big", []string{"/sax/test/small"}, func(ctx context.Context, id string) bool {
+			return id == "/sax/test/small"
+		}),
+		versions: make(map[string]*Model),
+	}
+	got := m.version(ctx)
+	if got.modelID != "/sax/test/small" {
+		t.Fatalf("version() = %s, want /sax/test/small", got.modelID)
+	}
+	if got.fallback != nil || got.split != nil {
+		t.Errorf("version() returned a fallback model that falls back or splits traffic on its own")
+	}
+	if again := m.version(ctx); again != got {
+		t.Errorf("version() opened the fallback model again")
+	}
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	ctx := WithIdempotencyKey(context.Background(), "request-1")
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		t.Fatal("FromOutgoingContext() found no metadata, want some")
+	}
+	if got := md.Get(idempotencyKeyHeader); len(got) != 1 || got[0] != "request-1" {
+		t.Errorf("md.Get(%q) = %v, want [request-1]", idempotencyKeyHeader, got)
+	}
+}
+
+func TestDefaultRequestTimeout(t *testing.T) {
+	loads := 0
+	model := &Model{requestTimeout: newRequestTimeout(func(ctx context.Context) (time.Duration, error) {
+		loads++
+		return time.Minute, nil
+	})}
+
+	// The default applies to a context without a deadline.
+	ctx, cancel := model.withDefaultTimeout(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("withDefaultTimeout() returned no deadline, want one")
+	}
+	if left := time.Until(deadline); left <= 0 || left > time.Minute {
+		t.Errorf("withDefaultTimeout() deadline in %v, want within %v", left, time.Minute)
+	}
+
+	// An explicit deadline is kept, even if it is later than the default.
+	want := time.Now().Add(time.Hour)
+	explicit, cancelExplicit := context.WithDeadline(context.Background(), want)
+	defer cancelExplicit()
+	ctx, cancel = model.withDefaultTimeout(explicit)
+	defer cancel()
+	if got, _ := ctx.Deadline(); !got.Equal(want) {
+		t.Errorf("withDefaultTimeout() deadline = %v, want %v", got, want)
+	}
+
+	// The cell config is only loaded once within the TTL.
+	_, cancel = model.withDefaultTimeout(context.Background())
+	cancel()
+	if loads != 1 {
+		t.Errorf("Default request timeout loaded %d times, want 1", loads)
+	}
+
+	// And loaded again after it.
+	model.requestTimeout.ttl = 0
+	model.requestTimeout.next = time.Time{}
+	_, cancel = model.withDefaultTimeout(context.Background())
+	cancel()
+	if loads != 2 {
+		t.Errorf("Default request timeout loaded %d times after the TTL, want 2", loads)
+	}
+}
+
+func TestDefaultRequestTimeoutRetriesFailedLoads(t *testing.T) {
+	var err error
+	loads := 0
+	timeout := newRequestTimeout(func(ctx context.Context) (time.Duration, error) {
+		loads++
+		return time.Minute, err
+	})
+	timeout.retry = 0
+
+	// A failed load leaves no default, but doesn't stop later calls from loading it.
+	err = errors.ErrUnavailable
+	if got := timeout.get(context.Background()); got != 0 {
+		t.Errorf("get() after a failed load = %v, want 0", got)
+	}
+	err = nil
+	if got := timeout.get(context.Background()); got != time.Minute {
+		t.Errorf("get() after a successful retry = %v, want %v", got, time.Minute)
+	}
+
+	// A failed reload keeps the last known default.
+	err = errors.ErrUnavailable
+	timeout.next = time.Time{}
+	if got := timeout.get(context.Background()); got != time.Minute {
+		t.Errorf("get() after a failed reload = %v, want %v", got, time.Minute)
+	}
+	if loads != 3 {
+		t.Errorf("Default request timeout loaded %d times, want 3", loads)
+	}
+}
+
+func TestNoDefaultRequestTimeout(t *testing.T) {
+	model := &Model{requestTimeout: newRequestTimeout(func(ctx context.Context) (time.Duration, error) {
+		return 0, nil
+	})}
+	ctx, cancel := model.withDefaultTimeout(context.Background())
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); ok {
+		t.Errorf("withDefaultTimeout() deadline = %v, want none for a cell without a default", deadline)
+	}
+}
+
+// unavailableFactory fails every connection attempt with an unavailable error.
+type unavailableFactory struct {
+	attempts int
+}
+
+func (f *unavailableFactory) GetOrCreate(ctx context.Context) (*grpc.ClientConn, error) {
+	f.attempts++
+	return nil, errors.ErrUnavailable
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	embed, generate := "/sax/test-retry-policy/embed", "/sax/test-retry-policy/generate"
+	SetRetryPolicy(embed, RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond, RetryableCodes: []codes.Code{codes.Unavailable}})
+	SetRetryPolicy(generate, RetryPolicy{RetryableCodes: []codes.Code{}})
+	defer SetRetryPolicy(embed, RetryPolicy{})
+	defer SetRetryPolicy(generate, RetryPolicy{})
+
+	tests := []struct {
+		modelID      string
+		wantAttempts int
+	}{
+		{embed, 4},
+		{generate, 1},
+	}
+	for _, tc := range tests {
+		factory := &unavailableFactory{}
+		m := &Model{modelID: tc.modelID, connectionFactory: factory, retryingBehavior: errors.ServerShouldRetry}
+		err := m.run(ctx, "Test", func(conn *grpc.ClientConn) error { return nil })
+		if errors.Code(err) != codes.Unavailable {
+			t.Errorf("run(%s) error %v, want %v", tc.modelID, err, errors.ErrUnavailable)
+		}
+		if factory.attempts != tc.wantAttempts {
+			t.Errorf("run(%s) made %d attempts, want %d", tc.modelID, factory.attempts, tc.wantAttempts)
+		}
+	}
+
+	// Without a policy, the model retries until the context is done.
+	factory := &unavailableFactory{}
+	m := &Model{modelID: "/sax/test-retry-policy/other", connectionFactory: factory, retryingBehavior: errors.ServerShouldRetry}
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	m.run(ctx, "Test", func(conn *grpc.ClientConn) error { return nil })
+	if factory.attempts <= tests[0].wantAttempts {
+		t.Errorf("run() without a policy made %d attempts, want more than %d", factory.attempts, tests[0].wantAttempts)
+	}
+}
+
+func TestFallbackResponse(t *testing.T) {
+	ctx := context.Background()
+	var gotRequests []proto.Message
+	var gotErrs []error
+	fallback := func(ctx context.Context, request proto.Message, err error) (proto.Message, error) {
+		gotRequests = append(gotRequests, request)
+		gotErrs = append(gotErrs, err)
+		return &lmpb.GenerateResponse{Texts: []*lmpb.DecodedText{{Text: "canned"}}}, nil
+	}
+
+	// A model with no model server left answers with the fallback response.
+	unavailable := "/sax/test-fallback-response/unavailable"
+	SetRetryPolicy(unavailable, RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	defer SetRetryPolicy(unavailable, RetryPolicy{})
+	m := &Model{modelID: unavailable, connectionFactory: &unavailableFactory{}, retryingBehavior: errors.ServerShouldRetry, fallbackResponse: fallback}
+	got, err := m.LM().Generate(ctx, "hello")
+	if err != nil {
+		t.Fatalf("Generate() on an unavailable model error %v, want no error", err)
+	}
+	if len(got) != 1 || got[0].Text != "canned" {
+		t.Errorf("Generate() on an unavailable model = %v, want the fallback response", got)
+	}
+	if len(gotRequests) != 1 {
+		t.Fatalf("Fallback called %d times, want once", len(gotRequests))
+	}
+	if req, ok := gotRequests[0].(*lmpb.GenerateRequest); !ok || req.GetText() != "hello" {
+		t.Errorf("Fallback got request %v, want the Generate request", gotRequests[0])
+	}
+	if errors.Code(gotErrs[0]) != codes.Unavailable {
+		t.Errorf("Fallback got error %v, want %v", gotErrs[0], errors.ErrUnavailable)
+	}
+
+	// A response of the wrong type is ignored.
+	if _, err := m.LM().Embed(ctx, "hello"); errors.Code(err) != codes.Unavailable {
+		t.Errorf("Embed() with a mismatched fallback response error %v, want %v", err, errors.ErrUnavailable)
+	}
+
+	// Models that serve, or fail for other reasons, don't fall back.
+	saxCell := "/sax/test-fallback-response"
+	testutil.NewCluster(saxCell).Start(ctx, t)
+	model, err := Open(saxCell+"/lm", WithFallbackResponse(fallback))
+	if err != nil {
+		t.Fatalf("Open() error %v, want no error", err)
+	}
+	gotRequests = nil
+	if _, err := model.LM().Generate(ctx, "hello"); err != nil {
+		t.Errorf("Generate() error %v, want no error", err)
+	}
+	if _, err := model.LM().Generate(ctx, "bad-input"); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("Generate() with bad input error %v, want %v", err, errors.ErrInvalidArgument)
+	}
+	if len(gotRequests) != 0 {
+		t.Errorf("Fallback called %d times for an available model, want none", len(gotRequests))
+	}
+}
+
+func TestQueryCosts(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-query-costs"
+	testutil.NewCluster(saxCell).Start(ctx, t)
+	model, err := Open(saxCell + "/lm")
+	if err != nil {
+		t.Fatalf("Open() error %v, want no error", err)
+	}
+	lm := model.LM()
+
+	// The stub model server charges one TPU millisecond and one token per byte of text.
+	var cost QueryCost
+	if _, err := lm.Generate(ctx, "hello", WithQueryCost(&cost)); err != nil {
+		t.Fatalf("Generate() error %v, want no error", err)
+	}
+	if want := (QueryCost{TpuMs: 5, Tokens: 5}); cost != want {
+		t.Errorf("Generate() cost = %+v, want %+v", cost, want)
+	}
+	if _, err := lm.Embed(ctx, "abc"); err != nil {
+		t.Fatalf("Embed() error %v, want no error", err)
+	}
+	// Failed queries and queries whose server reports no cost are not counted.
+	if _, err := lm.Generate(ctx, "bad-input"); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("Generate() with bad input error %v, want %v", err, errors.ErrInvalidArgument)
+	}
+	if _, err := lm.Score(ctx, "ab", []string{"cd"}); err != nil {
+		t.Fatalf("Score() error %v, want no error", err)
+	}
+
+	if got, want := model.QueryCosts(), (QueryCostTotals{Queries: 2, TpuMs: 8, Tokens: 8}); got != want {
+		t.Errorf("QueryCosts() = %+v, want %+v", got, want)
+	}
+
+	// The totals are also exported as metrics.
+	for _, tc := range []struct {
+		v    *expvar.Map
+		want int64
+	}{
+		{queryCostQueriesVar, 2},
+		{queryCostTpuMsVar, 8},
+		{queryCostTokensVar, 8},
+	} {
+		if got := tc.v.Get(model.modelID); got == nil || got.(*expvar.Int).Value() != tc.want {
+			t.Errorf("Metric of model %s = %v, want %d", model.modelID, got, tc.want)
+		}
+	}
+}
+
+func TestQueryCostsOtherModels(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		modelType testutil.ModelType
+		query     func(m *Model) error
+	}{
+		{"vision", testutil.Vision, func(m *Model) error {
+			_, err := m.VM().Classify(ctx, []byte("hello"))
+			return err
+		}},
+		{"audio", testutil.Audio, func(m *Model) error {
+			_, err := m.AM().Recognize(ctx, []byte("hello"))
+			return err
+		}},
+		{"custom", testutil.Custom, func(m *Model) error {
+			_, err := m.CM().Custom(ctx, []byte("hello"), "method")
+			return err
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			saxCell := "/sax/test-query-costs-" + tc.name
+			testutil.NewCluster(saxCell).SetModelType(tc.modelType).Start(ctx, t)
+			model, err := Open(saxCell + "/model")
+			if err != nil {
+				t.Fatalf("Open() error %v, want no error", err)
+			}
+			if err := tc.query(model); err != nil {
+				t.Fatalf("Query error %v, want no error", err)
+			}
+			if got, want := model.QueryCosts(), (QueryCostTotals{Queries: 1, TpuMs: 5, Tokens: 5}); got != want {
+				t.Errorf("QueryCosts() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// fakeScorer scores each suffix with its length, and records the suffixes of each call.
+type fakeScorer struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (f *fakeScorer) score(ctx context.Context, prefix string, suffix []string, options ...ModelOptionSetter) ([]float64, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, suffix)
+	f.mu.Unlock()
+	logP := make([]float64, len(suffix))
+	for i, s := range suffix {
+		logP[i] = float64(len(prefix) + len(s))
+	}
+	return logP, nil
+}
+
+func (f *fakeScorer) numCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+func TestScoreBatcher(t *testing.T) {
+	ctx := context.Background()
+	scorer := &fakeScorer{}
+	b := newScoreBatcher(scorer.score, 100*time.Millisecond, 0)
+
+	// Concurrent calls with the same prefix within the window share one call, and each gets the
+	// scores of its own suffixes.
+	suffixes := [][]string{{"a"}, {"bb", "ccc"}, {"dddd"}}
+	var wg sync.WaitGroup
+	for _, suffix := range suffixes {
+		wg.Add(1)
+		go func(suffix []string) {
+			defer wg.Done()
+			logP, err := b.Score(ctx, "p", suffix)
+			if err != nil {
+				t.Errorf("Score(%v) error %v, want no error", suffix, err)
+				return
+			}
+			if len(logP) != len(suffix) {
+				t.Errorf("Score(%v) = %v, want %d scores", suffix, logP, len(suffix))
+				return
+			}
+			for i, s := range suffix {
+				if want := float64(1 + len(s)); logP[i] != want {
+					t.Errorf("Score(%v)[%d] = %v, want %v", suffix, i, logP[i], want)
+				}
+			}
+		}(suffix)
+	}
+	wg.Wait()
+	if got := scorer.numCalls(); got != 1 {
+		t.Errorf("Score calls for one batch = %d, want 1", got)
+	}
+
+	// Different prefixes or extra inputs aren't batched together.
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		b.Score(ctx, "q", []string{"a"})
+	}()
+	go func() {
+		defer wg.Done()
+		b.Score(ctx, "p", []string{"a"}, WithExtraInput("temperature", 2))
+	}()
+	wg.Wait()
+	if got := scorer.numCalls(); got != 3 {
+		t.Errorf("Score calls after 2 unrelated calls = %d, want 3", got)
+	}
+}
+
+func TestScoreBatcherIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	keys := map[int][]string{} // number of suffixes -> idempotency keys of the call
+	score := func(ctx context.Context, prefix string, suffix []string, options ...ModelOptionSetter) ([]float64, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		mu.Lock()
+		keys[len(suffix)] = md.Get(idempotencyKeyHeader)
+		mu.Unlock()
+		return make([]float64, len(suffix)), nil
+	}
+	b := newScoreBatcher(score, 100*time.Millisecond, 0)
+
+	// A call carrying an idempotency key is sent on its own with its key, while the others are
+	// batched without it.
+	var wg sync.WaitGroup
+	for _, key := range []string{"request-1", "", ""} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			ctx := context.Background()
+			if key != "" {
+				ctx = WithIdempotencyKey(ctx, key)
+			}
+			if _, err := b.Score(ctx, "p", []string{"a"}); err != nil {
+				t.Errorf("Score(key %q) error %v, want no error", key, err)
+			}
+		}(key)
+	}
+	wg.Wait()
+	if len(keys) != 2 {
+		t.Fatalf("Score calls by number of suffixes = %v, want a keyed call of 1 and a batch of 2", keys)
+	}
+	if got := keys[1]; len(got) != 1 || got[0] != "request-1" {
+		t.Errorf("Idempotency keys of the unbatched call = %v, want [request-1]", got)
+	}
+	if got := keys[2]; len(got) != 0 {
+		t.Errorf("Idempotency keys of the batched call = %v, want none", got)
+	}
+}
+
+func TestScoreBatcherFlushesEarly(t *testing.T) {
+	scorer := &fakeScorer{}
+	b := newScoreBatcher(scorer.score, time.Hour, 2)
+
+	// A call with a near deadline doesn't wait for the window.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := b.Score(ctx, "p", []string{"a"}); err != nil {
+		t.Fatalf("Score() with a deadline error %v, want no error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Score() with a 200ms deadline took %v, want it sent before the deadline", elapsed)
+	}
+
+	// A full batch is sent right away.
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Score(context.Background(), "p", []string{"a", "b"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Score() with a full batch error %v, want no error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Score() with a full batch didn't return, want it sent right away")
+	}
+	if got := scorer.numCalls(); got != 2 {
+		t.Errorf("Score calls = %d, want 2", got)
+	}
+}
+
+func TestRoutingSampler(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newRoutingSampler()
+	s.now = func() time.Time { return now }
+	count := func(calls int) int {
+		n := 0
+		for i := 0; i < calls; i++ {
+			if s.sample() {
+				n++
+			}
+		}
+		return n
+	}
+
+	if got := count(1000); got != 0 {
+		t.Errorf("Sampled %d calls by default, want 0", got)
+	}
+
+	// The sampling rate is honored within 10%, with a volume cap out of reach.
+	const calls, rate = 100000, 0.05
+	s.set(rate, calls)
+	got := count(calls)
+	if want := calls * rate; math.Abs(float64(got)-want) > want/10 {
+		t.Errorf("Sampled %d calls out of %d at rate %v, want about %v", got, calls, rate, want)
+	}
+
+	// The volume cap bounds how many calls are logged each second.
+	s.set(1, 5)
+	if got := count(100); got != 5 {
+		t.Errorf("Sampled %d calls within a second capped at 5, want 5", got)
+	}
+	now = now.Add(time.Second)
+	if got := count(100); got != 5 {
+		t.Errorf("Sampled %d calls within the next second capped at 5, want 5", got)
+	}
+
+	s.set(0, 5)
+	if got := count(100); got != 0 {
+		t.Errorf("Sampled %d calls with sampling turned off, want 0", got)
+	}
+}
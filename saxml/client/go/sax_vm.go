@@ -18,6 +18,7 @@ import (
 	"context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	pb "saxml/protobuf/vision_go_proto_grpc"
 	pbgrpc "saxml/protobuf/vision_go_proto_grpc"
@@ -46,22 +47,30 @@ func extractClassfyResponse(res *pb.ClassifyResponse) []ClassifyResult {
 
 // Classify performs classificiation for a serialized image (`imageBytes`) against a vision model.
 func (v *VisionModel) Classify(ctx context.Context, imageBytes []byte, options ...ModelOptionSetter) ([]ClassifyResult, error) {
+	model := v.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.ClassifyRequest{
-		ModelKey:    v.model.modelID,
+		ModelKey:    model.modelID,
 		ImageBytes:  imageBytes,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.ClassifyResponse
-	err := v.model.run(ctx, "Classify", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "Classify", func(conn *grpc.ClientConn) error {
 		var classifyErr error
-		resp, classifyErr = pbgrpc.NewVisionServiceClient(conn).Classify(ctx, req)
+		resp, classifyErr = pbgrpc.NewVisionServiceClient(conn).Classify(ctx, req, grpc.Trailer(&trailer))
 		return classifyErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := v.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	res := extractClassfyResponse(resp)
 	return res, nil
 }
@@ -83,22 +92,30 @@ func extractGeneratedImageResponse(res *pb.TextToImageResponse) []GeneratedImage
 
 // TextToImage generates a list of image (`imageBytes`) and log probability for a given text.
 func (v *VisionModel) TextToImage(ctx context.Context, text string, options ...ModelOptionSetter) ([]GeneratedImage, error) {
+	model := v.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.TextToImageRequest{
-		ModelKey:    v.model.modelID,
+		ModelKey:    model.modelID,
 		Text:        text,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.TextToImageResponse
-	err := v.model.run(ctx, "TextToImage", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "TextToImage", func(conn *grpc.ClientConn) error {
 		var textToImageErr error
-		resp, textToImageErr = pbgrpc.NewVisionServiceClient(conn).TextToImage(ctx, req)
+		resp, textToImageErr = pbgrpc.NewVisionServiceClient(conn).TextToImage(ctx, req, grpc.Trailer(&trailer))
 		return textToImageErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := v.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	res := extractGeneratedImageResponse(resp)
 	return res, nil
 }
@@ -115,45 +132,61 @@ func extractTextAndImageToImageResponse(res *pb.TextAndImageToImageResponse) []G
 // TextAndImageToImage generates a list of image (`imageBytes`) and log probability for a given
 // text and image.
 func (v *VisionModel) TextAndImageToImage(ctx context.Context, text string, imageBytes []byte, options ...ModelOptionSetter) ([]GeneratedImage, error) {
+	model := v.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.TextAndImageToImageRequest{
-		ModelKey:    v.model.modelID,
+		ModelKey:    model.modelID,
 		Text:        text,
 		ImageBytes:  imageBytes,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.TextAndImageToImageResponse
-	err := v.model.run(ctx, "TextAndImageToImage", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "TextAndImageToImage", func(conn *grpc.ClientConn) error {
 		var textAndImageToImageErr error
-		resp, textAndImageToImageErr = pbgrpc.NewVisionServiceClient(conn).TextAndImageToImage(ctx, req)
+		resp, textAndImageToImageErr = pbgrpc.NewVisionServiceClient(conn).TextAndImageToImage(ctx, req, grpc.Trailer(&trailer))
 		return textAndImageToImageErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := v.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	res := extractTextAndImageToImageResponse(resp)
 	return res, nil
 }
 
 // Embed performs embedding for an image as byte array.
 func (v *VisionModel) Embed(ctx context.Context, imageBytes []byte, options ...ModelOptionSetter) ([]float64, error) {
+	model := v.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.EmbedRequest{
-		ModelKey:    v.model.modelID,
+		ModelKey:    model.modelID,
 		ImageBytes:  imageBytes,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.EmbedResponse
-	err := v.model.run(ctx, "Embed", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "Embed", func(conn *grpc.ClientConn) error {
 		var sampleErr error
-		resp, sampleErr = pbgrpc.NewVisionServiceClient(conn).Embed(ctx, req)
+		resp, sampleErr = pbgrpc.NewVisionServiceClient(conn).Embed(ctx, req, grpc.Trailer(&trailer))
 		return sampleErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := v.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	return resp.GetEmbedding(), nil
 }
 
@@ -221,9 +254,12 @@ func extractBoundingBoxes(res *pb.DetectResponse) []DetectResult {
 
 // Detect performs detection for a serialized image (`imageBytes`) against a vision model.
 func (v *VisionModel) Detect(ctx context.Context, imageBytes []byte, text []string, boxes []BoundingBox, options ...ModelOptionSetter) ([]DetectResult, error) {
+	model := v.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.DetectRequest{
-		ModelKey:    v.model.modelID,
+		ModelKey:    model.modelID,
 		ImageBytes:  imageBytes,
 		Text:        text,
 		ExtraInputs: opts.ExtraInputs(),
@@ -231,14 +267,19 @@ func (v *VisionModel) Detect(ctx context.Context, imageBytes []byte, text []stri
 	insertBoundingBoxes(boxes, req)
 
 	var resp *pb.DetectResponse
-	err := v.model.run(ctx, "Detect", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "Detect", func(conn *grpc.ClientConn) error {
 		var detectErr error
-		resp, detectErr = pbgrpc.NewVisionServiceClient(conn).Detect(ctx, req)
+		resp, detectErr = pbgrpc.NewVisionServiceClient(conn).Detect(ctx, req, grpc.Trailer(&trailer))
 		return detectErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := v.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	res := extractBoundingBoxes(resp)
 	return res, nil
 }
@@ -260,23 +301,31 @@ func extractImageToTextResponse(res *pb.ImageToTextResponse) []ImageToTextResult
 
 // ImageToText performs captioning for a serialized image (`imageBytes`) against a vision model.
 func (v *VisionModel) ImageToText(ctx context.Context, imageBytes []byte, text string, options ...ModelOptionSetter) ([]ImageToTextResult, error) {
+	model := v.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.ImageToTextRequest{
-		ModelKey:    v.model.modelID,
+		ModelKey:    model.modelID,
 		ImageBytes:  imageBytes,
 		Text:        text,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.ImageToTextResponse
-	err := v.model.run(ctx, "ImageToText", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "ImageToText", func(conn *grpc.ClientConn) error {
 		var imageToTextErr error
-		resp, imageToTextErr = pbgrpc.NewVisionServiceClient(conn).ImageToText(ctx, req)
+		resp, imageToTextErr = pbgrpc.NewVisionServiceClient(conn).ImageToText(ctx, req, grpc.Trailer(&trailer))
 		return imageToTextErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := v.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	res := extractImageToTextResponse(resp)
 	return res, nil
 }
@@ -298,22 +347,30 @@ func extractImageToImageResponse(res *pb.ImageToImageResponse) []ImageToImageRes
 
 // ImageToImage returns images for a serialized image (`imageBytes`) against a vision model.
 func (v *VisionModel) ImageToImage(ctx context.Context, imageBytes []byte, options ...ModelOptionSetter) ([]ImageToImageResult, error) {
+	model := v.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.ImageToImageRequest{
-		ModelKey:    v.model.modelID,
+		ModelKey:    model.modelID,
 		ImageBytes:  imageBytes,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.ImageToImageResponse
-	err := v.model.run(ctx, "ImageToImage", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "ImageToImage", func(conn *grpc.ClientConn) error {
 		var ImageToImageErr error
-		resp, ImageToImageErr = pbgrpc.NewVisionServiceClient(conn).ImageToImage(ctx, req)
+		resp, ImageToImageErr = pbgrpc.NewVisionServiceClient(conn).ImageToImage(ctx, req, grpc.Trailer(&trailer))
 		return ImageToImageErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := v.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	res := extractImageToImageResponse(resp)
 	return res, nil
 }
@@ -338,23 +395,31 @@ func extractVideoToTextResponse(res *pb.VideoToTextResponse) []VideoToTextResult
 // - 'imageFrames' is a list of bytes where each element is a serialized image frame.
 // - 'text' is the (optional) prefix text for prefix decoding.
 func (v *VisionModel) VideoToText(ctx context.Context, imageFrames [][]byte, text string, options ...ModelOptionSetter) ([]VideoToTextResult, error) {
+	model := v.model.version(ctx)
+	ctx, cancel := model.withDefaultTimeout(ctx)
+	defer cancel()
 	opts := NewModelOptions(options...)
 	req := &pb.VideoToTextRequest{
-		ModelKey:    v.model.modelID,
+		ModelKey:    model.modelID,
 		ImageFrames: imageFrames,
 		Text:        text,
 		ExtraInputs: opts.ExtraInputs(),
 	}
 
 	var resp *pb.VideoToTextResponse
-	err := v.model.run(ctx, "VideoToText", func(conn *grpc.ClientConn) error {
+	var trailer metadata.MD
+	err := model.run(ctx, "VideoToText", func(conn *grpc.ClientConn) error {
 		var videoToTextErr error
-		resp, videoToTextErr = pbgrpc.NewVisionServiceClient(conn).VideoToText(ctx, req)
+		resp, videoToTextErr = pbgrpc.NewVisionServiceClient(conn).VideoToText(ctx, req, grpc.Trailer(&trailer))
 		return videoToTextErr
 	})
+	resp, err = orFallback(ctx, model, req, resp, err)
 	if err != nil {
 		return nil, err
 	}
+	if err := v.model.extractQueryCost(opts, &trailer); err != nil {
+		return nil, err
+	}
 	res := extractVideoToTextResponse(resp)
 	return res, nil
 }
@@ -0,0 +1,315 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package saxtest runs a Sax cell inside a test process, for integration tests of services that
+// depend on Sax. The cell has a real admin server and fake model servers whose responses the test
+// programs:
+//
+//	cell := saxtest.NewCell("/sax/test-myservice").AddLanguageModelServer(&saxtest.LanguageModel{
+//		Generate: func(ctx context.Context, text string) ([]sax.GenerateResult, error) {
+//			return []sax.GenerateResult{{Text: "hello", Score: -0.1}}, nil
+//		},
+//	})
+//	cell.Start(ctx, t)
+//	if err := cell.Publish(ctx, "/sax/test-myservice/lm", 1); err != nil { ... }
+//	model, err := cell.Open("/sax/test-myservice/lm")
+//
+// Everything is torn down when the test ends. Tests in the same process should use different Sax
+// cells.
+//
+// Tests of resilience features can make a standby admin server take over the cell with Failover,
+// then wait for model servers and clients to follow it with WaitForConvergence.
+package saxtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"saxml/admin/admin"
+	"saxml/client/go/sax"
+	"saxml/client/go/saxadmin"
+	"saxml/common/addr"
+	"saxml/common/errors"
+	"saxml/common/ipaddr"
+	"saxml/common/location"
+	"saxml/common/platform/env"
+	"saxml/common/testutil"
+
+	apb "saxml/protobuf/admin_go_proto_grpc"
+	lmpb "saxml/protobuf/lm_go_proto_grpc"
+	lmgrpc "saxml/protobuf/lm_go_proto_grpc"
+)
+
+const (
+	// ModelPath is the model path Publish publishes models with. Fake model servers can serve it.
+	ModelPath = "saxtest.FakeLanguageModel"
+
+	checkpointPath = "None"
+
+	// How often WaitForConvergence checks whether the cell has converged.
+	convergencePollPeriod = 100 * time.Millisecond
+)
+
+// LanguageModel programs the responses of a fake language model server. Requests to methods left
+// nil fail with an Unimplemented error.
+type LanguageModel struct {
+	// Score returns the score of each suffix following prefix.
+	Score func(ctx context.Context, prefix string, suffixes []string) ([]float64, error)
+	// Generate returns the texts generated from text.
+	Generate func(ctx context.Context, text string) ([]sax.GenerateResult, error)
+	// Embed returns the embedding of text.
+	Embed func(ctx context.Context, text string) ([]float64, error)
+}
+
+// lmServer serves a LanguageModel over gRPC.
+type lmServer struct {
+	lm *LanguageModel
+}
+
+func (s *lmServer) Score(ctx context.Context, in *lmpb.ScoreRequest) (*lmpb.ScoreResponse, error) {
+	if s.lm.Score == nil {
+		return nil, fmt.Errorf("Score not programmed: %w", errors.ErrUnimplemented)
+	}
+	logP, err := s.lm.Score(ctx, in.GetPrefix(), in.GetSuffix())
+	if err != nil {
+		return nil, err
+	}
+	return &lmpb.ScoreResponse{Logp: logP}, nil
+}
+
+func (s *lmServer) Generate(ctx context.Context, in *lmpb.GenerateRequest) (*lmpb.GenerateResponse, error) {
+	if s.lm.Generate == nil {
+		return nil, fmt.Errorf("Generate not programmed: %w", errors.ErrUnimplemented)
+	}
+	results, err := s.lm.Generate(ctx, in.GetText())
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]*lmpb.DecodedText, len(results))
+	for i, result := range results {
+		texts[i] = &lmpb.DecodedText{Text: result.Text, Score: result.Score}
+	}
+	return &lmpb.GenerateResponse{Texts: texts}, nil
+}
+
+func (s *lmServer) GenerateStream(in *lmpb.GenerateRequest, stream lmgrpc.LMService_GenerateStreamServer) error {
+	return fmt.Errorf("GenerateStream not supported by fake model servers: %w", errors.ErrUnimplemented)
+}
+
+func (s *lmServer) Embed(ctx context.Context, in *lmpb.EmbedRequest) (*lmpb.EmbedResponse, error) {
+	if s.lm.Embed == nil {
+		return nil, fmt.Errorf("Embed not programmed: %w", errors.ErrUnimplemented)
+	}
+	embedding, err := s.lm.Embed(ctx, in.GetText())
+	if err != nil {
+		return nil, err
+	}
+	return &lmpb.EmbedResponse{Embedding: embedding}, nil
+}
+
+func (s *lmServer) Gradient(ctx context.Context, in *lmpb.GradientRequest) (*lmpb.GradientResponse, error) {
+	return nil, fmt.Errorf("Gradient not supported by fake model servers: %w", errors.ErrUnimplemented)
+}
+
+// Cell is a Sax cell running in the test process.
+type Cell struct {
+	saxCell string
+	lms     []*LanguageModel
+
+	// modelAddrs are the addresses of the model servers, set by Start.
+	modelAddrs []string
+	// adminServer is the admin server leading the cell, listening on adminPort. It is nil after a
+	// failed Failover.
+	adminServer *admin.Server
+	adminPort   int
+}
+
+// NewCell creates a cell named saxCell, e.g. "/sax/test-myservice". Add model servers to it before
+// calling Start.
+func NewCell(saxCell string) *Cell {
+	return &Cell{saxCell: saxCell}
+}
+
+// AddLanguageModelServer adds a fake model server answering language model requests with lm.
+// Model servers added share lm, so a model published with several replicas answers the same way
+// on all of them.
+func (c *Cell) AddLanguageModelServer(lm *LanguageModel) *Cell {
+	c.lms = append(c.lms, lm)
+	return c
+}
+
+// Start creates the cell in a temporary directory, starts its admin server and model servers, and
+// joins the model servers to the admin server. They are all closed when the test ends.
+func (c *Cell) Start(ctx context.Context, t *testing.T) {
+	t.Helper()
+	testutil.SetUp(ctx, t, c.saxCell, "")
+
+	for _, lm := range c.lms {
+		port, err := env.Get().PickUnusedPort()
+		if err != nil {
+			t.Fatalf("Start failed: pick model port error: %v", err)
+		}
+		closer, err := testutil.StartLanguageModelServer(port, &lmServer{lm: lm})
+		if err != nil {
+			t.Fatalf("Start failed: start model server error: %v", err)
+		}
+		t.Cleanup(func() { close(closer) })
+		c.modelAddrs = append(c.modelAddrs, fmt.Sprintf("localhost:%d", port))
+	}
+
+	adminPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("Start failed: pick admin port error: %v", err)
+	}
+	c.adminServer, c.adminPort = admin.NewServer(c.saxCell, adminPort), adminPort
+	if err := c.adminServer.Start(ctx); err != nil {
+		t.Fatalf("Start failed: start admin server error: %v", err)
+	}
+	// Registered after the model servers' cleanups, so it runs before them. It closes whichever
+	// admin server leads the cell when the test ends.
+	t.Cleanup(func() {
+		if c.adminServer != nil {
+			c.adminServer.Close()
+		}
+	})
+
+	specs := &apb.ModelServer{
+		ChipType:           apb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       apb.ModelServer_CHIP_TOPOLOGY_1X1,
+		ServableModelPaths: []string{ModelPath},
+	}
+	for _, addr := range c.modelAddrs {
+		// Join right away, so models can be published as soon as Start returns.
+		req := &apb.JoinRequest{Address: addr, DataAddress: addr, ModelServer: specs}
+		if _, err := c.adminServer.Join(ctx, req); err != nil {
+			t.Fatalf("Start failed: join model server %v error: %v", addr, err)
+		}
+		// Keep joining like real model servers do, following the admin server address.
+		joiner := location.NewJoiner(addr, "", addr, specs, 0)
+		if err := joiner.Join(ctx, c.saxCell); err != nil {
+			t.Fatalf("Start failed: watch admin address for model server %v error: %v", addr, err)
+		}
+		t.Cleanup(joiner.Leave)
+	}
+}
+
+// Failover kills the admin server leading the cell and starts a standby admin server on a new
+// port, which becomes the leader and writes its address to the cell location file. It returns once
+// the standby leads the cell. Model servers and clients follow the new leader on their own; use
+// WaitForConvergence to wait until they have.
+func (c *Cell) Failover(ctx context.Context) error {
+	if c.adminServer == nil {
+		return fmt.Errorf("no admin server leads cell %s: %w", c.saxCell, errors.ErrFailedPrecondition)
+	}
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		return fmt.Errorf("pick standby admin port error: %w", err)
+	}
+	standby := admin.NewServer(c.saxCell, port)
+	started := make(chan error, 1)
+	// Start blocks until the standby becomes the leader, which happens once the leader is gone.
+	go func() { started <- standby.Start(ctx) }()
+
+	c.adminServer.Close()
+	c.adminServer = nil
+	if err := <-started; err != nil {
+		return fmt.Errorf("standby admin server failed to take over cell %s: %w", c.saxCell, err)
+	}
+	c.adminServer, c.adminPort = standby, port
+	return nil
+}
+
+// WaitForConvergence waits until the cell has fully recovered from a change of admin server: the
+// location file points at the current leader, every model server has joined it, and each model in
+// numReplicas is reported ready with that many replicas to clients, which must have re-resolved
+// the admin server address to see it. It returns an error if ctx is done before then.
+func (c *Cell) WaitForConvergence(ctx context.Context, numReplicas map[string]int) error {
+	if c.adminServer == nil {
+		return fmt.Errorf("no admin server leads cell %s: %w", c.saxCell, errors.ErrFailedPrecondition)
+	}
+	leader := net.JoinHostPort(ipaddr.MyIPAddr().String(), strconv.Itoa(c.adminPort))
+	converged := func() error {
+		got, err := addr.FetchAddr(ctx, c.saxCell)
+		if err != nil {
+			return err
+		}
+		if got != leader {
+			return fmt.Errorf("location file points at %s, want the leader %s", got, leader)
+		}
+		joined, err := c.adminServer.Mgr.LocateAll()
+		if err != nil {
+			return err
+		}
+		if len(joined) != len(c.modelAddrs) {
+			return fmt.Errorf("%d of %d model servers joined the leader", len(joined), len(c.modelAddrs))
+		}
+		return nil
+	}
+	ticker := time.NewTicker(convergencePollPeriod)
+	defer ticker.Stop()
+	for {
+		err := converged()
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cell %s did not converge, last %v: %w", c.saxCell, err, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	// Assign models to the model servers that rejoined right away.
+	c.adminServer.Mgr.Refresh(ctx)
+	for modelID, n := range numReplicas {
+		if err := c.Admin().WaitForReady(ctx, modelID, n); err != nil {
+			return fmt.Errorf("model %s not ready after failover: %w", modelID, err)
+		}
+	}
+	return nil
+}
+
+// Publish publishes a model served by numReplicas fake model servers, and waits until they have
+// loaded it.
+func (c *Cell) Publish(ctx context.Context, modelID string, numReplicas int) error {
+	if err := c.Admin().Publish(ctx, modelID, ModelPath, checkpointPath, numReplicas, nil); err != nil {
+		return err
+	}
+	// Assign the model right away instead of at the next periodic refresh.
+	c.adminServer.Mgr.Refresh(ctx)
+	return c.Admin().WaitForReady(ctx, modelID, numReplicas)
+}
+
+// Unpublish unpublishes a model.
+func (c *Cell) Unpublish(ctx context.Context, modelID string) error {
+	if err := c.Admin().Unpublish(ctx, modelID); err != nil {
+		return err
+	}
+	c.adminServer.Mgr.Refresh(ctx)
+	return nil
+}
+
+// Open returns a client of a model published in the cell.
+func (c *Cell) Open(modelID string, options ...sax.OptionSetter) (*sax.Model, error) {
+	return sax.Open(modelID, options...)
+}
+
+// Admin returns an admin client of the cell.
+func (c *Cell) Admin() *saxadmin.Admin {
+	return saxadmin.Open(c.saxCell)
+}
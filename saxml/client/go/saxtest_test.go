@@ -0,0 +1,187 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saxtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"saxml/client/go/sax"
+	"saxml/client/go/saxadmin"
+	"saxml/client/go/saxtest"
+	"saxml/common/errors"
+	_ "saxml/common/platform/register" // registers a platform
+)
+
+func TestPublishAndServe(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-saxtest"
+	modelID := saxCell + "/lm"
+
+	var gotTexts []string
+	cell := saxtest.NewCell(saxCell).AddLanguageModelServer(&saxtest.LanguageModel{
+		Generate: func(ctx context.Context, text string) ([]sax.GenerateResult, error) {
+			gotTexts = append(gotTexts, text)
+			return []sax.GenerateResult{{Text: text + " world", Score: -0.5}}, nil
+		},
+	})
+	cell.Start(ctx, t)
+
+	if err := cell.Publish(ctx, modelID, 1); err != nil {
+		t.Fatalf("Publish(%s) error %v, want no error", modelID, err)
+	}
+	model, err := cell.Open(modelID)
+	if err != nil {
+		t.Fatalf("Open(%s) error %v, want no error", modelID, err)
+	}
+
+	got, err := model.LM().Generate(ctx, "hello")
+	if err != nil {
+		t.Fatalf("Generate() error %v, want no error", err)
+	}
+	want := []sax.GenerateResult{{Text: "hello world", Score: -0.5}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Generate() unexpected diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"hello"}, gotTexts); diff != "" {
+		t.Errorf("Texts received by the model server unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Methods the test didn't program fail.
+	if _, err := model.LM().Score(ctx, "hello", []string{"world"}); errors.Code(err) != codes.Unimplemented {
+		t.Errorf("Score() error %v, want %v", err, errors.ErrUnimplemented)
+	}
+}
+
+func TestWatchModel(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-watchmodel"
+	watched, other := saxCell+"/watched", saxCell+"/other"
+	cell := saxtest.NewCell(saxCell).
+		AddLanguageModelServer(&saxtest.LanguageModel{}).
+		AddLanguageModelServer(&saxtest.LanguageModel{})
+	cell.Start(ctx, t)
+	if err := cell.Publish(ctx, watched, 1); err != nil {
+		t.Fatalf("Publish(%s) error %v, want no error", watched, err)
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan *saxadmin.WatchResult)
+	done := make(chan error, 1)
+	go func() { done <- cell.Admin().WatchModel(watchCtx, watched, results) }()
+	next := func(desc string) *saxadmin.WatchResult {
+		t.Helper()
+		select {
+		case res := <-results:
+			return res
+		case err := <-done:
+			t.Fatalf("%s: WatchModel(%s) ended with error %v, want a result", desc, watched, err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: no result from WatchModel(%s) in time", desc, watched)
+		}
+		return nil
+	}
+
+	// The first result is the full set of servers.
+	snapshot := next("snapshot")
+	if snapshot.Result.Data == nil {
+		t.Fatalf("First WatchModel(%s) result has no full set, want one", watched)
+	}
+	servers := snapshot.Result.Data
+	servers.Apply(snapshot.Result.Log)
+	if got := servers.Size(); got != 1 {
+		t.Errorf("First WatchModel(%s) result has %d servers, want 1", watched, got)
+	}
+
+	// Changes to other models are not delivered.
+	if err := cell.Publish(ctx, other, 1); err != nil {
+		t.Fatalf("Publish(%s) error %v, want no error", other, err)
+	}
+	select {
+	case res := <-results:
+		t.Errorf("WatchModel(%s) got result %+v after publishing %s, want none", watched, res.Result, other)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Changes to the watched model are, and the stream ends once it is unpublished.
+	if err := cell.Unpublish(ctx, watched); err != nil {
+		t.Fatalf("Unpublish(%s) error %v, want no error", watched, err)
+	}
+	for {
+		select {
+		case res := <-results:
+			t.Logf("WatchModel(%s) result after unpublishing: %+v", watched, res.Result)
+			continue
+		case err := <-done:
+			if errors.Code(err) != codes.NotFound {
+				t.Errorf("WatchModel(%s) after unpublishing error %v, want %v", watched, err, codes.NotFound)
+			}
+		case <-time.After(10 * time.Second):
+			t.Errorf("WatchModel(%s) still running 10s after unpublishing", watched)
+		}
+		break
+	}
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-failover"
+	modelID := saxCell + "/lm"
+	cell := saxtest.NewCell(saxCell)
+	for i := 0; i < 2; i++ {
+		cell.AddLanguageModelServer(&saxtest.LanguageModel{
+			Generate: func(ctx context.Context, text string) ([]sax.GenerateResult, error) {
+				return []sax.GenerateResult{{Text: text + " world"}}, nil
+			},
+		})
+	}
+	cell.Start(ctx, t)
+	if err := cell.Publish(ctx, modelID, 2); err != nil {
+		t.Fatalf("Publish(%s) error %v, want no error", modelID, err)
+	}
+	model, err := cell.Open(modelID)
+	if err != nil {
+		t.Fatalf("Open(%s) error %v, want no error", modelID, err)
+	}
+	if _, err := model.LM().Generate(ctx, "hello"); err != nil {
+		t.Fatalf("Generate() before failover error %v, want no error", err)
+	}
+
+	if err := cell.Failover(ctx); err != nil {
+		t.Fatalf("Failover() error %v, want no error", err)
+	}
+	convergeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := cell.WaitForConvergence(convergeCtx, map[string]int{modelID: 2}); err != nil {
+		t.Fatalf("WaitForConvergence() error %v, want no error", err)
+	}
+
+	// The new leader remembers the published model, and the model client opened before the
+	// failover keeps serving.
+	if _, err := cell.Admin().List(ctx, modelID); err != nil {
+		t.Errorf("List(%s) after failover error %v, want no error", modelID, err)
+	}
+	got, err := model.LM().Generate(ctx, "hello")
+	if err != nil {
+		t.Fatalf("Generate() after failover error %v, want no error", err)
+	}
+	if want := []sax.GenerateResult{{Text: "hello world"}}; !cmp.Equal(want, got) {
+		t.Errorf("Generate() after failover = %v, want %v", got, want)
+	}
+}
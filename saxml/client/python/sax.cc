@@ -245,6 +245,9 @@ PYBIND11_MODULE(sax, m) {
 
   m.def("StartDebugPort", &sax::client::pybind::StartDebugPort);
 
+  m.def("SetTransportFiles", &sax::client::pybind::SetTransportFiles,
+        py::arg("cert_file"), py::arg("key_file"), py::arg("ca_file"));
+
   py::class_<sax::client::AdminOptions>(m, "AdminOptions")
       .def(py::init<>())
       .def("__copy__",
@@ -104,6 +104,7 @@ def List(id: str, options: AdminOptions = ...) -> tuple[str,str,int]: ...
 def ListAll(id: str, options: AdminOptions = ...) -> list[str]: ...
 def ListDetail(id: str, options: AdminOptions = ...) -> ModelDetail: ...
 def Publish(id: str, model_path: str, checkpoint_path: str, num_replicas: int, overrides: Optional[dict[str,str]] = ..., options: AdminOptions = ...) -> None: ...
+def SetTransportFiles(cert_file: str, key_file: str, ca_file: str) -> None: ...
 def StartDebugPort(arg0: int) -> None: ...
 def Stats(id: str, options: AdminOptions = ...) -> list[ModelServerTypeStat]: ...
 def Unpublish(id: str, options: AdminOptions = ...) -> None: ...
@@ -522,6 +522,12 @@ MultimodalModel Model::MM() { return MultimodalModel(base_, status_); }
 
 void StartDebugPort(int port) { ::sax::client::StartDebugPort(port); }
 
+absl::Status SetTransportFiles(absl::string_view cert_file,
+                               absl::string_view key_file,
+                               absl::string_view ca_file) {
+  return ::sax::client::SetTransportFiles(cert_file, key_file, ca_file);
+}
+
 absl::Status Publish(
     absl::string_view id, absl::string_view model_path,
     absl::string_view checkpoint_path, int num_replicas,
@@ -256,6 +256,10 @@ class Model {
 
 void StartDebugPort(int port);
 
+absl::Status SetTransportFiles(absl::string_view cert_file,
+                               absl::string_view key_file,
+                               absl::string_view ca_file);
+
 absl::Status Publish(
     absl::string_view id, absl::string_view model_path,
     absl::string_view checkpoint_path, int num_replicas,
@@ -17,16 +17,24 @@ package addr
 
 import (
 	"context"
+	"expvar"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"time"
 
 	log "github.com/golang/glog"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/proto"
 	"saxml/common/cell"
+	"saxml/common/cellcrypt"
+	"saxml/common/config"
 	"saxml/common/errors"
 	"saxml/common/ipaddr"
+	"saxml/common/naming"
 	"saxml/common/platform/env"
 	pb "saxml/protobuf/admin_go_proto_grpc"
 )
@@ -38,47 +46,213 @@ const (
 	// LocationFileInitialContent is the initial content of the location file before any admin server
 	// has run in this cell.
 	LocationFileInitialContent = "No admin server has been started for this Sax cell."
+	// MaxLocationSize is the largest location file size in bytes readers accept. Real location
+	// files are well under 1 KiB; anything larger is corrupted.
+	MaxLocationSize = 4096
+	// DefaultLocationReplicas is the number of copies of the location file kept in a location
+	// directory, including the primary, unless the cell config sets location_replicas.
+	DefaultLocationReplicas = 3
 )
 
-// ParseAddr reads the admin server address from bytes.
-func ParseAddr(bytes []byte) (string, error) {
+var (
+	// The number of location file replicas that admin servers failed to write, exported for alerts.
+	// Readers only fall back to replicas written successfully.
+	locationReplicaErrorsVar = expvar.NewInt("sax_location_replica_write_errors")
+)
+
+// LocationReplicas returns the number of copies of the location file kept in each location
+// directory of the Sax cell stored in a cell directory, including the primary. It comes from the
+// cell config, so admin servers, model servers, and clients of a cell all agree on it.
+func LocationReplicas(ctx context.Context, path string) int {
+	cfg, err := config.LoadCachedFromDir(ctx, path)
+	if err != nil {
+		// Cells created without a config still have the default number of replicas.
+		log.V(2).Infof("Using %d location replicas for %s: %v", DefaultLocationReplicas, path, err)
+		return DefaultLocationReplicas
+	}
+	if n := int(cfg.GetLocationReplicas()); n > 0 {
+		return n
+	}
+	return DefaultLocationReplicas
+}
+
+// LocationFiles returns the paths of all location file replicas in a cell directory. The first
+// one is the primary, which is the one admin servers lead on.
+func LocationFiles(ctx context.Context, path string) []string {
+	return locationFiles(path, LocationReplicas(ctx, path))
+}
+
+// ShardLocationFiles returns the paths of all location file replicas of an admin shard in a cell
+// directory. Shard 0 uses the cell's location files, so a cell with one shard is laid out the same
+// as one without sharding.
+func ShardLocationFiles(ctx context.Context, path string, shard int) []string {
+	n := LocationReplicas(ctx, path)
+	if shard == 0 {
+		return locationFiles(path, n)
+	}
+	return locationFiles(filepath.Join(path, fmt.Sprintf("shard%d", shard)), n)
+}
+
+func locationFiles(dir string, n int) []string {
+	fnames := []string{filepath.Join(dir, LocationFile)}
+	for i := 1; i < n; i++ {
+		fnames = append(fnames, filepath.Join(dir, fmt.Sprintf("%s.%d", LocationFile, i)))
+	}
+	return fnames
+}
+
+// ShardOf returns the admin shard owning a model, given its ID (e.g. /sax/test/foo) and the number
+// of shards in the cell.
+func ShardOf(modelID string, numShards int) int {
+	if numShards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(modelID))
+	return int(h.Sum32() % uint32(numShards))
+}
+
+// parseLocation parses the content of a location file.
+//
+// Oversized, truncated, or otherwise garbled content, e.g. from storage corruption or a reader
+// racing a non-atomic write, is reported as ErrUnavailable, which callers retry as if no admin
+// server were elected yet.
+func parseLocation(ctx context.Context, bytes []byte) (*pb.Location, error) {
+	if len(bytes) > MaxLocationSize {
+		return nil, fmt.Errorf("location of %d bytes is larger than %d bytes: %w", len(bytes), MaxLocationSize, errors.ErrUnavailable)
+	}
+	bytes, err := cellcrypt.Open(ctx, bytes)
+	if err != nil {
+		// Readers can't tell a wrong key from garbled ciphertext, so treat both as corruption.
+		if errors.Code(err) == codes.DataLoss {
+			return nil, fmt.Errorf("malformed location: %v: %w", err, errors.ErrUnavailable)
+		}
+		return nil, err
+	}
 	location := &pb.Location{}
 	if err := proto.Unmarshal(bytes, location); err != nil {
-		return "", err
+		return nil, fmt.Errorf("malformed location: %v: %w", err, errors.ErrUnavailable)
 	}
 	addr := location.GetLocation()
 	// Return failed precondition errors below for unrecoverable errors. Because ErrFailedPrecondition
 	// is not in adminRetryCodes, the client will return the error to the user instead of retrying.
 	if addr == "" {
-		return "", fmt.Errorf("got an empty location: %w", errors.ErrFailedPrecondition)
+		return nil, fmt.Errorf("got an empty location: %w", errors.ErrFailedPrecondition)
 	}
 	if addr == LocationFileInitialContent {
-		return "", fmt.Errorf("no admin server has ever run: %w", errors.ErrFailedPrecondition)
+		return nil, fmt.Errorf("no admin server has ever run: %w", errors.ErrFailedPrecondition)
+	}
+	if err := ValidateHostPort(addr); err != nil {
+		return nil, fmt.Errorf("malformed location: %v: %w", err, errors.ErrUnavailable)
 	}
-	return addr, nil
+	return location, nil
 }
 
-// FetchAddr fetches the admin server address for a Sax cell.
-func FetchAddr(ctx context.Context, saxCell string) (string, error) {
-	if err := cell.Exists(ctx, saxCell); err != nil {
-		return "", err
+// ValidateHostPort checks that hostPort is a "host:port" network address, where host is a host
+// name or an IP address, in brackets if it is IPv6, and port is a port number.
+func ValidateHostPort(hostPort string) error {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v: %w", hostPort, err, errors.ErrInvalidArgument)
 	}
-	path, err := cell.Path(ctx, saxCell)
+	if host == "" {
+		return fmt.Errorf("invalid address %q: missing host: %w", hostPort, errors.ErrInvalidArgument)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("invalid address %q: bad port %q: %w", hostPort, port, errors.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// ParseAddr reads the admin server address from the content of a location file.
+func ParseAddr(ctx context.Context, bytes []byte) (string, error) {
+	location, err := parseLocation(ctx, bytes)
 	if err != nil {
 		return "", err
 	}
-	fname := filepath.Join(path, LocationFile)
+	return location.GetLocation(), nil
+}
 
-	bytes, err := env.Get().ReadCachedFile(ctx, fname)
+// FetchAddr fetches the admin server address for a Sax cell, or its shard 0 if it is sharded.
+func FetchAddr(ctx context.Context, saxCell string) (string, error) {
+	return FetchShardAddr(ctx, saxCell, 0)
+}
+
+// FetchShardAddr fetches the address of an admin shard for a Sax cell.
+func FetchShardAddr(ctx context.Context, saxCell string, shard int) (string, error) {
+	location, err := fetchLocation(ctx, saxCell, shard)
 	if err != nil {
 		return "", err
 	}
-	addr, err := ParseAddr(bytes)
+	return location.GetLocation(), nil
+}
+
+// NumShards returns the number of admin shards in a Sax cell, as advertised by shard 0.
+func NumShards(ctx context.Context, saxCell string) (int, error) {
+	location, err := fetchLocation(ctx, saxCell, 0)
+	if err != nil {
+		return 0, err
+	}
+	if n := int(location.GetNumShards()); n > 1 {
+		return n, nil
+	}
+	return 1, nil
+}
+
+// FetchModelAddr fetches the address of the admin shard owning a model in a Sax cell.
+func FetchModelAddr(ctx context.Context, saxCell, modelID string) (string, error) {
+	n, err := NumShards(ctx, saxCell)
 	if err != nil {
 		return "", err
 	}
-	log.Infof("FetchAddr %s %q", fname, addr)
-	return addr, nil
+	return FetchShardAddr(ctx, saxCell, ShardOf(modelID, n))
+}
+
+func fetchLocation(ctx context.Context, saxCell string, shard int) (*pb.Location, error) {
+	if err := cell.Exists(ctx, saxCell); err != nil {
+		return nil, err
+	}
+	path, err := cell.Path(ctx, saxCell)
+	if err != nil {
+		return nil, err
+	}
+	return freshestLocation(ctx, ShardLocationFiles(ctx, path, shard))
+}
+
+// freshestLocation returns the readable copy among fnames with the highest epoch, preferring
+// earlier files among equals, so that a replica an admin server failed to update never wins over
+// a newer copy. If none is readable, it returns the error for the first file, i.e. the primary.
+func freshestLocation(ctx context.Context, fnames []string) (*pb.Location, error) {
+	var freshest *pb.Location
+	var freshestName string
+	var firstErr error
+	for _, fname := range fnames {
+		location, err := fetchLocationFromFile(ctx, fname)
+		if err != nil {
+			log.V(2).Infof("FetchAddr %s error: %v", fname, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if freshest == nil || location.GetEpoch() > freshest.GetEpoch() {
+			freshest, freshestName = location, fname
+		}
+	}
+	if freshest == nil {
+		return nil, firstErr
+	}
+	log.Infof("FetchAddr %s %q", freshestName, freshest.GetLocation())
+	return freshest, nil
+}
+
+func fetchLocationFromFile(ctx context.Context, fname string) (*pb.Location, error) {
+	// Location files are read on every admin RPC a client makes, so use the cached read.
+	bytes, err := env.Get().ReadCachedFile(ctx, fname)
+	if err != nil {
+		return nil, err
+	}
+	return parseLocation(ctx, bytes)
 }
 
 // SetAddr makes this task the admin server for a Sax cell. This function blocks until it
@@ -88,6 +262,15 @@ func FetchAddr(ctx context.Context, saxCell string) (string, error) {
 // In tests, users should arrange to call SetAddr (directly or by creating an admin server) before
 // FetchAddr is called anywhere.
 func SetAddr(ctx context.Context, port int, saxCell string) (chan<- struct{}, error) {
+	return SetShardAddr(ctx, port, saxCell, 0, 1)
+}
+
+// SetShardAddr is like SetAddr, but makes this task the admin server for one of numShards shards
+// of a Sax cell. Every shard leads on its own location file.
+func SetShardAddr(ctx context.Context, port int, saxCell string, shard, numShards int) (chan<- struct{}, error) {
+	if shard < 0 || shard >= numShards {
+		return nil, fmt.Errorf("shard %d out of %d shards: %w", shard, numShards, errors.ErrInvalidArgument)
+	}
 	if err := cell.Exists(ctx, saxCell); err != nil {
 		return nil, err
 	}
@@ -95,21 +278,194 @@ func SetAddr(ctx context.Context, port int, saxCell string) (chan<- struct{}, er
 	if err != nil {
 		return nil, err
 	}
-	fname := filepath.Join(path, LocationFile)
+	fnames := ShardLocationFiles(ctx, path, shard)
+	fname := fnames[0]
+	if shard > 0 {
+		if err := env.Get().CreateDir(ctx, filepath.Dir(fname), ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// If the platform supports it, block until this process becomes the leader.
+	closer, err := env.Get().Lead(ctx, fname)
+	if err != nil {
+		return nil, err
+	}
 
 	addr := net.JoinHostPort(ipaddr.MyIPAddr().String(), strconv.Itoa(port))
-	location := &pb.Location{Location: addr}
+	location := &pb.Location{Location: addr, Epoch: nextEpoch(ctx, fnames)}
+	if numShards > 1 {
+		location.Shard = int32(shard)
+		location.NumShards = int32(numShards)
+	}
 	content, err := proto.Marshal(location)
+	if err == nil {
+		content, err = cellcrypt.Seal(ctx, content)
+	}
 	if err != nil {
+		close(closer)
 		return nil, err
 	}
 
-	// If the platform supports it, block until this process becomes the leader.
-	closer, err := env.Get().Lead(ctx, fname)
+	// Write the replicas before the primary, so that readers notified of a change to the primary
+	// see consistent replicas. A replica write failure is not fatal, as long as the primary is
+	// written, but it is counted in sax_location_replica_write_errors: readers can't fall back to
+	// the replica until the next admin server writes it.
+	for _, replica := range fnames[1:] {
+		if err := env.Get().WriteFile(ctx, replica, "", content); err != nil {
+			locationReplicaErrorsVar.Add(1)
+			log.Errorf("SetAddr failed to write location replica %s: %v", replica, err)
+		}
+	}
+	log.Infof("SetAddr %s %q epoch %d", fname, addr, location.GetEpoch())
+	return closer, env.Get().WriteFile(ctx, fname, "", content)
+}
+
+// nextEpoch returns an epoch for a new leader of the location files fnames: the current time in
+// nanoseconds, or one more than the highest epoch of any readable copy if that is later, e.g.
+// because of clock skew between admin servers.
+func nextEpoch(ctx context.Context, fnames []string) int64 {
+	epoch := time.Now().UnixNano()
+	for _, fname := range fnames {
+		// Bypass the cache to see the previous leader's last write.
+		bytes, err := env.Get().ReadFile(ctx, fname)
+		if err != nil {
+			continue
+		}
+		if location, err := parseLocation(ctx, bytes); err == nil && location.GetEpoch() >= epoch {
+			epoch = location.GetEpoch() + 1
+		}
+	}
+	return epoch
+}
+
+// WatchShardLocation subscribes to location updates of an admin shard of a Sax cell, if the
+// platform supports it. Every time any location file replica changes, the freshest readable copy
+// is sent if it is newer than the last one sent, so watchers keep seeing admin server changes
+// while the primary copy is missing or corrupted, and never go back to an older admin server.
+func WatchShardLocation(ctx context.Context, saxCell string, shard int) (<-chan *pb.Location, error) {
+	path, err := cell.Path(ctx, saxCell)
 	if err != nil {
 		return nil, err
 	}
+	fnames := ShardLocationFiles(ctx, path, shard)
+	var watches []<-chan []byte
+	for _, fname := range fnames {
+		updates, err := env.Get().Watch(ctx, fname)
+		if err != nil {
+			return nil, err
+		}
+		watches = append(watches, updates)
+	}
 
-	log.Infof("SetAddr %s %q", fname, addr)
-	return closer, env.Get().WriteFile(ctx, fname, "", content)
+	// Coalesce changes to any replica into one signal to re-read them all.
+	changed := make(chan struct{}, 1)
+	for _, updates := range watches {
+		go func(updates <-chan []byte) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case _, ok := <-updates:
+					if !ok {
+						return
+					}
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}(updates)
+	}
+
+	locations := make(chan *pb.Location)
+	go func() {
+		var last *pb.Location
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+			location, err := freshestLocation(ctx, fnames)
+			if err != nil {
+				log.V(2).Infof("WatchShardLocation %v shard %d error: %v", saxCell, shard, err)
+				continue
+			}
+			// Copies written before epochs existed all have epoch 0, so tell them apart by address.
+			if last != nil && (location.GetEpoch() < last.GetEpoch() || location.GetEpoch() == last.GetEpoch() && location.GetLocation() == last.GetLocation()) {
+				continue
+			}
+			select {
+			case locations <- location:
+				last = location
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return locations, nil
+}
+
+// CellAmbiguity describes a Sax cell name found under several root directories with different
+// admin servers.
+type CellAmbiguity struct {
+	// SaxCell is the cell name, e.g. /sax/test.
+	SaxCell string
+	// Addrs maps each root the cell exists under to the address of its admin server, or "" if none
+	// is readable.
+	Addrs map[string]string
+}
+
+// FindAmbiguousCells lists the Sax cells under each of roots, e.g. the root directories of several
+// storage backends, and returns the cells that exist under more than one root with different admin
+// server addresses, sorted by name. Clients configured with different roots would reach different
+// admin servers for the same cell name. Every ambiguity found is also logged as a warning. Roots
+// without any Sax cells are ignored.
+func FindAmbiguousCells(ctx context.Context, roots []string) ([]CellAmbiguity, error) {
+	addrs := make(map[string]map[string]string) // cell name -> root -> admin address
+	for _, root := range roots {
+		dir := filepath.Join(root, naming.Prefix)
+		exist, err := env.Get().DirExists(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !exist {
+			continue
+		}
+		cells, err := env.Get().ListSubdirs(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range cells {
+			saxCell := "/" + naming.Prefix + "/" + name
+			if _, err := naming.SaxCellToCell(saxCell); err != nil {
+				continue
+			}
+			addr := ""
+			if location, err := freshestLocation(ctx, LocationFiles(ctx, filepath.Join(dir, name))); err == nil {
+				addr = location.GetLocation()
+			}
+			if addrs[saxCell] == nil {
+				addrs[saxCell] = make(map[string]string)
+			}
+			addrs[saxCell][root] = addr
+		}
+	}
+
+	var ambiguities []CellAmbiguity
+	for saxCell, byRoot := range addrs {
+		distinct := make(map[string]bool)
+		for _, addr := range byRoot {
+			distinct[addr] = true
+		}
+		if len(distinct) < 2 {
+			continue
+		}
+		log.Warningf("Sax cell %s resolves to different admin servers across roots: %v", saxCell, byRoot)
+		ambiguities = append(ambiguities, CellAmbiguity{SaxCell: saxCell, Addrs: byRoot})
+	}
+	sort.Slice(ambiguities, func(i, j int) bool { return ambiguities[i].SaxCell < ambiguities[j].SaxCell })
+	return ambiguities, nil
 }
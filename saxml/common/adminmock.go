@@ -0,0 +1,308 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package adminmock provides an in-process admin service client for unit testing code that calls
+// the admin server, without running one.
+package adminmock
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"saxml/common/errors"
+
+	pb "saxml/protobuf/admin_go_proto_grpc"
+	pbgrpc "saxml/protobuf/admin_go_proto_grpc"
+)
+
+// Client implements the admin service client interface with programmable responses.
+//
+// Every call is recorded. A call to a method with a non-nil handler returns what the handler
+// returns; otherwise it returns an empty response and no error. Streaming calls are not recorded
+// and fail with Unimplemented unless their handler is set. Set handlers before the client is
+// used concurrently.
+//
+// E.g.,
+//
+//	client := adminmock.New()
+//	client.JoinFunc = func(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse, error) {
+//	  return nil, errors.ErrUnavailable
+//	}
+//	codeUnderTest(client)
+//	reqs := client.Requests()
+type Client struct {
+	PublishFunc                func(ctx context.Context, in *pb.PublishRequest) (*pb.PublishResponse, error)
+	PublishStagedFunc          func(ctx context.Context, in *pb.PublishStagedRequest) (*pb.PublishStagedResponse, error)
+	SimulatePlacementFunc      func(ctx context.Context, in *pb.SimulatePlacementRequest) (*pb.SimulatePlacementResponse, error)
+	UpdateFunc                 func(ctx context.Context, in *pb.UpdateRequest) (*pb.UpdateResponse, error)
+	UnpublishFunc              func(ctx context.Context, in *pb.UnpublishRequest) (*pb.UnpublishResponse, error)
+	ListFunc                   func(ctx context.Context, in *pb.ListRequest) (*pb.ListResponse, error)
+	StatsFunc                  func(ctx context.Context, in *pb.StatsRequest) (*pb.StatsResponse, error)
+	WatchLocFunc               func(ctx context.Context, in *pb.WatchLocRequest) (*pb.WatchLocResponse, error)
+	WaitForReadyFunc           func(ctx context.Context, in *pb.WaitForReadyRequest) (*pb.WaitForReadyResponse, error)
+	ApproveScaleFunc           func(ctx context.Context, in *pb.ApproveScaleRequest) (*pb.ApproveScaleResponse, error)
+	OverrideConstraintsFunc    func(ctx context.Context, in *pb.OverrideConstraintsRequest) (*pb.OverrideConstraintsResponse, error)
+	SetReplicasForFunc         func(ctx context.Context, in *pb.SetReplicasForRequest) (*pb.SetReplicasForResponse, error)
+	ServingReadyFunc           func(ctx context.Context, in *pb.ServingReadyRequest) (*pb.ServingReadyResponse, error)
+	PromoteWarmFunc            func(ctx context.Context, in *pb.PromoteWarmRequest) (*pb.PromoteWarmResponse, error)
+	DrainServerFunc            func(ctx context.Context, in *pb.DrainServerRequest) (*pb.DrainServerResponse, error)
+	EvacuateLabelFunc          func(ctx context.Context, in *pb.EvacuateLabelRequest) (*pb.EvacuateLabelResponse, error)
+	ListIncarnationsFunc       func(ctx context.Context, in *pb.ListIncarnationsRequest) (*pb.ListIncarnationsResponse, error)
+	PurgeStaleIncarnationsFunc func(ctx context.Context, in *pb.PurgeStaleIncarnationsRequest) (*pb.PurgeStaleIncarnationsResponse, error)
+	GetEffectiveConfigFunc     func(ctx context.Context, in *pb.GetEffectiveConfigRequest) (*pb.GetEffectiveConfigResponse, error)
+	JoinFunc                   func(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse, error)
+	UploadBlobFunc             func(ctx context.Context) (pbgrpc.Admin_UploadBlobClient, error)
+	WatchModelFunc             func(ctx context.Context, in *pb.WatchModelRequest) (pbgrpc.Admin_WatchModelClient, error)
+	ExportAuditFunc            func(ctx context.Context, in *pb.ExportAuditRequest) (pbgrpc.Admin_ExportAuditClient, error)
+
+	mu       sync.Mutex
+	requests []proto.Message
+}
+
+var _ pbgrpc.AdminClient = (*Client)(nil)
+
+// New creates a client that returns empty responses to all calls.
+func New() *Client {
+	return &Client{}
+}
+
+// Requests returns copies of all requests received so far, in call order.
+func (c *Client) Requests() []proto.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reqs := make([]proto.Message, len(c.requests))
+	copy(reqs, c.requests)
+	return reqs
+}
+
+// Reset forgets all recorded requests.
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = nil
+}
+
+func (c *Client) record(in proto.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, proto.Clone(in))
+}
+
+// Publish implements the admin service client interface.
+func (c *Client) Publish(ctx context.Context, in *pb.PublishRequest, opts ...grpc.CallOption) (*pb.PublishResponse, error) {
+	c.record(in)
+	if c.PublishFunc != nil {
+		return c.PublishFunc(ctx, in)
+	}
+	return &pb.PublishResponse{}, nil
+}
+
+// PublishStaged implements the admin service client interface.
+func (c *Client) PublishStaged(ctx context.Context, in *pb.PublishStagedRequest, opts ...grpc.CallOption) (*pb.PublishStagedResponse, error) {
+	c.record(in)
+	if c.PublishStagedFunc != nil {
+		return c.PublishStagedFunc(ctx, in)
+	}
+	return &pb.PublishStagedResponse{}, nil
+}
+
+// SimulatePlacement implements the admin service client interface.
+func (c *Client) SimulatePlacement(ctx context.Context, in *pb.SimulatePlacementRequest, opts ...grpc.CallOption) (*pb.SimulatePlacementResponse, error) {
+	c.record(in)
+	if c.SimulatePlacementFunc != nil {
+		return c.SimulatePlacementFunc(ctx, in)
+	}
+	return &pb.SimulatePlacementResponse{}, nil
+}
+
+// Update implements the admin service client interface.
+func (c *Client) Update(ctx context.Context, in *pb.UpdateRequest, opts ...grpc.CallOption) (*pb.UpdateResponse, error) {
+	c.record(in)
+	if c.UpdateFunc != nil {
+		return c.UpdateFunc(ctx, in)
+	}
+	return &pb.UpdateResponse{}, nil
+}
+
+// Unpublish implements the admin service client interface.
+func (c *Client) Unpublish(ctx context.Context, in *pb.UnpublishRequest, opts ...grpc.CallOption) (*pb.UnpublishResponse, error) {
+	c.record(in)
+	if c.UnpublishFunc != nil {
+		return c.UnpublishFunc(ctx, in)
+	}
+	return &pb.UnpublishResponse{}, nil
+}
+
+// List implements the admin service client interface.
+func (c *Client) List(ctx context.Context, in *pb.ListRequest, opts ...grpc.CallOption) (*pb.ListResponse, error) {
+	c.record(in)
+	if c.ListFunc != nil {
+		return c.ListFunc(ctx, in)
+	}
+	return &pb.ListResponse{}, nil
+}
+
+// Stats implements the admin service client interface.
+func (c *Client) Stats(ctx context.Context, in *pb.StatsRequest, opts ...grpc.CallOption) (*pb.StatsResponse, error) {
+	c.record(in)
+	if c.StatsFunc != nil {
+		return c.StatsFunc(ctx, in)
+	}
+	return &pb.StatsResponse{}, nil
+}
+
+// WatchLoc implements the admin service client interface.
+func (c *Client) WatchLoc(ctx context.Context, in *pb.WatchLocRequest, opts ...grpc.CallOption) (*pb.WatchLocResponse, error) {
+	c.record(in)
+	if c.WatchLocFunc != nil {
+		return c.WatchLocFunc(ctx, in)
+	}
+	return &pb.WatchLocResponse{}, nil
+}
+
+// WaitForReady implements the admin service client interface.
+func (c *Client) WaitForReady(ctx context.Context, in *pb.WaitForReadyRequest, opts ...grpc.CallOption) (*pb.WaitForReadyResponse, error) {
+	c.record(in)
+	if c.WaitForReadyFunc != nil {
+		return c.WaitForReadyFunc(ctx, in)
+	}
+	return &pb.WaitForReadyResponse{}, nil
+}
+
+// ApproveScale implements the admin service client interface.
+func (c *Client) ApproveScale(ctx context.Context, in *pb.ApproveScaleRequest, opts ...grpc.CallOption) (*pb.ApproveScaleResponse, error) {
+	c.record(in)
+	if c.ApproveScaleFunc != nil {
+		return c.ApproveScaleFunc(ctx, in)
+	}
+	return &pb.ApproveScaleResponse{}, nil
+}
+
+// OverrideConstraints implements the admin service client interface.
+func (c *Client) OverrideConstraints(ctx context.Context, in *pb.OverrideConstraintsRequest, opts ...grpc.CallOption) (*pb.OverrideConstraintsResponse, error) {
+	c.record(in)
+	if c.OverrideConstraintsFunc != nil {
+		return c.OverrideConstraintsFunc(ctx, in)
+	}
+	return &pb.OverrideConstraintsResponse{Override: in.GetOverride()}, nil
+}
+
+// SetReplicasFor implements the admin service client interface.
+func (c *Client) SetReplicasFor(ctx context.Context, in *pb.SetReplicasForRequest, opts ...grpc.CallOption) (*pb.SetReplicasForResponse, error) {
+	c.record(in)
+	if c.SetReplicasForFunc != nil {
+		return c.SetReplicasForFunc(ctx, in)
+	}
+	return &pb.SetReplicasForResponse{Bump: &pb.ReplicaBump{NumReplicas: in.GetNumReplicas()}}, nil
+}
+
+// ServingReady implements the admin service client interface.
+func (c *Client) ServingReady(ctx context.Context, in *pb.ServingReadyRequest, opts ...grpc.CallOption) (*pb.ServingReadyResponse, error) {
+	c.record(in)
+	if c.ServingReadyFunc != nil {
+		return c.ServingReadyFunc(ctx, in)
+	}
+	return &pb.ServingReadyResponse{}, nil
+}
+
+// PromoteWarm implements the admin service client interface.
+func (c *Client) PromoteWarm(ctx context.Context, in *pb.PromoteWarmRequest, opts ...grpc.CallOption) (*pb.PromoteWarmResponse, error) {
+	c.record(in)
+	if c.PromoteWarmFunc != nil {
+		return c.PromoteWarmFunc(ctx, in)
+	}
+	return &pb.PromoteWarmResponse{}, nil
+}
+
+// DrainServer implements the admin service client interface.
+func (c *Client) DrainServer(ctx context.Context, in *pb.DrainServerRequest, opts ...grpc.CallOption) (*pb.DrainServerResponse, error) {
+	c.record(in)
+	if c.DrainServerFunc != nil {
+		return c.DrainServerFunc(ctx, in)
+	}
+	return &pb.DrainServerResponse{}, nil
+}
+
+// EvacuateLabel implements the admin service client interface.
+func (c *Client) EvacuateLabel(ctx context.Context, in *pb.EvacuateLabelRequest, opts ...grpc.CallOption) (*pb.EvacuateLabelResponse, error) {
+	c.record(in)
+	if c.EvacuateLabelFunc != nil {
+		return c.EvacuateLabelFunc(ctx, in)
+	}
+	return &pb.EvacuateLabelResponse{}, nil
+}
+
+// ListIncarnations implements the admin service client interface.
+func (c *Client) ListIncarnations(ctx context.Context, in *pb.ListIncarnationsRequest, opts ...grpc.CallOption) (*pb.ListIncarnationsResponse, error) {
+	c.record(in)
+	if c.ListIncarnationsFunc != nil {
+		return c.ListIncarnationsFunc(ctx, in)
+	}
+	return &pb.ListIncarnationsResponse{}, nil
+}
+
+// PurgeStaleIncarnations implements the admin service client interface.
+func (c *Client) PurgeStaleIncarnations(ctx context.Context, in *pb.PurgeStaleIncarnationsRequest, opts ...grpc.CallOption) (*pb.PurgeStaleIncarnationsResponse, error) {
+	c.record(in)
+	if c.PurgeStaleIncarnationsFunc != nil {
+		return c.PurgeStaleIncarnationsFunc(ctx, in)
+	}
+	return &pb.PurgeStaleIncarnationsResponse{}, nil
+}
+
+// GetEffectiveConfig implements the admin service client interface.
+func (c *Client) GetEffectiveConfig(ctx context.Context, in *pb.GetEffectiveConfigRequest, opts ...grpc.CallOption) (*pb.GetEffectiveConfigResponse, error) {
+	c.record(in)
+	if c.GetEffectiveConfigFunc != nil {
+		return c.GetEffectiveConfigFunc(ctx, in)
+	}
+	return &pb.GetEffectiveConfigResponse{}, nil
+}
+
+// Join implements the admin service client interface.
+func (c *Client) Join(ctx context.Context, in *pb.JoinRequest, opts ...grpc.CallOption) (*pb.JoinResponse, error) {
+	c.record(in)
+	if c.JoinFunc != nil {
+		return c.JoinFunc(ctx, in)
+	}
+	return &pb.JoinResponse{}, nil
+}
+
+// UploadBlob implements the admin service client interface.
+func (c *Client) UploadBlob(ctx context.Context, opts ...grpc.CallOption) (pbgrpc.Admin_UploadBlobClient, error) {
+	if c.UploadBlobFunc != nil {
+		return c.UploadBlobFunc(ctx)
+	}
+	return nil, errors.ErrUnimplemented
+}
+
+// WatchModel implements the admin service client interface.
+func (c *Client) WatchModel(ctx context.Context, in *pb.WatchModelRequest, opts ...grpc.CallOption) (pbgrpc.Admin_WatchModelClient, error) {
+	c.record(in)
+	if c.WatchModelFunc != nil {
+		return c.WatchModelFunc(ctx, in)
+	}
+	return nil, errors.ErrUnimplemented
+}
+
+// ExportAudit implements the admin service client interface.
+func (c *Client) ExportAudit(ctx context.Context, in *pb.ExportAuditRequest, opts ...grpc.CallOption) (pbgrpc.Admin_ExportAuditClient, error) {
+	c.record(in)
+	if c.ExportAuditFunc != nil {
+		return c.ExportAuditFunc(ctx, in)
+	}
+	return nil, errors.ErrUnimplemented
+}
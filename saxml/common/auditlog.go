@@ -0,0 +1,83 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auditlog implements eventlog.Logger by keeping the most recent events in memory, with
+// the time they were logged, so they can be exported later, and passing them on to another logger.
+package auditlog
+
+import (
+	"sync"
+	"time"
+
+	"saxml/common/eventlog"
+)
+
+// Record is an event kept by Logger.
+type Record struct {
+	Time time.Time
+	Type eventlog.Type
+	Args []any
+}
+
+// Logger is an implementation of eventlog.Logger that keeps the last events for export.
+type Logger struct {
+	next eventlog.Logger
+
+	mu sync.Mutex
+	// A ring buffer of up to cap(records) records, the oldest at records[head] once it is full.
+	records []Record
+	head    int
+}
+
+// New returns a Logger that keeps up to capacity events and passes all events on to next.
+func New(next eventlog.Logger, capacity int) *Logger {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Logger{next: next, records: make([]Record, 0, capacity)}
+}
+
+// Log records an event, dropping the oldest one if the logger is full, and passes it on.
+func (l *Logger) Log(eventType eventlog.Type, args ...any) {
+	l.mu.Lock()
+	// Taking the time under the lock keeps records in chronological order.
+	r := Record{Time: time.Now(), Type: eventType, Args: args}
+	if len(l.records) < cap(l.records) {
+		l.records = append(l.records, r)
+	} else {
+		l.records[l.head] = r
+		l.head = (l.head + 1) % len(l.records)
+	}
+	l.mu.Unlock()
+	l.next.Log(eventType, args...)
+}
+
+// Export returns the kept events logged at or after since and before until, oldest first.
+func (l *Logger) Export(since, until time.Time) []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var records []Record
+	for i := range l.records {
+		r := l.records[(l.head+i)%len(l.records)]
+		if !r.Time.Before(since) && r.Time.Before(until) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// Close closes the next logger. Kept events can still be exported.
+func (l *Logger) Close() {
+	l.next.Close()
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"saxml/common/auditlog"
+	"saxml/common/basiceventlogger"
+	"saxml/common/eventlog"
+)
+
+// exported returns the first argument of the events l exports between since and until.
+func exported(l *auditlog.Logger, since, until time.Time) []int {
+	var got []int
+	for _, r := range l.Export(since, until) {
+		got = append(got, r.Args[0].(int))
+	}
+	return got
+}
+
+// logSpaced logs events with the given arguments, returning the time just before each one. Events
+// are spaced out so that they are never logged at the same time.
+func logSpaced(l *auditlog.Logger, args ...int) []time.Time {
+	var times []time.Time
+	for _, arg := range args {
+		time.Sleep(time.Millisecond)
+		times = append(times, time.Now())
+		l.Log(eventlog.Deploy, arg)
+	}
+	return times
+}
+
+func TestExportRange(t *testing.T) {
+	l := auditlog.New(basiceventlogger.New(), 10)
+	defer l.Close()
+	times := logSpaced(l, 0, 1, 2, 3, 4)
+	end := time.Now()
+
+	tests := []struct {
+		desc         string
+		since, until time.Time
+		want         []int
+	}{
+		{"everything", time.Time{}, end, []int{0, 1, 2, 3, 4}},
+		{"middle", times[1], times[3], []int{1, 2}},
+		{"since is inclusive", times[4], end, []int{4}},
+		{"until is exclusive", time.Time{}, times[0], nil},
+		{"empty", times[3], times[1], nil},
+	}
+	for _, tc := range tests {
+		if diff := cmp.Diff(tc.want, exported(l, tc.since, tc.until)); diff != "" {
+			t.Errorf("%s: Export() unexpected diff (-want +got):\n%s", tc.desc, diff)
+		}
+	}
+}
+
+func TestExportDropsOldest(t *testing.T) {
+	l := auditlog.New(basiceventlogger.New(), 3)
+	defer l.Close()
+	logSpaced(l, 0, 1, 2, 3, 4)
+
+	records := l.Export(time.Time{}, time.Now())
+	if diff := cmp.Diff([]int{2, 3, 4}, exported(l, time.Time{}, time.Now())); diff != "" {
+		t.Errorf("Export() after overflowing unexpected diff (-want +got):\n%s", diff)
+	}
+	for i := 1; i < len(records); i++ {
+		if !records[i-1].Time.Before(records[i].Time) {
+			t.Errorf("Export() records %d and %d logged at %v and %v, want chronological order", i-1, i, records[i-1].Time, records[i].Time)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blob stores large model config blobs in a Sax cell, addressed by their content digest:
+//
+//	Blob path := <Sax cell path>/blobs/<sha256 hex>
+//
+// Clients upload blobs to the admin server compressed and in chunks, so a blob can be larger than
+// the maximum gRPC message size. Model servers read blobs directly from the cell storage.
+package blob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"saxml/common/cell"
+	"saxml/common/errors"
+	"saxml/common/platform/env"
+)
+
+const (
+	// MaxSize is the maximum size of a blob, before and after compression.
+	MaxSize = 256 << 20
+
+	// ChunkSize is the size of each compressed chunk clients send, well below the default maximum
+	// gRPC message size.
+	ChunkSize = 1 << 20
+
+	digestPrefix = "sha256:"
+	blobsDir     = "blobs"
+)
+
+// Digest returns the content digest of a blob.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return digestPrefix + hex.EncodeToString(sum[:])
+}
+
+// Path returns the path of a blob in a Sax cell.
+func Path(ctx context.Context, saxCell, digest string) (string, error) {
+	sum := strings.TrimPrefix(digest, digestPrefix)
+	if len(sum) == len(digest) || len(sum) != 2*sha256.Size {
+		return "", fmt.Errorf("invalid blob digest %q: %w", digest, errors.ErrInvalidArgument)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", fmt.Errorf("invalid blob digest %q: %w", digest, errors.ErrInvalidArgument)
+	}
+	path, err := cell.Path(ctx, saxCell)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, blobsDir, sum), nil
+}
+
+// Exists returns nil if and only if the blob with the given digest is stored in a Sax cell.
+func Exists(ctx context.Context, saxCell, digest string) error {
+	path, err := Path(ctx, saxCell, digest)
+	if err != nil {
+		return err
+	}
+	exist, err := env.Get().FileExists(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("blob %s not found in %s: %w", digest, saxCell, errors.ErrNotFound)
+	}
+	return nil
+}
+
+// Write stores a blob in a Sax cell and returns its digest. Writing the same blob again is a no-op.
+func Write(ctx context.Context, saxCell string, data []byte) (string, error) {
+	digest := Digest(data)
+	path, err := Path(ctx, saxCell, digest)
+	if err != nil {
+		return "", err
+	}
+	if exist, err := env.Get().FileExists(ctx, path); err == nil && exist {
+		return digest, nil
+	}
+	if err := env.Get().CreateDir(ctx, filepath.Dir(path), ""); err != nil {
+		return "", err
+	}
+	if err := env.Get().WriteFileAtomically(ctx, path, data); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// Read returns the content of a blob stored in a Sax cell, after verifying it against its digest.
+func Read(ctx context.Context, saxCell, digest string) ([]byte, error) {
+	path, err := Path(ctx, saxCell, digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := env.Get().ReadFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if got := Digest(data); got != digest {
+		return nil, fmt.Errorf("blob %s has digest %s: %w", path, got, errors.ErrDataLoss)
+	}
+	return data, nil
+}
+
+// Compress compresses a blob for upload.
+func Compress(data []byte) ([]byte, error) {
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("blob size %d exceeds %d: %w", len(data), MaxSize, errors.ErrResourceExhausted)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Receive assembles a compressed blob from chunks returned by recv until it returns io.EOF,
+// stores the blob in a Sax cell, and returns its digest.
+func Receive(ctx context.Context, saxCell string, recv func() ([]byte, error)) (string, error) {
+	var compressed bytes.Buffer
+	for {
+		chunk, err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if compressed.Len()+len(chunk) > MaxSize {
+			return "", fmt.Errorf("compressed blob exceeds %d bytes: %w", MaxSize, errors.ErrResourceExhausted)
+		}
+		compressed.Write(chunk)
+	}
+
+	r, err := gzip.NewReader(&compressed)
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed blob: %v: %w", err, errors.ErrInvalidArgument)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed blob: %v: %w", err, errors.ErrInvalidArgument)
+	}
+	if len(data) > MaxSize {
+		return "", fmt.Errorf("blob exceeds %d bytes: %w", MaxSize, errors.ErrResourceExhausted)
+	}
+	return Write(ctx, saxCell, data)
+}
@@ -0,0 +1,149 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bufferedeventlogger implements eventlog.Logger by passing events to another logger
+// through a bounded buffer, so that a stuck logger can't block its callers or grow without bound.
+package bufferedeventlogger
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"saxml/common/eventlog"
+)
+
+// Policy decides what Log does when the buffer is full.
+type Policy int
+
+const (
+	// DropOldest drops the oldest buffered event to make room for the new one.
+	DropOldest Policy = iota
+	// Block waits up to the block timeout for room in the buffer, then drops the new event.
+	Block
+)
+
+// droppedEventsVar counts the events dropped by all Loggers in this process.
+var droppedEventsVar = expvar.NewInt("sax_dropped_events")
+
+type event struct {
+	eventType eventlog.Type
+	args      []any
+}
+
+// Logger is an implementation of eventlog.Logger that buffers events for another logger.
+type Logger struct {
+	next         eventlog.Logger
+	policy       Policy
+	blockTimeout time.Duration
+
+	events chan event
+	stop   chan struct{}
+	once   sync.Once
+
+	mu      sync.Mutex
+	dropped int64
+}
+
+// New returns a Logger that buffers up to capacity events for next, applying policy when the
+// buffer is full. blockTimeout is only used by the Block policy.
+func New(next eventlog.Logger, capacity int, policy Policy, blockTimeout time.Duration) *Logger {
+	if capacity < 1 {
+		capacity = 1
+	}
+	l := &Logger{
+		next:         next,
+		policy:       policy,
+		blockTimeout: blockTimeout,
+		events:       make(chan event, capacity),
+		stop:         make(chan struct{}),
+	}
+	go l.drain()
+	return l
+}
+
+func (l *Logger) drain() {
+	for {
+		select {
+		case <-l.stop:
+			return
+		case e := <-l.events:
+			l.next.Log(e.eventType, e.args...)
+		}
+	}
+}
+
+// drop counts a dropped event, logging a warning when the count reaches a power of two.
+func (l *Logger) drop(eventType eventlog.Type) {
+	l.mu.Lock()
+	l.dropped++
+	dropped := l.dropped
+	l.mu.Unlock()
+	droppedEventsVar.Add(1)
+	if dropped&(dropped-1) == 0 {
+		log.Warningf("Event buffer full, dropped %d events so far, the last one of type %s", dropped, eventType)
+	}
+}
+
+// Log buffers an event for the next logger. It never blocks for longer than the block timeout.
+func (l *Logger) Log(eventType eventlog.Type, args ...any) {
+	e := event{eventType, args}
+	select {
+	case <-l.stop:
+		l.drop(eventType)
+		return
+	default:
+	}
+
+	if l.policy == Block {
+		timer := time.NewTimer(l.blockTimeout)
+		defer timer.Stop()
+		select {
+		case l.events <- e:
+		case <-timer.C:
+			l.drop(eventType)
+		}
+		return
+	}
+
+	for {
+		select {
+		case l.events <- e:
+			return
+		default:
+		}
+		select {
+		case oldest := <-l.events:
+			l.drop(oldest.eventType)
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full or closed.
+func (l *Logger) Dropped() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped
+}
+
+// Close stops passing events to the next logger and closes it, without waiting for an event the
+// next logger is stuck on. Buffered events are dropped.
+func (l *Logger) Close() {
+	l.once.Do(func() {
+		close(l.stop)
+		l.next.Close()
+	})
+}
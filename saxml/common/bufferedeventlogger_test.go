@@ -0,0 +1,146 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufferedeventlogger_test
+
+import (
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"saxml/common/bufferedeventlogger"
+	"saxml/common/eventlog"
+)
+
+// stalledLogger records the first argument of logged events. It signals started on the first
+// event and then blocks every Log call until release is closed.
+type stalledLogger struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	mu     sync.Mutex
+	logged []int
+	closed bool
+}
+
+func newStalledLogger() *stalledLogger {
+	return &stalledLogger{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (l *stalledLogger) Log(eventType eventlog.Type, args ...any) {
+	l.once.Do(func() { close(l.started) })
+	<-l.release
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logged = append(l.logged, args[0].(int))
+}
+
+func (l *stalledLogger) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+}
+
+// waitLogged waits for n events to reach the stalled logger after it is released.
+func (l *stalledLogger) waitLogged(t *testing.T, n int) []int {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		logged := append([]int{}, l.logged...)
+		l.mu.Unlock()
+		if len(logged) >= n {
+			return logged
+		}
+	}
+	t.Fatalf("Timed out waiting for %d logged events", n)
+	return nil
+}
+
+func TestDropOldest(t *testing.T) {
+	next := newStalledLogger()
+	l := bufferedeventlogger.New(next, 2, bufferedeventlogger.DropOldest, 0)
+	defer l.Close()
+	droppedBefore := expvar.Get("sax_dropped_events").(*expvar.Int).Value()
+
+	// The first event is handed to the stalled logger, the rest wait in the buffer.
+	l.Log(eventlog.Deploy, 0)
+	<-next.started
+	for i := 1; i <= 4; i++ {
+		l.Log(eventlog.Deploy, i)
+	}
+	if got := l.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+	// Drops are also exported for monitoring.
+	if got := expvar.Get("sax_dropped_events").(*expvar.Int).Value() - droppedBefore; got != 2 {
+		t.Errorf("sax_dropped_events increased by %d, want 2", got)
+	}
+
+	close(next.release)
+	want := []int{0, 3, 4}
+	if diff := cmp.Diff(want, next.waitLogged(t, len(want))); diff != "" {
+		t.Errorf("Logged events unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestBlockWithTimeout(t *testing.T) {
+	next := newStalledLogger()
+	timeout := 50 * time.Millisecond
+	l := bufferedeventlogger.New(next, 1, bufferedeventlogger.Block, timeout)
+	defer l.Close()
+
+	l.Log(eventlog.Deploy, 0)
+	<-next.started
+	l.Log(eventlog.Deploy, 1)
+
+	// The buffer is full, so the next event waits for the timeout and is dropped.
+	start := time.Now()
+	l.Log(eventlog.Deploy, 2)
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("Log() into a full buffer returned after %v, want at least %v", elapsed, timeout)
+	}
+	if got := l.Dropped(); got != 1 {
+		t.Errorf("Dropped() = %d, want 1", got)
+	}
+
+	close(next.release)
+	want := []int{0, 1}
+	if diff := cmp.Diff(want, next.waitLogged(t, len(want))); diff != "" {
+		t.Errorf("Logged events unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestClose(t *testing.T) {
+	next := newStalledLogger()
+	l := bufferedeventlogger.New(next, 1, bufferedeventlogger.Block, time.Hour)
+
+	// Close doesn't wait for the stalled logger, and later events are dropped right away.
+	l.Log(eventlog.Deploy, 0)
+	<-next.started
+	l.Close()
+	l.Log(eventlog.Deploy, 1)
+	if got := l.Dropped(); got != 1 {
+		t.Errorf("Dropped() after Close = %d, want 1", got)
+	}
+	next.mu.Lock()
+	closed := next.closed
+	next.mu.Unlock()
+	if !closed {
+		t.Error("Close() didn't close the next logger")
+	}
+	close(next.release)
+}
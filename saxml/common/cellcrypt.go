@@ -0,0 +1,125 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cellcrypt optionally encrypts Sax cell files, such as location, config, and admin state
+// files, at rest:
+//
+//	Encrypted content := magic | nonce | AES-GCM ciphertext
+//
+// Encryption is off until a process calls SetKeyProvider. Content without the magic header is
+// returned as is, so cells written before encryption was turned on stay readable.
+package cellcrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	"saxml/common/errors"
+)
+
+// magic starts every encrypted file. Its first byte is 0, which never starts a serialized proto
+// message, so unencrypted files are never mistaken for encrypted ones.
+var magic = []byte("\x00saxenc1")
+
+// KeyProvider supplies the key cell files are encrypted with, e.g. from a key management service.
+type KeyProvider interface {
+	// Key returns an AES key of 16, 24, or 32 bytes.
+	Key(ctx context.Context) ([]byte, error)
+}
+
+var (
+	mu       sync.RWMutex
+	provider KeyProvider
+)
+
+// SetKeyProvider makes Seal encrypt content with keys from p, and Open decrypt with them. Passing
+// nil turns encryption off; encrypted files can't be read until a provider is set again.
+//
+// Every process reading or writing the cell, i.e. admin servers, model servers, and clients, must
+// use the same key.
+func SetKeyProvider(p KeyProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+	provider = p
+}
+
+func aead(ctx context.Context) (cipher.AEAD, error) {
+	mu.RLock()
+	p := provider
+	mu.RUnlock()
+	if p == nil {
+		return nil, nil
+	}
+	key, err := p.Key(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cell file key unavailable: %v: %w", err, errors.ErrUnavailable)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("bad cell file key: %v: %w", err, errors.ErrFailedPrecondition)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypted returns whether data was produced by Seal with encryption on.
+func Encrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Seal encrypts the content of a cell file before it is written, if encryption is on.
+func Seal(ctx context.Context, data []byte) ([]byte, error) {
+	gcm, err := aead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if gcm == nil {
+		return data, nil
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce generation error: %v: %w", err, errors.ErrInternal)
+	}
+	out := append(bytes.Clone(magic), nonce...)
+	return gcm.Seal(out, nonce, data, magic), nil
+}
+
+// Open decrypts the content of a cell file after it is read. Content Seal didn't encrypt is
+// returned as is.
+func Open(ctx context.Context, data []byte) ([]byte, error) {
+	if !Encrypted(data) {
+		return data, nil
+	}
+	gcm, err := aead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if gcm == nil {
+		return nil, fmt.Errorf("cell file is encrypted but no key provider is set: %w", errors.ErrFailedPrecondition)
+	}
+	sealed := data[len(magic):]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted cell file of %d bytes is truncated: %w", len(data), errors.ErrDataLoss)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	out, err := gcm.Open(nil, nonce, ciphertext, magic)
+	if err != nil {
+		return nil, fmt.Errorf("cell file decryption error, wrong key or corrupted content: %v: %w", err, errors.ErrDataLoss)
+	}
+	return out, nil
+}
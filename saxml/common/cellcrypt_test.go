@@ -0,0 +1,136 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cellcrypt_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"saxml/common/addr"
+	"saxml/common/cell"
+	"saxml/common/cellcrypt"
+	"saxml/common/errors"
+	"saxml/common/platform/env"
+	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/testutil"
+)
+
+type staticKey []byte
+
+func (k staticKey) Key(ctx context.Context) ([]byte, error) { return k, nil }
+
+var testKey = staticKey(bytes.Repeat([]byte{0x42}, 32))
+
+func TestSealOpen(t *testing.T) {
+	ctx := context.Background()
+	plain := []byte("\n\x0flocalhost:10001")
+
+	// Encryption is off by default.
+	sealed, err := cellcrypt.Seal(ctx, plain)
+	if err != nil {
+		t.Fatalf("Seal() without a key provider error %v, want no error", err)
+	}
+	if !bytes.Equal(sealed, plain) {
+		t.Errorf("Seal() without a key provider = %q, want %q", sealed, plain)
+	}
+
+	cellcrypt.SetKeyProvider(testKey)
+	defer cellcrypt.SetKeyProvider(nil)
+	sealed, err = cellcrypt.Seal(ctx, plain)
+	if err != nil {
+		t.Fatalf("Seal() error %v, want no error", err)
+	}
+	if !cellcrypt.Encrypted(sealed) || bytes.Contains(sealed, []byte("localhost")) {
+		t.Errorf("Seal() = %q, want encrypted content", sealed)
+	}
+	opened, err := cellcrypt.Open(ctx, sealed)
+	if err != nil {
+		t.Fatalf("Open() error %v, want no error", err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Errorf("Open() = %q, want %q", opened, plain)
+	}
+
+	// Unencrypted content is read as is.
+	opened, err = cellcrypt.Open(ctx, plain)
+	if err != nil {
+		t.Fatalf("Open() on unencrypted content error %v, want no error", err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Errorf("Open() on unencrypted content = %q, want %q", opened, plain)
+	}
+
+	// Tampered content and a wrong key are both detected.
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := cellcrypt.Open(ctx, tampered); errors.Code(err) != codes.DataLoss {
+		t.Errorf("Open() on tampered content error %v, want %v", err, errors.ErrDataLoss)
+	}
+	cellcrypt.SetKeyProvider(staticKey(bytes.Repeat([]byte{0x24}, 32)))
+	if _, err := cellcrypt.Open(ctx, sealed); errors.Code(err) != codes.DataLoss {
+		t.Errorf("Open() with a wrong key error %v, want %v", err, errors.ErrDataLoss)
+	}
+	cellcrypt.SetKeyProvider(nil)
+	if _, err := cellcrypt.Open(ctx, sealed); errors.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Open() without a key provider error %v, want %v", err, errors.ErrFailedPrecondition)
+	}
+}
+
+func TestEncryptedCell(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-cellcrypt"
+	testutil.SetUp(ctx, t, saxCell, "")
+	path, err := cell.Path(ctx, saxCell)
+	if err != nil {
+		t.Fatalf("Path(%s) error %v, want no error", saxCell, err)
+	}
+	fname := addr.LocationFiles(ctx, path)[0]
+
+	// A location written before encryption was turned on stays readable after.
+	c, err := addr.SetAddr(ctx, 10001, saxCell)
+	if err != nil {
+		t.Fatalf("SetAddr() error %v, want no error", err)
+	}
+	close(c)
+	cellcrypt.SetKeyProvider(testKey)
+	defer cellcrypt.SetKeyProvider(nil)
+	if got, err := addr.FetchAddr(ctx, saxCell); err != nil || got == "" {
+		t.Errorf("FetchAddr() on an unencrypted cell = (%q, %v), want an address", got, err)
+	}
+
+	// New locations are written encrypted and read back transparently.
+	c, err = addr.SetAddr(ctx, 10002, saxCell)
+	if err != nil {
+		t.Fatalf("SetAddr() error %v, want no error", err)
+	}
+	defer close(c)
+	content, err := env.Get().ReadFile(ctx, fname)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) error %v, want no error", fname, err)
+	}
+	if !cellcrypt.Encrypted(content) {
+		t.Errorf("Location file content %q, want encrypted content", content)
+	}
+	got, err := addr.ParseAddr(ctx, content)
+	if err != nil {
+		t.Fatalf("ParseAddr() on encrypted content error %v, want no error", err)
+	}
+	if !strings.HasSuffix(got, ":10002") {
+		t.Errorf("ParseAddr() on encrypted content = %q, want port 10002", got)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cell
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"saxml/common/naming"
+
+	pb "saxml/protobuf/admin_go_proto_grpc"
+)
+
+// Snapshot is the state of a Sax cell at some point in time, e.g., as persisted by its admin
+// server.
+type Snapshot struct {
+	Models []*pb.Model
+}
+
+// NewSnapshot returns a snapshot of an admin server state.
+func NewSnapshot(state *pb.State) Snapshot {
+	return Snapshot{Models: state.GetModels()}
+}
+
+// ModelChange describes a model present in two snapshots with different configurations.
+type ModelChange struct {
+	// The model name, without the cell part of its ID.
+	Name string
+	// The model in the first and second snapshot.
+	Before, After *pb.Model
+	// Names of the Model proto fields that differ, e.g., "requested_num_replicas", in field
+	// number order.
+	Fields []string
+}
+
+// Diff is the difference between two cell snapshots. All lists are sorted by model name.
+type Diff struct {
+	// Models only in the second snapshot.
+	Added []*pb.Model
+	// Models only in the first snapshot.
+	Removed []*pb.Model
+	// Models in both snapshots that differ.
+	Changed []ModelChange
+}
+
+// Empty returns true if the two snapshots have the same models.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// modelsByName indexes models by their names, so snapshots of different cells, e.g., staging and
+// production, can be compared. Models with invalid IDs are indexed by their full IDs.
+func modelsByName(models []*pb.Model) map[string]*pb.Model {
+	byName := make(map[string]*pb.Model, len(models))
+	for _, model := range models {
+		name := model.GetModelId()
+		if fullName, err := naming.NewModelFullName(name); err == nil {
+			name = fullName.ModelName()
+		}
+		byName[name] = model
+	}
+	return byName
+}
+
+// fieldEqual returns true if a field has the same value in two models.
+func fieldEqual(a, b protoreflect.Message, fd protoreflect.FieldDescriptor) bool {
+	x, y := (&pb.Model{}).ProtoReflect(), (&pb.Model{}).ProtoReflect()
+	if a.Has(fd) {
+		x.Set(fd, a.Get(fd))
+	}
+	if b.Has(fd) {
+		y.Set(fd, b.Get(fd))
+	}
+	return proto.Equal(x.Interface(), y.Interface())
+}
+
+// changedFields returns the names of fields that differ between two models, ignoring the model ID
+// and the deployment UUID.
+func changedFields(a, b *pb.Model) []string {
+	var changed []string
+	ra, rb := a.ProtoReflect(), b.ProtoReflect()
+	fields := ra.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if name := fd.Name(); name == "model_id" || name == "uuid" {
+			continue
+		}
+		if !fieldEqual(ra, rb, fd) {
+			changed = append(changed, string(fd.Name()))
+		}
+	}
+	return changed
+}
+
+// DiffSnapshots returns the models added, removed, and changed from snapshot a to snapshot b.
+// Models are matched by name, ignoring the cell part of their IDs.
+func DiffSnapshots(a, b Snapshot) Diff {
+	before, after := modelsByName(a.Models), modelsByName(b.Models)
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diff Diff
+	for _, name := range names {
+		x, inA := before[name]
+		y, inB := after[name]
+		switch {
+		case !inA:
+			diff.Added = append(diff.Added, y)
+		case !inB:
+			diff.Removed = append(diff.Removed, x)
+		default:
+			if fields := changedFields(x, y); len(fields) > 0 {
+				diff.Changed = append(diff.Changed, ModelChange{Name: name, Before: x, After: y, Fields: fields})
+			}
+		}
+	}
+	return diff
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cell
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	pb "saxml/protobuf/admin_go_proto_grpc"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	lm := func(cell string, replicas int32) *pb.Model {
+		return &pb.Model{
+			ModelId:              "/sax/" + cell + "/lm",
+			ModelPath:            "saxml.server.lm.params.lm_cloud.LmCloudSpmd2B",
+			CheckpointPath:       "/tmp/checkpoint",
+			RequestedNumReplicas: replicas,
+			Uuid:                 []byte(cell),
+		}
+	}
+	vm := &pb.Model{
+		ModelId:              "/sax/prod/vm",
+		ModelPath:            "saxml.server.pax.vision.params.ResNet50Params",
+		CheckpointPath:       "/tmp/checkpoint",
+		RequestedNumReplicas: 1,
+	}
+	staging := Snapshot{Models: []*pb.Model{lm("staging", 1)}}
+	prod := Snapshot{Models: []*pb.Model{lm("prod", 3), vm}}
+
+	got := DiffSnapshots(staging, prod)
+	want := Diff{
+		Added: []*pb.Model{vm},
+		Changed: []ModelChange{{
+			Name:   "lm",
+			Before: lm("staging", 1),
+			After:  lm("prod", 3),
+			Fields: []string{"requested_num_replicas"},
+		}},
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("DiffSnapshots(staging, prod) unexpected diff (-want +got):\n%s", diff)
+	}
+
+	got = DiffSnapshots(prod, staging)
+	if len(got.Removed) != 1 || got.Removed[0].GetModelId() != vm.GetModelId() {
+		t.Errorf("DiffSnapshots(prod, staging).Removed = %v, want [%v]", got.Removed, vm)
+	}
+
+	// Only model IDs and deployment UUIDs differ.
+	if got := DiffSnapshots(staging, Snapshot{Models: []*pb.Model{lm("prod", 1)}}); !got.Empty() {
+		t.Errorf("DiffSnapshots() of equivalent snapshots = %v, want empty", got)
+	}
+}
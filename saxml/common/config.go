@@ -24,6 +24,7 @@ import (
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 	"saxml/common/cell"
+	"saxml/common/cellcrypt"
 	"saxml/common/errors"
 	"saxml/common/platform/env"
 	pb "saxml/protobuf/admin_go_proto_grpc"
@@ -56,6 +57,25 @@ func Load(ctx context.Context, saxCell string) (*pb.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parse(ctx, out)
+}
+
+// LoadCachedFromDir loads the server config of the Sax cell stored in a directory, e.g. as
+// returned by cell.Path, using the platform's cached file read. It suits callers that read the
+// config on every request.
+func LoadCachedFromDir(ctx context.Context, path string) (*pb.Config, error) {
+	out, err := env.Get().ReadCachedFile(ctx, filepath.Join(path, configFile))
+	if err != nil {
+		return nil, err
+	}
+	return parse(ctx, out)
+}
+
+func parse(ctx context.Context, out []byte) (*pb.Config, error) {
+	out, err := cellcrypt.Open(ctx, out)
+	if err != nil {
+		return nil, err
+	}
 	config := &pb.Config{}
 	if err := proto.Unmarshal(out, config); err != nil {
 		return nil, err
@@ -77,6 +97,11 @@ func Watch(ctx context.Context, saxCell string) (<-chan *pb.Config, error) {
 	configUpdates := make(chan *pb.Config)
 	go func() {
 		for content := range contentUpdates {
+			content, err := cellcrypt.Open(ctx, content)
+			if err != nil {
+				log.Errorf("Watch error: %v", err)
+				continue
+			}
 			config := &pb.Config{}
 			if err := proto.Unmarshal(content, config); err != nil {
 				log.Errorf("Watch erroro: %v", err)
@@ -101,6 +126,10 @@ func Save(ctx context.Context, config *pb.Config, saxCell string, writeACL strin
 	if err != nil {
 		return err
 	}
+	in, err = cellcrypt.Seal(ctx, in)
+	if err != nil {
+		return err
+	}
 	return env.Get().WriteFile(ctx, fname, writeACL, in)
 }
 
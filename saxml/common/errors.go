@@ -146,3 +146,8 @@ func IsDeadlineExceeded(err error) bool {
 func IsNotFound(err error) bool {
 	return Code(err) == codes.NotFound
 }
+
+// IsUnimplemented checks if an error is unimplemented.
+func IsUnimplemented(err error) bool {
+	return Code(err) == codes.Unimplemented
+}
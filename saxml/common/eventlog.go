@@ -25,6 +25,14 @@ const (
 	ServingStart
 	// ServingStop indicates that client is initiated model unpublish.
 	ServingStop
+	// ConstraintOverride indicates that an operator relaxed a model's placement constraints.
+	ConstraintOverride
+	// ConstraintRevert indicates that a placement constraint override expired.
+	ConstraintRevert
+	// Reassign indicates that a model server asked to have a model moved off it.
+	Reassign
+	// RolloutHalt indicates that a staged publish was reverted because its canary replicas failed.
+	RolloutHalt
 )
 
 func (t Type) String() string {
@@ -35,6 +43,14 @@ func (t Type) String() string {
 		return "ServingStart"
 	case ServingStop:
 		return "ServingStop"
+	case ConstraintOverride:
+		return "ConstraintOverride"
+	case ConstraintRevert:
+		return "ConstraintRevert"
+	case Reassign:
+		return "Reassign"
+	case RolloutHalt:
+		return "RolloutHalt"
 	}
 	return "Unknown"
 }
@@ -37,4 +37,26 @@ std::string Join(const std::string& sax_cell, const std::string& ip_port,
   return ret;
 }
 
+void Rejoin() { sax_rejoin(); }
+
+std::string SetTransportFiles(const std::string& cert_file,
+                              const std::string& key_file,
+                              const std::string& ca_file) {
+  const char* result = sax_set_transport_files(
+      const_cast<char*>(cert_file.data()), cert_file.size(),
+      const_cast<char*>(key_file.data()), key_file.size(),
+      const_cast<char*>(ca_file.data()), ca_file.size());
+  auto ret = std::string(result, strlen(result));
+  free(reinterpret_cast<void*>(const_cast<char*>(result)));
+  return ret;
+}
+
+std::string CellTransportSecurity(const std::string& sax_cell, int* policy) {
+  const char* result = sax_cell_transport_security(
+      const_cast<char*>(sax_cell.data()), sax_cell.size(), policy);
+  auto ret = std::string(result, strlen(result));
+  free(reinterpret_cast<void*>(const_cast<char*>(result)));
+  return ret;
+}
+
 }  // namespace sax
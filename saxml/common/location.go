@@ -17,7 +17,10 @@ package location
 
 import (
 	"context"
-	"path/filepath"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
@@ -26,8 +29,8 @@ import (
 	"saxml/common/addr"
 	"saxml/common/cell"
 	"saxml/common/errors"
-	"saxml/common/platform/env"
 	"saxml/common/retrier"
+	"saxml/common/transport"
 
 	pb "saxml/protobuf/admin_go_proto_grpc"
 	pbgrpc "saxml/protobuf/admin_go_proto_grpc"
@@ -40,27 +43,170 @@ const (
 	// Join RPC call timeout.
 	joinTimeout = time.Second * 10
 
-	// Call Join at least every this much time, to make sure model servers accidentally dropped by
-	// an admin server (that never changes addresses) still has a chance to join.
-	joinPeriod = time.Minute * 15
-
 	// Timeout for repeated Join RPC calls. When a model server just boots up and calls Join, it may
 	// not be ready to respond to GetStatus calls issued by the admin server Join RPC handler yet.
 	// Retry Join calls for this much time to allow the model server to become ready.
 	retryTimeout = time.Minute
+
+	// Number of recent Join RPC attempts kept for RecentJoins by default.
+	defaultJoinHistorySize = 32
+)
+
+var (
+	// Call Join at least every this much time, to make sure model servers accidentally dropped by
+	// an admin server (that never changes addresses) still has a chance to join.
+	joinPeriod = time.Minute * 15
+
+	// Delay the first Join call of the address watcher by this much time, so the calling model
+	// server can get ready to handle GetStatus calls issued by the admin server being joined.
+	firstJoinDelay = time.Second * 2
 )
 
-// join makes a Join RPC call to an admin server address.
-func join(ctx context.Context, addr string, ipPort string, debugAddr string, dataAddr string, specs *pb.ModelServer) error {
+// Options contains optional settings for Join.
+type Options struct {
+	preflight     func(ctx context.Context) error
+	addrCacheFile string
+	joinObserver  func(JoinLatency)
+	historySize   int
+}
+
+// Option sets an optional setting for Join.
+type Option func(*Options)
+
+// WithPreflightServe makes Join call preflight before the model server joins any admin server.
+// preflight should verify the model server can actually serve, e.g., by loading a canary model
+// and running a trivial inference. If it returns an error, Join returns that error and the model
+// server never joins, keeping it out of rotation.
+func WithPreflightServe(preflight func(ctx context.Context) error) Option {
+	return func(o *Options) {
+		o.preflight = preflight
+	}
+}
+
+// WithAddrCacheFile makes Join remember the address of the last admin server it joined in a local
+// file. When a model server restarts, Join immediately tries the remembered address, in parallel
+// with the regular address resolution, which takes over if the remembered address is stale.
+func WithAddrCacheFile(path string) Option {
+	return func(o *Options) {
+		o.addrCacheFile = path
+	}
+}
+
+// JoinLatency describes how long a model server took to join an admin server.
+type JoinLatency struct {
+	// Addr is the admin server address joined.
+	Addr string
+	// Attempts holds the duration of each Join RPC attempt, including failed ones, in order.
+	Attempts []time.Duration
+	// Total is the end-to-end duration, including backoff between retries.
+	Total time.Duration
+	// Err is the error of the last attempt, or nil if the model server joined.
+	Err error
+}
+
+// WithJoinObserver makes Join call observe with the latency of every join, whether it succeeds
+// or not, e.g. to export it as a metric. observe must not block.
+func WithJoinObserver(observe func(JoinLatency)) Option {
+	return func(o *Options) {
+		o.joinObserver = observe
+	}
+}
+
+// WithJoinHistorySize makes Join keep the given number of most recent Join RPC attempts for
+// RecentJoins, instead of the default 32.
+func WithJoinHistorySize(size int) Option {
+	return func(o *Options) {
+		o.historySize = size
+	}
+}
+
+// errRecovering is the error of a join the admin server turned away because it is still
+// restoring its state.
+var errRecovering = fmt.Errorf("admin server is recovering its state: %w", errors.ErrUnavailable)
+
+// joinShouldRetry returns true iff a Join RPC attempt returning resp and err should be retried:
+// the admin server is still restoring its state, or the model server wasn't ready for the admin
+// server's checks yet. Unavailable errors, e.g. from a stale admin address, aren't retried here;
+// the address watcher joins again on the next address update or join period.
+func joinShouldRetry(resp *pb.JoinResponse, err error) bool {
+	if err != nil {
+		return errors.JoinShouldRetry(err)
+	}
+	return resp.GetRecovering()
+}
+
+// timedJoin calls attempt, retrying it if shouldRetry is not nil, and measures how long each
+// attempt and the whole join take. A join the admin server turned away fails with errRecovering.
+func timedJoin(ctx context.Context, addr string, attempt func(ctx context.Context) (*pb.JoinResponse, error), shouldRetry retrier.ShouldRetry[*pb.JoinResponse]) JoinLatency {
+	latency := JoinLatency{Addr: addr}
+	timed := func() (*pb.JoinResponse, error) {
+		start := time.Now()
+		resp, err := attempt(ctx)
+		latency.Attempts = append(latency.Attempts, time.Since(start))
+		return resp, err
+	}
+	start := time.Now()
+	var resp *pb.JoinResponse
+	if shouldRetry == nil {
+		resp, latency.Err = timed()
+	} else {
+		resp, latency.Err = retrier.DoWithResult(ctx, timed, shouldRetry, retrier.Policy{})
+	}
+	if latency.Err == retrier.ErrRetriableResult || latency.Err == nil && resp.GetRecovering() {
+		latency.Err = errRecovering
+	}
+	latency.Total = time.Since(start)
+	return latency
+}
+
+// readCachedAddr returns the admin server address remembered in a local file.
+func readCachedAddr(path string) (string, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	addr := strings.TrimSpace(string(bytes))
+	if addr == "" {
+		return "", fmt.Errorf("empty cached admin address in %s: %w", path, errors.ErrNotFound)
+	}
+	return addr, nil
+}
+
+// writeCachedAddr remembers an admin server address in a local file.
+func writeCachedAddr(path, addr string) {
+	if err := os.WriteFile(path, []byte(addr), 0644); err != nil {
+		log.Warningf("Failed to cache admin address %v in %s: %v", addr, path, err)
+	}
+}
+
+// join makes a Join RPC call to an admin server address of saxCell, over a connection its
+// transport security policy allows.
+func join(ctx context.Context, saxCell string, addr string, ipPort string, debugAddr string, dataAddr string, specs *pb.ModelServer) (*pb.JoinResponse, error) {
 	dialCtx, dialCancel := context.WithTimeout(ctx, dialTimeout)
 	defer dialCancel()
-	conn, err := env.Get().DialContext(dialCtx, addr)
+	conn, err := transport.DialCell(dialCtx, saxCell, addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer conn.Close()
-	client := pbgrpc.NewAdminClient(conn)
+	return joinClient(ctx, pbgrpc.NewAdminClient(conn), ipPort, debugAddr, dataAddr, specs)
+}
 
+// JoinClient makes a single Join RPC call through an admin service client, without retries.
+// ipPort, debugAddr, dataAddr, and specs are those of the model server's.
+//
+// Most model servers should call Join instead. JoinClient is useful for testing with a mock client.
+// A join the admin server turned away because it is still restoring its state fails with an
+// Unavailable error.
+func JoinClient(ctx context.Context, client pbgrpc.AdminClient, ipPort string, debugAddr string, dataAddr string, specs *pb.ModelServer) error {
+	resp, err := joinClient(ctx, client, ipPort, debugAddr, dataAddr, specs)
+	if err == nil && resp.GetRecovering() {
+		return errRecovering
+	}
+	return err
+}
+
+func joinClient(ctx context.Context, client pbgrpc.AdminClient, ipPort string, debugAddr string, dataAddr string, specs *pb.ModelServer) (*pb.JoinResponse, error) {
 	req := &pb.JoinRequest{
 		Address:      ipPort,
 		DebugAddress: debugAddr,
@@ -69,112 +215,443 @@ func join(ctx context.Context, addr string, ipPort string, debugAddr string, dat
 	}
 	joinCtx, joinCancel := context.WithTimeout(ctx, joinTimeout)
 	defer joinCancel()
-	_, err = client.Join(joinCtx, req)
-	return err
+	return client.Join(joinCtx, req)
 }
 
 // Join is called by model servers to join the admin server in a Sax cell. ipPort and specs
-// are those of the model server's.
+// are those of the model server's. ipPort and debugAddr, if not empty, must be "host:port"
+// addresses.
 //
 // A background address watcher starts running indefinitely on successful calls. This address
 // watcher will attempt to rejoin periodically.
 //
+// If the cell is sharded, the model server joins the admin shard its ipPort hashes to. The number
+// of shards is read once, so model servers need to restart to pick up a change. If no admin server
+// has advertised it yet, the address watcher waits for one to.
+//
 // If admin_port is not 0, start an admin server for sax_cell at the given port in the background.
-func Join(ctx context.Context, saxCell string, ipPort string, debugAddr string, dataAddr string, specs *pb.ModelServer, adminPort int) error {
-	if err := cell.Exists(ctx, saxCell); err != nil {
-		return err
-	}
-	path, err := cell.Path(ctx, saxCell)
+//
+// To move a model server to another cell at runtime, use a Joiner instead.
+func Join(ctx context.Context, saxCell string, ipPort string, debugAddr string, dataAddr string, specs *pb.ModelServer, adminPort int, opts ...Option) error {
+	_, rejoin, err := startJoin(ctx, saxCell, ipPort, debugAddr, dataAddr, specs, adminPort, opts)
 	if err != nil {
 		return err
 	}
-	fname := filepath.Join(path, addr.LocationFile)
+	muRejoin.Lock()
+	lastRejoin = rejoin
+	muRejoin.Unlock()
+	return nil
+}
+
+var (
+	// Signals the address watcher started by the most recent successful Join call to join again.
+	muRejoin   sync.Mutex
+	lastRejoin chan<- struct{}
+)
+
+// Rejoin makes the model server set up by the most recent successful Join call join the admin
+// server again right away, instead of at the next periodic join. Model servers call it when the
+// admin server asks them to, e.g. because it suspects its view of them is stale after it
+// recovered.
+func Rejoin() {
+	muRejoin.Lock()
+	rejoin := lastRejoin
+	muRejoin.Unlock()
+	if rejoin == nil {
+		log.Warning("Rejoin requested without a Join call")
+		return
+	}
+	requestRejoin(rejoin)
+}
 
+// requestRejoin signals an address watcher to join again, unless a signal is already pending.
+func requestRejoin(rejoin chan<- struct{}) {
+	select {
+	case rejoin <- struct{}{}:
+	default:
+	}
+}
+
+// startJoin implements Join. On success, it returns a function that waits for the admin server
+// started in the background, if any, and closes it. Call it only after ctx is done, which also
+// stops the address watcher. Sending on the returned rejoin channel makes the address watcher join
+// again right away.
+func startJoin(ctx context.Context, saxCell string, ipPort string, debugAddr string, dataAddr string, specs *pb.ModelServer, adminPort int, opts []Option) (stopAdmin func(), rejoin chan<- struct{}, err error) {
+	if err := addr.ValidateHostPort(ipPort); err != nil {
+		return nil, nil, fmt.Errorf("bad model server address: %w", err)
+	}
+	if debugAddr != "" {
+		if err := addr.ValidateHostPort(debugAddr); err != nil {
+			return nil, nil, fmt.Errorf("bad model server debug address: %w", err)
+		}
+	}
+
+	options := &Options{historySize: defaultJoinHistorySize}
+	for _, opt := range opts {
+		opt(options)
+	}
+	r := &readiness{
+		adminWanted: adminPort != 0,
+		serving:     options.preflight,
+		history:     newJoinHistory(options.historySize),
+	}
+	muReady.Lock()
+	ready = r
+	muReady.Unlock()
+
+	if err := cell.Exists(ctx, saxCell); err != nil {
+		return nil, nil, err
+	}
+	if options.preflight != nil {
+		if err := options.preflight(ctx); err != nil {
+			return nil, nil, fmt.Errorf("preflight check for model server %v failed: %w", ipPort, err)
+		}
+		log.Infof("Preflight check for model server %v passed", ipPort)
+	}
 	// If multiple model servers call Join with non-zero admin port values, all but one model server
 	// will be stuck at leader election. Put the admin server start call in a goroutine so Join calls
 	// aren't blocked.
+	stopAdmin = func() {}
 	if adminPort != 0 {
+		started := make(chan *admin.Server, 1)
 		go func() {
 			adminServer := admin.NewServer(saxCell, adminPort)
 			log.Infof("Starting admin server at :%v", adminPort)
 			adminServer.EnableStatusPages()
-			if err := adminServer.Start(ctx); err != nil {
+			err := adminServer.Start(ctx)
+			r.setAdminStarted(err)
+			if err != nil {
 				log.Errorf("Failed to start admin server at :%v: %v", adminPort, err)
+				started <- nil
 				return
 			}
 			log.Infof("Started admin server at :%v", adminPort)
+			started <- adminServer
 		}()
+		stopAdmin = func() {
+			if adminServer := <-started; adminServer != nil {
+				adminServer.Close()
+				log.Infof("Stopped admin server at :%v", adminPort)
+			}
+		}
 	}
 
 	// If the platform supports it, subscribe to ongoing admin server address updates.
-	var updates <-chan []byte
-	updates, err = env.Get().Watch(ctx, fname)
+	updates, shard, err := watchOwnShard(ctx, saxCell, ipPort)
 	if err != nil {
-		return err
+		return stopAdmin, nil, err
+	}
+
+	// joinAttempt returns a single Join RPC attempt to the admin server at addr, recorded in the
+	// join history.
+	joinAttempt := func(addr string) func(ctx context.Context) (*pb.JoinResponse, error) {
+		return func(ctx context.Context) (*pb.JoinResponse, error) {
+			start := time.Now()
+			resp, err := join(ctx, saxCell, addr, ipPort, debugAddr, dataAddr, specs)
+			attempt := JoinAttempt{Start: start, Addr: addr, Duration: time.Since(start), Err: err}
+			if err == nil && resp.GetRecovering() {
+				attempt.Err = errRecovering
+			}
+			r.history.record(attempt)
+			return resp, err
+		}
 	}
 
 	retryJoinWithTimeout := func(ctx context.Context, addr string) error {
 		ctx, cancel := context.WithTimeout(ctx, retryTimeout)
 		defer cancel()
-		return retrier.Do(
-			ctx, func() error {
-				err := join(ctx, addr, ipPort, debugAddr, dataAddr, specs)
-				return err
-			}, errors.JoinShouldRetry,
-		)
+		latency := timedJoin(ctx, addr, joinAttempt(addr), joinShouldRetry)
+		if options.joinObserver != nil {
+			options.joinObserver(latency)
+		}
+		err := latency.Err
+		r.setJoined(err == nil)
+		if err == nil && options.addrCacheFile != "" {
+			writeCachedAddr(options.addrCacheFile, addr)
+		}
+		return err
+	}
+
+	// Try the remembered admin server address right away, without waiting for the address watcher.
+	// A single attempt is enough: if the address is stale, the address watcher below joins the
+	// current admin server anyway.
+	if options.addrCacheFile != "" {
+		if cached, err := readCachedAddr(options.addrCacheFile); err != nil {
+			log.Infof("No cached admin address: %v", err)
+		} else {
+			go func() {
+				log.Infof("Calling Join on cached admin address %v", cached)
+				latency := timedJoin(ctx, cached, joinAttempt(cached), nil)
+				if options.joinObserver != nil {
+					options.joinObserver(latency)
+				}
+				if err := latency.Err; err != nil {
+					log.Infof("Failed to join cached admin address %v: %v", cached, err)
+					return
+				}
+				r.setJoined(true)
+				log.Infof("Joined cached admin address %v", cached)
+			}()
+		}
+	}
+
+	// Start a best-effort background address watcher that runs until ctx is done and ensures the
+	// server has joined the latest admin server.
+	fetchAddr := func(ctx context.Context) (string, error) {
+		owner := shard()
+		if owner < 0 {
+			return "", fmt.Errorf("no admin server of %v has advertised its number of shards yet: %w", saxCell, errors.ErrUnavailable)
+		}
+		return addr.FetchShardAddr(ctx, saxCell, owner)
+	}
+	rejoinCh := make(chan struct{}, 1)
+	go watchAddr(ctx, updates, rejoinCh, fetchAddr, retryJoinWithTimeout)
+
+	return stopAdmin, rejoinCh, nil
+}
+
+// watchOwnShard subscribes to address updates of the admin shard owning the model server at
+// ipPort, and returns a function that returns that shard, or -1 while it isn't known yet.
+//
+// The number of shards is read from shard 0 once. If no admin server has advertised it yet, e.g.
+// because the model server starts before any admin server, shard 0's location is watched until
+// it does, and then the owning shard's, so that Join doesn't wait for an admin server.
+func watchOwnShard(ctx context.Context, saxCell, ipPort string) (<-chan *pb.Location, func() int, error) {
+	if n, err := addr.NumShards(ctx, saxCell); err == nil {
+		shard := addr.ShardOf(ipPort, n)
+		updates, err := addr.WatchShardLocation(ctx, saxCell, shard)
+		return updates, func() int { return shard }, err
+	}
+	shard0, err := addr.WatchShardLocation(ctx, saxCell, 0)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Start a best-effort background address watcher that runs indefinitely and ensures the server
-	// has joined the latest admin server.
+	var mu sync.Mutex
+	shard := -1
+	resolved := make(chan *pb.Location)
 	go func() {
-		// Delay the first call by a few seconds so the calling model server can get ready to handle
-		// GetStatus calls issued by the admin server being joined.
-		time.Sleep(2 * time.Second)
-		// The address watcher may send the old address a few times repeatedly during an address change.
-		// Use this variable to filter out unnecessary Join calls.
-		var joinedAddr string
-		// Regardless of address updates, we want to call Join on the admin server at least once this
-		// much time in case address watching doesn't work.
-		timer := time.NewTimer(joinPeriod)
+		updates := shard0
 		for {
+			var location *pb.Location
 			select {
-			// Call Join every time the admin address changes.
-			case bytes := <-updates:
-				log.Info("Calling Join due to address update")
-				addr, err := addr.ParseAddr(bytes)
+			case <-ctx.Done():
+				return
+			case location = <-updates:
+			}
+			mu.Lock()
+			known := shard >= 0
+			mu.Unlock()
+			if !known {
+				n, err := addr.NumShards(ctx, saxCell)
 				if err != nil {
-					log.Errorf("ParseAddr error: %v", err)
+					log.Infof("Waiting for an admin server of %v to advertise its number of shards: %v", saxCell, err)
 					continue
 				}
-				if addr == joinedAddr {
-					log.Infof("Not calling Join on old address %v", addr)
-					continue
+				owner := addr.ShardOf(ipPort, n)
+				if owner != 0 {
+					if updates, err = addr.WatchShardLocation(ctx, saxCell, owner); err != nil {
+						log.Errorf("Failed to watch admin shard %d of %v: %v", owner, saxCell, err)
+						return
+					}
 				}
-				if err := retryJoinWithTimeout(ctx, addr); err != nil {
-					log.Errorf("Failed to join %v: %v", addr, err)
-					continue
-				}
-				log.Infof("Joined %v", addr)
-				// On success, remember the address so this select branch calls Join only when a new address
-				// is received.
-				joinedAddr = addr
-			// Call Join at least every `joinPeriod` regardless of address changes.
-			case <-timer.C:
-				timer.Reset(joinPeriod)
-				log.Info("Calling Join at fixed interval")
-				addr, err := addr.FetchAddr(ctx, saxCell)
-				if err != nil {
-					log.Errorf("FetchAddr error: %v", err)
+				mu.Lock()
+				shard = owner
+				mu.Unlock()
+				log.Infof("Watching admin shard %d of %d of %v", owner, n, saxCell)
+				if owner != 0 {
 					continue
 				}
-				if err := retryJoinWithTimeout(ctx, addr); err != nil {
-					log.Errorf("Failed to join %v: %v", addr, err)
-					continue
-				}
-				log.Infof("Joined %v", addr)
+			}
+			select {
+			case resolved <- location:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
+	return resolved, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return shard
+	}, nil
+}
+
+// Joiner keeps a model server joined to one Sax cell at a time, and can move it to another cell
+// at runtime, e.g. when the configured cell changes, without restarting the process.
+type Joiner struct {
+	ipPort    string
+	debugAddr string
+	dataAddr  string
+	specs     *pb.ModelServer
+	adminPort int
+	opts      []Option
+
+	mu        sync.Mutex
+	saxCell   string
+	cancel    context.CancelFunc
+	stopAdmin func()
+	rejoin    chan<- struct{}
+}
+
+// NewJoiner creates a Joiner for a model server. The arguments are the same as Join's.
+func NewJoiner(ipPort string, debugAddr string, dataAddr string, specs *pb.ModelServer, adminPort int, opts ...Option) *Joiner {
+	return &Joiner{
+		ipPort:    ipPort,
+		debugAddr: debugAddr,
+		dataAddr:  dataAddr,
+		specs:     specs,
+		adminPort: adminPort,
+		opts:      opts,
+	}
+}
+
+// Join makes the model server leave its current cell, if any, and join saxCell like Join does.
+// The address watcher and the admin server started for saxCell keep running until the next Join
+// or Leave call, or until ctx is done.
+//
+// The admin server of the cell left forgets this model server only once it stops answering
+// GetStatus calls or reports shutting down, as with any model server that stops joining.
+func (j *Joiner) Join(ctx context.Context, saxCell string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.leaveLocked()
 
+	ctx, cancel := context.WithCancel(ctx)
+	stopAdmin, rejoin, err := startJoin(ctx, saxCell, j.ipPort, j.debugAddr, j.dataAddr, j.specs, j.adminPort, j.opts)
+	if err != nil {
+		cancel()
+		if stopAdmin != nil {
+			stopAdmin()
+		}
+		return err
+	}
+	j.saxCell = saxCell
+	j.cancel = cancel
+	j.stopAdmin = stopAdmin
+	j.rejoin = rejoin
+	log.Infof("Model server %v joined cell %v", j.ipPort, saxCell)
 	return nil
 }
+
+// Leave stops the address watcher and closes the admin server started by the last Join call, if
+// any. It waits for the admin server to finish starting or to give up, so its port can be reused
+// right away.
+func (j *Joiner) Leave() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.leaveLocked()
+}
+
+func (j *Joiner) leaveLocked() {
+	if j.cancel == nil {
+		return
+	}
+	j.cancel()
+	j.stopAdmin()
+	log.Infof("Model server %v left cell %v", j.ipPort, j.saxCell)
+	j.saxCell = ""
+	j.cancel = nil
+	j.stopAdmin = nil
+	j.rejoin = nil
+}
+
+// Rejoin makes the model server join the admin server of its current cell again right away,
+// instead of at the next periodic join. It does nothing if the model server isn't in any cell.
+func (j *Joiner) Rejoin() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.rejoin == nil {
+		return
+	}
+	log.Infof("Model server %v rejoining cell %v on request", j.ipPort, j.saxCell)
+	requestRejoin(j.rejoin)
+}
+
+// Cell returns the cell the model server last joined, or "" if it isn't in any cell.
+func (j *Joiner) Cell() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.saxCell
+}
+
+// watchAddr calls join every time updates delivers a new admin server address, and on the address
+// returned by fetchAddr at least every joinPeriod and whenever rejoin is signaled, until ctx is
+// done. Updates with an older epoch than the last address joined from updates are dropped.
+func watchAddr(ctx context.Context, updates <-chan *pb.Location, rejoin <-chan struct{}, fetchAddr func(context.Context) (string, error), join func(context.Context, string) error) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(firstJoinDelay):
+	}
+	// The address watcher may send the old address a few times repeatedly during an address change.
+	// Use this variable to filter out unnecessary Join calls.
+	var joinedAddr string
+	var joinedEpoch int64
+	// Regardless of address updates, we want to call Join on the admin server at least once this
+	// much time in case address watching doesn't work.
+	timer := time.NewTimer(joinPeriod)
+	defer timer.Stop()
+	// joinLatest calls Join on the address returned by fetchAddr.
+	joinLatest := func() {
+		addr, err := fetchAddr(ctx)
+		if err != nil {
+			log.Errorf("FetchAddr error: %v", err)
+			return
+		}
+		if err := join(ctx, addr); err != nil {
+			log.Errorf("Failed to join %v: %v", addr, err)
+			return
+		}
+		log.Infof("Joined %v", addr)
+	}
+	for {
+		select {
+		// Stop once the model server no longer needs to stay joined, e.g. when it shuts down.
+		case <-ctx.Done():
+			log.Infof("Stopped watching the admin server address: %v", ctx.Err())
+			return
+		// Call Join every time the admin address changes.
+		case location := <-updates:
+			if ctx.Err() != nil {
+				continue
+			}
+			log.Info("Calling Join due to address update")
+			addr := location.GetLocation()
+			if addr == joinedAddr {
+				log.Infof("Not calling Join on old address %v", addr)
+				continue
+			}
+			// A stale copy of the location may point at an admin server that is gone. Joining it would
+			// block this loop until join gives up.
+			if location.GetEpoch() < joinedEpoch {
+				log.Infof("Not calling Join on address %v of epoch %d older than %d", addr, location.GetEpoch(), joinedEpoch)
+				continue
+			}
+			if err := join(ctx, addr); err != nil {
+				log.Errorf("Failed to join %v: %v", addr, err)
+				continue
+			}
+			log.Infof("Joined %v", addr)
+			// On success, remember the address so this select branch calls Join only when a new address
+			// is received.
+			joinedAddr, joinedEpoch = addr, location.GetEpoch()
+		// Call Join at least every `joinPeriod` regardless of address changes.
+		case <-timer.C:
+			if ctx.Err() != nil {
+				continue
+			}
+			timer.Reset(joinPeriod)
+			log.Info("Calling Join at fixed interval")
+			joinLatest()
+		// Call Join when asked to, e.g. by an admin server that suspects its view of this model server
+		// is stale.
+		case <-rejoin:
+			if ctx.Err() != nil {
+				continue
+			}
+			log.Info("Calling Join on request")
+			joinLatest()
+		}
+	}
+}
@@ -34,6 +34,27 @@ std::string Join(const std::string& sax_cell, const std::string& ip_port,
                  const std::string& debug_addr, const std::string& data_addr,
                  const std::string& serialized_specs, int admin_port = 0);
 
+// Rejoin makes the model server set up by the most recent successful Join call
+// join the admin server again right away, instead of at the next periodic
+// join, e.g. when the admin server asks it to.
+void Rejoin();
+
+// SetTransportFiles makes connections this process makes to Sax components,
+// such as Join, use the PEM certificate in cert_file with its private key in
+// key_file, and verify peers against the CA certificates in ca_file. Empty
+// file names leave the corresponding part unset; see saxml/common/transport.go.
+// It returns an empty string if the call is successful, or a non-empty error
+// message otherwise.
+std::string SetTransportFiles(const std::string& cert_file,
+                              const std::string& key_file,
+                              const std::string& ca_file);
+
+// CellTransportSecurity sets policy to the transport security policy in the
+// config of sax_cell, an admin.Config.TransportSecurity value, which servers
+// of the cell must enforce. It returns an empty string if the call is
+// successful, or a non-empty error message otherwise.
+std::string CellTransportSecurity(const std::string& sax_cell, int* policy);
+
 }  // namespace sax
 
 #endif  // SAXML_COMMON_LOCATION_H_
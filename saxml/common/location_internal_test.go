@@ -0,0 +1,178 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package location
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"saxml/common/adminmock"
+	"saxml/common/errors"
+
+	pb "saxml/protobuf/admin_go_proto_grpc"
+)
+
+func TestWatchAddrStopsOnCancel(t *testing.T) {
+	defer func(delay, period time.Duration) {
+		firstJoinDelay, joinPeriod = delay, period
+	}(firstJoinDelay, joinPeriod)
+	firstJoinDelay, joinPeriod = 0, 10*time.Millisecond
+
+	var mu sync.Mutex
+	var joins []string
+	joined := make(chan string, 1000)
+	join := func(ctx context.Context, addr string) error {
+		if err := ctx.Err(); err != nil {
+			t.Errorf("join(%s) called with a done context: %v", addr, err)
+		}
+		mu.Lock()
+		joins = append(joins, addr)
+		mu.Unlock()
+		joined <- addr
+		return nil
+	}
+	fetchAddr := func(ctx context.Context) (string, error) { return "localhost:10001", nil }
+	numJoins := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(joins)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan *pb.Location)
+	done := make(chan struct{})
+	go func() {
+		watchAddr(ctx, updates, nil, fetchAddr, join)
+		close(done)
+	}()
+
+	location := &pb.Location{Location: "localhost:10000"}
+	updates <- location
+	// Periodic joins on the fetched address may come first.
+	for got := <-joined; got != "localhost:10000"; got = <-joined {
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watchAddr didn't return after its context was cancelled")
+	}
+
+	// No more joins, even after the join period passes or the address changes.
+	before := numJoins()
+	time.Sleep(5 * joinPeriod)
+	select {
+	case updates <- location:
+		t.Error("watchAddr received an address update after returning")
+	default:
+	}
+	if got := numJoins(); got != before {
+		t.Errorf("Got %d joins after cancellation, want none", got-before)
+	}
+}
+
+func TestWatchAddrDropsOlderEpochs(t *testing.T) {
+	defer func(delay, period time.Duration) {
+		firstJoinDelay, joinPeriod = delay, period
+	}(firstJoinDelay, joinPeriod)
+	firstJoinDelay, joinPeriod = 0, time.Hour
+
+	joined := make(chan string, 10)
+	join := func(ctx context.Context, addr string) error {
+		joined <- addr
+		return nil
+	}
+	fetchAddr := func(ctx context.Context) (string, error) { return "", errors.ErrUnavailable }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *pb.Location)
+	go watchAddr(ctx, updates, nil, fetchAddr, join)
+
+	updates <- &pb.Location{Location: "localhost:10000", Epoch: 2}
+	// A stale replica of the previous admin server.
+	updates <- &pb.Location{Location: "localhost:10001", Epoch: 1}
+	updates <- &pb.Location{Location: "localhost:10002", Epoch: 3}
+	for _, want := range []string{"localhost:10000", "localhost:10002"} {
+		select {
+		case got := <-joined:
+			if got != want {
+				t.Errorf("Joined %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Joined nothing, want %s", want)
+		}
+	}
+}
+
+func TestTimedJoin(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	ctx := context.Background()
+	client := adminmock.New()
+	calls := 0
+	client.JoinFunc = func(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse, error) {
+		time.Sleep(delay)
+		calls++
+		switch calls {
+		case 1:
+			return nil, errors.ErrDeadlineExceeded
+		case 2:
+			return &pb.JoinResponse{Recovering: true}, nil
+		}
+		return &pb.JoinResponse{}, nil
+	}
+	attempt := func(ctx context.Context) (*pb.JoinResponse, error) {
+		return joinClient(ctx, client, "localhost:10000", "localhost:10001", "", &pb.ModelServer{})
+	}
+
+	// Joins are retried while the model server isn't ready and while the admin server recovers.
+	latency := timedJoin(ctx, "localhost:20000", attempt, joinShouldRetry)
+	if latency.Err != nil {
+		t.Fatalf("timedJoin() error %v, want no error", latency.Err)
+	}
+	if latency.Addr != "localhost:20000" {
+		t.Errorf("timedJoin() addr = %v, want localhost:20000", latency.Addr)
+	}
+	if len(latency.Attempts) != 3 {
+		t.Fatalf("timedJoin() made %d attempts, want 3", len(latency.Attempts))
+	}
+	var sum time.Duration
+	for i, d := range latency.Attempts {
+		if d < delay {
+			t.Errorf("timedJoin() attempt %d took %v, want at least %v", i, d, delay)
+		}
+		sum += d
+	}
+	if latency.Total < sum {
+		t.Errorf("timedJoin() total %v, want at least the attempt sum %v", latency.Total, sum)
+	}
+
+	// Without retries, a failed attempt is reported as is.
+	calls = 0
+	latency = timedJoin(ctx, "localhost:20000", attempt, nil)
+	if !errors.IsDeadlineExceeded(latency.Err) {
+		t.Errorf("timedJoin() error %v, want %v", latency.Err, errors.ErrDeadlineExceeded)
+	}
+	if len(latency.Attempts) != 1 || latency.Attempts[0] < delay || latency.Total < latency.Attempts[0] {
+		t.Errorf("timedJoin() attempts %v total %v, want one attempt of at least %v", latency.Attempts, latency.Total, delay)
+	}
+	// A join turned away by a recovering admin server fails.
+	latency = timedJoin(ctx, "localhost:20000", attempt, nil)
+	if latency.Err != errRecovering {
+		t.Errorf("timedJoin() turned away error %v, want %v", latency.Err, errRecovering)
+	}
+}
@@ -16,14 +16,25 @@ package location_test
 
 import (
 	"context"
+	"errors"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+	"saxml/admin/admin"
 	"saxml/common/addr"
+	"saxml/common/adminmock"
+	"saxml/common/cell"
+	"saxml/common/config"
+	saxerrors "saxml/common/errors"
 	"saxml/common/location"
 	"saxml/common/platform/env"
 	_ "saxml/common/platform/register" // registers a platform
@@ -55,6 +66,280 @@ func TestSetFetchAddr(t *testing.T) {
 	}
 }
 
+// modelsByShard returns a model ID in each of numShards admin shards of a cell.
+func modelsByShard(t *testing.T, saxCell string, numShards int) []string {
+	t.Helper()
+	models := make([]string, numShards)
+	for i, found := 0, 0; found < numShards; i++ {
+		if i > 1000 {
+			t.Fatalf("Found no model IDs for all %d shards", numShards)
+		}
+		modelID := saxCell + "/model" + strconv.Itoa(i)
+		if shard := addr.ShardOf(modelID, numShards); models[shard] == "" {
+			models[shard] = modelID
+			found++
+		}
+	}
+	return models
+}
+
+// Tests that models are routed to the admin shard owning them, and that a shard changing leaders
+// leaves other shards alone.
+func TestSetFetchShardAddr(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-addr-shard"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	const numShards = 2
+	ports := []int{10010, 10011}
+	closers := make([]chan<- struct{}, numShards)
+	for shard, port := range ports {
+		c, err := addr.SetShardAddr(ctx, port, saxCell, shard, numShards)
+		if err != nil {
+			t.Fatalf("SetShardAddr(%v, %s, %d) error %v, want no error", port, saxCell, shard, err)
+		}
+		closers[shard] = c
+	}
+	defer close(closers[0])
+
+	if got, err := addr.NumShards(ctx, saxCell); err != nil || got != numShards {
+		t.Errorf("NumShards(%s) = %d, %v, want %d, no error", saxCell, got, err, numShards)
+	}
+	checkModelAddrs := func(desc string, wantPorts []int) {
+		t.Helper()
+		for shard, modelID := range modelsByShard(t, saxCell, numShards) {
+			got, err := addr.FetchModelAddr(ctx, saxCell, modelID)
+			wantSuffix := ":" + strconv.Itoa(wantPorts[shard])
+			if err != nil {
+				t.Errorf("%s: FetchModelAddr(%s) error %v, want no error", desc, modelID, err)
+			} else if !strings.HasSuffix(got, wantSuffix) {
+				t.Errorf("%s: FetchModelAddr(%s) = %s, want suffix %s", desc, modelID, got, wantSuffix)
+			}
+		}
+	}
+	checkModelAddrs("initially", ports)
+
+	// Another server takes over shard 1.
+	close(closers[1])
+	c, err := addr.SetShardAddr(ctx, 10012, saxCell, 1, numShards)
+	if err != nil {
+		t.Fatalf("SetShardAddr(10012, %s, 1) error %v, want no error", saxCell, err)
+	}
+	defer close(c)
+	checkModelAddrs("after failover", []int{10010, 10012})
+
+	if _, err := addr.SetShardAddr(ctx, 10013, saxCell, numShards, numShards); err == nil {
+		t.Errorf("SetShardAddr(10013, %s, %d) succeeded, want error", saxCell, numShards)
+	}
+}
+
+// Tests that the address can still be fetched when some location file replicas are unreadable.
+func TestFetchAddrReplicaUnavailable(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-addr-replica"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	port := 10001
+	c, err := addr.SetAddr(ctx, port, saxCell)
+	if err != nil {
+		t.Fatalf("SetAddr(%v, %s) error %v, want no error", port, saxCell, err)
+	}
+	defer close(c)
+
+	path, err := cell.Path(ctx, saxCell)
+	if err != nil {
+		t.Fatalf("Path(%s) error %v, want no error", saxCell, err)
+	}
+	fnames := addr.LocationFiles(ctx, path)
+	if len(fnames) != addr.DefaultLocationReplicas {
+		t.Fatalf("LocationFiles(%s) = %v, want %d files", path, fnames, addr.DefaultLocationReplicas)
+	}
+
+	// Corrupt all but the last replica, one at a time.
+	wantSuffix := strconv.Itoa(port)
+	for _, fname := range fnames[:len(fnames)-1] {
+		if err := env.Get().WriteFile(ctx, fname, "", []byte("corrupted")); err != nil {
+			t.Fatalf("WriteFile(%s) error %v, want no error", fname, err)
+		}
+		got, err := addr.FetchAddr(ctx, saxCell)
+		if err != nil {
+			t.Errorf("FetchAddr(%s) with %s corrupted error %v, want no error", saxCell, fname, err)
+		} else if !strings.HasSuffix(got, wantSuffix) {
+			t.Errorf("FetchAddr(%s) with %s corrupted = %s, want suffix %s", saxCell, fname, got, wantSuffix)
+		}
+	}
+
+	// With every replica corrupted, the error for the primary is returned.
+	last := fnames[len(fnames)-1]
+	if err := env.Get().WriteFile(ctx, last, "", []byte("corrupted")); err != nil {
+		t.Fatalf("WriteFile(%s) error %v, want no error", last, err)
+	}
+	if got, err := addr.FetchAddr(ctx, saxCell); err == nil {
+		t.Errorf("FetchAddr(%s) with all replicas corrupted = %s, want error", saxCell, got)
+	}
+}
+
+// Tests that the number of location file replicas comes from the cell config, and that watchers
+// see the admin server address through the replicas while the primary copy is corrupted.
+func TestWatchShardLocation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	saxCell := "/sax/test-addr-watch"
+	testutil.SetUp(ctx, t, saxCell, "")
+	cfg, err := config.Load(ctx, saxCell)
+	if err != nil {
+		t.Fatalf("Load(%s) error %v, want no error", saxCell, err)
+	}
+	cfg.LocationReplicas = 2
+	if err := config.Save(ctx, cfg, saxCell, ""); err != nil {
+		t.Fatalf("Save(%s) error %v, want no error", saxCell, err)
+	}
+	port := 10001
+	c, err := addr.SetAddr(ctx, port, saxCell)
+	if err != nil {
+		t.Fatalf("SetAddr(%v, %s) error %v, want no error", port, saxCell, err)
+	}
+	defer close(c)
+
+	path, err := cell.Path(ctx, saxCell)
+	if err != nil {
+		t.Fatalf("Path(%s) error %v, want no error", saxCell, err)
+	}
+	fnames := addr.LocationFiles(ctx, path)
+	if len(fnames) != 2 {
+		t.Fatalf("LocationFiles(%s) = %v, want 2 files", path, fnames)
+	}
+	if err := env.Get().WriteFile(ctx, fnames[0], "", []byte("corrupted")); err != nil {
+		t.Fatalf("WriteFile(%s) error %v, want no error", fnames[0], err)
+	}
+
+	updates, err := addr.WatchShardLocation(ctx, saxCell, 0)
+	if err != nil {
+		t.Fatalf("WatchShardLocation(%s) error %v, want no error", saxCell, err)
+	}
+	wantSuffix := ":" + strconv.Itoa(port)
+	select {
+	case location := <-updates:
+		if got := location.GetLocation(); !strings.HasSuffix(got, wantSuffix) {
+			t.Errorf("WatchShardLocation(%s) got %s, want suffix %s", saxCell, got, wantSuffix)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("WatchShardLocation(%s) got no update in time", saxCell)
+	}
+}
+
+// Tests that readers and watchers prefer the freshest copy of the location over a replica an admin
+// server failed to update.
+func TestFetchAddrStaleReplica(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	saxCell := "/sax/test-addr-stale"
+	testutil.SetUp(ctx, t, saxCell, "")
+	path, err := cell.Path(ctx, saxCell)
+	if err != nil {
+		t.Fatalf("Path(%s) error %v, want no error", saxCell, err)
+	}
+	fnames := addr.LocationFiles(ctx, path)
+
+	c, err := addr.SetAddr(ctx, 10001, saxCell)
+	if err != nil {
+		t.Fatalf("SetAddr(10001, %s) error %v, want no error", saxCell, err)
+	}
+	stale, err := env.Get().ReadFile(ctx, fnames[1])
+	if err != nil {
+		t.Fatalf("ReadFile(%s) error %v, want no error", fnames[1], err)
+	}
+	close(c)
+	c, err = addr.SetAddr(ctx, 10002, saxCell)
+	if err != nil {
+		t.Fatalf("SetAddr(10002, %s) error %v, want no error", saxCell, err)
+	}
+	defer close(c)
+
+	// The first replica missed the takeover and the primary is unreadable.
+	if err := env.Get().WriteFile(ctx, fnames[1], "", stale); err != nil {
+		t.Fatalf("WriteFile(%s) error %v, want no error", fnames[1], err)
+	}
+	if err := env.Get().WriteFile(ctx, fnames[0], "", []byte("corrupted")); err != nil {
+		t.Fatalf("WriteFile(%s) error %v, want no error", fnames[0], err)
+	}
+	if got, err := addr.FetchAddr(ctx, saxCell); err != nil || !strings.HasSuffix(got, ":10002") {
+		t.Errorf("FetchAddr(%s) = (%q, %v), want port 10002", saxCell, got, err)
+	}
+
+	updates, err := addr.WatchShardLocation(ctx, saxCell, 0)
+	if err != nil {
+		t.Fatalf("WatchShardLocation(%s) error %v, want no error", saxCell, err)
+	}
+	select {
+	case location := <-updates:
+		if got := location.GetLocation(); !strings.HasSuffix(got, ":10002") {
+			t.Errorf("WatchShardLocation(%s) got %s, want port 10002", saxCell, got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("WatchShardLocation(%s) got no update in time", saxCell)
+	}
+}
+
+func TestFetchAddrAnomalies(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-addr-anomalies"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	port := 10001
+	c, err := addr.SetAddr(ctx, port, saxCell)
+	if err != nil {
+		t.Fatalf("SetAddr(%v, %s) error %v, want no error", port, saxCell, err)
+	}
+	defer close(c)
+	path, err := cell.Path(ctx, saxCell)
+	if err != nil {
+		t.Fatalf("Path(%s) error %v, want no error", saxCell, err)
+	}
+
+	oversized, err := proto.Marshal(&pb.Location{Location: "localhost:10001" + strings.Repeat(" ", addr.MaxLocationSize)})
+	if err != nil {
+		t.Fatalf("Marshal error %v, want no error", err)
+	}
+	valid, err := proto.Marshal(&pb.Location{Location: "localhost:10001"})
+	if err != nil {
+		t.Fatalf("Marshal error %v, want no error", err)
+	}
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"oversized", oversized},
+		{"truncated", valid[:len(valid)-3]},
+		{"garbled address", []byte("\n\x0bnot-an-addr")},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, err := addr.ParseAddr(ctx, tc.content); !saxerrors.AdminShouldRetry(err) {
+				t.Errorf("ParseAddr() = (%q, %v), want a retriable error", got, err)
+			}
+			for _, fname := range addr.LocationFiles(ctx, path) {
+				if err := env.Get().WriteFile(ctx, fname, "", tc.content); err != nil {
+					t.Fatalf("WriteFile(%s) error %v, want no error", fname, err)
+				}
+			}
+			if got, err := addr.FetchAddr(ctx, saxCell); !saxerrors.AdminShouldRetry(err) {
+				t.Errorf("FetchAddr(%s) = (%q, %v), want a retriable error", saxCell, got, err)
+			}
+		})
+	}
+
+	// Readers recover once a valid location is written again.
+	for _, fname := range addr.LocationFiles(ctx, path) {
+		if err := env.Get().WriteFile(ctx, fname, "", valid); err != nil {
+			t.Fatalf("WriteFile(%s) error %v, want no error", fname, err)
+		}
+	}
+	if got, err := addr.FetchAddr(ctx, saxCell); err != nil || got != "localhost:10001" {
+		t.Errorf("FetchAddr(%s) = (%q, %v), want (localhost:10001, nil)", saxCell, got, err)
+	}
+}
+
 // Test the address watcher using a test cell.
 func TestJoin(t *testing.T) {
 	ctx := context.Background()
@@ -96,6 +381,238 @@ func TestJoin(t *testing.T) {
 	}
 }
 
+// Tests that a model server joining before any admin server has run joins the first one to start.
+func TestJoinBeforeAdmin(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-join-before-admin"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	modelAddr := "localhost:10000"
+	specs := &pb.ModelServer{
+		ChipType:     pb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology: pb.ModelServer_CHIP_TOPOLOGY_2X2,
+	}
+	if err := location.Join(ctx, saxCell, modelAddr, "", "", specs, 0); err != nil {
+		t.Fatalf("Join(%s) error %v, want no error", saxCell, err)
+	}
+
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubAdminServerT(t, port, nil, saxCell)
+
+	// The address watcher notices the admin server's location file within a watch period.
+	var got []string
+	for start := time.Now(); time.Since(start) < 20*time.Second; time.Sleep(100 * time.Millisecond) {
+		resp, err := testutil.CallAdminServer(ctx, saxCell, &pb.WatchLocRequest{Seqno: 0})
+		if err != nil {
+			t.Fatalf("CallAdminServer(%s) error %v, want no error", saxCell, err)
+		}
+		result := watchable.FromProto(resp.(*pb.WatchLocResponse).GetResult())
+		dataset := result.Data
+		if dataset == nil {
+			dataset = watchable.NewDataSet()
+		}
+		dataset.Apply(result.Log)
+		if got = dataset.ToList(); len(got) == 1 && got[0] == modelAddr {
+			return
+		}
+	}
+	t.Errorf("WatchLoc got %v, want [%q]", got, modelAddr)
+}
+
+// Tests that a model server joins only if its preflight check passes.
+func TestJoinPreflight(t *testing.T) {
+	errPreflight := errors.New("canary inference failed")
+	tests := []struct {
+		desc      string
+		preflight func(ctx context.Context) error
+		wantErr   error
+		wantAddrs int
+	}{
+		{
+			desc:      "pass",
+			preflight: func(ctx context.Context) error { return nil },
+			wantAddrs: 1,
+		},
+		{
+			desc:      "fail",
+			preflight: func(ctx context.Context) error { return errPreflight },
+			wantErr:   errPreflight,
+			wantAddrs: 0,
+		},
+	}
+	for i, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := context.Background()
+			saxCell := "/sax/test-join-preflight-" + strconv.Itoa(i)
+			testutil.SetUp(ctx, t, saxCell, "")
+
+			port, err := env.Get().PickUnusedPort()
+			if err != nil {
+				t.Fatalf("PickUnusedPort() error %v, want no error", err)
+			}
+			testutil.StartStubAdminServerT(t, port, nil, saxCell)
+
+			modelAddr := "localhost:10000"
+			specs := &pb.ModelServer{
+				ChipType:     pb.ModelServer_CHIP_TYPE_TPU_V4,
+				ChipTopology: pb.ModelServer_CHIP_TOPOLOGY_2X2,
+			}
+			err = location.Join(ctx, saxCell, modelAddr, "", "", specs, 0, location.WithPreflightServe(tc.preflight))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Join(%s) error %v, want %v", saxCell, err, tc.wantErr)
+			}
+
+			// Give the address watcher enough time to call Join.
+			time.Sleep(3 * time.Second)
+			resp, err := testutil.CallAdminServer(ctx, saxCell, &pb.WatchLocRequest{Seqno: 0})
+			if err != nil {
+				t.Fatalf("CallAdminServer(%s) error %v, want no error", saxCell, err)
+			}
+			result := watchable.FromProto(resp.(*pb.WatchLocResponse).GetResult())
+			dataset := result.Data
+			if dataset == nil {
+				dataset = watchable.NewDataSet()
+			}
+			dataset.Apply(result.Log)
+			if got := dataset.ToList(); len(got) != tc.wantAddrs {
+				t.Errorf("WatchLoc got %v, want %d addresses", got, tc.wantAddrs)
+			}
+		})
+	}
+}
+
+// Tests that Join tries a cached admin address before the address watcher kicks in, and that it
+// falls back to and remembers the resolved address when the cached one is stale.
+func TestJoinAddrCacheFile(t *testing.T) {
+	tests := []struct {
+		desc  string
+		stale bool
+		wait  time.Duration
+	}{
+		{
+			desc: "cached",
+			// Well below the address watcher's initial delay.
+			wait: 500 * time.Millisecond,
+		},
+		{
+			desc:  "stale",
+			stale: true,
+			wait:  3 * time.Second,
+		},
+	}
+	for i, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := context.Background()
+			saxCell := "/sax/test-join-cache-" + strconv.Itoa(i)
+			testutil.SetUp(ctx, t, saxCell, "")
+
+			port, err := env.Get().PickUnusedPort()
+			if err != nil {
+				t.Fatalf("PickUnusedPort() error %v, want no error", err)
+			}
+			testutil.StartStubAdminServerT(t, port, nil, saxCell)
+			adminAddr, err := addr.FetchAddr(ctx, saxCell)
+			if err != nil {
+				t.Fatalf("FetchAddr(%s) error %v, want no error", saxCell, err)
+			}
+
+			cached := adminAddr
+			if tc.stale {
+				stalePort, err := env.Get().PickUnusedPort()
+				if err != nil {
+					t.Fatalf("PickUnusedPort() error %v, want no error", err)
+				}
+				cached = "localhost:" + strconv.Itoa(stalePort)
+			}
+			cacheFile := filepath.Join(t.TempDir(), "admin_addr")
+			if err := os.WriteFile(cacheFile, []byte(cached), 0644); err != nil {
+				t.Fatalf("WriteFile(%s) error %v, want no error", cacheFile, err)
+			}
+
+			modelAddr := "localhost:10000"
+			specs := &pb.ModelServer{
+				ChipType:     pb.ModelServer_CHIP_TYPE_TPU_V4,
+				ChipTopology: pb.ModelServer_CHIP_TOPOLOGY_2X2,
+			}
+			if err := location.Join(ctx, saxCell, modelAddr, "", "", specs, 0, location.WithAddrCacheFile(cacheFile)); err != nil {
+				t.Fatalf("Join(%s) error %v, want no error", saxCell, err)
+			}
+
+			time.Sleep(tc.wait)
+			resp, err := testutil.CallAdminServer(ctx, saxCell, &pb.WatchLocRequest{Seqno: 0})
+			if err != nil {
+				t.Fatalf("CallAdminServer(%s) error %v, want no error", saxCell, err)
+			}
+			result := watchable.FromProto(resp.(*pb.WatchLocResponse).GetResult())
+			dataset := result.Data
+			if dataset == nil {
+				dataset = watchable.NewDataSet()
+			}
+			dataset.Apply(result.Log)
+			if got := dataset.ToList(); len(got) != 1 {
+				t.Errorf("WatchLoc got %v, want 1 address", got)
+			}
+
+			if tc.stale {
+				got, err := os.ReadFile(cacheFile)
+				if err != nil {
+					t.Fatalf("ReadFile(%s) error %v, want no error", cacheFile, err)
+				}
+				if string(got) != adminAddr {
+					t.Errorf("Cached admin address %q, want %q", got, adminAddr)
+				}
+			}
+		})
+	}
+}
+
+// Tests the Join request contents using a mock admin client.
+func TestJoinClient(t *testing.T) {
+	ctx := context.Background()
+	client := adminmock.New()
+	specs := &pb.ModelServer{
+		ChipType:           pb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology:       pb.ModelServer_CHIP_TOPOLOGY_2X2,
+		ServableModelPaths: []string{"saxml.server.lm.params.lm_cloud.LmCloudSpmd2B"},
+	}
+	if err := location.JoinClient(ctx, client, "localhost:10000", "localhost:10001", "localhost:10002", specs); err != nil {
+		t.Fatalf("JoinClient() error %v, want no error", err)
+	}
+
+	want := []proto.Message{
+		&pb.JoinRequest{
+			Address:      "localhost:10000",
+			DebugAddress: "localhost:10001",
+			DataAddress:  "localhost:10002",
+			ModelServer:  specs,
+		},
+	}
+	if diff := cmp.Diff(want, client.Requests(), protocmp.Transform()); diff != "" {
+		t.Errorf("JoinClient() requests unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Errors from the admin server are returned as is.
+	client.JoinFunc = func(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse, error) {
+		return nil, saxerrors.ErrDeadlineExceeded
+	}
+	if err := location.JoinClient(ctx, client, "localhost:10000", "", "", specs); !saxerrors.JoinShouldRetry(err) {
+		t.Errorf("JoinClient() error %v, want a retriable error", err)
+	}
+	// An admin server restoring its state turns the model server away without an error.
+	client.JoinFunc = func(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse, error) {
+		return &pb.JoinResponse{Recovering: true}, nil
+	}
+	if err := location.JoinClient(ctx, client, "localhost:10000", "", "", specs); err == nil {
+		t.Error("JoinClient() turned away by a recovering admin server succeeded, want an error")
+	}
+	if got := len(client.Requests()); got != 3 {
+		t.Errorf("len(Requests()) = %d, want 3", got)
+	}
+}
+
 // Test the address watcher in a test cell where no admin server has ever run.
 func TestJoinEmptyCell(t *testing.T) {
 	ctx := context.Background()
@@ -117,6 +634,131 @@ func TestJoinEmptyCell(t *testing.T) {
 	}
 }
 
+func TestJoinFromConfig(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-join-config"
+	testutil.SetUp(ctx, t, saxCell, "")
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubAdminServerT(t, port, nil, saxCell)
+
+	modelAddr := "localhost:10000"
+	path := filepath.Join(t.TempDir(), "join.textproto")
+	content := `
+		sax_cell: "` + saxCell + `"
+		address: "` + modelAddr + `"
+		model_server {
+			chip_type: CHIP_TYPE_TPU_V4
+			chip_topology: CHIP_TOPOLOGY_2X2
+		}
+		join_history_size: 4
+	`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile(%s) error %v, want no error", path, err)
+	}
+	if err := location.JoinFromConfig(ctx, path); err != nil {
+		t.Fatalf("JoinFromConfig(%s) error %v, want no error", path, err)
+	}
+
+	time.Sleep(time.Second)
+	resp, err := testutil.CallAdminServer(ctx, saxCell, &pb.WatchLocRequest{Seqno: 0})
+	if err != nil {
+		t.Fatalf("CallAdminServer(%s) error %v, want no error", saxCell, err)
+	}
+	result := watchable.FromProto(resp.(*pb.WatchLocResponse).GetResult())
+	dataset := result.Data
+	if dataset == nil {
+		dataset = watchable.NewDataSet()
+	}
+	dataset.Apply(result.Log)
+	if got := dataset.ToList(); len(got) != 1 || got[0] != modelAddr {
+		t.Errorf("WatchLoc got %v, want [%q]", got, modelAddr)
+	}
+}
+
+func TestLoadJoinConfigInvalid(t *testing.T) {
+	ctx := context.Background()
+	const specs = `model_server { chip_type: CHIP_TYPE_TPU_V4 chip_topology: CHIP_TOPOLOGY_2X2 }`
+	tests := []struct {
+		desc      string
+		content   string
+		wantField string
+	}{
+		{"missing cell", `address: "localhost:10000" ` + specs, "sax_cell"},
+		{"bad cell", `sax_cell: "test" address: "localhost:10000" ` + specs, "sax_cell"},
+		{"missing address", `sax_cell: "/sax/test" ` + specs, "address"},
+		{"bad port", `sax_cell: "/sax/test" address: "localhost:99999" ` + specs, "address"},
+		{"bad debug port", `sax_cell: "/sax/test" address: "localhost:10000" debug_address: "localhost:http" ` + specs, "debug_address"},
+		{"bad admin port", `sax_cell: "/sax/test" address: "localhost:10000" admin_port: 70000 ` + specs, "admin_port"},
+		{"missing specs", `sax_cell: "/sax/test" address: "localhost:10000"`, "model_server"},
+		{"missing chip type", `sax_cell: "/sax/test" address: "localhost:10000" model_server { chip_topology: CHIP_TOPOLOGY_2X2 }`, "model_server.chip_type"},
+		{"unknown field", `sax_cell: "/sax/test" port: 10000`, "port"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "join.textproto")
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatalf("WriteFile(%s) error %v, want no error", path, err)
+			}
+			_, err := location.LoadJoinConfig(ctx, path)
+			if !errors.Is(err, saxerrors.ErrInvalidArgument) {
+				t.Fatalf("LoadJoinConfig(%q) error %v, want %v", tc.content, err, saxerrors.ErrInvalidArgument)
+			}
+			if !strings.Contains(err.Error(), tc.wantField) {
+				t.Errorf("LoadJoinConfig(%q) error %v, want it to name %s", tc.content, err, tc.wantField)
+			}
+			if err := location.JoinFromConfig(ctx, path); !errors.Is(err, saxerrors.ErrInvalidArgument) {
+				t.Errorf("JoinFromConfig(%q) error %v, want %v", tc.content, err, saxerrors.ErrInvalidArgument)
+			}
+		})
+	}
+}
+
+func TestValidateHostPort(t *testing.T) {
+	for _, hostPort := range []string{"localhost:10000", "1.2.3.4:10000", "[::1]:10000", "[2001:db8::1]:65535", "model-server.example.com:80"} {
+		if err := addr.ValidateHostPort(hostPort); err != nil {
+			t.Errorf("ValidateHostPort(%q) error %v, want no error", hostPort, err)
+		}
+	}
+	for _, hostPort := range []string{"", "localhost", "localhost:", ":10000", "::1:10000", "localhost:port", "localhost:0", "localhost:65536", "localhost:-1", "http://localhost:10000"} {
+		if err := addr.ValidateHostPort(hostPort); !errors.Is(err, saxerrors.ErrInvalidArgument) {
+			t.Errorf("ValidateHostPort(%q) error %v, want %v", hostPort, err, saxerrors.ErrInvalidArgument)
+		}
+	}
+}
+
+func TestJoinMalformedAddrs(t *testing.T) {
+	ctx := context.Background()
+	// The addresses are checked before the Sax cell is even looked up.
+	saxCell := "/sax/test-join-malformed"
+	specs := &pb.ModelServer{
+		ChipType:     pb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology: pb.ModelServer_CHIP_TOPOLOGY_2X2,
+	}
+	tests := []struct {
+		name      string
+		ipPort    string
+		debugAddr string
+	}{
+		{"empty ipPort", "", ""},
+		{"ipPort without port", "localhost", ""},
+		{"ipPort with unbracketed IPv6", "::1:10000", ""},
+		{"ipPort with bad port", "localhost:http", ""},
+		{"debugAddr without port", "localhost:10000", "localhost"},
+		{"debugAddr without host", "localhost:10000", ":10001"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := location.Join(ctx, saxCell, tc.ipPort, tc.debugAddr, "", specs, 0)
+			if !errors.Is(err, saxerrors.ErrInvalidArgument) {
+				t.Errorf("Join(%q, %q) error %v, want %v", tc.ipPort, tc.debugAddr, err, saxerrors.ErrInvalidArgument)
+			}
+		})
+	}
+}
+
 // Tests leader election between a few participants.
 func TestLeaderElection(t *testing.T) {
 	ctx := context.Background()
@@ -155,3 +797,224 @@ func TestLeaderElection(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// Tests that Ready reports a joined model server as ready only after it has joined the admin
+// server and while its preflight check passes.
+func TestJoinReady(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-join-ready"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubAdminServerT(t, port, nil, saxCell)
+
+	var mu sync.Mutex
+	var servingErr error
+	preflight := func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return servingErr
+	}
+	modelAddr := "localhost:10000"
+	specs := &pb.ModelServer{
+		ChipType:     pb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology: pb.ModelServer_CHIP_TOPOLOGY_2X2,
+	}
+	if err := location.Join(ctx, saxCell, modelAddr, "", "", specs, 0, location.WithPreflightServe(preflight)); err != nil {
+		t.Fatalf("Join(%s) error %v, want no error", saxCell, err)
+	}
+
+	// The address watcher hasn't called Join yet.
+	if err := location.Ready(ctx); !errors.Is(err, saxerrors.ErrUnavailable) {
+		t.Errorf("Ready() right after Join error %v, want %v", err, saxerrors.ErrUnavailable)
+	}
+
+	// Give the address watcher enough time to call Join.
+	time.Sleep(3 * time.Second)
+	if err := location.Ready(ctx); err != nil {
+		t.Errorf("Ready() after joining error %v, want no error", err)
+	}
+
+	mu.Lock()
+	servingErr = errors.New("canary inference failed")
+	mu.Unlock()
+	if err := location.Ready(ctx); !errors.Is(err, saxerrors.ErrUnavailable) {
+		t.Errorf("Ready() with a failing preflight check error %v, want %v", err, saxerrors.ErrUnavailable)
+	}
+}
+
+// joinedAddrs returns the model server addresses joined to the stub admin server of saxCell.
+func joinedAddrs(ctx context.Context, t *testing.T, saxCell string) []string {
+	t.Helper()
+	resp, err := testutil.CallAdminServer(ctx, saxCell, &pb.WatchLocRequest{Seqno: 0})
+	if err != nil {
+		t.Fatalf("CallAdminServer(%s) error %v, want no error", saxCell, err)
+	}
+	result := watchable.FromProto(resp.(*pb.WatchLocResponse).GetResult())
+	dataset := result.Data
+	if dataset == nil {
+		dataset = watchable.NewDataSet()
+	}
+	dataset.Apply(result.Log)
+	return dataset.ToList()
+}
+
+// Tests that a Joiner moves a model server from one cell to another at runtime.
+func TestJoinerSwitchCell(t *testing.T) {
+	ctx := context.Background()
+	oldCell := "/sax/test-joiner-old"
+	newCell := "/sax/test-joiner-new"
+	testutil.SetUp(ctx, t, oldCell, "")
+	testutil.SetUp(ctx, t, newCell, "")
+
+	oldPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	oldCloser, err := testutil.StartStubAdminServer(oldPort, nil, oldCell)
+	if err != nil {
+		t.Fatalf("StartStubAdminServer(%s) error %v, want no error", oldCell, err)
+	}
+	newPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubAdminServerT(t, newPort, nil, newCell)
+
+	modelAddr := "localhost:10000"
+	specs := &pb.ModelServer{
+		ChipType:     pb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology: pb.ModelServer_CHIP_TOPOLOGY_2X2,
+	}
+	joiner := location.NewJoiner(modelAddr, "", "", specs, 0)
+	defer joiner.Leave()
+	if err := joiner.Join(ctx, oldCell); err != nil {
+		t.Fatalf("Join(%s) error %v, want no error", oldCell, err)
+	}
+	time.Sleep(3 * time.Second)
+	if got := joinedAddrs(ctx, t, oldCell); len(got) != 1 || got[0] != modelAddr {
+		t.Errorf("Joined addresses of %s got %v, want [%q]", oldCell, got, modelAddr)
+	}
+
+	if err := joiner.Join(ctx, newCell); err != nil {
+		t.Fatalf("Join(%s) error %v, want no error", newCell, err)
+	}
+	if got := joiner.Cell(); got != newCell {
+		t.Errorf("Cell() = %q, want %q", got, newCell)
+	}
+	time.Sleep(3 * time.Second)
+	if got := joinedAddrs(ctx, t, newCell); len(got) != 1 || got[0] != modelAddr {
+		t.Errorf("Joined addresses of %s got %v, want [%q]", newCell, got, modelAddr)
+	}
+
+	// A new admin server in the old cell is no longer joined.
+	close(oldCloser)
+	restartPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubAdminServerT(t, restartPort, nil, oldCell)
+	time.Sleep(3 * time.Second)
+	if got := joinedAddrs(ctx, t, oldCell); len(got) != 0 {
+		t.Errorf("Joined addresses of %s after switching cells got %v, want none", oldCell, got)
+	}
+
+	joiner.Leave()
+	if got := joiner.Cell(); got != "" {
+		t.Errorf("Cell() after Leave = %q, want empty", got)
+	}
+}
+
+// Tests that an admin server prompting a model server to rejoin makes it join again right away.
+func TestAdminPromptsRejoin(t *testing.T) {
+	ctx := context.Background()
+	saxCell := "/sax/test-prompt-rejoin"
+	testutil.SetUp(ctx, t, saxCell, "")
+
+	adminPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	adminServer := admin.NewServer(saxCell, adminPort)
+	if err := adminServer.Start(ctx); err != nil {
+		t.Fatalf("Start() error %v, want no error", err)
+	}
+	defer adminServer.Close()
+
+	modelPort, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubModelServerT(t, modelPort)
+	modelAddr := "localhost:" + strconv.Itoa(modelPort)
+	specs := &pb.ModelServer{
+		ChipType:     pb.ModelServer_CHIP_TYPE_TPU_V4,
+		ChipTopology: pb.ModelServer_CHIP_TOPOLOGY_2X2,
+	}
+	joiner := location.NewJoiner(modelAddr, "", "", specs, 0)
+	defer joiner.Leave()
+	if err := testutil.SetStubModelServerRejoin(modelPort, joiner.Rejoin); err != nil {
+		t.Fatalf("SetStubModelServerRejoin() error %v, want no error", err)
+	}
+	if err := joiner.Join(ctx, saxCell); err != nil {
+		t.Fatalf("Join(%s) error %v, want no error", saxCell, err)
+	}
+	time.Sleep(3 * time.Second)
+	joins := len(location.RecentJoins())
+	if joins == 0 {
+		t.Fatalf("RecentJoins() is empty, want the first join")
+	}
+
+	// The next periodic join is far off, so a prompt join must come from the prompt.
+	if err := adminServer.Mgr.PromptRejoin(ctx, modelAddr); err != nil {
+		t.Fatalf("PromptRejoin(%s) error %v, want no error", modelAddr, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(location.RecentJoins()) == joins {
+		if time.Now().After(deadline) {
+			t.Fatalf("No join within a second of PromptRejoin(%s), want a rejoin", modelAddr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Tests that a cell name resolving to different admin servers across roots is reported.
+func TestFindAmbiguousCells(t *testing.T) {
+	ctx := context.Background()
+	writeCell := func(root, name, location string) {
+		t.Helper()
+		dir := filepath.Join(root, "sax", name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll(%s) error %v, want no error", dir, err)
+		}
+		content, err := proto.Marshal(&pb.Location{Location: location})
+		if err != nil {
+			t.Fatalf("Marshal error %v, want no error", err)
+		}
+		fname := addr.LocationFiles(ctx, dir)[0]
+		if err := env.Get().WriteFile(ctx, fname, "", content); err != nil {
+			t.Fatalf("WriteFile(%s) error %v, want no error", fname, err)
+		}
+	}
+	rootA, rootB, empty := t.TempDir(), t.TempDir(), t.TempDir()
+	writeCell(rootA, "shared", "10.0.0.1:10000")
+	writeCell(rootB, "shared", "10.0.0.1:10000")
+	writeCell(rootA, "dup", "10.0.0.1:10001")
+	writeCell(rootB, "dup", "10.0.0.2:10001")
+	writeCell(rootB, "only-b", "10.0.0.2:10002")
+
+	got, err := addr.FindAmbiguousCells(ctx, []string{rootA, rootB, empty})
+	if err != nil {
+		t.Fatalf("FindAmbiguousCells() error %v, want no error", err)
+	}
+	want := []addr.CellAmbiguity{{
+		SaxCell: "/sax/dup",
+		Addrs:   map[string]string{rootA: "10.0.0.1:10001", rootB: "10.0.0.2:10001"},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FindAmbiguousCells() unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package location
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"saxml/common/addr"
+	"saxml/common/errors"
+	"saxml/common/naming"
+	"saxml/common/platform/env"
+
+	pb "saxml/protobuf/admin_go_proto_grpc"
+)
+
+// LoadJoinConfig reads a JoinConfig text proto file and validates it.
+func LoadJoinConfig(ctx context.Context, path string) (*pb.JoinConfig, error) {
+	content, err := env.Get().ReadFile(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("join config %s: %w", path, err)
+	}
+	cfg := &pb.JoinConfig{}
+	if err := prototext.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("join config %s: %v: %w", path, err, errors.ErrInvalidArgument)
+	}
+	if err := validateJoinConfig(cfg); err != nil {
+		return nil, fmt.Errorf("join config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// validateJoinConfig checks the fields of cfg, naming the first bad one in the error.
+func validateJoinConfig(cfg *pb.JoinConfig) error {
+	if cfg.GetSaxCell() == "" {
+		return fmt.Errorf("sax_cell is missing: %w", errors.ErrInvalidArgument)
+	}
+	if _, err := naming.NewCellFullName(cfg.GetSaxCell()); err != nil {
+		return fmt.Errorf("sax_cell %q: %v: %w", cfg.GetSaxCell(), err, errors.ErrInvalidArgument)
+	}
+	if cfg.GetAddress() == "" {
+		return fmt.Errorf("address is missing: %w", errors.ErrInvalidArgument)
+	}
+	if err := addr.ValidateHostPort(cfg.GetAddress()); err != nil {
+		return fmt.Errorf("address: %w", err)
+	}
+	if debugAddr := cfg.GetDebugAddress(); debugAddr != "" {
+		if err := addr.ValidateHostPort(debugAddr); err != nil {
+			return fmt.Errorf("debug_address: %w", err)
+		}
+	}
+	if dataAddr := cfg.GetDataAddress(); dataAddr != "" {
+		if err := addr.ValidateHostPort(dataAddr); err != nil {
+			return fmt.Errorf("data_address: %w", err)
+		}
+	}
+	specs := cfg.GetModelServer()
+	if specs == nil {
+		return fmt.Errorf("model_server is missing: %w", errors.ErrInvalidArgument)
+	}
+	if specs.GetChipType() == pb.ModelServer_CHIP_TYPE_UNKNOWN {
+		return fmt.Errorf("model_server.chip_type is missing: %w", errors.ErrInvalidArgument)
+	}
+	if specs.GetChipTopology() == pb.ModelServer_CHIP_TOPOLOGY_UNKNOWN {
+		return fmt.Errorf("model_server.chip_topology is missing: %w", errors.ErrInvalidArgument)
+	}
+	if port := cfg.GetAdminPort(); port < 0 || port > 65535 {
+		return fmt.Errorf("admin_port %d is not a valid port: %w", port, errors.ErrInvalidArgument)
+	}
+	if size := cfg.GetJoinHistorySize(); size < 0 {
+		return fmt.Errorf("join_history_size %d must be non-negative: %w", size, errors.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// JoinFromConfig is Join with its arguments read from a JoinConfig text proto file at path, so that
+// deployments can keep them in one reviewable file instead of a long list of flags. The file is
+// validated before joining. opts are applied after the options set by the file.
+func JoinFromConfig(ctx context.Context, path string, opts ...Option) error {
+	cfg, err := LoadJoinConfig(ctx, path)
+	if err != nil {
+		return err
+	}
+	var options []Option
+	if cacheFile := cfg.GetAddrCacheFile(); cacheFile != "" {
+		options = append(options, WithAddrCacheFile(cacheFile))
+	}
+	if size := cfg.GetJoinHistorySize(); size > 0 {
+		options = append(options, WithJoinHistorySize(int(size)))
+	}
+	options = append(options, opts...)
+	return Join(ctx, cfg.GetSaxCell(), cfg.GetAddress(), cfg.GetDebugAddress(), cfg.GetDataAddress(), cfg.GetModelServer(), int(cfg.GetAdminPort()), options...)
+}
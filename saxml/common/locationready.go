@@ -0,0 +1,165 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package location
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"saxml/common/errors"
+)
+
+// JoinAttempt records one Join RPC attempt of a model server.
+type JoinAttempt struct {
+	// Start is when the attempt started.
+	Start time.Time
+	// Addr is the admin server address the attempt targeted.
+	Addr string
+	// Duration is how long the attempt took.
+	Duration time.Duration
+	// Err is the error the attempt failed with, or nil if it succeeded.
+	Err error
+}
+
+// String returns a human-readable string for debugging.
+func (a JoinAttempt) String() string {
+	outcome := "ok"
+	if a.Err != nil {
+		outcome = a.Err.Error()
+	}
+	return fmt.Sprintf("%s %s (%v): %s", a.Start.Format(time.RFC3339Nano), a.Addr, a.Duration, outcome)
+}
+
+// joinHistory is a ring buffer of the most recent Join RPC attempts.
+type joinHistory struct {
+	mu       sync.Mutex
+	attempts []JoinAttempt
+	next     int  // index in attempts to overwrite next
+	full     bool // whether attempts has wrapped around
+}
+
+func newJoinHistory(size int) *joinHistory {
+	if size <= 0 {
+		size = 1
+	}
+	return &joinHistory{attempts: make([]JoinAttempt, size)}
+}
+
+func (h *joinHistory) record(a JoinAttempt) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.attempts[h.next] = a
+	h.next = (h.next + 1) % len(h.attempts)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns the recorded attempts, oldest first.
+func (h *joinHistory) list() []JoinAttempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]JoinAttempt(nil), h.attempts[:h.next]...)
+	}
+	return append(append([]JoinAttempt(nil), h.attempts[h.next:]...), h.attempts[:h.next]...)
+}
+
+// readiness tracks the subsystems started by a Join call.
+type readiness struct {
+	mu sync.Mutex
+	// Whether the last Join RPC to the admin server succeeded.
+	joined bool
+	// Whether Join starts an admin server, and how that went.
+	adminWanted  bool
+	adminStarted bool
+	adminErr     error
+	// Checks whether the model server can serve, if not nil.
+	serving func(ctx context.Context) error
+	// Recent Join RPC attempts, if not nil.
+	history *joinHistory
+}
+
+func (r *readiness) setJoined(joined bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.joined = joined
+}
+
+func (r *readiness) setAdminStarted(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.adminStarted = err == nil
+	r.adminErr = err
+}
+
+func (r *readiness) check(ctx context.Context) error {
+	r.mu.Lock()
+	joined, adminWanted, adminStarted, adminErr := r.joined, r.adminWanted, r.adminStarted, r.adminErr
+	r.mu.Unlock()
+
+	if adminWanted && adminErr != nil {
+		return fmt.Errorf("admin server failed to start: %v: %w", adminErr, errors.ErrUnavailable)
+	}
+	if adminWanted && !adminStarted {
+		return fmt.Errorf("admin server not started yet: %w", errors.ErrUnavailable)
+	}
+	if !joined {
+		return fmt.Errorf("model server not joined to the admin server: %w", errors.ErrUnavailable)
+	}
+	if r.serving != nil {
+		if err := r.serving(ctx); err != nil {
+			return fmt.Errorf("model server not serving: %v: %w", err, errors.ErrUnavailable)
+		}
+	}
+	return nil
+}
+
+var (
+	// The readiness of the most recent Join call, or nil if Join hasn't been called.
+	muReady sync.Mutex
+	ready   *readiness
+)
+
+// Ready returns nil if everything the most recent Join call started is up: the admin server, if
+// Join was asked to start one, has been elected and started; the model server has joined the
+// admin server; and the model server passes its preflight serving check, if Join was given one.
+// Otherwise, it returns an Unavailable error naming the first subsystem that isn't up.
+//
+// Ready is suitable as a readiness probe. It runs the preflight serving check on each call.
+func Ready(ctx context.Context) error {
+	muReady.Lock()
+	r := ready
+	muReady.Unlock()
+	if r == nil {
+		return fmt.Errorf("no Join call: %w", errors.ErrUnavailable)
+	}
+	return r.check(ctx)
+}
+
+// RecentJoins returns the most recent Join RPC attempts of the most recent Join call, oldest
+// first, e.g. for a debug page showing whether joins flap. WithJoinHistorySize sets how many
+// attempts are kept.
+func RecentJoins() []JoinAttempt {
+	muReady.Lock()
+	r := ready
+	muReady.Unlock()
+	if r == nil || r.history == nil {
+		return nil
+	}
+	return r.history.list()
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package location
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	saxerrors "saxml/common/errors"
+)
+
+func TestReadinessCheck(t *testing.T) {
+	errServing := errors.New("canary inference failed")
+	serving := func(ctx context.Context) error { return nil }
+	notServing := func(ctx context.Context) error { return errServing }
+
+	tests := []struct {
+		desc    string
+		r       *readiness
+		wantErr string // empty if ready
+	}{
+		{
+			desc:    "not joined",
+			r:       &readiness{},
+			wantErr: "not joined",
+		},
+		{
+			desc:    "admin not started",
+			r:       &readiness{joined: true, adminWanted: true},
+			wantErr: "admin server not started",
+		},
+		{
+			desc:    "admin failed",
+			r:       &readiness{joined: true, adminWanted: true, adminErr: errors.New("address in use")},
+			wantErr: "admin server failed to start",
+		},
+		{
+			desc:    "not serving",
+			r:       &readiness{joined: true, serving: notServing},
+			wantErr: errServing.Error(),
+		},
+		{
+			desc: "model server ready",
+			r:    &readiness{joined: true, serving: serving},
+		},
+		{
+			desc: "model and admin servers ready",
+			r:    &readiness{joined: true, adminWanted: true, adminStarted: true, serving: serving},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.r.check(context.Background())
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("check() error %v, want no error", err)
+				}
+				return
+			}
+			if !errors.Is(err, saxerrors.ErrUnavailable) || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("check() error %v, want an Unavailable error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadinessTransitions(t *testing.T) {
+	ctx := context.Background()
+	r := &readiness{adminWanted: true}
+	r.setJoined(true)
+	if err := r.check(ctx); err == nil {
+		t.Error("check() before the admin server starts succeeded, want error")
+	}
+	r.setAdminStarted(nil)
+	if err := r.check(ctx); err != nil {
+		t.Errorf("check() after the admin server starts error %v, want no error", err)
+	}
+	// A later failed Join makes the server not ready again.
+	r.setJoined(false)
+	if err := r.check(ctx); err == nil {
+		t.Error("check() after a failed Join succeeded, want error")
+	}
+}
+
+func TestJoinHistory(t *testing.T) {
+	h := newJoinHistory(3)
+	if got := h.list(); len(got) != 0 {
+		t.Fatalf("list() = %v, want empty", got)
+	}
+
+	errJoin := saxerrors.ErrDeadlineExceeded
+	start := time.Unix(1000, 0)
+	attempts := []JoinAttempt{
+		{Start: start, Addr: "admin-0:10000", Err: errJoin},
+		{Start: start.Add(time.Second), Addr: "admin-0:10000"},
+		{Start: start.Add(2 * time.Second), Addr: "admin-1:10000", Err: errJoin},
+		{Start: start.Add(3 * time.Second), Addr: "admin-1:10000", Err: errJoin},
+		{Start: start.Add(4 * time.Second), Addr: "admin-1:10000"},
+	}
+	for i, a := range attempts {
+		h.record(a)
+		want := attempts[:i+1]
+		if len(want) > 3 {
+			want = want[len(want)-3:]
+		}
+		got := h.list()
+		if len(got) != len(want) {
+			t.Fatalf("After %d attempts, list() = %v, want %v", i+1, got, want)
+		}
+		for j := range want {
+			if got[j] != want[j] {
+				t.Errorf("After %d attempts, list()[%d] = %v, want %v", i+1, j, got[j], want[j])
+			}
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"google.golang.org/protobuf/proto"
 	"saxml/common/location"
 	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/transport"
 
 	pb "saxml/protobuf/admin_go_proto_grpc"
 )
@@ -56,4 +57,31 @@ func sax_join(saxCellPtr *C.char, saxCellSize C.int, ipPortPtr *C.char, ipPortSi
 	return C.CString("")
 }
 
+//export sax_rejoin
+func sax_rejoin() {
+	location.Rejoin()
+}
+
+//export sax_set_transport_files
+func sax_set_transport_files(certPtr *C.char, certSize C.int, keyPtr *C.char, keySize C.int, caPtr *C.char, caSize C.int) *C.char {
+	cert := C.GoStringN(certPtr, certSize)
+	key := C.GoStringN(keyPtr, keySize)
+	ca := C.GoStringN(caPtr, caSize)
+	if err := transport.LoadFiles(cert, key, ca); err != nil {
+		return C.CString(err.Error())
+	}
+	return C.CString("")
+}
+
+//export sax_cell_transport_security
+func sax_cell_transport_security(saxCellPtr *C.char, saxCellSize C.int, policy *C.int) *C.char {
+	saxCell := C.GoStringN(saxCellPtr, saxCellSize)
+	p, err := transport.CellPolicy(context.Background(), saxCell)
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	*policy = C.int(p)
+	return C.CString("")
+}
+
 func main() {}
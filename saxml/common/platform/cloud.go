@@ -38,6 +38,7 @@ import (
 	"google.golang.org/grpc/reflection"
 	"golang.org/x/oauth2/google"
 	"saxml/common/basiceventlogger"
+	"saxml/common/bufferedeventlogger"
 	"saxml/common/errors"
 	"saxml/common/eventlog"
 	"saxml/common/platform/env"
@@ -65,6 +66,9 @@ var (
 	saxRoot  = flag.String("sax_root", "", "Sax cell root, e.g. /local/dir or gs://bucket/dir")
 	testRoot = filepath.Join(os.TempDir(), "sax-test-root")
 
+	eventBufferSize         = flag.Int("event_buffer_size", 1024, "Maximum number of events buffered for the event logger")
+	eventBufferBlockTimeout = flag.Duration("event_buffer_block_timeout", 0, "If positive, how long to wait for room in a full event buffer before dropping the new event. Otherwise, the oldest buffered event is dropped.")
+
 	projectID string
 	gcsClient *storage.Client
 
@@ -72,8 +76,10 @@ var (
 	watchPeriod = 5 * time.Second
 
 	// We don't support cross-process, file lock-based leader election yet.
-	// This in-process implementation makes the unit test pass.
-	muLeader sync.Mutex
+	// This in-process implementation makes the unit test pass. Each leader file has its own lock,
+	// so that admin shards of a cell can all lead at once.
+	muLeaders sync.Mutex
+	leaders   = make(map[string]*sync.Mutex)
 
 	testACLNames = make(map[string][]string)
 
@@ -490,11 +496,19 @@ func (e *Env) Watch(ctx context.Context, path string) (<-chan []byte, error) {
 // Lead blocks until it acquires exclusive access to a file. The caller should arrange calling
 // close() on the returned channel to release the exclusive lock.
 func (e *Env) Lead(ctx context.Context, path string) (chan<- struct{}, error) {
-	muLeader.Lock()
+	muLeaders.Lock()
+	mu, ok := leaders[path]
+	if !ok {
+		mu = &sync.Mutex{}
+		leaders[path] = mu
+	}
+	muLeaders.Unlock()
+
+	mu.Lock()
 	closer := make(chan struct{})
 	go func() {
 		<-closer
-		muLeader.Unlock()
+		mu.Unlock()
 	}()
 	return closer, nil
 }
@@ -612,13 +626,17 @@ func (s *Server) WriteStatusPage(w http.ResponseWriter, data *env.StatusPageData
 }
 
 // NewServer creates a gRPC server.
-func (e *Env) NewServer(ctx context.Context) (env.Server, error) {
-	s := &Server{grpc.NewServer()}
+func (e *Env) NewServer(ctx context.Context, opts ...grpc.ServerOption) (env.Server, error) {
+	s := &Server{grpc.NewServer(opts...)}
 	reflection.Register(s.GRPCServer())
 	return s, nil
 }
 
 // NewEventLogger creates new event logger for cloud environment.
 func (e *Env) NewEventLogger() eventlog.Logger {
-	return basiceventlogger.New()
+	policy := bufferedeventlogger.DropOldest
+	if *eventBufferBlockTimeout > 0 {
+		policy = bufferedeventlogger.Block
+	}
+	return bufferedeventlogger.New(basiceventlogger.New(), *eventBufferSize, policy, *eventBufferBlockTimeout)
 }
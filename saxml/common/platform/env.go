@@ -123,8 +123,8 @@ type Env interface {
 	DialContext(ctx context.Context, target string, opts ...grpc.DialOption) (*grpc.ClientConn, error)
 	// RequiredACLNamePrefixList returns a list of possible strings required to prefix all ACL names.
 	RequiredACLNamePrefixList() []string
-	// NewServer creates a server.
-	NewServer(ctx context.Context) (Server, error)
+	// NewServer creates a server with options, such as the credentials it serves with.
+	NewServer(ctx context.Context, opts ...grpc.ServerOption) (Server, error)
 
 	// NewEventLogger creates new client for logging lineage events.
 	NewEventLogger() eventlog.Logger
@@ -56,3 +56,51 @@ def Join(
   )
   if result:
     raise RuntimeError(result)
+
+
+def Rejoin() -> None:
+  """Rejoin makes the model server join the admin server again right away.
+
+  It applies to the model server set up by the most recent successful Join call,
+  which otherwise joins again only periodically or when the admin server address
+  changes. Model servers call it when the admin server asks them to.
+  """
+  pybind_location.Rejoin()
+
+
+def SetTransportFiles(cert_file: str, key_file: str, ca_file: str) -> None:
+  """Sets the files connections to Sax components are secured with.
+
+  It applies to connections the process makes through this module, e.g. Join.
+
+  Args:
+    cert_file: PEM certificate the process presents, or empty for none.
+    key_file: PEM private key of cert_file, or empty for none.
+    ca_file: PEM certificates of the CAs peers are verified against, or empty
+      for the host's root CAs.
+
+  Raises:
+    RuntimeError: The files can't be loaded.
+  """
+  result: str = pybind_location.SetTransportFiles(cert_file, key_file, ca_file)
+  if result:
+    raise RuntimeError(result)
+
+
+def CellTransportSecurity(sax_cell: str) -> int:
+  """Returns the transport security policy in the config of a Sax cell.
+
+  Args:
+    sax_cell: The Sax cell, e.g. /sax/test.
+
+  Returns:
+    An admin_pb2.Config.TransportSecurity value, which servers of the cell must
+    enforce.
+
+  Raises:
+    RuntimeError: The cell config can't be loaded.
+  """
+  policy, result = pybind_location.CellTransportSecurity(sax_cell)
+  if result:
+    raise RuntimeError(result)
+  return policy
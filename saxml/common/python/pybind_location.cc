@@ -12,14 +12,29 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+#include <string>
+#include <utility>
+
 #include "saxml/common/location.h"
 #include "pybind11/pybind11.h"
+#include "pybind11/stl.h"
 
 namespace sax {
 namespace {
 
 PYBIND11_MODULE(pybind_location, m) {
   m.def("Join", &Join, "Join a Sax admin server");
+  m.def("Rejoin", &Rejoin, "Join the Sax admin server again right away");
+  m.def("SetTransportFiles", &SetTransportFiles,
+        "Set the files Sax connections are secured with");
+  m.def(
+      "CellTransportSecurity",
+      [](const std::string& sax_cell) {
+        int policy = 0;
+        std::string error = CellTransportSecurity(sax_cell, &policy);
+        return std::make_pair(policy, error);
+      },
+      "Get the transport security policy of a Sax cell");
 }
 
 }  // namespace
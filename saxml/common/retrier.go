@@ -17,6 +17,7 @@ package retrier
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -30,12 +31,23 @@ type Closure func() error
 // IsRetriable returns true iff the error is retriable.
 type IsRetriable func(error) bool
 
+// Policy tunes how Do retries. Zero fields keep the defaults.
+type Policy struct {
+	// The maximum number of times to call the query, including the first call. If not positive,
+	// the query is retried until ctx is done.
+	MaxAttempts int
+	// The delay before the first retry.
+	InitialInterval time.Duration
+	// The cap on the delay between retries.
+	MaxInterval time.Duration
+}
+
 // queryRetrier manages retry logic.
 type queryRetrier struct {
 	retryCount int // For logging purpose.
 }
 
-func (q queryRetrier) Do(ctx context.Context, query Closure, retriable IsRetriable) error {
+func (q queryRetrier) Do(ctx context.Context, query Closure, retriable IsRetriable, policy Policy) error {
 	withRetryCheck := func() error {
 		err := query()
 
@@ -65,7 +77,17 @@ func (q queryRetrier) Do(ctx context.Context, query Closure, retriable IsRetriab
 	// The default 1.5 is too large when there are many servers in loading state, where the client
 	// has a high chance of not being able to find a server in loaded state within the deadline.
 	opts.Multiplier = 1.1
-	err := backoff.Retry(withRetryCheck, backoff.WithContext(opts, ctx))
+	if policy.InitialInterval > 0 {
+		opts.InitialInterval = policy.InitialInterval
+	}
+	if policy.MaxInterval > 0 {
+		opts.MaxInterval = policy.MaxInterval
+	}
+	var b backoff.BackOff = opts
+	if policy.MaxAttempts > 0 {
+		b = backoff.WithMaxRetries(b, uint64(policy.MaxAttempts-1))
+	}
+	err := backoff.Retry(withRetryCheck, backoff.WithContext(b, ctx))
 
 	// Check if canceled or deadline exceeded.
 	//
@@ -85,7 +107,47 @@ func (q queryRetrier) Do(ctx context.Context, query Closure, retriable IsRetriab
 // reaching deadline on the context. If an error is not retrieable,
 // the error is considered a permanent error.
 func Do(ctx context.Context, query Closure, retriable IsRetriable) error {
-	return queryRetrier{retryCount: 0}.Do(ctx, query, retriable)
+	return queryRetrier{retryCount: 0}.Do(ctx, query, retriable, Policy{})
+}
+
+// DoWithPolicy is like Do, but tunes retries with policy.
+func DoWithPolicy(ctx context.Context, query Closure, retriable IsRetriable, policy Policy) error {
+	return queryRetrier{retryCount: 0}.Do(ctx, query, retriable, policy)
+}
+
+// ErrRetriableResult is returned by DoWithResult when attempts run out while query still returns
+// results that should be retried.
+var ErrRetriableResult = errors.New("query result still retriable")
+
+// ShouldRetry returns true iff a query returning result and err should be retried. Unlike
+// IsRetriable, it also gets called when err is nil, e.g. for responses that report a "try again
+// later" status in their body instead of as an error.
+type ShouldRetry[T any] func(result T, err error) bool
+
+// DoWithResult is like DoWithPolicy, but decides whether to retry from both the result and the
+// error of query. It returns the result of the last attempt. If attempts run out on a result that
+// should be retried, the error is ErrRetriableResult.
+func DoWithResult[T any](ctx context.Context, query func() (T, error), shouldRetry ShouldRetry[T], policy Policy) (T, error) {
+	var result T
+	attempt := func() error {
+		var err error
+		result, err = query()
+		if err == nil && shouldRetry(result, nil) {
+			return ErrRetriableResult
+		}
+		return err
+	}
+	retriable := func(err error) bool {
+		if errors.Is(err, ErrRetriableResult) {
+			return true
+		}
+		return shouldRetry(result, err)
+	}
+	err := queryRetrier{retryCount: 0}.Do(ctx, attempt, retriable, policy)
+	if errors.Is(err, ErrRetriableResult) {
+		err = ErrRetriableResult
+	}
+	return result, err
 }
 
 // CreatePermanentError creates permanent error so client code can inform retrier explicitly.
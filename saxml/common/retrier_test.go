@@ -101,3 +101,85 @@ func TestDirectFail(t *testing.T) {
 		t.Fatalf("TestDirectFail should fail\n")
 	}
 }
+
+// Retry fails after the maximum number of attempts set by the policy.
+func TestMaxAttempts(t *testing.T) {
+	retriableError := fmt.Errorf("%w", errors.ErrResourceExhausted)
+	errs := []error{retriableError, retriableError, retriableError, nil}
+	m := multipleReturn{errors: errs, index: 0}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	policy := retrier.Policy{MaxAttempts: 2, InitialInterval: time.Millisecond}
+	err := retrier.DoWithPolicy(ctx, m.query, errors.AdminShouldRetry, policy)
+	if err == nil {
+		t.Fatalf("TestMaxAttempts should fail\n")
+	}
+	if m.index != 2 {
+		t.Errorf("TestMaxAttempts made %d attempts, want 2\n", m.index)
+	}
+}
+
+// status is a query result that can ask to be retried without an error.
+type status struct {
+	recovering bool
+	value      int
+}
+
+func retryRecovering(s status, err error) bool {
+	if err != nil {
+		return errors.AdminShouldRetry(err)
+	}
+	return s.recovering
+}
+
+// Retry continues on results that should be retried and stops on the first terminal result.
+func TestDoWithResult(t *testing.T) {
+	results := []status{{recovering: true}, {recovering: true}, {value: 7}, {value: 8}}
+	calls := 0
+	query := func() (status, error) {
+		r := results[calls]
+		calls++
+		return r, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	got, err := retrier.DoWithResult(ctx, query, retryRecovering, retrier.Policy{InitialInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("TestDoWithResult should return nil error but got %v\n", err)
+	}
+	if got.value != 7 || calls != 3 {
+		t.Errorf("TestDoWithResult got %v after %d calls, want value 7 after 3 calls\n", got, calls)
+	}
+}
+
+// Retry fails with ErrRetriableResult when attempts run out on results that should be retried.
+func TestDoWithResultMaxAttempts(t *testing.T) {
+	calls := 0
+	query := func() (status, error) {
+		calls++
+		return status{recovering: true}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	policy := retrier.Policy{MaxAttempts: 3, InitialInterval: time.Millisecond}
+	got, err := retrier.DoWithResult(ctx, query, retryRecovering, policy)
+	if err != retrier.ErrRetriableResult {
+		t.Fatalf("TestDoWithResultMaxAttempts got error %v, want %v\n", err, retrier.ErrRetriableResult)
+	}
+	if !got.recovering || calls != 3 {
+		t.Errorf("TestDoWithResultMaxAttempts got %v after %d calls, want the last recovering result after 3 calls\n", got, calls)
+	}
+
+	// Errors are still judged by the predicate.
+	calls = 0
+	failing := func() (status, error) {
+		calls++
+		return status{}, fmt.Errorf("%w", errors.ErrInternal)
+	}
+	if _, err := retrier.DoWithResult(ctx, failing, retryRecovering, policy); err == nil || calls != 1 {
+		t.Errorf("TestDoWithResultMaxAttempts got error %v after %d calls on a non-retriable error, want an error after 1 call\n", err, calls)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"path"
 
 	"google.golang.org/protobuf/proto"
+	"saxml/common/cellcrypt"
 	"saxml/common/platform/env"
 
 	pb "saxml/protobuf/admin_go_proto_grpc"
@@ -42,6 +43,10 @@ func (s *State) Write(ctx context.Context, state *pb.State) error {
 	if err != nil {
 		return err
 	}
+	out, err = cellcrypt.Seal(ctx, out)
+	if err != nil {
+		return err
+	}
 
 	return env.Get().WriteFileAtomically(ctx, path, out)
 }
@@ -66,6 +71,10 @@ func (s *State) Read(ctx context.Context) (*pb.State, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Read %s error: %v", path, err)
 	}
+	in, err = cellcrypt.Open(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("Read %s error: %w", path, err)
+	}
 	state := &pb.State{}
 	if err := proto.Unmarshal(in, state); err != nil {
 		return nil, fmt.Errorf("State (%q) unmarshal error: %v", in, err)
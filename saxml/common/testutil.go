@@ -27,8 +27,11 @@ import (
 
 	log "github.com/golang/glog"
 	// unused internal test dependency
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/proto"
 	"saxml/common/addr"
+	"saxml/common/blob"
 	"saxml/common/cell"
 	"saxml/common/config"
 	"saxml/common/errors"
@@ -94,6 +97,25 @@ func (s *stubAdminServer) Publish(ctx context.Context, in *apb.PublishRequest) (
 	return &apb.PublishResponse{}, nil
 }
 
+func (s *stubAdminServer) PublishStaged(ctx context.Context, in *apb.PublishStagedRequest) (*apb.PublishStagedResponse, error) {
+	return &apb.PublishStagedResponse{}, nil
+}
+
+func (s *stubAdminServer) SimulatePlacement(ctx context.Context, in *apb.SimulatePlacementRequest) (*apb.SimulatePlacementResponse, error) {
+	return &apb.SimulatePlacementResponse{}, nil
+}
+
+func (s *stubAdminServer) UploadBlob(stream agrpc.Admin_UploadBlobServer) error {
+	digest, err := blob.Receive(stream.Context(), s.saxCell, func() ([]byte, error) {
+		req, err := stream.Recv()
+		return req.GetChunk(), err
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&apb.UploadBlobResponse{Digest: digest})
+}
+
 func (s *stubAdminServer) Update(ctx context.Context, in *apb.UpdateRequest) (*apb.UpdateResponse, error) {
 	return &apb.UpdateResponse{}, nil
 }
@@ -157,10 +179,68 @@ func (s *stubAdminServer) WatchLoc(ctx context.Context, in *apb.WatchLocRequest)
 	}, nil
 }
 
+func (s *stubAdminServer) WatchModel(in *apb.WatchModelRequest, stream agrpc.Admin_WatchModelServer) error {
+	seqno := int32(-1)
+	for {
+		result, err := s.modelAddresses.Watch(stream.Context(), seqno)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&apb.WatchLocResponse{Result: result.ToProto()}); err != nil {
+			return err
+		}
+		seqno = result.Next
+	}
+}
+
+func (s *stubAdminServer) ExportAudit(in *apb.ExportAuditRequest, stream agrpc.Admin_ExportAuditServer) error {
+	return nil
+}
+
 func (s *stubAdminServer) WaitForReady(ctx context.Context, in *apb.WaitForReadyRequest) (*apb.WaitForReadyResponse, error) {
 	return &apb.WaitForReadyResponse{}, nil
 }
 
+func (s *stubAdminServer) ApproveScale(ctx context.Context, in *apb.ApproveScaleRequest) (*apb.ApproveScaleResponse, error) {
+	return &apb.ApproveScaleResponse{}, nil
+}
+
+func (s *stubAdminServer) OverrideConstraints(ctx context.Context, in *apb.OverrideConstraintsRequest) (*apb.OverrideConstraintsResponse, error) {
+	return &apb.OverrideConstraintsResponse{Override: in.GetOverride()}, nil
+}
+
+func (s *stubAdminServer) SetReplicasFor(ctx context.Context, in *apb.SetReplicasForRequest) (*apb.SetReplicasForResponse, error) {
+	return &apb.SetReplicasForResponse{Bump: &apb.ReplicaBump{NumReplicas: in.GetNumReplicas()}}, nil
+}
+
+func (s *stubAdminServer) ServingReady(ctx context.Context, in *apb.ServingReadyRequest) (*apb.ServingReadyResponse, error) {
+	return &apb.ServingReadyResponse{}, nil
+}
+
+func (s *stubAdminServer) PromoteWarm(ctx context.Context, in *apb.PromoteWarmRequest) (*apb.PromoteWarmResponse, error) {
+	return &apb.PromoteWarmResponse{}, nil
+}
+
+func (s *stubAdminServer) DrainServer(ctx context.Context, in *apb.DrainServerRequest) (*apb.DrainServerResponse, error) {
+	return &apb.DrainServerResponse{}, nil
+}
+
+func (s *stubAdminServer) EvacuateLabel(ctx context.Context, in *apb.EvacuateLabelRequest) (*apb.EvacuateLabelResponse, error) {
+	return &apb.EvacuateLabelResponse{}, nil
+}
+
+func (s *stubAdminServer) ListIncarnations(ctx context.Context, in *apb.ListIncarnationsRequest) (*apb.ListIncarnationsResponse, error) {
+	return &apb.ListIncarnationsResponse{}, nil
+}
+
+func (s *stubAdminServer) PurgeStaleIncarnations(ctx context.Context, in *apb.PurgeStaleIncarnationsRequest) (*apb.PurgeStaleIncarnationsResponse, error) {
+	return &apb.PurgeStaleIncarnationsResponse{}, nil
+}
+
+func (s *stubAdminServer) GetEffectiveConfig(ctx context.Context, in *apb.GetEffectiveConfigRequest) (*apb.GetEffectiveConfigResponse, error) {
+	return &apb.GetEffectiveConfigResponse{}, nil
+}
+
 func (s *stubAdminServer) Join(ctx context.Context, in *apb.JoinRequest) (*apb.JoinResponse, error) {
 	addr := in.GetAddress()
 	if !strings.HasPrefix(addr, "localhost:") {
@@ -258,6 +338,93 @@ type stubModeletServer struct {
 
 	mu           sync.Mutex
 	loadedModels map[string]bool // model key as key
+	shuttingDown bool
+	reassign     map[string]bool // model key as key
+	failing      map[string]bool // model key as key
+	rejoin       func()
+	p99Latency   float32 // in seconds, reported for all loaded models if positive
+}
+
+var (
+	// Modelet services of running stub model servers, keyed by port.
+	muStubModelets sync.Mutex
+	stubModelets   = make(map[int]*stubModeletServer)
+)
+
+// SetStubModelServerShuttingDown makes the stub model server at port report whether it is
+// shutting down in its status.
+func SetStubModelServerShuttingDown(port int, shuttingDown bool) error {
+	muStubModelets.Lock()
+	s, ok := stubModelets[port]
+	muStubModelets.Unlock()
+	if !ok {
+		return fmt.Errorf("no stub model server at port %d: %w", port, errors.ErrNotFound)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shuttingDown = shuttingDown
+	return nil
+}
+
+// SetStubModelServerP99Latency makes the stub model server at port report serving all loaded
+// models with the given p99 latency in seconds, or no method stats if it is not positive.
+func SetStubModelServerP99Latency(port int, seconds float32) error {
+	muStubModelets.Lock()
+	s, ok := stubModelets[port]
+	muStubModelets.Unlock()
+	if !ok {
+		return fmt.Errorf("no stub model server at port %d: %w", port, errors.ErrNotFound)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.p99Latency = seconds
+	return nil
+}
+
+// RequestStubModelServerReassign makes the stub model server at port ask the admin server, in its
+// status, to move the model with the given key to other model servers.
+func RequestStubModelServerReassign(port int, modelKey string) error {
+	muStubModelets.Lock()
+	s, ok := stubModelets[port]
+	muStubModelets.Unlock()
+	if !ok {
+		return fmt.Errorf("no stub model server at port %d: %w", port, errors.ErrNotFound)
+	}
+	_, err := s.RequestReassign(context.Background(), &mpb.RequestReassignRequest{ModelKey: modelKey})
+	return err
+}
+
+// FailStubModelServerModel makes the stub model server at port report the model with the given
+// key as failed in its status whenever the model is loaded.
+func FailStubModelServerModel(port int, modelKey string) error {
+	muStubModelets.Lock()
+	s, ok := stubModelets[port]
+	muStubModelets.Unlock()
+	if !ok {
+		return fmt.Errorf("no stub model server at port %d: %w", port, errors.ErrNotFound)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing == nil {
+		s.failing = make(map[string]bool)
+	}
+	s.failing[modelKey] = true
+	return nil
+}
+
+// SetStubModelServerRejoin makes the stub model server at port call rejoin when an admin server
+// asks it to rejoin, e.g. the Rejoin method of the Joiner that joined it.
+func SetStubModelServerRejoin(port int, rejoin func()) error {
+	muStubModelets.Lock()
+	s, ok := stubModelets[port]
+	muStubModelets.Unlock()
+	if !ok {
+		return fmt.Errorf("no stub model server at port %d: %w", port, errors.ErrNotFound)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejoin = rejoin
+	return nil
 }
 
 func (s *stubModeletServer) Load(ctx context.Context, in *mpb.LoadRequest) (*mpb.LoadResponse, error) {
@@ -279,6 +446,7 @@ func (s *stubModeletServer) Unload(ctx context.Context, in *mpb.UnloadRequest) (
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.loadedModels, in.GetModelKey())
+	delete(s.reassign, in.GetModelKey())
 	return &mpb.UnloadResponse{}, nil
 }
 
@@ -296,12 +464,53 @@ func (s *stubModeletServer) GetStatus(ctx context.Context, in *mpb.GetStatusRequ
 	var models []*mpb.GetStatusResponse_ModelWithStatus
 	for key := range s.loadedModels {
 		model := &mpb.GetStatusResponse_ModelWithStatus{
-			ModelKey:    key,
-			ModelStatus: cpb.ModelStatus_LOADED,
+			ModelKey:          key,
+			ModelStatus:       cpb.ModelStatus_LOADED,
+			ReassignRequested: s.reassign[key],
+		}
+		if s.failing[key] {
+			model.ModelStatus = cpb.ModelStatus_FAILED
+		}
+		if s.p99Latency > 0 && in.GetIncludeMethodStats() {
+			model.MethodStats = []*mpb.GetStatusResponse_MethodStats{{
+				Method:                       "lm.generate",
+				SuccessesPerSecond:           1,
+				P99LatencyOnSuccessPerSecond: proto.Float32(s.p99Latency),
+			}}
 		}
 		models = append(models, model)
 	}
-	return &mpb.GetStatusResponse{Models: models}, nil
+	return &mpb.GetStatusResponse{Models: models, ShuttingDown: s.shuttingDown}, nil
+}
+
+func (s *stubModeletServer) RequestReassign(ctx context.Context, in *mpb.RequestReassignRequest) (*mpb.RequestReassignResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loadedModels[in.GetModelKey()] {
+		return nil, fmt.Errorf("model %s not loaded: %w", in.GetModelKey(), errors.ErrNotFound)
+	}
+	if s.reassign == nil {
+		s.reassign = make(map[string]bool)
+	}
+	s.reassign[in.GetModelKey()] = true
+	return &mpb.RequestReassignResponse{}, nil
+}
+
+func (s *stubModeletServer) Rejoin(ctx context.Context, in *mpb.RejoinRequest) (*mpb.RejoinResponse, error) {
+	s.mu.Lock()
+	rejoin := s.rejoin
+	s.mu.Unlock()
+	if rejoin == nil {
+		return nil, errors.ErrUnimplemented
+	}
+	rejoin()
+	return &mpb.RejoinResponse{}, nil
+}
+
+// WatchStatus is left unimplemented, like on older model servers, so callers fall back to
+// GetStatus.
+func (s *stubModeletServer) WatchStatus(in *mpb.WatchStatusRequest, stream mgrpc.Modelet_WatchStatusServer) error {
+	return errors.ErrUnimplemented
 }
 
 type stubLanguageModelServer struct {
@@ -309,7 +518,45 @@ type stubLanguageModelServer struct {
 	unavailableModel string
 }
 
+// stubExtraInputs lists the extra inputs understood by stub language model server methods.
+var stubExtraInputs = map[string]bool{
+	"temperature":  true,
+	"extra_tensor": true,
+	"extra_string": true,
+}
+
+// validateExtraInputs rejects extra inputs the stub doesn't understand, like real model servers
+// do, instead of silently ignoring them.
+func validateExtraInputs(extra *cpb.ExtraInputs) error {
+	var keys []string
+	for key := range extra.GetItems() {
+		keys = append(keys, key)
+	}
+	for key := range extra.GetTensors() {
+		keys = append(keys, key)
+	}
+	for key := range extra.GetStrings() {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if !stubExtraInputs[key] {
+			return fmt.Errorf("unknown extra input %q: %w", key, errors.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// setStubQueryCost sets the query cost trailers real model servers set, charging one TPU
+// millisecond and one token per byte of text.
+func setStubQueryCost(ctx context.Context, text string) {
+	cost := strconv.Itoa(len(text))
+	grpc.SetTrailer(ctx, metadata.Pairs("query_cost_v0", cost, "query_tokens_v0", cost))
+}
+
 func (s *stubLanguageModelServer) Score(ctx context.Context, in *lmpb.ScoreRequest) (*lmpb.ScoreResponse, error) {
+	if err := validateExtraInputs(in.GetExtraInputs()); err != nil {
+		return nil, err
+	}
 	prefix := in.GetPrefix()
 	suffixes := in.GetSuffix()
 	extra := in.GetExtraInputs().GetItems()
@@ -352,6 +599,9 @@ func (s *stubLanguageModelServer) Generate(ctx context.Context, in *lmpb.Generat
 	if text == "bad-input" {
 		return nil, fmt.Errorf("bad input %w", errors.ErrInvalidArgument)
 	}
+	if err := validateExtraInputs(in.GetExtraInputs()); err != nil {
+		return nil, err
+	}
 	extra := in.GetExtraInputs().GetItems()
 	temperature := 1.0
 	if val, found := extra["temperature"]; found {
@@ -374,6 +624,7 @@ func (s *stubLanguageModelServer) Generate(ctx context.Context, in *lmpb.Generat
 	for _, value := range extraVector {
 		product *= value
 	}
+	setStubQueryCost(ctx, text)
 	return &lmpb.GenerateResponse{
 		Texts: []*lmpb.DecodedText{
 			&lmpb.DecodedText{
@@ -421,6 +672,7 @@ func (s *stubLanguageModelServer) GenerateStream(in *lmpb.GenerateRequest, strea
 
 func (s *stubLanguageModelServer) Embed(ctx context.Context, in *lmpb.EmbedRequest) (*lmpb.EmbedResponse, error) {
 	value := float64(len(in.GetText()))
+	setStubQueryCost(ctx, in.GetText())
 	return &lmpb.EmbedResponse{
 		Embedding: []float64{
 			value * 0.6,
@@ -446,6 +698,7 @@ type stubVisionModelServer struct{}
 
 func (s *stubVisionModelServer) Classify(ctx context.Context, in *vmpb.ClassifyRequest) (*vmpb.ClassifyResponse, error) {
 	text := string(in.GetImageBytes())
+	setStubQueryCost(ctx, text)
 	return &vmpb.ClassifyResponse{
 		Texts: []*vmpb.DecodedText{
 			&vmpb.DecodedText{
@@ -588,6 +841,7 @@ type stubAudioModelServer struct{}
 
 func (s *stubAudioModelServer) Recognize(ctx context.Context, in *ampb.AsrRequest) (*ampb.AsrResponse, error) {
 	text := string(in.GetAudioBytes())
+	setStubQueryCost(ctx, text)
 	return &ampb.AsrResponse{
 		Hyps: []*ampb.AsrHypothesis{
 			&ampb.AsrHypothesis{
@@ -606,6 +860,7 @@ type stubCustomModelServer struct{}
 
 func (s *stubCustomModelServer) Custom(ctx context.Context, in *cmpb.CustomRequest) (*cmpb.CustomResponse, error) {
 	text := in.GetRequest()
+	setStubQueryCost(ctx, string(text))
 	request := &mmpb.GenerateRequest{}
 	err := proto.Unmarshal(text, request)
 	if err == nil {
@@ -656,6 +911,37 @@ func (m *stubMultimodalModelServer) Score(ctx context.Context, in *mmpb.ScoreRpc
 // also runs a modelet service.
 // Close the returned channel to close the server.
 func StartStubModelServer(modelType ModelType, modelPort int, scoreDelay time.Duration, unavailableModel string, loadDelay time.Duration) (chan struct{}, error) {
+	return startModelServer(modelPort, loadDelay, func(gRPCServer env.Server) {
+		switch modelType {
+		case Language:
+			lmgrpc.RegisterLMServiceServer(gRPCServer.GRPCServer(), &stubLanguageModelServer{
+				scoreDelay:       scoreDelay,
+				unavailableModel: unavailableModel,
+			})
+		case Vision:
+			vmgrpc.RegisterVisionServiceServer(gRPCServer.GRPCServer(), &stubVisionModelServer{})
+		case Audio:
+			amgrpc.RegisterAudioServiceServer(gRPCServer.GRPCServer(), &stubAudioModelServer{})
+		case Custom:
+			cmgrpc.RegisterCustomServiceServer(gRPCServer.GRPCServer(), &stubCustomModelServer{})
+		case Multimodal:
+			mmgrpc.RegisterMultimodalServiceServer(gRPCServer.GRPCServer(), &stubMultimodalModelServer{})
+		}
+	})
+}
+
+// StartLanguageModelServer starts a new model server that answers language model requests with
+// lm, and runs a stub modelet service that loads any model instantly.
+// Close the returned channel to close the server.
+func StartLanguageModelServer(modelPort int, lm lmgrpc.LMServiceServer) (chan struct{}, error) {
+	return startModelServer(modelPort, 0, func(gRPCServer env.Server) {
+		lmgrpc.RegisterLMServiceServer(gRPCServer.GRPCServer(), lm)
+	})
+}
+
+// startModelServer starts a model server with a stub modelet service at modelPort. register
+// registers the model services to run along.
+func startModelServer(modelPort int, loadDelay time.Duration, register func(env.Server)) (chan struct{}, error) {
 	ctx := context.Background()
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", modelPort))
 	if err != nil {
@@ -666,34 +952,25 @@ func StartStubModelServer(modelType ModelType, modelPort int, scoreDelay time.Du
 	if err != nil {
 		return nil, err
 	}
-
-	switch modelType {
-	case Language:
-		lmgrpc.RegisterLMServiceServer(gRPCServer.GRPCServer(), &stubLanguageModelServer{
-			scoreDelay:       scoreDelay,
-			unavailableModel: unavailableModel,
-		})
-	case Vision:
-		vmgrpc.RegisterVisionServiceServer(gRPCServer.GRPCServer(), &stubVisionModelServer{})
-	case Audio:
-		amgrpc.RegisterAudioServiceServer(gRPCServer.GRPCServer(), &stubAudioModelServer{})
-	case Custom:
-		cmgrpc.RegisterCustomServiceServer(gRPCServer.GRPCServer(), &stubCustomModelServer{})
-	case Multimodal:
-		mmgrpc.RegisterMultimodalServiceServer(gRPCServer.GRPCServer(), &stubMultimodalModelServer{})
-	}
+	register(gRPCServer)
 
 	modeletServer := &stubModeletServer{
 		loadDelay:    loadDelay,
 		loadedModels: make(map[string]bool),
 	}
 	mgrpc.RegisterModeletServer(gRPCServer.GRPCServer(), modeletServer)
+	muStubModelets.Lock()
+	stubModelets[modelPort] = modeletServer
+	muStubModelets.Unlock()
 
 	go gRPCServer.Serve(lis)
 	closer := make(chan struct{})
 	go func() {
 		<-closer
 		gRPCServer.Stop()
+		muStubModelets.Lock()
+		delete(stubModelets, modelPort)
+		muStubModelets.Unlock()
 	}()
 
 	return closer, nil
@@ -0,0 +1,230 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transport enforces the transport security policy of Sax cells on the connections Sax
+// components make to each other.
+//
+// A cell sets its policy in its config. Processes load their certificates through LoadFlags or
+// LoadFiles, dial through Dial or DialCell, which refuse connections the policy doesn't allow
+// instead of silently falling back to an insecure connection, and serve with ServerOptions, which
+// make servers refuse connections the policy doesn't allow.
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"flag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"saxml/common/config"
+	"saxml/common/errors"
+	"saxml/common/platform/env"
+
+	pb "saxml/protobuf/admin_go_proto_grpc"
+)
+
+var (
+	certFile = flag.String("sax_tls_cert", "", "PEM certificate this process presents on Sax connections: as a server in cells requiring TLS or mTLS, and as a client in cells requiring mTLS")
+	keyFile  = flag.String("sax_tls_key", "", "PEM private key of --sax_tls_cert")
+	caFile   = flag.String("sax_tls_ca", "", "PEM certificates of the CAs that sign the certificates of Sax components. If empty, the host's root CAs are used.")
+)
+
+// How long the policy of a cell is cached before it is loaded from the cell config again.
+var policyTTL = time.Minute
+
+// Credentials are the credentials a process dials Sax components with.
+type Credentials struct {
+	// Client secures connections. If nil, connections are made by the platform, usually insecure.
+	Client credentials.TransportCredentials
+	// Mutual is true if Client presents a certificate to servers, i.e. connections are mTLS.
+	Mutual bool
+	// Server holds the certificate servers in the process present and the CAs they verify client
+	// certificates against. If nil, servers can only accept insecure connections.
+	Server *tls.Config
+}
+
+var (
+	muCreds sync.RWMutex
+	creds   Credentials
+
+	muPolicies sync.Mutex
+	policies   = make(map[string]cachedPolicy)
+)
+
+type cachedPolicy struct {
+	policy pb.Config_TransportSecurity
+	loaded time.Time
+}
+
+// SetCredentials sets the credentials the process dials with from now on. Connections already made
+// are kept.
+func SetCredentials(c Credentials) {
+	muCreds.Lock()
+	defer muCreds.Unlock()
+	creds = c
+}
+
+// LoadFlags is LoadFiles with the files named by --sax_tls_cert, --sax_tls_key and --sax_tls_ca.
+func LoadFlags() error {
+	return LoadFiles(*certFile, *keyFile, *caFile)
+}
+
+// LoadFiles sets the process credentials to the PEM certificate in certFile with its private key in
+// keyFile, verifying peers against the CA certificates in caFile, or the host's root CAs if caFile
+// is empty. Without a certificate, the process dials with server-only TLS and can't serve cells
+// requiring TLS. Without any file, the process has no credentials.
+func LoadFiles(certFile, keyFile, caFile string) error {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		SetCredentials(Credentials{})
+		return nil
+	}
+
+	client := &tls.Config{MinVersion: tls.VersionTLS12}
+	var server *tls.Config
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the certificate in %s and %s: %v: %w", certFile, keyFile, err, errors.ErrInvalidArgument)
+		}
+		client.Certificates = []tls.Certificate{cert}
+		server = &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificates in %s: %v: %w", caFile, err, errors.ErrInvalidArgument)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no PEM certificate in %s: %w", caFile, errors.ErrInvalidArgument)
+		}
+		client.RootCAs = pool
+		if server != nil {
+			server.ClientCAs = pool
+		}
+	}
+	SetCredentials(Credentials{
+		Client: credentials.NewTLS(client),
+		Mutual: len(client.Certificates) > 0,
+		Server: server,
+	})
+	return nil
+}
+
+// currentCredentials returns the process credentials, with insecure client credentials treated as
+// no client credentials.
+func currentCredentials() Credentials {
+	muCreds.RLock()
+	defer muCreds.RUnlock()
+	c := creds
+	if c.Client != nil && c.Client.Info().SecurityProtocol == "insecure" {
+		c.Client, c.Mutual = nil, false
+	}
+	return c
+}
+
+// CellPolicy returns the transport security policy in the config of saxCell.
+func CellPolicy(ctx context.Context, saxCell string) (pb.Config_TransportSecurity, error) {
+	muPolicies.Lock()
+	cached, ok := policies[saxCell]
+	muPolicies.Unlock()
+	if ok && time.Since(cached.loaded) < policyTTL {
+		return cached.policy, nil
+	}
+
+	cfg, err := config.Load(ctx, saxCell)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load the transport security policy of cell %s: %w", saxCell, err)
+	}
+	policy := cfg.GetTransportSecurity()
+	muPolicies.Lock()
+	policies[saxCell] = cachedPolicy{policy: policy, loaded: time.Now()}
+	muPolicies.Unlock()
+	return policy, nil
+}
+
+// Check returns an error if the process credentials can't make connections policy allows.
+func Check(policy pb.Config_TransportSecurity) error {
+	c := currentCredentials()
+	switch policy {
+	case pb.Config_TRANSPORT_INSECURE:
+		return nil
+	case pb.Config_TRANSPORT_TLS:
+		if c.Client == nil {
+			return fmt.Errorf("transport security policy %v refuses insecure connections: %w", policy, errors.ErrFailedPrecondition)
+		}
+		return nil
+	case pb.Config_TRANSPORT_MTLS_REQUIRED:
+		if c.Client == nil || !c.Mutual {
+			return fmt.Errorf("transport security policy %v refuses connections without mTLS: %w", policy, errors.ErrFailedPrecondition)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown transport security policy %v: %w", policy, errors.ErrInvalidArgument)
+}
+
+// ServerOptions returns the options that make a gRPC server accept only connections policy allows.
+// Under policies requiring TLS, the server presents the process certificate, and under
+// TRANSPORT_MTLS_REQUIRED, it also refuses clients without a certificate signed by a trusted CA.
+// Servers keep the policy they were created with, so a new policy applies to them on restart.
+func ServerOptions(policy pb.Config_TransportSecurity) ([]grpc.ServerOption, error) {
+	var clientAuth tls.ClientAuthType
+	switch policy {
+	case pb.Config_TRANSPORT_INSECURE:
+		return nil, nil
+	case pb.Config_TRANSPORT_TLS:
+		clientAuth = tls.NoClientCert
+	case pb.Config_TRANSPORT_MTLS_REQUIRED:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown transport security policy %v: %w", policy, errors.ErrInvalidArgument)
+	}
+	c := currentCredentials()
+	if c.Server == nil {
+		return nil, fmt.Errorf("transport security policy %v requires a server certificate: %w", policy, errors.ErrFailedPrecondition)
+	}
+	cfg := c.Server.Clone()
+	cfg.ClientAuth = clientAuth
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(cfg))}, nil
+}
+
+// Dial connects to target with the process credentials, or fails if policy doesn't allow them.
+// Under TRANSPORT_INSECURE, connections are insecure, to match servers of the cell.
+func Dial(ctx context.Context, policy pb.Config_TransportSecurity, target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if err := Check(policy); err != nil {
+		return nil, fmt.Errorf("refusing to dial %s: %w", target, err)
+	}
+	c := currentCredentials()
+	if c.Client == nil || policy == pb.Config_TRANSPORT_INSECURE {
+		return env.Get().DialContext(ctx, target, opts...)
+	}
+	// Platforms may add insecure credentials to the options they dial with, so dial directly.
+	opts = append(opts, grpc.WithTransportCredentials(c.Client), grpc.WithBlock())
+	return grpc.DialContext(ctx, target, opts...)
+}
+
+// DialCell is like Dial, under the transport security policy of saxCell.
+func DialCell(ctx context.Context, saxCell, target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	policy, err := CellPolicy(ctx, saxCell)
+	if err != nil {
+		return nil, err
+	}
+	return Dial(ctx, policy, target, opts...)
+}
@@ -0,0 +1,339 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"saxml/common/config"
+	"saxml/common/errors"
+	"saxml/common/platform/env"
+	_ "saxml/common/platform/register" // registers a platform
+	"saxml/common/testutil"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	pb "saxml/protobuf/admin_go_proto_grpc"
+)
+
+// setPolicy sets the transport security policy in the config of saxCell.
+func setPolicy(ctx context.Context, t *testing.T, saxCell string, policy pb.Config_TransportSecurity) {
+	t.Helper()
+	cfg, err := config.Load(ctx, saxCell)
+	if err != nil {
+		t.Fatalf("config.Load(%s) error %v, want no error", saxCell, err)
+	}
+	cfg.TransportSecurity = policy
+	if err := config.Save(ctx, cfg, saxCell, ""); err != nil {
+		t.Fatalf("config.Save(%s) error %v, want no error", saxCell, err)
+	}
+}
+
+// testFiles names PEM files of a test CA and of the server and client certificates it signs.
+type testFiles struct {
+	ca                    string
+	serverCert, serverKey string
+	clientCert, clientKey string
+}
+
+// writePEM writes der in a PEM block of type typ to dir/name and returns the file path.
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatalf("WriteFile(%s) error %v, want no error", path, err)
+	}
+	return path
+}
+
+// writeTestFiles writes a test CA and certificates for localhost it signs in a temporary directory.
+func writeTestFiles(t *testing.T) testFiles {
+	t.Helper()
+	dir := t.TempDir()
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey() error %v, want no error", err)
+		}
+		return key
+	}
+
+	caKey := newKey()
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sax test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate(CA) error %v, want no error", err)
+	}
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		t.Fatalf("ParseCertificate(CA) error %v, want no error", err)
+	}
+	files := testFiles{ca: writePEM(t, dir, "ca.pem", "CERTIFICATE", caDER)}
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+		key := newKey()
+		cert := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			DNSNames:     []string{"localhost"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("CreateCertificate(%s) error %v, want no error", name, err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatalf("MarshalECPrivateKey(%s) error %v, want no error", name, err)
+		}
+		return writePEM(t, dir, name+".pem", "CERTIFICATE", der), writePEM(t, dir, name+".key", "EC PRIVATE KEY", keyDER)
+	}
+	files.serverCert, files.serverKey = issue("server", 2, x509.ExtKeyUsageServerAuth)
+	files.clientCert, files.clientKey = issue("client", 3, x509.ExtKeyUsageClientAuth)
+	return files
+}
+
+// loadFiles is LoadFiles failing t on errors.
+func loadFiles(t *testing.T, certFile, keyFile, caFile string) {
+	t.Helper()
+	if err := LoadFiles(certFile, keyFile, caFile); err != nil {
+		t.Fatalf("LoadFiles(%q, %q, %q) error %v, want no error", certFile, keyFile, caFile, err)
+	}
+}
+
+func TestDialCell(t *testing.T) {
+	defer func(ttl time.Duration) { policyTTL = ttl }(policyTTL)
+	policyTTL = 0
+	defer SetCredentials(Credentials{})
+	files := writeTestFiles(t)
+
+	ctx := context.Background()
+	saxCell := "/sax/test-transport"
+	testutil.SetUp(ctx, t, saxCell, "")
+	port, err := env.Get().PickUnusedPort()
+	if err != nil {
+		t.Fatalf("PickUnusedPort() error %v, want no error", err)
+	}
+	testutil.StartStubModelServerT(t, port)
+	target := fmt.Sprintf("localhost:%d", port)
+
+	// Cells allow insecure connections by default.
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := DialCell(dialCtx, saxCell, target)
+	if err != nil {
+		t.Fatalf("DialCell() under the default policy error %v, want no error", err)
+	}
+	conn.Close()
+
+	tests := []struct {
+		desc     string
+		policy   pb.Config_TransportSecurity
+		creds    func()
+		wantCode codes.Code
+	}{
+		{
+			desc:     "insecure dial under mtls-required",
+			policy:   pb.Config_TRANSPORT_MTLS_REQUIRED,
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			desc:     "insecure credentials under mtls-required",
+			policy:   pb.Config_TRANSPORT_MTLS_REQUIRED,
+			creds:    func() { SetCredentials(Credentials{Client: insecure.NewCredentials(), Mutual: true}) },
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			desc:     "server-only TLS under mtls-required",
+			policy:   pb.Config_TRANSPORT_MTLS_REQUIRED,
+			creds:    func() { loadFiles(t, "", "", files.ca) },
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			desc:     "insecure dial under tls",
+			policy:   pb.Config_TRANSPORT_TLS,
+			wantCode: codes.FailedPrecondition,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			setPolicy(ctx, t, saxCell, tc.policy)
+			SetCredentials(Credentials{})
+			if tc.creds != nil {
+				tc.creds()
+			}
+			if _, err := DialCell(ctx, saxCell, target); errors.Code(err) != tc.wantCode {
+				t.Errorf("DialCell() error %v, want code %v", err, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	defer SetCredentials(Credentials{})
+	files := writeTestFiles(t)
+
+	loadFiles(t, files.clientCert, files.clientKey, files.ca)
+	for _, policy := range []pb.Config_TransportSecurity{pb.Config_TRANSPORT_INSECURE, pb.Config_TRANSPORT_TLS, pb.Config_TRANSPORT_MTLS_REQUIRED} {
+		if err := Check(policy); err != nil {
+			t.Errorf("Check(%v) with mTLS credentials error %v, want no error", policy, err)
+		}
+	}
+	loadFiles(t, "", "", files.ca)
+	if err := Check(pb.Config_TRANSPORT_TLS); err != nil {
+		t.Errorf("Check(%v) with TLS credentials error %v, want no error", pb.Config_TRANSPORT_TLS, err)
+	}
+	if err := Check(pb.Config_TRANSPORT_MTLS_REQUIRED); errors.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Check(%v) with TLS credentials error %v, want %v", pb.Config_TRANSPORT_MTLS_REQUIRED, err, errors.ErrFailedPrecondition)
+	}
+	if err := Check(pb.Config_TransportSecurity(42)); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("Check() of an unknown policy error %v, want %v", err, errors.ErrInvalidArgument)
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	defer SetCredentials(Credentials{})
+	files := writeTestFiles(t)
+
+	loadFiles(t, "", "", files.ca)
+	if opts, err := ServerOptions(pb.Config_TRANSPORT_INSECURE); err != nil || len(opts) != 0 {
+		t.Errorf("ServerOptions(%v) = %v, %v, want no options and no error", pb.Config_TRANSPORT_INSECURE, opts, err)
+	}
+	if _, err := ServerOptions(pb.Config_TRANSPORT_TLS); errors.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ServerOptions(%v) without a server certificate error %v, want %v", pb.Config_TRANSPORT_TLS, err, errors.ErrFailedPrecondition)
+	}
+	if err := LoadFiles(files.serverCert, "", files.ca); errors.Code(err) != codes.InvalidArgument {
+		t.Errorf("LoadFiles() without a key error %v, want %v", err, errors.ErrInvalidArgument)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	defer SetCredentials(Credentials{})
+	files := writeTestFiles(t)
+	ctx := context.Background()
+
+	// serve starts a server with the test server certificate under policy and returns its address.
+	serve := func(t *testing.T, policy pb.Config_TransportSecurity) string {
+		t.Helper()
+		loadFiles(t, files.serverCert, files.serverKey, files.ca)
+		opts, err := ServerOptions(policy)
+		if err != nil {
+			t.Fatalf("ServerOptions(%v) error %v, want no error", policy, err)
+		}
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error %v, want no error", err)
+		}
+		s := grpc.NewServer(opts...)
+		healthpb.RegisterHealthServer(s, health.NewServer())
+		go s.Serve(lis)
+		t.Cleanup(s.Stop)
+		return lis.Addr().String()
+	}
+
+	tests := []struct {
+		desc      string
+		policy    pb.Config_TransportSecurity
+		cert, key string
+		insecure  bool
+		wantErr   bool
+	}{
+		{
+			desc:   "tls client under tls",
+			policy: pb.Config_TRANSPORT_TLS,
+		},
+		{
+			desc:   "mtls client under tls",
+			policy: pb.Config_TRANSPORT_TLS,
+			cert:   files.clientCert,
+			key:    files.clientKey,
+		},
+		{
+			desc:   "mtls client under mtls-required",
+			policy: pb.Config_TRANSPORT_MTLS_REQUIRED,
+			cert:   files.clientCert,
+			key:    files.clientKey,
+		},
+		{
+			desc:    "tls client under mtls-required",
+			policy:  pb.Config_TRANSPORT_MTLS_REQUIRED,
+			wantErr: true,
+		},
+		{
+			desc:     "insecure client under tls",
+			policy:   pb.Config_TRANSPORT_TLS,
+			insecure: true,
+			wantErr:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			target := serve(t, tc.policy)
+			loadFiles(t, tc.cert, tc.key, files.ca)
+			dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+
+			// Dial refuses connections the client knows the policy doesn't allow, so make the ones
+			// the server must refuse directly.
+			var conn *grpc.ClientConn
+			var err error
+			if tc.wantErr {
+				creds := currentCredentials().Client
+				if tc.insecure {
+					creds = insecure.NewCredentials()
+				}
+				conn, err = grpc.DialContext(dialCtx, target, grpc.WithTransportCredentials(creds))
+			} else {
+				conn, err = Dial(dialCtx, tc.policy, target)
+			}
+			if err != nil {
+				t.Fatalf("Dial(%s) error %v, want no error", target, err)
+			}
+			defer conn.Close()
+
+			_, err = healthpb.NewHealthClient(conn).Check(dialCtx, &healthpb.HealthCheckRequest{})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Check() error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
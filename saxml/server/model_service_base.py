@@ -20,6 +20,7 @@ import copy
 import dataclasses
 import enum
 import functools
+import hashlib
 import json
 import queue
 import threading
@@ -30,6 +31,7 @@ from typing import Any, Awaitable, Callable, Deque, Dict, List, Mapping, Optiona
 import uuid
 
 from absl import logging
+from etils import epath
 import grpc
 from grpc_reflection.v1alpha import reflection
 import jaxtyping as jt
@@ -73,6 +75,18 @@ _SAX_PREFIX = '/sax/'
 # Keep warm for every 2 minutes
 _KEEP_WARM_SECONDS = 120
 
+# Clients may set this gRPC metadata key on a unary request. A later request
+# with the same key, method, model and user gets the first request's result
+# instead of being computed again, if it arrives within the window.
+_IDEMPOTENCY_KEY_HEADER = 'sax-idempotency-key'
+_IDEMPOTENCY_CACHE_SIZE = 1024
+_IDEMPOTENCY_WINDOW_SECONDS = 600
+
+# WatchStatus checks for model status changes this often, and sends the status
+# at least every keepalive period if the caller doesn't ask for one.
+_WATCH_STATUS_POLL_SECONDS = 0.1
+_WATCH_STATUS_KEEPALIVE_MS = 10000
+
 # pylint: disable=invalid-name
 
 
@@ -171,6 +185,9 @@ class Method:
   # statistic tracker.
   ok_stats: utils.RequestStats
   err_stats: utils.RequestStats
+  # ok_stats split into time spent queued and processing.
+  queue_stats: utils.RequestStats
+  processing_stats: utils.RequestStats
 
   # Sizes of recent batches.
   recent_batch_sizes: Deque[int]
@@ -193,6 +210,8 @@ class Method:
     # pytype: disable=wrong-arg-types  # numpy-scalars
     self.ok_stats = utils.RequestStats(timespan_sec=60.0)
     self.err_stats = utils.RequestStats(timespan_sec=60.0)
+    self.queue_stats = utils.RequestStats(timespan_sec=60.0)
+    self.processing_stats = utils.RequestStats(timespan_sec=60.0)
     # pytype: enable=wrong-arg-types  # numpy-scalars
     self.recent_batch_sizes = collections.deque()
 
@@ -380,7 +399,10 @@ class ContinuousBatchingState:
 class PerMethodBatcher:
   """Runs per-method batching, and result batches are pushed to a queue."""
 
-  def __init__(self):
+  def __init__(self, limiter: Optional[utils.ReservationLimiter] = None):
+    # If set, bounds the requests of model methods running at once, keeping
+    # slots for models the server reserves compute for.
+    self._limiter = limiter
     self._per_method_queues: Dict[MethodKey, Method] = {}
     self._batch_queue: queue.SimpleQueue[Batch] = queue.SimpleQueue()
     self._global_live_batches_lock: threading.Lock = threading.Lock()
@@ -538,6 +560,8 @@ class PerMethodBatcher:
           utils.RequestStats.Stats,
           int,
           List[int],
+          utils.RequestStats.Stats,
+          utils.RequestStats.Stats,
       ]
   ]:
     """Returns the latest stats for every method key."""
@@ -550,6 +574,8 @@ class PerMethodBatcher:
           method.err_stats.get(1),
           method.batch_size,
           list(method.recent_batch_sizes),
+          method.queue_stats.get(100),
+          method.processing_stats.get(100),
       ))
     return ret
 
@@ -566,16 +592,23 @@ class PerMethodBatcher:
     method = self._per_method_queues.get(key)
     trace_callback = utils.get_current_trace_printer()
     trace_callback(f'Add item {key}')
+    task: Optional[utils.RpcQueueTask] = None
 
     def done(status, *args, **kwargs):
       """Helper to run the done callback if it's not None."""
       if optional_done:
         optional_done(status, *args, **kwargs)
       if method is not None:
+        done_ts = time.time()
         if status.ok():
-          method.ok_stats.add(time.time() - start_ts)
+          method.ok_stats.add(done_ts - start_ts)
+          # Requests that never went through get_batch have no queue/processing
+          # split.
+          if task is not None and task.dequeue_ts is not None:
+            method.queue_stats.add(task.dequeue_ts - start_ts)
+            method.processing_stats.add(done_ts - task.dequeue_ts)
         else:
-          method.err_stats.add(time.time() - start_ts)
+          method.err_stats.add(done_ts - start_ts)
 
     if method is None:
       return done(utils.not_found(f'method {key} is unloaded'))
@@ -616,16 +649,32 @@ class PerMethodBatcher:
           utils.resource_exhausted(f'Too many requests: {key} {method.limit()}')
       )
 
+    release_slot = None
+    if self._limiter is not None and key.model_key is not None:
+      release_slot = self._limiter.try_acquire(key.model_key)
+      if release_slot is None:
+        method.admissioner.release()
+        return done(
+            utils.resource_exhausted(
+                f'No request slot left for model {key.model_key}'
+            )
+        )
+
     def _done(status: utils.Status, *args, **kwargs):
       done(status, *args, **kwargs)
       method.admissioner.release()
+      if release_slot is not None:
+        release_slot()
 
-    method.queue.send(rpc, req, resp, _done, trace_callback)
+    task = method.queue.send(rpc, req, resp, _done, trace_callback)
 
   def get_batch(self) -> Batch:
     """Dequeues an available batch."""
     qlen = self._batch_queue.qsize()  # Approximately.
     batch = self._batch_queue.get()
+    dequeue_ts = time.time()
+    for rpc_task in batch.rpc_tasks:
+      rpc_task.dequeue_ts = dequeue_ts
     self._per_method_queues[batch.method].record_batch_size(batch.size())
     utils.traceprint_all(
         batch.rpc_tasks,
@@ -648,6 +697,8 @@ class LoadedModelManager:
     self._model_metadata = {}
     # Indexed by key.
     self._errors = {}
+    # Keys of models to be moved to other servers. See request_reassign.
+    self._reassign = set()
     self._primary_process_id = primary_process_id
 
   def load(
@@ -661,6 +712,7 @@ class LoadedModelManager:
       register_methods_callback: Optional[
           Callable[[servable_model.ServableModel], None]
       ] = None,
+      config_blob_paths: Optional[Mapping[str, str]] = None,
   ) -> servable_model.ServableModel:
     """Loads and initializes a model.
 
@@ -673,6 +725,8 @@ class LoadedModelManager:
         params.
       prng_key: PRNG key for this model.
       register_methods_callback: Optional callback to initialize model methods.
+      config_blob_paths: Paths of large model config blobs in the cell storage,
+        keyed by name. They are read and applied to the params after overrides.
 
     Returns:
       The loaded model object.
@@ -694,6 +748,8 @@ class LoadedModelManager:
       # pytype: disable=not-instantiable
       params = model_class()
       params.apply_model_overrides(overrides)
+      if config_blob_paths:
+        params.apply_config_blobs(_read_config_blobs(config_blob_paths))
       loaded = params.load(key, ckpt_path, self._primary_process_id, prng_key)
       # pytype: enable=not-instantiable
       loaded.set_acls(acls)
@@ -731,6 +787,7 @@ class LoadedModelManager:
     """Unloads a model."""
     if not self.contains(key):
       raise ValueError(f'Model {key} is not loaded, cannot unload.')
+    self._reassign.discard(key)
     if self._status[key] == common_pb2.ModelStatus.FAILED:
       del self._status[key]
       del self._errors[key]
@@ -777,6 +834,15 @@ class LoadedModelManager:
   def get_error(self, key: str) -> str:
     return self._errors[key]
 
+  def request_reassign(self, key: str) -> None:
+    """Asks the admin server to move a loaded model to other servers."""
+    if not self.contains(key):
+      raise ValueError(f'Model {key} is not loaded, cannot reassign.')
+    self._reassign.add(key)
+
+  def reassign_requested(self, key: str) -> bool:
+    return key in self._reassign
+
 
 class ModelService(metaclass=abc.ABCMeta):
   """Model RPC server base class."""
@@ -792,6 +858,9 @@ class ModelService(metaclass=abc.ABCMeta):
     self._batcher = batcher
     self._loader = loader
     self._service_id = service_id
+    self._results = utils.ResultCache(
+        _IDEMPOTENCY_CACHE_SIZE, _IDEMPOTENCY_WINDOW_SECONDS
+    )
     # Forward arguments to other parent classes.
     super().__init__(*args, **kwargs)  # pytype: disable=invalid-directive,wrong-keyword-args
 
@@ -891,21 +960,49 @@ class ModelServiceGRPC(ModelService):
     loop = asyncio.get_running_loop()
     fut = loop.create_future()
 
-    def _done(status: utils.Status, query_cost: Optional[int] = None):
-      if query_cost:
-        context.set_trailing_metadata([('query_cost_v0', str(query_cost))])
+    def _done(
+        status: utils.Status,
+        query_cost: Optional[int] = None,
+        query_tokens: Optional[int] = None,
+    ):
+      _set_query_cost(context, query_cost, query_tokens)
       if not status.ok():
         context.set_code(status.code)
         context.set_details(status.details)
       loop.call_soon_threadsafe(fut.set_result, None)
 
+    rpc_context = utils.RPCContextGRPC(context)
+    done = _done
+    key = _idempotency_key(context)
+    if key is not None:
+      result_key = (method, model_key, rpc_context.username(), key)
+
+      def _replay(status: utils.Status, data: Optional[bytes]):
+        if data is not None:
+          resp.ParseFromString(data)
+        _done(status)
+
+      if not self._results.begin(result_key, _replay):
+        return fut
+
+      def _done_and_record(
+          status: utils.Status,
+          query_cost: Optional[int] = None,
+          query_tokens: Optional[int] = None,
+      ):
+        data = resp.SerializeToString() if status.ok() else None
+        self._results.finish(result_key, status, data)
+        _done(status, query_cost, query_tokens)
+
+      done = _done_and_record
+
     self._EnqueueRequestInternal(
         method,
         model_key,
-        utils.RPCContextGRPC(context),
+        rpc_context,
         req,
         resp,
-        _done,
+        done,
         streaming_output=False,
     )
     return fut
@@ -926,9 +1023,9 @@ class ModelServiceGRPC(ModelService):
         status: utils.Status,
         resp: Optional[message.Message] = None,
         query_cost: Optional[int] = None,
+        query_tokens: Optional[int] = None,
     ):
-      if query_cost:
-        context.set_trailing_metadata([('query_cost_v0', str(query_cost))])
+      _set_query_cost(context, query_cost, query_tokens)
       if not status.ok():
         context.set_code(status.code)
         context.set_details(status.details)
@@ -947,6 +1044,66 @@ class ModelServiceGRPC(ModelService):
     return q
 
 
+def _set_query_cost(
+    context: grpc.ServicerContext,
+    query_cost: Optional[int],
+    query_tokens: Optional[int],
+) -> None:
+  """Returns the cost of a request to the client in trailing metadata."""
+  if not query_cost:
+    return
+  trailer = [('query_cost_v0', str(query_cost))]
+  if query_tokens is not None:
+    trailer.append(('query_tokens_v0', str(query_tokens)))
+  context.set_trailing_metadata(trailer)
+
+
+def _total_tokens(
+    input_tokens: Optional[int], output_tokens: Optional[int]
+) -> Optional[int]:
+  """Returns the number of tokens a request processed, or None if unknown."""
+  if input_tokens is None and output_tokens is None:
+    return None
+  return (input_tokens or 0) + (output_tokens or 0)
+
+
+def _idempotency_key(context: grpc.ServicerContext) -> Optional[str]:
+  """Returns the idempotency key the client set on the request, if any."""
+  for key, value in context.invocation_metadata() or ():
+    if key == _IDEMPOTENCY_KEY_HEADER:
+      return value
+  return None
+
+
+def _read_config_blobs(paths: Mapping[str, str]) -> Dict[str, bytes]:
+  """Reads model config blobs from the cell storage, keyed by name.
+
+  Blob paths end in the SHA-256 hex digest of the blob, which is verified.
+
+  Args:
+    paths: Blob paths keyed by name.
+
+  Returns:
+    Blob contents keyed by name.
+  """
+  blobs = {}
+  for name, path in paths.items():
+    data = epath.Path(path).read_bytes()
+    digest = hashlib.sha256(data).hexdigest()
+    if digest != path.rsplit('/', 1)[-1]:
+      raise ValueError(
+          f'Config blob {name} at {path} is corrupted: its digest is {digest}.'
+      )
+    blobs[name] = data
+  return blobs
+
+
+def _read_file(path: str) -> bytes:
+  """Returns the contents of a local file, such as a PEM certificate."""
+  with open(path, 'rb') as f:
+    return f.read()
+
+
 def register_service(
     service_id: str,
 ) -> Callable[[Type[ModelService]], Type[ModelService]]:
@@ -977,6 +1134,8 @@ class ModeletService:
       platform_topology: Optional[str],
       tags: Optional[List[str]],
       *args,
+      role: Optional[str] = None,
+      reservations: Optional[Sequence[admin_pb2.ResourceReservation]] = None,
       **kwargs,
   ):
     self._services = {}
@@ -984,6 +1143,7 @@ class ModeletService:
     self._loader = loader
     self._unload_lock = threading.Lock()
     self._models_being_unloaded = set()
+    self._shutting_down = threading.Event()
 
     super().__init__(*args, **kwargs)
     self._batcher.register_method(
@@ -1044,6 +1204,8 @@ class ModeletService:
           self._loadable_model_paths.append(alias)
 
     self._tags = tags
+    self._role = role
+    self._reservations = list(reservations or [])
     self._ipport = ipaddr.Join(ipaddr.MyIPAddr(), service_port)
     self._debug_addr = (
         '' if debug_port is None else ipaddr.Join(ipaddr.MyIPAddr(), debug_port)
@@ -1063,7 +1225,9 @@ class ModeletService:
           chip_type=self._platform_chip,
           chip_topology=self._platform_topology,
           servable_model_paths=list(self._loadable_model_paths),
-          tags=self._tags
+          tags=self._tags,
+          role=self._role or '',
+          reservations=self._reservations,
       )
       try:
         location.Join(
@@ -1079,6 +1243,30 @@ class ModeletService:
         raise e
       logging.info('Started joining SAX cell %s', self._sax_cell)
 
+  def start_shutdown(self) -> None:
+    """Reports the server as shutting down in GetStatus from now on."""
+    logging.info('Shutting down, asking the admin server to move models away')
+    self._shutting_down.set()
+
+  def request_reassign(self, model_key: str) -> None:
+    """Asks the admin server to move a loaded model to other servers.
+
+    The admin server unloads the model and doesn't place it on this server again
+    while the server is joined.
+
+    Args:
+      model_key: The key of the loaded model.
+    """
+    logging.warning('Asking the admin server to reassign model %s', model_key)
+    self._loader.request_reassign(model_key)
+
+  def rejoin(self) -> None:
+    """Joins the admin server again right away, as asked by the admin server."""
+    if self._sax_cell is None:
+      return
+    logging.info('Rejoining SAX cell %s on request', self._sax_cell)
+    location.Rejoin()
+
   def load(
       self,
       rpc_context: utils.RPCContext,
@@ -1180,7 +1368,9 @@ class ModeletService:
     model_by_key: dict[str, modelet_pb2.GetStatusResponse.ModelWithStatus] = {}
     for key, status in self._loader.get_status().items():
       model = modelet_pb2.GetStatusResponse.ModelWithStatus(
-          model_key=key, model_status=status
+          model_key=key,
+          model_status=status,
+          reassign_requested=self._loader.reassign_requested(key),
       )
       model_by_key[key] = model
       if (
@@ -1196,6 +1386,8 @@ class ModeletService:
           err_stats,
           _,
           recent_batch_sizes,
+          queue_stats,
+          processing_stats,
       ) in self._batcher.get_method_stats():
         if (
             key.service_id
@@ -1213,9 +1405,13 @@ class ModeletService:
             stats.p50_latency_on_success_per_second = percentiles[0]
             stats.p95_latency_on_success_per_second = percentiles[1]
             stats.p99_latency_on_success_per_second = percentiles[2]
+          if np.size(queue_stats.samples) > 0:
+            stats.mean_queue_seconds_on_success = queue_stats.mean()
+            stats.mean_processing_seconds_on_success = processing_stats.mean()
 
     for model in model_by_key.values():
       resp.models.append(model)
+    resp.shutting_down = self._shutting_down.is_set()
 
 
 class ModeletServiceGRPC(ModeletService, modelet_pb2_grpc.ModeletServicer):
@@ -1278,6 +1474,41 @@ class ModeletServiceGRPC(ModeletService, modelet_pb2_grpc.ModeletServicer):
     self.get_status(request, resp)
     return resp
 
+  async def Rejoin(self, request, context):
+    self.rejoin()
+    return modelet_pb2.RejoinResponse()
+
+  async def RequestReassign(self, request, context):
+    try:
+      self.request_reassign(request.model_key)
+    except ValueError as e:
+      await context.abort(grpc.StatusCode.NOT_FOUND, str(e))
+    return modelet_pb2.RequestReassignResponse()
+
+  async def WatchStatus(self, request, context):
+    req = modelet_pb2.GetStatusRequest(
+        include_method_stats=request.include_method_stats
+    )
+    keepalive_sec = (request.keepalive_ms or _WATCH_STATUS_KEEPALIVE_MS) / 1000
+    last_sent_sec, last_statuses = 0.0, None
+    while True:
+      resp = modelet_pb2.GetStatusResponse()
+      self.get_status(req, resp)
+      # Method stats change all the time, so only model status changes and
+      # the start of a shutdown count.
+      statuses = (
+          {model.model_key: model.model_status for model in resp.models},
+          resp.shutting_down,
+      )
+      now_sec = time.time()
+      if (
+          statuses != last_statuses
+          or now_sec - last_sent_sec >= keepalive_sec
+      ):
+        yield resp
+        last_sent_sec, last_statuses = now_sec, statuses
+      await asyncio.sleep(_WATCH_STATUS_POLL_SECONDS)
+
 
 class Exporter:
   """Injectable class for implementing exporting of models."""
@@ -1333,14 +1564,39 @@ class ModelServicesRunner:
       platform_chip: Optional[str] = None,
       platform_topology: Optional[str] = None,
       tags: Optional[List[str]] = None,
+      role: Optional[str] = None,
       backend: Optional[spmd_backend.SPMDBackend] = None,
       fail_on_error: bool = False,
+      tls_cert_file: Optional[str] = None,
+      tls_key_file: Optional[str] = None,
+      tls_ca_file: Optional[str] = None,
+      reservations: Optional[Sequence[admin_pb2.ResourceReservation]] = None,
+      request_slots: int = 64,
   ):
     self._is_primary = is_primary_process
+    # Connections within the cell, including the ones this server accepts,
+    # follow the transport security policy of the cell.
+    self._tls_cert_file = tls_cert_file or ''
+    self._tls_key_file = tls_key_file or ''
+    self._tls_ca_file = tls_ca_file or ''
+    self._transport_security = admin_pb2.Config.TRANSPORT_INSECURE
+    if sax_cell is not None:
+      location.SetTransportFiles(
+          self._tls_cert_file, self._tls_key_file, self._tls_ca_file
+      )
+      self._transport_security = location.CellTransportSecurity(sax_cell)
     # If deterministic_prng_seed is provided, all models will use this as the
     # initial seed.
     self._det_prng_seed = deterministic_prng_seed
-    self._batcher = PerMethodBatcher()
+    # Compute shares reserved for models are enforced by bounding the model
+    # requests running at once.
+    limiter = None
+    if any(r.min_compute_share > 0 for r in reservations or []):
+      limiter = utils.ReservationLimiter(
+          request_slots,
+          {r.model_id: r.min_compute_share for r in reservations},
+      )
+    self._batcher = PerMethodBatcher(limiter)
     self._batcher.register_method(
         None,
         MethodKey(MethodName.KEEP_DEVICES_WARM),
@@ -1417,7 +1673,9 @@ class ModelServicesRunner:
         admin_port=admin_port,
         platform_chip=platform_chip,
         platform_topology=platform_topology,
-        tags=tags
+        tags=tags,
+        role=role,
+        reservations=reservations,
     )
     self._platform_topology = platform_topology
     all_grpc_services = [self._modelet_service]
@@ -1481,14 +1739,49 @@ class ModelServicesRunner:
     return grpc.aio.server()
 
   def _get_server_credentials(self) -> Optional[grpc.ServerCredentials]:
-    # TODO(sax-dev): Add credentials for OSS.
-    return None
+    if self._transport_security == admin_pb2.Config.TRANSPORT_INSECURE:
+      return None
+    if not self._tls_cert_file or not self._tls_key_file:
+      raise ValueError(
+          'Transport security policy'
+          f' {admin_pb2.Config.TransportSecurity.Name(self._transport_security)}'
+          ' requires a server certificate and key'
+      )
+    mutual = (
+        self._transport_security == admin_pb2.Config.TRANSPORT_MTLS_REQUIRED
+    )
+    if mutual and not self._tls_ca_file:
+      raise ValueError(
+          'Transport security policy TRANSPORT_MTLS_REQUIRED requires CA'
+          ' certificates to verify clients against'
+      )
+    return grpc.ssl_server_credentials(
+        [(_read_file(self._tls_key_file), _read_file(self._tls_cert_file))],
+        root_certificates=(
+            _read_file(self._tls_ca_file) if self._tls_ca_file else None
+        ),
+        require_client_auth=mutual,
+    )
 
   def _get_client_channel_credentials(
       self,
   ) -> Optional[grpc.ChannelCredentials]:
-    # TODO(sax-dev): Add credentials for OSS.
-    return None
+    if self._transport_security == admin_pb2.Config.TRANSPORT_INSECURE:
+      return None
+    mutual = (
+        self._transport_security == admin_pb2.Config.TRANSPORT_MTLS_REQUIRED
+    )
+    return grpc.ssl_channel_credentials(
+        root_certificates=(
+            _read_file(self._tls_ca_file) if self._tls_ca_file else None
+        ),
+        private_key=_read_file(self._tls_key_file) if mutual else None,
+        certificate_chain=_read_file(self._tls_cert_file) if mutual else None,
+    )
+
+  def client_channel_credentials(self) -> Optional[grpc.ChannelCredentials]:
+    """Returns the credentials to connect to this server with."""
+    return self._get_client_channel_credentials()
 
   def _run_keep_warm_loop(self):
     while True:
@@ -1541,6 +1834,19 @@ class ModelServicesRunner:
     self._aio_thread.join()
     self._multihost_sync.wait()
 
+  def shutdown(self, grace_seconds: float) -> None:
+    """Stops gracefully.
+
+    Reports the server as shutting down, so that the admin server stops placing
+    models on it and moves its models elsewhere, and stops after grace_seconds.
+
+    Args:
+      grace_seconds: How long to keep serving after reporting the shutdown.
+    """
+    self._modelet_service.start_shutdown()
+    time.sleep(grace_seconds)
+    self.stop()
+
   def stop(self) -> None:
     """Wait until all threads finishes."""
     self._aio_loop.call_soon_threadsafe(self._terminate_future.set_result, ())
@@ -1568,6 +1874,7 @@ class ModelServicesRunner:
       acls: Dict[str, str],
       overrides: Dict[str, str],
       prng_key: int,
+      config_blob_paths: Dict[str, str],
   ) -> None:
     """Loads a model and initializes its methods."""
     if self._loaded_models.contains(model_key):
@@ -1590,6 +1897,10 @@ class ModelServicesRunner:
               service.ParseMethodRPCRequest(method_name, t.request)
               for t in rpc_tasks
           ])
+          input_tokens = method.input_tokens(inputs, len(rpc_tasks))
+          if input_tokens is not None:
+            for t, n in zip(rpc_tasks, input_tokens):
+              t.input_tokens = n
           extra_inputs = [
               method.get_extra_inputs_from_request_inputs(t.request)
               for t in rpc_tasks
@@ -1654,6 +1965,7 @@ class ModelServicesRunner:
         overrides,
         prng_key,
         register_methods,
+        config_blob_paths,
     )
 
     for method_name, method_obj in model.methods.items():
@@ -1724,6 +2036,18 @@ class ModelServicesRunner:
               proto_util.count_physical_chips(self._platform_topology) or 1
           )
           tpu_ms = (tpu_ms_per_chip * chips_count) // len(batch.rpc_tasks)
+          output_tokens = method_obj.tokens_processed(
+              host_tensors, len(batch.rpc_tasks)
+          ) or [None] * len(batch.rpc_tasks)
+          if len(output_tokens) != len(batch.rpc_tasks):
+            raise ValueError(
+                f'tokens_processed returned {output_tokens=}, expected'
+                f' {len(batch.rpc_tasks)} items.'
+            )
+          tokens = [
+              _total_tokens(task.input_tokens, n)
+              for task, n in zip(batch.rpc_tasks, output_tokens)
+          ]
           # Free device tensors.
           del out_tensors_container[0]
           utils.traceprint_all(
@@ -1732,8 +2056,13 @@ class ModelServicesRunner:
           if not pre_process_failure:
             # No more result for streaming.
             if streaming_done is not None:
-              for task in batch.rpc_tasks:
-                task.done(utils.ok(), resp=None, query_cost=tpu_ms)
+              for task, query_tokens in zip(batch.rpc_tasks, tokens):
+                task.done(
+                    utils.ok(),
+                    resp=None,
+                    query_cost=tpu_ms,
+                    query_tokens=query_tokens,
+                )
               return
             # TODO(zhifengc): Might make more sense to split this phase into
             # two. One calls output_to_host and the other calls post_processing.
@@ -1746,11 +2075,15 @@ class ModelServicesRunner:
                   f'post_processing returned {outputs=}, expected'
                   f' {len(batch.rpc_tasks)} items.'
               )
-            for out, task in zip(outputs, batch.rpc_tasks):
+            for out, task, query_tokens in zip(
+                outputs, batch.rpc_tasks, tokens
+            ):
               self._model_services[batch.method.service_id].FillRPCResponse(
                   batch.method.model_method, out, task.response
               )
-              task.done(utils.ok(), query_cost=tpu_ms)
+              task.done(
+                  utils.ok(), query_cost=tpu_ms, query_tokens=query_tokens
+              )
               done_rpcs += 1
         except Exception as e:  # pylint: disable=broad-except
           if not pre_process_failure:
@@ -1854,6 +2187,7 @@ class ModelServicesRunner:
                   request.checkpoint_path,
                   json.dumps({k: v for k, v in request.overrides.items()}),
                   str(prng_seed),
+                  json.dumps(dict(request.config_blob_paths)),
               )
               self._load_model(
                   model_key,
@@ -1862,6 +2196,7 @@ class ModelServicesRunner:
                   dict(request.acls.items),
                   dict(request.overrides),
                   prng_seed,
+                  dict(request.config_blob_paths),
               )
               task.done(utils.ok())
             except ValueError as e:
@@ -2329,8 +2664,16 @@ class ModelServicesRunner:
       method = msgs.pop(0)
       match method:
         case MethodName.LOAD:
-          model_key, model_path, ckpt_path, overrides_json, prng_seed = msgs
+          (
+              model_key,
+              model_path,
+              ckpt_path,
+              overrides_json,
+              prng_seed,
+              config_blob_paths_json,
+          ) = msgs
           overrides = json.loads(overrides_json)
+          config_blob_paths = json.loads(config_blob_paths_json)
           logging.info('Received load: %s', model_key)
           try:
             prng_seed = int(prng_seed)
@@ -2342,6 +2685,7 @@ class ModelServicesRunner:
                   {},  # Empty ACLs because only the primary worker needs it.
                   overrides,
                   prng_seed,
+                  config_blob_paths=config_blob_paths,
               )
           except Exception as e:  # pylint: disable=broad-except
             self._log_exception(
@@ -13,6 +13,7 @@
 # limitations under the License.
 """Tests for model_service_base."""
 
+import time
 from unittest import mock
 
 from absl.testing import absltest
@@ -37,6 +38,16 @@ class MethodKeyTest(absltest.TestCase):
     self.assertEqual(key.method_name(), 'method')
 
 
+class TotalTokensTest(absltest.TestCase):
+
+  def test_adds_known_counts(self):
+    self.assertEqual(model_service_base._total_tokens(3, 4), 7)
+    self.assertEqual(model_service_base._total_tokens(3, None), 3)
+    self.assertEqual(model_service_base._total_tokens(None, 4), 4)
+
+  def test_unknown_without_counts(self):
+    self.assertIsNone(model_service_base._total_tokens(None, None))
+
 class GetStatusTest(absltest.TestCase):
 
   def setUp(self):
@@ -58,6 +69,8 @@ class GetStatusTest(absltest.TestCase):
     mock_loader.get_status.return_value = {
         '/sax/foo/bar': common_pb2.ModelStatus.LOADED,
     }
+    mock_loader.reassign_requested.return_value = False
+    self._mock_loader = mock_loader
     mock_batcher = self.enter_context(
         mock.patch.object(self._service, '_batcher', autospec=True)
     )
@@ -81,6 +94,20 @@ class GetStatusTest(absltest.TestCase):
             ),
             10,
             [1, 2, 3, 4, 5, 6, 7, 8, 9, 10],
+            utils.RequestStats.Stats(
+                timespan_sec=8,
+                total=100,
+                summ=4000,
+                summ2=160000,
+                samples=np.array([40] * 100),
+            ),
+            utils.RequestStats.Stats(
+                timespan_sec=8,
+                total=100,
+                summ=950,
+                summ2=9025,
+                samples=np.array([9.5] * 100),
+            ),
         ),
     ]
 
@@ -105,6 +132,8 @@ class GetStatusTest(absltest.TestCase):
     self.assertAlmostEqual(
         98.01, method_stats.p99_latency_on_success_per_second, 3
     )
+    self.assertEqual(40, method_stats.mean_queue_seconds_on_success)
+    self.assertEqual(9.5, method_stats.mean_processing_seconds_on_success)
 
   def test_no_method_stats_if_not_requested(self):
     request = modelet_pb2.GetStatusRequest()
@@ -116,6 +145,77 @@ class GetStatusTest(absltest.TestCase):
     model = response.models[0]
     self.assertEmpty(model.method_stats)
 
+  def test_reports_reassign_requested(self):
+    self._mock_loader.reassign_requested.return_value = True
+    request = modelet_pb2.GetStatusRequest()
+    response = modelet_pb2.GetStatusResponse()
+
+    self._service.get_status(request, response)
+
+    self.assertLen(response.models, 1)
+    self.assertTrue(response.models[0].reassign_requested)
+    self._mock_loader.reassign_requested.assert_called_with('/sax/foo/bar')
+
+  def test_reports_shutting_down(self):
+    request = modelet_pb2.GetStatusRequest()
+    response = modelet_pb2.GetStatusResponse()
+    self._service.get_status(request, response)
+    self.assertFalse(response.shutting_down)
+
+    self._service.start_shutdown()
+    response = modelet_pb2.GetStatusResponse()
+    self._service.get_status(request, response)
+
+    self.assertTrue(response.shutting_down)
+    self.assertLen(response.models, 1)
+
+
+class PerMethodBatcherTest(absltest.TestCase):
+
+  def test_queue_wait_reported_separately(self):
+    batcher = model_service_base.PerMethodBatcher()
+    key = model_service_base.MethodKey(
+        model_service_base.MethodName.MODEL,
+        'method',
+        'service',
+        '/sax/foo/bar',
+    )
+    batcher.register_method(None, key, batch_size=1, max_live_batches=4)
+
+    # Queue 4 requests for a method that processes one at a time, so later
+    # requests wait for earlier ones.
+    num_requests = 4
+    processing_secs = 0.1
+    statuses = []
+    for _ in range(num_requests):
+      batcher.add_item(key, optional_done=statuses.append)
+    for _ in range(num_requests):
+      batch = batcher.get_batch()
+      batch.wait_for_ready()
+      time.sleep(processing_secs)
+      for rpc_task in batch.rpc_tasks:
+        rpc_task.done(utils.ok())
+      batch.finish()
+    self.assertLen(statuses, num_requests)
+
+    [(_, ok_stats, _, _, _, queue_stats, processing_stats)] = (
+        batcher.get_method_stats()
+    )
+    self.assertEqual(num_requests, ok_stats.total)
+    self.assertEqual(num_requests, queue_stats.total)
+    self.assertEqual(num_requests, processing_stats.total)
+    # Every request took about processing_secs to process, but the last one
+    # waited for the 3 before it.
+    self.assertGreaterEqual(min(processing_stats.samples), processing_secs)
+    self.assertGreaterEqual(
+        max(queue_stats.samples), (num_requests - 1) * processing_secs
+    )
+    self.assertLess(processing_stats.mean(), max(queue_stats.samples))
+    self.assertAlmostEqual(
+        ok_stats.summ, queue_stats.summ + processing_stats.summ, 3
+    )
+    batcher.unregister_method(key)
+
 
 if __name__ == '__main__':
   absltest.main()
@@ -14,6 +14,8 @@
 """The main module of model services."""
 
 import re
+import signal
+import threading
 from typing import Optional, Sequence
 
 from absl import app
@@ -22,6 +24,7 @@ from absl import logging
 import grpc
 import jax
 from jax.experimental.compilation_cache import compilation_cache
+from saxml.protobuf import admin_pb2
 from saxml.protobuf import modelet_pb2
 from saxml.protobuf import modelet_pb2_grpc
 from saxml.server import model_service_base
@@ -55,6 +58,62 @@ _PLATFORM_TOPOLOGY = flags.DEFINE_string(
 _TAGS = flags.DEFINE_list(
     'tags', [], 'Optional list of string tags.'
 )
+_ROLE = flags.DEFINE_string(
+    'role',
+    '',
+    (
+        'Optional role of the server, e.g. serving, eval, or batch. The admin'
+        ' server only assigns it models published with the same role.'
+    ),
+)
+_RESERVATIONS = flags.DEFINE_list(
+    'reservations',
+    [],
+    (
+        'Resources reserved for models, as'
+        ' <model_id>:<min_memory_bytes>:<min_compute_share>, e.g.,'
+        ' /sax/test/foo:8000000000:0.5. The admin server keeps the memory'
+        ' available for the models, and the server keeps the compute share of'
+        ' --request_slots for them.'
+    ),
+)
+_REQUEST_SLOTS = flags.DEFINE_integer(
+    'request_slots',
+    64,
+    (
+        'The number of model requests the server runs at once when'
+        ' --reservations reserve compute shares.'
+    ),
+)
+_TLS_CERT = flags.DEFINE_string(
+    'sax_tls_cert',
+    None,
+    (
+        'PEM certificate this server presents on Sax connections: as a server'
+        ' in cells requiring TLS or mTLS, and as a client in cells requiring'
+        ' mTLS.'
+    ),
+)
+_TLS_KEY = flags.DEFINE_string(
+    'sax_tls_key', None, 'PEM private key of --sax_tls_cert.'
+)
+_TLS_CA = flags.DEFINE_string(
+    'sax_tls_ca',
+    None,
+    (
+        'PEM certificates of the CAs that sign the certificates of Sax'
+        " components. If empty, the host's root CAs are used."
+    ),
+)
+_SHUTDOWN_GRACE_SECONDS = flags.DEFINE_float(
+    'shutdown_grace_seconds',
+    30.0,
+    (
+        'On SIGTERM, how long the server keeps serving after reporting it is'
+        ' shutting down, so that the admin server can move its models'
+        ' elsewhere first.'
+    ),
+)
 _JAX_PROFILER_PORT = flags.DEFINE_integer(
     'jax_profiler_port',
     None,
@@ -216,11 +275,30 @@ def run(channel_creds: Optional[grpc.ChannelCredentials]) -> None:
       platform_chip=_PLATFORM_CHIP.value,
       platform_topology=_PLATFORM_TOPOLOGY.value,
       tags=_TAGS.value,
+      role=_ROLE.value,
       backend=spmd_bknd,
+      tls_cert_file=_TLS_CERT.value,
+      tls_key_file=_TLS_KEY.value,
+      tls_ca_file=_TLS_CA.value,
+      reservations=_parse_reservations(_RESERVATIONS.value),
+      request_slots=_REQUEST_SLOTS.value,
   )
+  if channel_creds is None:
+    channel_creds = runner.client_channel_credentials()
   # Start jax.profiler for TensorBoard and profiling in open source.
   if _JAX_PROFILER_PORT.value:
     jax.profiler.start_server(_JAX_PROFILER_PORT.value)
+
+  def _on_sigterm(signum, frame):
+    del signum, frame
+    # Signal handlers run on the main thread, which waits for the runner.
+    threading.Thread(
+        target=runner.shutdown,
+        args=(_SHUTDOWN_GRACE_SECONDS.value,),
+        daemon=True,
+    ).start()
+
+  signal.signal(signal.SIGTERM, _on_sigterm)
   try:
     logging.info('Starting runner %d.', jax.process_index())
     runner.start()
@@ -235,6 +313,23 @@ def run(channel_creds: Optional[grpc.ChannelCredentials]) -> None:
     runner.stop()
 
 
+def _parse_reservations(
+    values: Sequence[str],
+) -> list[admin_pb2.ResourceReservation]:
+  """Parses --reservations."""
+  reservations = []
+  for value in values:
+    model_id, memory, share = value.rsplit(':', 2)
+    reservations.append(
+        admin_pb2.ResourceReservation(
+            model_id=model_id,
+            min_memory_bytes=int(memory),
+            min_compute_share=float(share),
+        )
+    )
+  return reservations
+
+
 def main(argv: Sequence[str]) -> None:
   del argv
   # TODO(sax-dev): Add secure channel for OSS.
@@ -213,6 +213,16 @@ class ServableLMModelParams(
     )
 
 
+def _count_input_tokens(
+    inputs: NestedNpTensor, batch_size: int
+) -> Optional[List[int]]:
+  """Returns the number of non-padding input tokens of each input."""
+  if not isinstance(inputs, dict) or 'paddings' not in inputs:
+    return None
+  non_paddings = 1.0 - np.asarray(inputs['paddings'])
+  return [int(n) for n in np.sum(non_paddings, axis=-1)[:batch_size]]
+
+
 class ServableLMMethod(servable_model.ServableMethod):
   """Implements common method of LM."""
 
@@ -248,6 +258,11 @@ class ServableLMMethod(servable_model.ServableMethod):
         ),
     )
 
+  def input_tokens(
+      self, inputs: HostTensors, batch_size: int
+  ) -> Optional[List[int]]:
+    return _count_input_tokens(inputs, batch_size)
+
   def get_padded_input_shape(
       self, unpadded_shape: InputShapeInfo
   ) -> InputShapeInfo:
@@ -854,6 +869,25 @@ class LMDecodeMethod(ServableLMMethod):
         for decoded, scores in zip(batched_decoded, batched_scores)
     ]
 
+  def tokens_processed(
+      self, compute_outputs: NestedNpTensor, batch_size: int
+  ) -> Optional[List[int]]:
+    # Counts the tokens generated by all samples of each input.
+    if not isinstance(compute_outputs, dict):
+      return None
+    decode_lengths = compute_outputs.get('decode_lengths')
+    prefix_lengths = compute_outputs.get('prefix_lengths')
+    if decode_lengths is None or prefix_lengths is None:
+      return None
+    # decode_lengths: [batch_size, num_samples], including the prefix.
+    # prefix_lengths: [batch_size].
+    generated = np.maximum(
+        np.asarray(decode_lengths)
+        - np.asarray(prefix_lengths)[:, np.newaxis],
+        0,
+    )
+    return [int(n) for n in np.sum(generated, axis=-1)[:batch_size]]
+
   def post_processing_stream(
       self,
       compute_outputs: Optional[NestedNpTensor] = None,
@@ -1896,6 +1930,11 @@ class TextToEmbedding(servable_model.ServableMethod):
     else:
       return list(compute_outputs['text_embedding'])
 
+  def input_tokens(
+      self, inputs: NestedNpTensor, batch_size: int
+  ) -> Optional[List[int]]:
+    return _count_input_tokens(inputs, batch_size)
+
 
 class LMGradientMethod(ServableLMMethod):
   """Implements the gradient method of LM."""
@@ -43,6 +43,7 @@ class UnionModelParams(
   """
 
   overrides: Dict[str, Any] = {}
+  config_blobs: Dict[str, bytes] = {}
 
   @classmethod
   @abc.abstractmethod
@@ -73,6 +74,10 @@ class UnionModelParams(
     """Delays the model overrides until child creation."""
     self.overrides = overrides
 
+  def apply_config_blobs(self, blobs: Dict[str, bytes]) -> None:
+    """Delays the model config blobs until child creation."""
+    self.config_blobs = blobs
+
 
 class UnionModel(servable_model.ServableModel):
   """A model that implements multiple interfaces."""
@@ -102,6 +107,7 @@ class UnionModel(servable_model.ServableModel):
     for child in children:
       child_inst = child()
       child_inst.apply_model_overrides(union_config.overrides)
+      child_inst.apply_config_blobs(union_config.config_blobs)
       self._models.append(child_inst.create_model(self.primary_process_id))
     return self._models[0].load_state(checkpoint_path, prng_key, precompile)
 
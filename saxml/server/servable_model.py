@@ -176,6 +176,44 @@ class ServableMethod(abc.ABC):
       RPC request.
     """
 
+  def input_tokens(
+      self, inputs: HostTensors, batch_size: int
+  ) -> Optional[List[int]]:
+    """Returns the number of input tokens of each RPC in a batch, if known.
+
+    The counts are added to those of tokens_processed and returned to clients
+    as part of the query cost.
+
+    Args:
+      inputs: Host tensors from ServableMethod.pre_processing.
+      batch_size: Number of RPCs in the batch.
+
+    Returns:
+      A list of token counts per RPC, or None if the method doesn't count
+      tokens.
+    """
+    del inputs, batch_size
+    return None
+
+  def tokens_processed(
+      self, compute_outputs: HostTensors, batch_size: int
+  ) -> Optional[List[int]]:
+    """Returns the number of tokens each RPC in a batch produced, if known.
+
+    The counts are added to those of input_tokens and returned to clients as
+    part of the query cost.
+
+    Args:
+      compute_outputs: Output host tensors from ServableMethod.device_compute.
+      batch_size: Number of RPCs in the batch.
+
+    Returns:
+      A list of token counts per RPC, or None if the method doesn't count
+      tokens.
+    """
+    del compute_outputs, batch_size
+    return None
+
   def post_processing_stream(
       self,
       compute_outputs: Optional[HostTensors] = None,
@@ -148,3 +148,26 @@ class ServableModelParams(abc.ABC):
         )
       setattr(self, k, v)
       logging.info('Set override %s to %s on %s', k, v, self)
+
+  def apply_config_blobs(self, blobs: Dict[str, bytes]) -> None:
+    """Applies large model config blobs received from Publish.
+
+    Blobs, such as tokenizer vocabularies, are uploaded to the Sax cell and
+    read by model servers from the cell storage. By default, each blob replaces
+    the model config value of the same name with its content, with a warning if
+    the name is not found on this model config.
+
+    This method may be overridden by subclasses for more customized behavior,
+    e.g., to write a blob to a local file the model config refers to.
+
+    Args:
+        blobs: Blob contents keyed by the names supplied by Publish.
+    """
+    for k, v in blobs.items():
+      if not hasattr(self, k):
+        logging.warning(
+            "Can't set config blob %s because it's not set on %s", k, self
+        )
+        continue
+      setattr(self, k, v)
+      logging.info('Set config blob %s (%d bytes) on %s', k, len(v), self)
@@ -52,6 +52,13 @@ class ServableModelParamsTest(absltest.TestCase):
     self.assertRaises(ValueError, params.apply_model_overrides, dict(
         INT_KEY="false",))
 
+  def test_config_blobs(self):
+    params = self.params
+    params.VOCAB = None
+    params.apply_config_blobs(dict(VOCAB=b"a b c", MISSING_KEY=b"x"))
+    self.assertEqual(params.VOCAB, b"a b c")
+    self.assertFalse(hasattr(params, "MISSING_KEY"))
+
 
 if __name__ == "__main__":
   absltest.main()
@@ -15,10 +15,11 @@
 
 import collections
 import dataclasses
+import math
 import queue
 import threading
 import time
-from typing import Any, Callable, Deque, List, Optional, Protocol, Sequence, Tuple
+from typing import Any, Callable, Deque, Dict, List, Optional, Protocol, Sequence, Tuple
 
 import grpc
 import jax
@@ -91,6 +92,10 @@ class RpcQueueTask:
   response: Optional[message.Message]
   done: Optional[StatusCallback]
   tc: Optional[TracerPrintCallback]
+  # When the task's batch was dequeued for processing, if it has been.
+  dequeue_ts: Optional[float] = None
+  # The number of input tokens of the request, if its method counts them.
+  input_tokens: Optional[int] = None
 
 
 def traceprint_all(rpc_tasks: Sequence[RpcQueueTask], msg: str):
@@ -114,7 +119,7 @@ class RpcQueue:
       response: Optional[message.Message],
       done: Optional[StatusCallback],
       tc: Optional[TracerPrintCallback] = None,
-  ):
+  ) -> RpcQueueTask:
     """Called from RPC handler to schedule a task for processing.
 
     Args:
@@ -123,8 +128,13 @@ class RpcQueue:
       response: response protocol message
       done: A callback when the rpc handling is done.
       tc: optional TracerPrintCallback object.
+
+    Returns:
+      The scheduled task.
     """
-    self._queue.put(RpcQueueTask(rpc, request, response, done, tc))
+    task = RpcQueueTask(rpc, request, response, done, tc)
+    self._queue.put(task)
+    return task
 
   def take_batch(self, batch_size: int) -> List[RpcQueueTask]:
     """Returns up to batch_size RpcQueueTask objects from the queue.
@@ -268,6 +278,72 @@ class Admissioner:
       self._shutdown = True
 
 
+class ReservationLimiter:
+  """Bounds the requests a server runs at once, keeping slots for some models.
+
+  A model with compute share s owns floor(s * slots) slots no other model can
+  take. The remaining slots are shared by all models, so a busy model without a
+  reservation can never use up the slots of a reserved one, and a reserved model
+  can go past its share when the server has room.
+  """
+
+  def __init__(self, slots: int, shares: Dict[str, float]):
+    """Constructor.
+
+    Args:
+      slots: The number of requests the server runs at once.
+      shares: The reserved compute share of models, in [0, 1], by model key.
+
+    Raises:
+      ValueError: If a share is out of range or shares reserve more than all
+        slots.
+    """
+    self._lock = threading.Lock()
+    self._owned: Dict[str, int] = {}
+    self._used: Dict[str, int] = {}
+    free = slots
+    for key, share in shares.items():
+      if share < 0 or share > 1:
+        raise ValueError(f'Compute share {share} of model {key} not in [0, 1]')
+      owned = math.floor(share * slots)
+      if owned == 0:
+        continue
+      self._owned[key] = owned
+      self._used[key] = 0
+      free -= owned
+    if free < 0:
+      raise ValueError(f'Compute shares {shares} reserve more than {slots} slots')
+    self._shared = free
+    self._shared_used = 0
+
+  def try_acquire(self, key: str) -> Optional[Callable[[], None]]:
+    """Takes a request slot for a model without blocking.
+
+    Requests use the model's own slots before shared ones.
+
+    Args:
+      key: The model key.
+
+    Returns:
+      A function to call when the request is done, or None if no slot is free.
+    """
+    with self._lock:
+      if self._used.get(key, 0) < self._owned.get(key, 0):
+        self._used[key] += 1
+        return lambda: self._release(key)
+      if self._shared_used < self._shared:
+        self._shared_used += 1
+        return lambda: self._release(None)
+      return None
+
+  def _release(self, key: Optional[str]) -> None:
+    with self._lock:
+      if key is None:
+        self._shared_used -= 1
+      else:
+        self._used[key] -= 1
+
+
 def ok() -> Status:
   return Status(grpc.StatusCode.OK)
 
@@ -427,6 +503,124 @@ class RequestStats:
     )
 
 
+ResultCallback = Callable[[Status, Optional[bytes]], None]
+
+
+class ResultCache:
+  """ResultCache remembers the results of recent requests by idempotency key.
+
+  A retried request carrying the same key as an earlier one gets the earlier
+  result instead of being computed again. A duplicate arriving while the first
+  request is still running waits for its result.
+
+  E.g.,
+    cache = ResultCache(capacity=1024, window_sec=600)
+
+    if cache.begin(key, replay):
+      # First request with this key: compute the result.
+      ...
+      cache.finish(key, status, response.SerializeToString())
+    # Otherwise, replay(status, data) is called with the result.
+  """
+
+  @dataclasses.dataclass
+  class _Entry:
+    timestamp_sec: float
+    done: bool = False
+    status: Optional[Status] = None
+    data: Optional[bytes] = None
+    waiters: List[ResultCallback] = dataclasses.field(default_factory=list)
+
+  def __init__(
+      self, capacity: int, window_sec: float, clock: ClockTime = time.time
+  ):
+    """Constructs a ResultCache object.
+
+    Args:
+      capacity: Keeps at most these many finished results.
+      window_sec: Keeps a finished result for these many seconds.
+      clock: A callback returns the current time. Useful for testing.
+    """
+    assert capacity > 0
+    assert window_sec > 0.0
+    self._capacity = capacity
+    self._window_sec = window_sec
+    self._clock = clock
+    self._mu = threading.Lock()
+    self._entries: collections.OrderedDict[Any, ResultCache._Entry] = (
+        collections.OrderedDict()
+    )
+
+  def _gc(self, now_sec: float):
+    """Drops expired results and the least recently used ones over capacity."""
+    # Entries are ordered from the least to the most recently used.
+    finished = [k for k, e in self._entries.items() if e.done]
+    num_finished = len(finished)
+    for key in finished:
+      expired = now_sec - self._entries[key].timestamp_sec >= self._window_sec
+      if expired or num_finished > self._capacity:
+        del self._entries[key]
+        num_finished -= 1
+
+  def begin(self, key: Any, replay: ResultCallback) -> bool:
+    """Starts a request with the given key.
+
+    Args:
+      key: The idempotency key of the request.
+      replay: Called with the status and the serialized response of an earlier
+        request with the same key, if there is one.
+
+    Returns:
+      True if the caller should compute the result and call finish(). False if
+      replay has been or will be called instead.
+    """
+    with self._mu:
+      now_sec = self._clock()
+      self._gc(now_sec)
+      entry = self._entries.get(key)
+      if entry is None:
+        self._entries[key] = self._Entry(timestamp_sec=now_sec)
+        return True
+      self._entries.move_to_end(key)
+      if not entry.done:
+        entry.waiters.append(replay)
+        return False
+      status, data = entry.status, entry.data
+    replay(status, data)
+    return False
+
+  def finish(self, key: Any, status: Status, data: Optional[bytes]):
+    """Records the result of a request started with begin().
+
+    Failed results are handed to waiting duplicates but not kept, so a later
+    retry computes the result again.
+
+    Args:
+      key: The idempotency key of the request.
+      status: The status of the request.
+      data: The serialized response if the request succeeded.
+    """
+    with self._mu:
+      entry = self._entries.get(key)
+      if entry is None or entry.done:
+        return
+      waiters = entry.waiters
+      if status.ok():
+        self._entries[key] = self._Entry(
+            timestamp_sec=self._clock(), done=True, status=status, data=data
+        )
+        self._entries.move_to_end(key)
+      else:
+        del self._entries[key]
+      self._gc(self._clock())
+    for waiter in waiters:
+      waiter(status, data)
+
+  def __len__(self) -> int:
+    with self._mu:
+      return len(self._entries)
+
+
 def is_mock_tpu_backend() -> bool:
   """Checks if a mock TPU backend is detected.
 
@@ -82,5 +82,98 @@ class RequestStatsTest(absltest.TestCase):
     np.testing.assert_allclose(1.0 / tick, result.rate())
 
 
+class ResultCacheTest(absltest.TestCase):
+
+  def _replayed(self):
+    results = []
+    return results, lambda status, data: results.append((status, data))
+
+  def testDuplicateKeyReplays(self):
+    cache = utils.ResultCache(capacity=8, window_sec=60)
+    self.assertTrue(cache.begin('key', lambda *_: None))
+    cache.finish('key', utils.ok(), b'result')
+
+    results, replay = self._replayed()
+    self.assertFalse(cache.begin('key', replay))
+    self.assertEqual(results, [(utils.ok(), b'result')])
+
+  def testDistinctKeysComputeIndependently(self):
+    cache = utils.ResultCache(capacity=8, window_sec=60)
+    self.assertTrue(cache.begin('key1', lambda *_: None))
+    self.assertTrue(cache.begin('key2', lambda *_: None))
+    cache.finish('key1', utils.ok(), b'result1')
+    cache.finish('key2', utils.ok(), b'result2')
+
+    results, replay = self._replayed()
+    self.assertFalse(cache.begin('key2', replay))
+    self.assertEqual(results, [(utils.ok(), b'result2')])
+
+  def testInflightDuplicateWaits(self):
+    cache = utils.ResultCache(capacity=8, window_sec=60)
+    self.assertTrue(cache.begin('key', lambda *_: None))
+    results, replay = self._replayed()
+    self.assertFalse(cache.begin('key', replay))
+    self.assertEmpty(results)
+
+    cache.finish('key', utils.ok(), b'result')
+    self.assertEqual(results, [(utils.ok(), b'result')])
+
+  def testFailureIsNotKept(self):
+    cache = utils.ResultCache(capacity=8, window_sec=60)
+    self.assertTrue(cache.begin('key', lambda *_: None))
+    results, replay = self._replayed()
+    self.assertFalse(cache.begin('key', replay))
+    cache.finish('key', utils.internal_error('boom'), None)
+    self.assertEqual(results, [(utils.internal_error('boom'), None)])
+
+    # A retry after the failure computes the result again.
+    self.assertTrue(cache.begin('key', lambda *_: None))
+
+  def testWindowAndCapacity(self):
+    clock = _TestClock()
+    cache = utils.ResultCache(capacity=2, window_sec=60, clock=clock.now)
+    for key in ['key1', 'key2', 'key3']:
+      self.assertTrue(cache.begin(key, lambda *_: None))
+      cache.finish(key, utils.ok(), key.encode())
+    # The least recently used result is dropped over capacity.
+    self.assertLen(cache, 2)
+    self.assertTrue(cache.begin('key1', lambda *_: None))
+    cache.finish('key1', utils.ok(), b'key1')
+
+    # All results are dropped after the window.
+    clock.advance(61)
+    self.assertTrue(cache.begin('key3', lambda *_: None))
+    self.assertLen(cache, 1)
+
+
+class ReservationLimiterTest(absltest.TestCase):
+
+  def testSharesUnderContention(self):
+    limiter = utils.ReservationLimiter(4, {'/sax/test/reserved': 0.5})
+
+    # A noisy model without a reservation only gets the 2 shared slots.
+    noisy = [limiter.try_acquire('/sax/test/noisy') for _ in range(2)]
+    self.assertNotIn(None, noisy)
+    self.assertIsNone(limiter.try_acquire('/sax/test/noisy'))
+
+    # The reserved model still gets its 2 slots, and no more while full.
+    for _ in range(2):
+      self.assertIsNotNone(limiter.try_acquire('/sax/test/reserved'))
+    self.assertIsNone(limiter.try_acquire('/sax/test/reserved'))
+
+    # Once the noisy model frees a shared slot, the reserved model can use it.
+    noisy[0]()
+    self.assertIsNotNone(limiter.try_acquire('/sax/test/reserved'))
+    self.assertIsNone(limiter.try_acquire('/sax/test/noisy'))
+
+  def testInvalidShares(self):
+    for shares in [
+        {'/sax/test/foo': 1.5},
+        {'/sax/test/foo': 0.75, '/sax/test/bar': 0.75},
+    ]:
+      with self.assertRaises(ValueError):
+        utils.ReservationLimiter(4, shares)
+
+
 if __name__ == '__main__':
   absltest.main()